@@ -5,17 +5,42 @@ import (
 	"golightly"
 	"os"
 	"runtime"
+	"strings"
 )
 
 func usage() {
 	fmt.Print(
 `Format: gl [options] [<file.go>|<directory>]...
+       gl vet [options] [<file.go>|<directory>]...
+       gl cache stats|clean
 	If no file arguments are provided the current directory will be
 	searched for .go files.
 
+	'gl vet' reports code which is probably a mistake instead of
+	compiling it - format strings which don't match their arguments,
+	malformed struct tags, unreachable code, variables assigned to
+	themselves and copied locks.
+
+	Imported packages are looked for in vendor directories, in the
+	module described by go.mod and in each directory listed in the
+	GOLIGHTLY_PATH environment variable.
+
+	What's found out about each package is kept in a build cache so
+	packages which haven't changed aren't checked again. The cache is
+	the file named by the GOLIGHTLY_CACHE environment variable, or
+	golightly/build.db in the user's cache directory. GOLIGHTLY_CACHE=off
+	turns it off. 'gl cache stats' describes what's in it and
+	'gl cache clean' empties it.
+
 Options:
-	-s - use GoScript syntax
-	-i - interactive mode
+	-i - interactive mode. unused imports, variables and labels and
+	     missing returns are warnings instead of errors.
+	-m - print escape analysis decisions, saying which variables and
+	     allocations are moved to the heap and why.
+	-e - report all the errors. Normally only the first 10 are.
+	-printf <func>,... - with 'gl vet', check calls to these functions
+	     as if they were fmt.Printf. They must take a format string
+	     followed by '...interface{}' arguments.
 `)
 }
 
@@ -28,10 +53,116 @@ func main() {
 	// create the compiler
 	c := golightly.NewCompiler()
 
-	// compile the program
-	err := c.Compile(os.Args)
+	// handle the options
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "cache" {
+		os.Exit(cacheCommand(args[1:]))
+	}
+	if len(args) > 0 && args[0] == "vet" {
+		c.SetVet(true)
+		args = args[1:]
+	}
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "-i":
+			c.SetLenient(true)
+		case "-m":
+			c.SetReportEscapes(true)
+		case "-e":
+			c.SetMaxErrors(0)
+		case "-printf":
+			if len(args) < 2 {
+				usage()
+				os.Exit(1)
+			}
+			for _, name := range strings.Split(args[1], ",") {
+				c.MarkPrintfLike(name)
+			}
+			args = args[1:]
+		default:
+			usage()
+			os.Exit(1)
+		}
+		args = args[1:]
+	}
+
+	// use the build cache if there is one. if it can't be opened the
+	// program is compiled without it.
+	cache, err := openCache()
+	if err != nil {
+		fmt.Println(err)
+	}
+	if cache != nil {
+		c.SetCache(cache)
+	}
+
+	// compile the program. if it fails the warnings are in with the
+	// errors.
+	err = c.Compile(args)
+	c.Close()
+	if cache != nil {
+		cache.Close()
+	}
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	for _, warning := range c.Warnings() {
+		fmt.Println(warning)
+	}
+}
+
+// openCache opens the build cache, or gives nil if it's turned off.
+func openCache() (*golightly.BuildCache, error) {
+	if os.Getenv("GOLIGHTLY_CACHE") == "off" {
+		return nil, nil
+	}
+
+	path, err := golightly.DefaultBuildCachePath()
+	if err != nil {
+		return nil, err
+	}
+
+	return golightly.OpenBuildCache(path)
+}
+
+// cacheCommand runs 'gl cache stats' or 'gl cache clean' and gives the
+// exit status.
+func cacheCommand(args []string) int {
+	if len(args) != 1 || (args[0] != "stats" && args[0] != "clean") {
+		usage()
+		return 1
+	}
+
+	cache, err := openCache()
+	if err == nil && cache == nil {
+		err = fmt.Errorf("the build cache is turned off")
+	}
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer cache.Close()
+
+	if args[0] == "clean" {
+		if err := cache.Clean(); err != nil {
+			fmt.Println("I can't clean the build cache:", err)
+			return 1
+		}
+		fmt.Println("cleaned", cache.Path())
+		return 0
+	}
+
+	stats, err := cache.Stats()
+	if err != nil {
+		fmt.Println("I can't read the build cache:", err)
+		return 1
+	}
+	fmt.Printf("cache:    %s\n", stats.Path)
+	fmt.Printf("size:     %d bytes\n", stats.Size)
+	fmt.Printf("packages: %d\n", stats.Packages)
+	fmt.Printf("code:     %d declarations\n", stats.Code)
+	fmt.Printf("hits:     %d\n", stats.Hits)
+	fmt.Printf("misses:   %d\n", stats.Misses)
+	return 0
 }
@@ -154,6 +154,7 @@ type ASTConstDecl struct {
 	ident AST // the variable to declare
 	typ   AST // the optional data type
 	value AST // the value to set it to
+	iota  int // the value of iota - the index of this spec in its group
 }
 
 func (ast ASTConstDecl) IsAST() {
@@ -165,7 +166,7 @@ func (ast ASTConstDecl) Pos() SrcSpan {
 
 func (ast ASTConstDecl) Equals(to AST) bool {
 	too := to.(ASTConstDecl)
-	return ast.ident.Equals(too.ident) && ast.typ.Equals(too.typ) && ast.value.Equals(too.value)
+	return ast.ident.Equals(too.ident) && astEqual(ast.typ, too.typ) && ast.value.Equals(too.value) && ast.iota == too.iota
 }
 
 // type ASTVarDecl describes a variable declaration.
@@ -189,12 +190,13 @@ func (ast ASTVarDecl) Equals(to AST) bool {
 
 // type ASTFunctionDecl describes a function or method declaration.
 type ASTFunctionDecl struct {
-	pos      SrcSpan // the 'func <name>' part of the declaration
-	name     string  // the function name
-	receiver AST     // the optional receiver
-	params   []AST   // the parameters
-	returns  []AST   // the return values
-	body     AST     // the body of the function
+	pos        SrcSpan // the 'func <name>' part of the declaration
+	name       string  // the function name
+	typeParams []AST   // the type parameters of a generic function
+	receiver   AST     // the optional receiver
+	params     []AST   // the parameters
+	returns    []AST   // the return values
+	body       AST     // the body of the function
 }
 
 func (ast ASTFunctionDecl) IsAST() {
@@ -210,6 +212,10 @@ func (ast ASTFunctionDecl) Equals(to AST) bool {
 		return false
 	}
 
+	if !astListEqual(ast.typeParams, too.typeParams) {
+		return false
+	}
+
 	if len(ast.params) != len(too.params) || len(ast.returns) != len(too.returns) {
 		return false
 	}
@@ -231,10 +237,11 @@ func (ast ASTFunctionDecl) Equals(to AST) bool {
 
 // type ASTReceiver describes a receiver in a method declaration.
 type ASTReceiver struct {
-	pos      SrcSpan // the whole receiver
-	name     string  // the receiving variable name
-	pointer  bool    // true if it's of the form *Type
-	typeName string  // the name of the receiver's type
+	pos        SrcSpan // the whole receiver
+	name       string  // the receiving variable name
+	pointer    bool    // true if it's of the form *Type
+	typeName   string  // the name of the receiver's type
+	typeParams []AST   // names for the type parameters of a generic receiver type
 }
 
 func (ast ASTReceiver) IsAST() {
@@ -246,13 +253,15 @@ func (ast ASTReceiver) Pos() SrcSpan {
 
 func (ast ASTReceiver) Equals(to AST) bool {
 	too := to.(ASTReceiver)
-	return ast.pos.Equals(too.pos) && ast.name == too.name && ast.pointer == too.pointer && ast.typeName == too.typeName
+	return ast.pos.Equals(too.pos) && ast.name == too.name && ast.pointer == too.pointer && ast.typeName == too.typeName && astListEqual(ast.typeParams, too.typeParams)
 }
 
 // type ASTDataTypeDecl describes a type declaration using the 'type' keyword.
 type ASTDataTypeDecl struct {
-	ident AST // the variable to declare
-	typ   AST // the data type
+	ident      AST   // the variable to declare
+	typeParams []AST // the type parameters of a generic type
+	typ        AST   // the data type
+	alias      bool  // true if it's an alias of the form 'type X = Y'
 }
 
 func (ast ASTDataTypeDecl) IsAST() {
@@ -264,7 +273,65 @@ func (ast ASTDataTypeDecl) Pos() SrcSpan {
 
 func (ast ASTDataTypeDecl) Equals(to AST) bool {
 	too := to.(ASTDataTypeDecl)
-	return ast.ident.Equals(too.ident) && ast.typ.Equals(too.typ)
+	return ast.ident.Equals(too.ident) && astListEqual(ast.typeParams, too.typeParams) && ast.typ.Equals(too.typ) && ast.alias == too.alias
+}
+
+// type ASTTypeParam describes a type parameter of a generic function or
+// type. 'K, V comparable' gives a parameter for each name, with the same
+// constraint.
+type ASTTypeParam struct {
+	ident      AST // the type parameter's name
+	constraint AST // the constraint the type argument has to satisfy
+}
+
+func (ast ASTTypeParam) IsAST() {
+}
+
+func (ast ASTTypeParam) Pos() SrcSpan {
+	return ast.ident.Pos()
+}
+
+func (ast ASTTypeParam) Equals(to AST) bool {
+	too, ok := to.(ASTTypeParam)
+	return ok && astEqual(ast.ident, too.ident) && astEqual(ast.constraint, too.constraint)
+}
+
+// type ASTDataTypeUnion describes a union of type terms in a constraint,
+// of the form '~int | ~float64 | string'.
+type ASTDataTypeUnion struct {
+	pos   SrcSpan // the whole union
+	terms []AST   // the terms, which are types or ASTDataTypeTildes
+}
+
+func (ast ASTDataTypeUnion) IsAST() {
+}
+
+func (ast ASTDataTypeUnion) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTDataTypeUnion) Equals(to AST) bool {
+	too, ok := to.(ASTDataTypeUnion)
+	return ok && ast.pos.Equals(too.pos) && astListEqual(ast.terms, too.terms)
+}
+
+// type ASTDataTypeTilde describes a type term of the form '~T' in a
+// constraint, which stands for all types with the underlying type T.
+type ASTDataTypeTilde struct {
+	pos SrcSpan // the whole term
+	typ AST     // the underlying type
+}
+
+func (ast ASTDataTypeTilde) IsAST() {
+}
+
+func (ast ASTDataTypeTilde) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTDataTypeTilde) Equals(to AST) bool {
+	too, ok := to.(ASTDataTypeTilde)
+	return ok && ast.pos.Equals(too.pos) && astEqual(ast.typ, too.typ)
 }
 
 // type ASTDataTypeSlice describes a slice declaration.
@@ -585,3 +652,538 @@ func (ast ASTBlock) Equals(to AST) bool {
 
 	return true
 }
+
+// astEqual compares two ASTs, either of which may be nil.
+func astEqual(a, b AST) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	return a.Equals(b)
+}
+
+// astListEqual compares two lists of ASTs.
+func astListEqual(a, b []AST) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i, ast := range a {
+		if !astEqual(ast, b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// type ASTCall describes a function call, method call or type conversion.
+type ASTCall struct {
+	pos      SrcSpan // the entire call
+	function AST     // the function being called, or the type being converted to
+	args     []AST   // the arguments
+	ellipsis bool    // true if the last argument is followed by '...'
+}
+
+func (ast ASTCall) IsAST() {
+}
+
+func (ast ASTCall) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTCall) Equals(to AST) bool {
+	too, ok := to.(ASTCall)
+	return ok && ast.pos.Equals(too.pos) && astEqual(ast.function, too.function) && astListEqual(ast.args, too.args) && ast.ellipsis == too.ellipsis
+}
+
+// type ASTSelector describes a field or method selection of the form 'x.name'.
+// Qualified identifiers of the form 'package.name' are ASTIdentifiers instead.
+type ASTSelector struct {
+	pos  SrcSpan // the entire selector expression
+	expr AST     // the expression being selected from
+	name string  // the field or method name
+}
+
+func (ast ASTSelector) IsAST() {
+}
+
+func (ast ASTSelector) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTSelector) Equals(to AST) bool {
+	too, ok := to.(ASTSelector)
+	return ok && ast.pos.Equals(too.pos) && astEqual(ast.expr, too.expr) && ast.name == too.name
+}
+
+// type ASTIndex describes an index expression of the form 'x[index]'.
+type ASTIndex struct {
+	pos   SrcSpan // the entire index expression
+	expr  AST     // the array, slice, string or map being indexed
+	index AST     // the index
+}
+
+func (ast ASTIndex) IsAST() {
+}
+
+func (ast ASTIndex) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTIndex) Equals(to AST) bool {
+	too, ok := to.(ASTIndex)
+	return ok && ast.pos.Equals(too.pos) && astEqual(ast.expr, too.expr) && astEqual(ast.index, too.index)
+}
+
+// type ASTInstance describes a generic function or type instantiated with
+// more than one type argument, of the form 'x[T1, T2]'. With a single type
+// argument it's indistinguishable from an index expression so it's an
+// ASTIndex.
+type ASTInstance struct {
+	pos      SrcSpan // the entire expression
+	expr     AST     // the generic function or type
+	typeArgs []AST   // the type arguments
+}
+
+func (ast ASTInstance) IsAST() {
+}
+
+func (ast ASTInstance) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTInstance) Equals(to AST) bool {
+	too, ok := to.(ASTInstance)
+	return ok && ast.pos.Equals(too.pos) && astEqual(ast.expr, too.expr) && astListEqual(ast.typeArgs, too.typeArgs)
+}
+
+// type ASTSliceExpr describes a slice expression of the form 'x[low:high:max]'.
+type ASTSliceExpr struct {
+	pos  SrcSpan // the entire slice expression
+	expr AST     // the array, slice or string being sliced
+	low  AST     // the optional low bound
+	high AST     // the optional high bound
+	max  AST     // the optional capacity bound
+}
+
+func (ast ASTSliceExpr) IsAST() {
+}
+
+func (ast ASTSliceExpr) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTSliceExpr) Equals(to AST) bool {
+	too, ok := to.(ASTSliceExpr)
+	return ok && ast.pos.Equals(too.pos) && astEqual(ast.expr, too.expr) && astEqual(ast.low, too.low) && astEqual(ast.high, too.high) && astEqual(ast.max, too.max)
+}
+
+// type ASTTypeAssertion describes a type assertion of the form 'x.(T)'.
+type ASTTypeAssertion struct {
+	pos  SrcSpan // the entire type assertion
+	expr AST     // the interface value
+	typ  AST     // the asserted type. nil in a type switch guard - 'x.(type)'.
+}
+
+func (ast ASTTypeAssertion) IsAST() {
+}
+
+func (ast ASTTypeAssertion) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTTypeAssertion) Equals(to AST) bool {
+	too, ok := to.(ASTTypeAssertion)
+	return ok && ast.pos.Equals(too.pos) && astEqual(ast.expr, too.expr) && astEqual(ast.typ, too.typ)
+}
+
+// type ASTCompositeLit describes a composite literal of the form 'T{elements}'.
+type ASTCompositeLit struct {
+	pos      SrcSpan // the entire literal
+	typ      AST     // the literal's type. nil if it's elided inside another literal.
+	elements []AST   // the elements. keyed elements are ASTKeyedElements.
+}
+
+func (ast ASTCompositeLit) IsAST() {
+}
+
+func (ast ASTCompositeLit) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTCompositeLit) Equals(to AST) bool {
+	too, ok := to.(ASTCompositeLit)
+	return ok && ast.pos.Equals(too.pos) && astEqual(ast.typ, too.typ) && astListEqual(ast.elements, too.elements)
+}
+
+// type ASTKeyedElement describes an element of a composite literal of the form 'key: value'.
+type ASTKeyedElement struct {
+	key   AST // the field name, index or map key
+	value AST // the element value
+}
+
+func (ast ASTKeyedElement) IsAST() {
+}
+
+func (ast ASTKeyedElement) Pos() SrcSpan {
+	return ast.key.Pos().Add(ast.value.Pos())
+}
+
+func (ast ASTKeyedElement) Equals(to AST) bool {
+	too, ok := to.(ASTKeyedElement)
+	return ok && astEqual(ast.key, too.key) && astEqual(ast.value, too.value)
+}
+
+// type ASTFunctionLit describes a function literal - ie. a closure.
+type ASTFunctionLit struct {
+	pos  SrcSpan         // the entire function literal
+	typ  ASTDataTypeFunc // the function's signature
+	body AST             // the body of the function
+}
+
+func (ast ASTFunctionLit) IsAST() {
+}
+
+func (ast ASTFunctionLit) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTFunctionLit) Equals(to AST) bool {
+	too, ok := to.(ASTFunctionLit)
+	return ok && ast.pos.Equals(too.pos) && ast.typ.Equals(too.typ) && astEqual(ast.body, too.body)
+}
+
+// type ASTAssign describes an assignment statement. It covers '=', ':='
+// and the arithmetic assignment operators such as '+='.
+type ASTAssign struct {
+	pos   SrcSpan   // the entire statement
+	op    TokenKind // the assignment operator
+	left  []AST     // the variables being assigned to
+	right []AST     // the values being assigned
+}
+
+func (ast ASTAssign) IsAST() {
+}
+
+func (ast ASTAssign) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTAssign) Equals(to AST) bool {
+	too, ok := to.(ASTAssign)
+	return ok && ast.pos.Equals(too.pos) && ast.op == too.op && astListEqual(ast.left, too.left) && astListEqual(ast.right, too.right)
+}
+
+// type ASTIncDec describes an increment or decrement statement.
+type ASTIncDec struct {
+	pos  SrcSpan   // the entire statement
+	op   TokenKind // TokenKindIncrement or TokenKindDecrement
+	expr AST       // the variable being changed
+}
+
+func (ast ASTIncDec) IsAST() {
+}
+
+func (ast ASTIncDec) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTIncDec) Equals(to AST) bool {
+	too, ok := to.(ASTIncDec)
+	return ok && ast.pos.Equals(too.pos) && ast.op == too.op && astEqual(ast.expr, too.expr)
+}
+
+// type ASTSend describes a channel send statement of the form 'ch <- value'.
+type ASTSend struct {
+	pos     SrcSpan // the entire statement
+	channel AST     // the channel
+	value   AST     // the value to send
+}
+
+func (ast ASTSend) IsAST() {
+}
+
+func (ast ASTSend) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTSend) Equals(to AST) bool {
+	too, ok := to.(ASTSend)
+	return ok && ast.pos.Equals(too.pos) && astEqual(ast.channel, too.channel) && astEqual(ast.value, too.value)
+}
+
+// type ASTReturn describes a return statement.
+type ASTReturn struct {
+	pos     SrcSpan // the entire statement
+	results []AST   // the values being returned
+}
+
+func (ast ASTReturn) IsAST() {
+}
+
+func (ast ASTReturn) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTReturn) Equals(to AST) bool {
+	too, ok := to.(ASTReturn)
+	return ok && ast.pos.Equals(too.pos) && astListEqual(ast.results, too.results)
+}
+
+// type ASTBranch describes a break, continue, goto or fallthrough statement.
+type ASTBranch struct {
+	pos   SrcSpan   // the entire statement
+	tok   TokenKind // which kind of branch it is
+	label string    // the optional label
+}
+
+func (ast ASTBranch) IsAST() {
+}
+
+func (ast ASTBranch) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTBranch) Equals(to AST) bool {
+	too, ok := to.(ASTBranch)
+	return ok && ast.pos.Equals(too.pos) && ast.tok == too.tok && ast.label == too.label
+}
+
+// type ASTLabeled describes a labeled statement.
+type ASTLabeled struct {
+	pos   SrcSpan // where the label is
+	label string  // the label name
+	stmt  AST     // the statement being labeled
+}
+
+func (ast ASTLabeled) IsAST() {
+}
+
+func (ast ASTLabeled) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTLabeled) Equals(to AST) bool {
+	too, ok := to.(ASTLabeled)
+	return ok && ast.pos.Equals(too.pos) && ast.label == too.label && astEqual(ast.stmt, too.stmt)
+}
+
+// type ASTGo describes a go statement.
+type ASTGo struct {
+	pos  SrcSpan // the entire statement
+	call AST     // the function call to run as a goroutine
+}
+
+func (ast ASTGo) IsAST() {
+}
+
+func (ast ASTGo) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTGo) Equals(to AST) bool {
+	too, ok := to.(ASTGo)
+	return ok && ast.pos.Equals(too.pos) && astEqual(ast.call, too.call)
+}
+
+// type ASTDefer describes a defer statement.
+type ASTDefer struct {
+	pos  SrcSpan // the entire statement
+	call AST     // the function call to defer
+}
+
+func (ast ASTDefer) IsAST() {
+}
+
+func (ast ASTDefer) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTDefer) Equals(to AST) bool {
+	too, ok := to.(ASTDefer)
+	return ok && ast.pos.Equals(too.pos) && astEqual(ast.call, too.call)
+}
+
+// type ASTIf describes an if statement.
+type ASTIf struct {
+	pos  SrcSpan // the entire statement
+	init AST     // the optional simple statement before the condition
+	cond AST     // the condition
+	then AST     // the block to run if the condition is true
+	els  AST     // the optional else block or if statement
+}
+
+func (ast ASTIf) IsAST() {
+}
+
+func (ast ASTIf) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTIf) Equals(to AST) bool {
+	too, ok := to.(ASTIf)
+	return ok && ast.pos.Equals(too.pos) && astEqual(ast.init, too.init) && astEqual(ast.cond, too.cond) && astEqual(ast.then, too.then) && astEqual(ast.els, too.els)
+}
+
+// type ASTFor describes a for statement with an optional init, condition
+// and post statement.
+type ASTFor struct {
+	pos  SrcSpan // the entire statement
+	init AST     // the optional init statement
+	cond AST     // the optional condition
+	post AST     // the optional post statement
+	body AST     // the loop body
+}
+
+func (ast ASTFor) IsAST() {
+}
+
+func (ast ASTFor) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTFor) Equals(to AST) bool {
+	too, ok := to.(ASTFor)
+	return ok && ast.pos.Equals(too.pos) && astEqual(ast.init, too.init) && astEqual(ast.cond, too.cond) && astEqual(ast.post, too.post) && astEqual(ast.body, too.body)
+}
+
+// type ASTRange describes a for statement with a range clause.
+type ASTRange struct {
+	pos    SrcSpan // the entire statement
+	key    AST     // the optional key variable
+	value  AST     // the optional value variable
+	define bool    // true if the variables are declared with ':='
+	expr   AST     // the expression being ranged over
+	body   AST     // the loop body
+}
+
+func (ast ASTRange) IsAST() {
+}
+
+func (ast ASTRange) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTRange) Equals(to AST) bool {
+	too, ok := to.(ASTRange)
+	return ok && ast.pos.Equals(too.pos) && astEqual(ast.key, too.key) && astEqual(ast.value, too.value) && ast.define == too.define && astEqual(ast.expr, too.expr) && astEqual(ast.body, too.body)
+}
+
+// type ASTSwitch describes an expression switch or a type switch. In a
+// type switch tag is an ASTTypeAssertion with a nil type, optionally
+// assigned to a variable with an ASTAssign.
+type ASTSwitch struct {
+	pos   SrcSpan // the entire statement
+	init  AST     // the optional init statement
+	tag   AST     // the optional switch expression
+	cases []AST   // the ASTCaseClauses
+}
+
+func (ast ASTSwitch) IsAST() {
+}
+
+func (ast ASTSwitch) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTSwitch) Equals(to AST) bool {
+	too, ok := to.(ASTSwitch)
+	return ok && ast.pos.Equals(too.pos) && astEqual(ast.init, too.init) && astEqual(ast.tag, too.tag) && astListEqual(ast.cases, too.cases)
+}
+
+// type ASTCaseClause describes a case or default clause of a switch statement.
+type ASTCaseClause struct {
+	pos   SrcSpan // the entire clause
+	exprs []AST   // the case expressions or types. nil for default.
+	body  []AST   // the statements in the clause
+}
+
+func (ast ASTCaseClause) IsAST() {
+}
+
+func (ast ASTCaseClause) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTCaseClause) Equals(to AST) bool {
+	too, ok := to.(ASTCaseClause)
+	return ok && ast.pos.Equals(too.pos) && astListEqual(ast.exprs, too.exprs) && astListEqual(ast.body, too.body)
+}
+
+// type ASTSelect describes a select statement.
+type ASTSelect struct {
+	pos   SrcSpan // the entire statement
+	cases []AST   // the ASTCommClauses
+}
+
+func (ast ASTSelect) IsAST() {
+}
+
+func (ast ASTSelect) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTSelect) Equals(to AST) bool {
+	too, ok := to.(ASTSelect)
+	return ok && ast.pos.Equals(too.pos) && astListEqual(ast.cases, too.cases)
+}
+
+// type ASTCommClause describes a case or default clause of a select statement.
+type ASTCommClause struct {
+	pos  SrcSpan // the entire clause
+	comm AST     // the send or receive statement. nil for default.
+	body []AST   // the statements in the clause
+}
+
+func (ast ASTCommClause) IsAST() {
+}
+
+func (ast ASTCommClause) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTCommClause) Equals(to AST) bool {
+	too, ok := to.(ASTCommClause)
+	return ok && ast.pos.Equals(too.pos) && astEqual(ast.comm, too.comm) && astListEqual(ast.body, too.body)
+}
+
+// type ASTClosure describes a closure value made by lowering a function
+// literal. It pairs the function the literal was lowered to with a context
+// holding the variables it captures.
+type ASTClosure struct {
+	pos      SrcSpan // the function literal it was lowered from
+	function string  // the name of the function the literal was lowered to
+	context  []AST   // the values of the context's fields. nil if nothing is captured.
+}
+
+func (ast ASTClosure) IsAST() {
+}
+
+func (ast ASTClosure) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTClosure) Equals(to AST) bool {
+	too, ok := to.(ASTClosure)
+	return ok && ast.pos.Equals(too.pos) && ast.function == too.function && astListEqual(ast.context, too.context)
+}
+
+// type ASTResolvedType describes a type which the compiler has already
+// resolved, for use in code it generates.
+type ASTResolvedType struct {
+	pos SrcSpan  // where the code it's used in was generated from
+	typ DataType // the type
+}
+
+func (ast ASTResolvedType) IsAST() {
+}
+
+func (ast ASTResolvedType) Pos() SrcSpan {
+	return ast.pos
+}
+
+func (ast ASTResolvedType) Equals(to AST) bool {
+	too, ok := to.(ASTResolvedType)
+	return ok && ast.pos.Equals(too.pos) && ast.typ == too.typ
+}
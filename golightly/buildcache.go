@@ -0,0 +1,707 @@
+package golightly
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// the buckets the build cache keeps things in.
+var (
+	packagesBucket = []byte("packages") // package summaries, by import path.
+	codeBucket     = []byte("code")     // generated code, by import path and declaration name.
+	statsBucket    = []byte("stats")    // how often summaries have been used.
+)
+
+// how long to wait for another compiler which has the build cache open.
+const buildCacheTimeout = time.Second
+
+// type BuildCache is a database of what was found out about packages the
+// last time they were compiled, so that packages which haven't changed
+// don't have to be checked again. It's kept in a single file.
+//
+// Each package has a summary - the content hash of each of its files, the
+// structural hash of each of its declarations, a hash of the API it
+// exports and the hashes of the APIs of the packages it was checked
+// against. A summary can be used while the package's files and the APIs
+// of its imports are unchanged. When a package's API changes the
+// summaries of the packages which import it, directly or not, are
+// removed.
+//
+// It also keeps the generated code for each declaration along with the
+// declaration's structural hash. The code is removed when the declaration
+// changes or when the API of a package it might depend on changes.
+type BuildCache struct {
+	path string   // the cache file.
+	db   *bolt.DB // the database in the file.
+
+	mutex  sync.Mutex // guards the following.
+	hits   uint64     // summaries which have been used since it was opened.
+	misses uint64     // packages which have been checked since it was opened.
+}
+
+// type BuildCacheStats describes what's in a build cache.
+type BuildCacheStats struct {
+	Path     string // the cache file.
+	Size     int64  // the size of the database in bytes.
+	Packages int    // how many package summaries there are.
+	Code     int    // how many declarations have generated code.
+	Hits     uint64 // how many times a summary has been used instead of checking a package.
+	Misses   uint64 // how many times a package has had to be checked.
+}
+
+// type packageSummary is what the build cache knows about a package. It's
+// gob encoded so the fields are exported.
+type packageSummary struct {
+	Options  string             // the compiler options it was checked with.
+	Files    map[string]string  // the content hash of each file, by file name.
+	Decls    map[string]string  // the structural hash of each declaration, by declaration name.
+	Imports  map[string]string  // the API hash of each imported package it was checked against, by import path.
+	API      string             // the hash of the API it exports.
+	Warnings []cachedDiagnostic // the warnings from checking it.
+	Escapes  string             // the escape analysis decisions, if they were asked for.
+}
+
+// type cachedDiagnostic is a warning kept in a package summary.
+type cachedDiagnostic struct {
+	Filename string
+	Start    SrcLoc
+	End      SrcLoc
+	Message  string
+	Severity Severity
+}
+
+// type cachedCode is the generated code for a declaration.
+type cachedCode struct {
+	Hash string // the structural hash of the declaration it was generated from.
+	Code []byte
+}
+
+// DefaultBuildCachePath gives where the build cache is kept - the file
+// named by the GOLIGHTLY_CACHE environment variable, or golightly/build.db
+// in the user's cache directory.
+func DefaultBuildCachePath() (string, error) {
+	if path := os.Getenv("GOLIGHTLY_CACHE"); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("I can't find a directory for the build cache: %v", err)
+	}
+
+	return filepath.Join(dir, "golightly", "build.db"), nil
+}
+
+// OpenBuildCache opens the build cache in a file, creating it if it
+// doesn't exist. Only one process can have it open at a time so it waits
+// a moment for any other to finish.
+func OpenBuildCache(path string) (*BuildCache, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("I can't create the build cache %s: %v", path, err)
+	}
+
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: buildCacheTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("I can't open the build cache %s: %v", path, err)
+	}
+
+	bc := &BuildCache{path: path, db: db}
+	if err := db.Update(bc.createBuckets); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("I can't open the build cache %s: %v", path, err)
+	}
+
+	return bc, nil
+}
+
+// createBuckets makes sure all the buckets exist.
+func (bc *BuildCache) createBuckets(tx *bolt.Tx) error {
+	for _, name := range [][]byte{packagesBucket, codeBucket, statsBucket} {
+		if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Path gives the name of the cache file.
+func (bc *BuildCache) Path() string {
+	return bc.path
+}
+
+// Close saves how often summaries were used and closes the file.
+func (bc *BuildCache) Close() error {
+	bc.mutex.Lock()
+	hits, misses := bc.hits, bc.misses
+	bc.hits, bc.misses = 0, 0
+	bc.mutex.Unlock()
+
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		stats := tx.Bucket(statsBucket)
+		if err := addCounter(stats, "hits", hits); err != nil {
+			return err
+		}
+		return addCounter(stats, "misses", misses)
+	})
+	if closeErr := bc.db.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Stats describes what's in the cache.
+func (bc *BuildCache) Stats() (BuildCacheStats, error) {
+	stats := BuildCacheStats{Path: bc.path}
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		stats.Size = tx.Size()
+		stats.Packages = tx.Bucket(packagesBucket).Stats().KeyN
+		stats.Code = tx.Bucket(codeBucket).Stats().KeyN
+		stats.Hits = counter(tx.Bucket(statsBucket), "hits")
+		stats.Misses = counter(tx.Bucket(statsBucket), "misses")
+		return nil
+	})
+
+	bc.mutex.Lock()
+	stats.Hits += bc.hits
+	stats.Misses += bc.misses
+	bc.mutex.Unlock()
+
+	return stats, err
+}
+
+// Clean removes everything from the cache.
+func (bc *BuildCache) Clean() error {
+	bc.mutex.Lock()
+	bc.hits, bc.misses = 0, 0
+	bc.mutex.Unlock()
+
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{packagesBucket, codeBucket, statsBucket} {
+			if err := tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		return bc.createBuckets(tx)
+	})
+}
+
+// Code gives the generated code for a declaration, if there's some which
+// was generated from a declaration with the same structural hash.
+func (bc *BuildCache) Code(packagePath string, decl string, hash string) ([]byte, bool) {
+	var code []byte
+	bc.db.View(func(tx *bolt.Tx) error {
+		var cached cachedCode
+		if decodeCached(tx.Bucket(codeBucket).Get(codeKey(packagePath, decl)), &cached) && cached.Hash == hash {
+			code = append([]byte{}, cached.Code...)
+		}
+		return nil
+	})
+
+	return code, code != nil
+}
+
+// PutCode stores the generated code for a declaration with the
+// declaration's structural hash.
+func (bc *BuildCache) PutCode(packagePath string, decl string, hash string, code []byte) error {
+	value, err := encodeCached(cachedCode{hash, code})
+	if err != nil {
+		return err
+	}
+
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(codeBucket).Put(codeKey(packagePath, decl), value)
+	})
+}
+
+// countLookup counts whether a package summary could be used.
+func (bc *BuildCache) countLookup(hit bool) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	if hit {
+		bc.hits++
+	} else {
+		bc.misses++
+	}
+}
+
+// summary gives the summary of a package, or nil if there isn't one.
+func (bc *BuildCache) summary(packagePath string) *packageSummary {
+	var summary *packageSummary
+	bc.db.View(func(tx *bolt.Tx) error {
+		summary = getSummary(tx, packagePath)
+		return nil
+	})
+
+	return summary
+}
+
+// putSummary stores the summary of a package which has just been checked.
+// If its API has changed the packages which depend on it are removed. Any
+// generated code which may depend on something which has changed is
+// removed too.
+func (bc *BuildCache) putSummary(packagePath string, summary *packageSummary) error {
+	value, err := encodeCached(summary)
+	if err != nil {
+		return err
+	}
+
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		old := getSummary(tx, packagePath)
+		if old != nil && old.API != summary.API {
+			if err := invalidateImporters(tx, packagePath); err != nil {
+				return err
+			}
+		}
+
+		importsChanged := old == nil || !stringMapsEqual(old.Imports, summary.Imports)
+		err := deleteCode(tx, packagePath, func(decl string, hash string) bool {
+			return importsChanged || summary.Decls[decl] != hash
+		})
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(packagesBucket).Put([]byte(packagePath), value)
+	})
+}
+
+// invalidateImporters removes the summaries and code of the packages which
+// import a package, directly or not.
+func invalidateImporters(tx *bolt.Tx, packagePath string) error {
+	importers := make(map[string][]string)
+	err := tx.Bucket(packagesBucket).ForEach(func(key []byte, value []byte) error {
+		var summary packageSummary
+		if decodeCached(value, &summary) {
+			for importPath := range summary.Imports {
+				importers[importPath] = append(importers[importPath], string(key))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	removed := map[string]bool{packagePath: true}
+	queue := []string{packagePath}
+	for len(queue) > 0 {
+		path := queue[0]
+		queue = queue[1:]
+		for _, importer := range importers[path] {
+			if removed[importer] {
+				continue
+			}
+			removed[importer] = true
+			queue = append(queue, importer)
+
+			if err := tx.Bucket(packagesBucket).Delete([]byte(importer)); err != nil {
+				return err
+			}
+			if err := deleteCode(tx, importer, func(string, string) bool { return true }); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// deleteCode removes the generated code of a package's declarations which
+// remove says to.
+func deleteCode(tx *bolt.Tx, packagePath string, remove func(decl string, hash string) bool) error {
+	prefix := codeKey(packagePath, "")
+	var keys [][]byte
+	cursor := tx.Bucket(codeBucket).Cursor()
+	for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+		var cached cachedCode
+		if !decodeCached(value, &cached) || remove(string(key[len(prefix):]), cached.Hash) {
+			keys = append(keys, append([]byte(nil), key...))
+		}
+	}
+
+	for _, key := range keys {
+		if err := tx.Bucket(codeBucket).Delete(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getSummary reads a package summary, giving nil if there isn't one or it
+// can't be read.
+func getSummary(tx *bolt.Tx, packagePath string) *packageSummary {
+	summary := new(packageSummary)
+	if !decodeCached(tx.Bucket(packagesBucket).Get([]byte(packagePath)), summary) {
+		return nil
+	}
+
+	return summary
+}
+
+// codeKey gives the key a declaration's code is kept under. An import path
+// can't contain a NUL so it separates the two.
+func codeKey(packagePath string, decl string) []byte {
+	return []byte(packagePath + "\x00" + decl)
+}
+
+func encodeCached(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decodeCached decodes something from the cache, returning false if it
+// isn't there or can't be decoded. An entry written by a different version
+// of the compiler is treated as missing.
+func decodeCached(value []byte, v interface{}) bool {
+	return value != nil && gob.NewDecoder(bytes.NewReader(value)).Decode(v) == nil
+}
+
+func counter(b *bolt.Bucket, name string) uint64 {
+	if value := b.Get([]byte(name)); len(value) == 8 {
+		return binary.BigEndian.Uint64(value)
+	}
+
+	return 0
+}
+
+func addCounter(b *bolt.Bucket, name string, n uint64) error {
+	var value [8]byte
+	binary.BigEndian.PutUint64(value[:], counter(b, name)+n)
+	return b.Put([]byte(name), value[:])
+}
+
+func stringMapsEqual(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newPackageSummary summarises a package which has just been checked
+// without errors.
+func newPackageSummary(options string, files []*sourceFile, scope *SymbolTable, packagePath string, apis map[string]string, warnings ErrorList) *packageSummary {
+	summary := &packageSummary{
+		Options: options,
+		Files:   make(map[string]string),
+		Decls:   declHashes(files),
+		Imports: make(map[string]string),
+		API:     exportedAPIHash(scope, packagePath, files),
+	}
+	for _, sf := range files {
+		summary.Files[sf.fileName] = sf.contentHash
+	}
+	for _, importPath := range packageImports(files) {
+		summary.Imports[importPath] = apis[importPath]
+	}
+	for _, warning := range warnings {
+		summary.Warnings = append(summary.Warnings, cachedDiagnostic{warning.filename, warning.pos.start, warning.pos.end, warning.message, warning.severity})
+	}
+
+	return summary
+}
+
+// usable returns true if the summary is still right for a package - its
+// files haven't changed and neither have the APIs of the packages it
+// imports.
+func (summary *packageSummary) usable(options string, files []*sourceFile, apis map[string]string) bool {
+	if summary.Options != options || len(summary.Files) != len(files) {
+		return false
+	}
+	for _, sf := range files {
+		if sf.contentHash == "" || summary.Files[sf.fileName] != sf.contentHash {
+			return false
+		}
+	}
+
+	for _, importPath := range packageImports(files) {
+		if api, ok := apis[importPath]; !ok || summary.Imports[importPath] != api {
+			return false
+		}
+	}
+
+	return true
+}
+
+// diagnostics gives the warnings which were found when the package was
+// checked.
+func (summary *packageSummary) diagnostics() ErrorList {
+	var diagnostics ErrorList
+	for _, cd := range summary.Warnings {
+		err := NewError(cd.Filename, SrcSpan{cd.Start, cd.End}, cd.Message)
+		err.severity = cd.Severity
+		diagnostics = append(diagnostics, err)
+	}
+
+	return diagnostics
+}
+
+// declName gives the name a package level declaration is known by in the
+// cache. Methods are named after their receiver's type.
+func declName(decl AST) string {
+	switch d := decl.(type) {
+	case ASTFunctionDecl:
+		if recv, ok := d.receiver.(ASTReceiver); ok {
+			return recv.typeName + "." + d.name
+		}
+		return d.name
+	case ASTConstDecl:
+		return d.ident.(ASTIdentifier).name
+	case ASTVarDecl:
+		return d.ident.(ASTIdentifier).name
+	case ASTDataTypeDecl:
+		return d.ident.(ASTIdentifier).name
+	}
+
+	return ""
+}
+
+// declHashes gives the structural hash of each of a package's
+// declarations by name. Names which can be declared more than once, like
+// init and _, are numbered in the order they're declared.
+func declHashes(files []*sourceFile) map[string]string {
+	hashes := make(map[string]string)
+	for _, sf := range files {
+		for _, decl := range sf.ast.(ASTTopLevel).topLevelDecls {
+			name := declName(decl)
+			for n := 2; hashes[name] != ""; n++ {
+				name = fmt.Sprintf("%s#%d", declName(decl), n)
+			}
+			hashes[name] = structuralHash(decl)
+		}
+	}
+
+	return hashes
+}
+
+// exportedAPIHash hashes everything about a package which the packages
+// importing it can depend on - its exported names with their types and
+// constant values, the underlying types and methods of every type they
+// use, and the declarations of its generic functions and types, which are
+// instantiated by the importers. The types used are only written by name
+// so the ones which are unexported, or from other packages, are hashed
+// too.
+func exportedAPIHash(scope *SymbolTable, packagePath string, files []*sourceFile) string {
+	h := sha256.New()
+	seen := make(map[DataType]bool)
+	var types []*DataTypeNamed
+	for _, sym := range scope.Symbols() {
+		if !isExported(sym.name) {
+			continue
+		}
+
+		fmt.Fprintf(h, "%s %s %s", sym.kind, sym.name, TypeString(sym.typ, packagePath))
+		if sym.kind == SymbolConst {
+			hashValue(h, reflect.ValueOf(sym.val), make(map[uintptr]bool))
+		}
+		io.WriteString(h, "\n")
+		types = namedTypesIn(sym.typ, seen, types)
+	}
+
+	// more types can be found while they're being hashed.
+	for i := 0; i < len(types); i++ {
+		named := types[i]
+		fmt.Fprintf(h, "type %s.%s %s", named.pkg, named.name, TypeString(named.Underlying(), packagePath))
+		types = namedTypesIn(named.Underlying(), seen, types)
+		for _, method := range named.Methods() {
+			fmt.Fprintf(h, " %s %v %s", method.name, method.pointerReceiver, TypeString(method.sig, packagePath))
+			types = namedTypesIn(method.sig, seen, types)
+		}
+		io.WriteString(h, "\n")
+	}
+
+	for _, sf := range files {
+		for _, decl := range sf.ast.(ASTTopLevel).topLevelDecls {
+			if isGenericDecl(decl) {
+				fmt.Fprintf(h, "%s %s\n", declName(decl), structuralHash(decl))
+			}
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// namedTypesIn adds the named types which dt is made from to types, apart
+// from the ones which have been seen already. Instances of generic types
+// add the generic type and the type arguments.
+func namedTypesIn(dt DataType, seen map[DataType]bool, types []*DataTypeNamed) []*DataTypeNamed {
+	if dt == nil || seen[dt] {
+		return types
+	}
+	seen[dt] = true
+
+	var parts []DataType
+	switch t := dt.(type) {
+	case *DataTypeNamed:
+		if t.origin == nil {
+			return append(types, t)
+		}
+		parts = append([]DataType{t.origin}, t.typeArgs...)
+	case *DataTypeUnary:
+		parts = []DataType{t.subType}
+	case *DataTypeArray:
+		parts = []DataType{t.elementType}
+	case *DataTypeMap:
+		parts = []DataType{t.keyType, t.valueType}
+	case *DataTypeChan:
+		parts = []DataType{t.elementType}
+	case *DataTypeFunc:
+		parts = append(append(parts, t.params...), t.returns...)
+	case *DataTypeStruct:
+		for _, field := range t.fields {
+			parts = append(parts, field.typ)
+		}
+	case *DataTypeInterface:
+		for _, method := range t.methods {
+			parts = append(parts, method.sig)
+		}
+		for _, term := range t.terms {
+			parts = append(parts, term.typ)
+		}
+	}
+
+	for _, part := range parts {
+		types = namedTypesIn(part, seen, types)
+	}
+
+	return types
+}
+
+// isGenericDecl returns true if a declaration is of a generic function or
+// type, or of a method of a generic type.
+func isGenericDecl(decl AST) bool {
+	switch d := decl.(type) {
+	case ASTFunctionDecl:
+		recv, ok := d.receiver.(ASTReceiver)
+		return len(d.typeParams) > 0 || ok && len(recv.typeParams) > 0
+	case ASTDataTypeDecl:
+		return len(d.typeParams) > 0
+	}
+
+	return false
+}
+
+// structuralHash hashes an AST without the source positions in it, so a
+// declaration which has only moved, or which has had comments or spacing
+// changed, still has the same hash.
+func structuralHash(ast AST) string {
+	h := sha256.New()
+	hashValue(h, reflect.ValueOf(ast), make(map[uintptr]bool))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+var (
+	srcSpanType       = reflect.TypeOf(SrcSpan{})
+	dataTypeNamedType = reflect.TypeOf((*DataTypeNamed)(nil))
+)
+
+// hashValue writes out the structure of a value for structuralHash. Source
+// positions and locks are left out and defined types are written as their
+// names. seen has the pointers being written, so cycles end.
+func hashValue(w io.Writer, v reflect.Value, seen map[uintptr]bool) {
+	switch v.Kind() {
+	case reflect.Invalid:
+		io.WriteString(w, "nil;")
+
+	case reflect.Bool:
+		fmt.Fprint(w, v.Bool(), ";")
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		fmt.Fprint(w, v.Int(), ";")
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		fmt.Fprint(w, v.Uint(), ";")
+
+	case reflect.Float32, reflect.Float64:
+		fmt.Fprint(w, v.Float(), ";")
+
+	case reflect.Complex64, reflect.Complex128:
+		fmt.Fprint(w, v.Complex(), ";")
+
+	case reflect.String:
+		fmt.Fprintf(w, "%q;", v.String())
+
+	case reflect.Interface:
+		if v.IsNil() {
+			io.WriteString(w, "nil;")
+			return
+		}
+		hashValue(w, v.Elem(), seen)
+
+	case reflect.Ptr:
+		switch {
+		case v.IsNil():
+			io.WriteString(w, "nil;")
+		case v.Type() == dataTypeNamedType:
+			fmt.Fprintf(w, "%s %q %q;", v.Type(), v.Elem().FieldByName("pkg").String(), v.Elem().FieldByName("name").String())
+		case seen[v.Pointer()]:
+			io.WriteString(w, "cycle;")
+		default:
+			seen[v.Pointer()] = true
+			io.WriteString(w, "&")
+			hashValue(w, v.Elem(), seen)
+			delete(seen, v.Pointer())
+		}
+
+	case reflect.Struct:
+		if v.Type() == srcSpanType || v.Type().PkgPath() == "sync" {
+			return
+		}
+		fmt.Fprint(w, v.Type(), "{")
+		for i := 0; i < v.NumField(); i++ {
+			hashValue(w, v.Field(i), seen)
+		}
+		io.WriteString(w, "}")
+
+	case reflect.Slice, reflect.Array:
+		fmt.Fprint(w, "[", v.Len(), ":")
+		for i := 0; i < v.Len(); i++ {
+			hashValue(w, v.Index(i), seen)
+		}
+		io.WriteString(w, "]")
+
+	case reflect.Map:
+		// map entries are hashed separately so they can be put in order.
+		entries := make([]string, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			var entry strings.Builder
+			hashValue(&entry, iter.Key(), seen)
+			hashValue(&entry, iter.Value(), seen)
+			entries = append(entries, entry.String())
+		}
+		sort.Strings(entries)
+		fmt.Fprint(w, "map[", strings.Join(entries, ","), "]")
+
+	default:
+		// functions and channels have nothing which can be compared.
+		fmt.Fprint(w, v.Kind(), ";")
+	}
+}
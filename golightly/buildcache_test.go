@@ -0,0 +1,115 @@
+package golightly
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestStructuralHash(t *testing.T) {
+	hashes := func(src string) map[string]string {
+		sf := &sourceFile{ast: ASTTopLevel{topLevelDecls: parseTestDecls(t, src)}}
+		return declHashes([]*sourceFile{sf})
+	}
+
+	before := hashes("const A = 1;\ntype T struct { x int; };\nfunc (t T) M(a int) int;\nfunc init();\nfunc init();\n")
+	after := hashes("\n\n// moved down\nconst A   =   1;\ntype T struct {\n\tx int;\n};\nfunc (t T) M(b int) int;\nfunc init();\nfunc init();\n")
+
+	for _, name := range []string{"A", "T", "T.M", "init", "init#2"} {
+		if before[name] == "" {
+			t.Error("there should be a hash for ", name)
+		}
+	}
+	if before["A"] != after["A"] || before["T"] != after["T"] || before["init#2"] != after["init#2"] {
+		t.Error("moving declarations shouldn't change their hashes")
+	}
+	if before["T.M"] == after["T.M"] {
+		t.Error("renaming a parameter should change the method's hash")
+	}
+	if hashes("const A = 2;\n")["A"] == before["A"] {
+		t.Error("changing a constant's value should change its hash")
+	}
+}
+
+func TestBuildCacheCompile(t *testing.T) {
+	cache, err := OpenBuildCache(filepath.Join(t.TempDir(), "cache", "build.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	root := t.TempDir()
+	compile := func(files map[string]string) error {
+		writeTestFiles(t, root, files)
+		c := NewCompiler()
+		c.SetLenient(true)
+		c.SetCache(cache)
+		err := c.Compile([]string{root})
+		c.Close()
+		return err
+	}
+	expect := func(hits uint64, misses uint64) {
+		t.Helper()
+		stats, err := cache.Stats()
+		if err != nil || stats.Hits != hits || stats.Misses != misses {
+			t.Error("expected ", hits, " hits and ", misses, " misses but got ", stats.Hits, " and ", stats.Misses, " ", err)
+		}
+	}
+
+	main := "package main;\nimport \"example.com/app/lib\";\nimport \"example.com/app/unused\";\nconst answer = lib.Answer;\n"
+	err = compile(map[string]string{
+		"go.mod":           "module example.com/app\n",
+		"main.go":          main,
+		"lib/lib.go":       "package lib;\nconst Answer = 42;\n",
+		"unused/unused.go": "package unused;\n",
+	})
+	if err != nil {
+		t.Fatal("error compiling: ", err)
+	}
+	expect(0, 3)
+
+	// nothing's changed so nothing's checked. the warnings are still given.
+	c := NewCompiler()
+	c.SetLenient(true)
+	c.SetCache(cache)
+	err = c.Compile([]string{root})
+	c.Close()
+	if err != nil || len(c.Warnings()) != 1 || c.Warnings()[0].Severity() != SeverityWarning {
+		t.Error("expected the warning about the unused import but got: ", c.Warnings(), err)
+	}
+	expect(3, 3)
+
+	// a change which doesn't change lib's API only needs lib checked.
+	compile(map[string]string{"lib/lib.go": "package lib;\n\n// the answer.\nconst Answer = 42;\nconst hidden = 1;\n"})
+	expect(5, 4)
+
+	// a change to the API needs main checked too, and makes the cache
+	// forget about main before then.
+	compile(map[string]string{"lib/lib.go": "package lib;\nconst Answer = \"42\";\n"})
+	expect(6, 6)
+
+	// main has an error after this so it isn't kept.
+	if err := compile(map[string]string{"lib/lib.go": "package lib;\nconst Question = 1;\n"}); err == nil {
+		t.Error("main should fail to compile")
+	}
+	if cache.summary(mainPackagePath) != nil {
+		t.Error("main's summary should have gone when lib's API changed")
+	}
+
+	// the API includes the types it uses, even unexported ones, so
+	// changing their fields or methods needs main checked too.
+	main = "package main;\nimport \"example.com/app/lib\";\nimport \"example.com/app/unused\";\nvar c = lib.C;\n"
+	if err := compile(map[string]string{"main.go": main, "lib/lib.go": "package lib;\ntype t struct { X int; };\nvar C t;\n"}); err != nil {
+		t.Fatal("error compiling: ", err)
+	}
+	expect(8, 10)
+	compile(map[string]string{"lib/lib.go": "package lib;\ntype t struct { Y string; };\nvar C t;\n"})
+	expect(9, 12)
+	compile(map[string]string{"lib/lib.go": "package lib;\ntype t int;\nvar C t;\n"})
+	expect(10, 14)
+	compile(map[string]string{"lib/lib.go": "package lib;\ntype t int;\nfunc (t) M();\nvar C t;\n"})
+	expect(11, 16)
+
+	// types the API doesn't use aren't part of it.
+	compile(map[string]string{"lib/lib.go": "package lib;\ntype t int;\nfunc (t) M();\nvar C t;\ntype u int;\n"})
+	expect(13, 17)
+}
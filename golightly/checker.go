@@ -0,0 +1,938 @@
+package golightly
+
+import (
+	"fmt"
+	"strings"
+)
+
+// type builtinID identifies one of the builtin functions.
+type builtinID int
+
+const (
+	builtinAppend builtinID = iota
+	builtinCap
+	builtinClear
+	builtinClose
+	builtinComplex
+	builtinCopy
+	builtinDelete
+	builtinImag
+	builtinLen
+	builtinMake
+	builtinMax
+	builtinMin
+	builtinNew
+	builtinPanic
+	builtinPrint
+	builtinPrintln
+	builtinReal
+	builtinRecover
+
+	// the functions of package unsafe.
+	builtinAlignof
+	builtinOffsetof
+	builtinSizeof
+)
+
+// the names of the builtin functions.
+var builtinNames = map[string]builtinID{
+	"append":  builtinAppend,
+	"cap":     builtinCap,
+	"clear":   builtinClear,
+	"close":   builtinClose,
+	"complex": builtinComplex,
+	"copy":    builtinCopy,
+	"delete":  builtinDelete,
+	"imag":    builtinImag,
+	"len":     builtinLen,
+	"make":    builtinMake,
+	"max":     builtinMax,
+	"min":     builtinMin,
+	"new":     builtinNew,
+	"panic":   builtinPanic,
+	"print":   builtinPrint,
+	"println": builtinPrintln,
+	"real":    builtinReal,
+	"recover": builtinRecover,
+}
+
+// the names of the functions in package unsafe.
+var unsafeBuiltinNames = map[string]builtinID{
+	"Alignof":  builtinAlignof,
+	"Offsetof": builtinOffsetof,
+	"Sizeof":   builtinSizeof,
+}
+
+// the names of the predeclared types.
+var predeclaredTypeNames = []string{
+	"bool", "byte", "complex64", "complex128", "error", "float32", "float64", "int", "int8", "int16", "int32",
+	"int64", "rune", "string", "uint", "uint8", "uint16", "uint32", "uint64", "uintptr", "any", "comparable",
+}
+
+// type exprKey identifies an expression by where it is in the source.
+// Every expression in a file has a distinct span.
+type exprKey struct {
+	filename string
+	pos      SrcSpan
+}
+
+// type TypeInfo holds the results of type checking a package.
+type TypeInfo struct {
+	types     map[exprKey]DataType      // the type of every expression
+	values    map[exprKey]Constant      // the value of every constant expression
+	instances map[exprKey]*FuncInstance // the instance of every generic function which is used
+	defs      map[exprKey]*Symbol       // the symbol each declaration declares
+	uses      map[exprKey]*Symbol       // the symbol each identifier refers to
+	initPlan  *InitPlan                 // the order the package is initialised in
+	reachable map[*Symbol]bool          // the package level declarations and methods which can be reached
+	reached   []*Symbol                 // the same, in the order they were found
+}
+
+// InitPlan returns the order the package's variables are initialised in
+// and its init functions are run in.
+func (ti *TypeInfo) InitPlan() *InitPlan {
+	return ti.initPlan
+}
+
+// TypeOf returns the type of an expression from a file. It returns nil if
+// the expression hasn't been type checked.
+func (ti *TypeInfo) TypeOf(filename string, ast AST) DataType {
+	return ti.types[exprKey{filename, ast.Pos()}]
+}
+
+// ValueOf returns the value of a constant expression from a file. The
+// second result is false if the expression isn't constant.
+func (ti *TypeInfo) ValueOf(filename string, ast AST) (Constant, bool) {
+	val, ok := ti.values[exprKey{filename, ast.Pos()}]
+	return val, ok
+}
+
+// InstanceOf returns the instance of a generic function which an
+// expression refers to, either explicitly instantiated or with its type
+// arguments inferred from a call. It returns nil if the expression isn't a
+// generic function.
+func (ti *TypeInfo) InstanceOf(filename string, ast AST) *FuncInstance {
+	return ti.instances[exprKey{filename, ast.Pos()}]
+}
+
+// ObjectOf returns the symbol an identifier refers to, or the symbol it
+// declares if it's the name in a declaration. It returns nil for
+// identifiers which haven't been resolved, such as field names.
+func (ti *TypeInfo) ObjectOf(filename string, ast AST) *Symbol {
+	key := exprKey{filename, ast.Pos()}
+	if sym, ok := ti.uses[key]; ok {
+		return sym
+	}
+
+	return ti.defs[key]
+}
+
+// type Checker type checks the parsed AST of a package. It assigns a
+// DataType to every expression and checks that the rules of the language
+// are followed - eg. that values are assignable to the variables they're
+// assigned to and operators are used on the right kinds of operands.
+type Checker struct {
+	ts          *DataTypeStore  // the data type store.
+	packagePath string          // the import path of the package being checked, which identifies it.
+	packageName string          // the name the package is declared with.
+	info        *TypeInfo       // the results of checking.
+	errors      []*Error        // the errors found so far.
+	warnings    []*Error        // the warnings found so far.
+	lenient     bool            // true to report unused names and missing returns as warnings.
+	printfLike  map[string]bool // the functions vet checks calls to as if they were fmt.Printf.
+
+	universe   *SymbolTable            // the predeclared names.
+	pkgScope   *SymbolTable            // the package level names.
+	fileScopes map[string]*SymbolTable // the imported package names of each file.
+	imports    map[string]*SymbolTable // the package scopes of the imported packages, by import path.
+	funcs      map[exprKey]*Symbol     // the symbols of methods and init functions, by where they're declared.
+	calls      map[*Symbol][]string    // the names of the methods each package level declaration calls through interfaces.
+	converted  map[*Symbol][]DataType  // the types each package level declaration converts to interfaces.
+
+	// the following track where we are while checking.
+	filename     string        // the file currently being checked.
+	scope        *SymbolTable  // the innermost scope.
+	sig          *DataTypeFunc // the signature of the function being checked.
+	namedResults bool          // true if the function being checked has named results.
+	iota         int           // the value of iota in the constant declaration being checked, or -1.
+	localVars    []*Symbol     // the variables declared in the body of the function being checked.
+	decl         *Symbol       // the package level declaration being checked, which references are recorded against.
+}
+
+// NewChecker creates a type checker for a package. Packages are told
+// apart by their import path rather than their name, since two packages
+// can have the same name. The name is taken from the files when they're
+// checked.
+func NewChecker(ts *DataTypeStore, packagePath string) *Checker {
+	c := new(Checker)
+	c.ts = ts
+	c.packagePath = packagePath
+	c.packageName = packagePath
+	c.info = &TypeInfo{make(map[exprKey]DataType), make(map[exprKey]Constant), make(map[exprKey]*FuncInstance), make(map[exprKey]*Symbol), make(map[exprKey]*Symbol), nil, make(map[*Symbol]bool), nil}
+
+	// set up the predeclared names.
+	c.universe = NewSymbolTable(ScopeUniverse, nil)
+	for _, name := range predeclaredTypeNames {
+		c.universe.syms[name] = &Symbol{kind: SymbolType, name: name, typ: ts.LookupName(name)}
+	}
+	for name, id := range builtinNames {
+		c.universe.syms[name] = &Symbol{kind: SymbolBuiltin, name: name, builtin: id}
+	}
+	c.universe.syms["true"] = &Symbol{kind: SymbolConst, name: "true", typ: ts.UntypedBoolType(), val: MakeBoolConstant(true)}
+	c.universe.syms["false"] = &Symbol{kind: SymbolConst, name: "false", typ: ts.UntypedBoolType(), val: MakeBoolConstant(false)}
+	c.universe.syms["iota"] = &Symbol{kind: SymbolConst, name: "iota", typ: ts.UntypedIntType()}
+	c.universe.syms["nil"] = &Symbol{kind: SymbolNil, name: "nil", typ: ts.UntypedNilType()}
+
+	c.pkgScope = NewSymbolTable(ScopePackage, c.universe)
+	c.fileScopes = make(map[string]*SymbolTable)
+	c.imports = make(map[string]*SymbolTable)
+	c.funcs = make(map[exprKey]*Symbol)
+	c.calls = make(map[*Symbol][]string)
+	c.converted = make(map[*Symbol][]DataType)
+	c.scope = c.pkgScope
+	c.iota = -1
+
+	return c
+}
+
+// Info returns the results of type checking.
+func (c *Checker) Info() *TypeInfo {
+	return c.info
+}
+
+// Scope returns the package scope. Its parent is the universe scope and
+// its children are the scopes of the files.
+func (c *Checker) Scope() *SymbolTable {
+	return c.pkgScope
+}
+
+// FileScope returns the scope of a file, which has the packages it
+// imports. It returns nil if the file hasn't been checked.
+func (c *Checker) FileScope(filename string) *SymbolTable {
+	return c.fileScopes[filename]
+}
+
+// AddImport makes the exported names of a package which has already been
+// checked available to the files which import it. scope is the package's
+// Checker.Scope().
+func (c *Checker) AddImport(path string, scope *SymbolTable) {
+	c.imports[path] = scope
+}
+
+// SetLenient makes the checks which gc rejects programs for but which
+// don't change what a program means - unused imports, variables and
+// labels, ':=' without any new variables and missing returns - give
+// warnings instead of errors. It's handy for scripts.
+func (c *Checker) SetLenient(lenient bool) {
+	c.lenient = lenient
+}
+
+// Warnings returns the problems which were reported as warnings because
+// the checker is lenient.
+func (c *Checker) Warnings() []*Error {
+	return c.warnings
+}
+
+// errorf reports an error at a position in the current file.
+func (c *Checker) errorf(pos SrcSpan, format string, args ...interface{}) {
+	c.errors = append(c.errors, NewErrorf(c.filename, pos, c.packagePath, format, args...))
+}
+
+// softErrorf reports an error which is only a warning if the checker is
+// lenient.
+func (c *Checker) softErrorf(pos SrcSpan, format string, args ...interface{}) {
+	err := NewErrorf(c.filename, pos, c.packagePath, format, args...)
+	if c.lenient {
+		err.severity = SeverityWarning
+		c.warnings = append(c.warnings, err)
+	} else {
+		c.errors = append(c.errors, err)
+	}
+}
+
+// typeString gives a type in Go syntax as it'd be written in the package
+// being checked.
+func (c *Checker) typeString(dt DataType) string {
+	return TypeString(dt, c.packagePath)
+}
+
+// CheckFiles type checks all the files of a package. It returns all the
+// errors found.
+func (c *Checker) CheckFiles(files []*sourceFile) []*Error {
+	// types are declared in the data type store. they can refer to each
+	// other from any file.
+	if len(files) > 0 && files[0].ast.(ASTTopLevel).packageName != "" {
+		c.packageName = files[0].ast.(ASTTopLevel).packageName
+	}
+	c.errors = append(c.errors, c.ts.DeclarePackageTypes(c.packagePath, files)...)
+
+	// declare all the package level names so they can be used in any order.
+	for _, sf := range files {
+		c.collectDecls(sf)
+	}
+
+	// an imported package name can't be declared in the package as well.
+	for _, sf := range files {
+		c.filename = sf.fileName
+		for _, sym := range c.fileScopes[sf.fileName].Symbols() {
+			if other := c.pkgScope.LookupLocal(sym.name); other != nil {
+				c.errorf(sym.pos, "'%s' is the name of an imported package so it can't also be declared in this package", sym.name)
+			}
+		}
+	}
+
+	// methods need their receiver types to exist first.
+	for _, sf := range files {
+		c.filename = sf.fileName
+		for _, decl := range sf.ast.(ASTTopLevel).topLevelDecls {
+			if fd, ok := decl.(ASTFunctionDecl); ok && fd.receiver != nil {
+				if err := c.ts.DeclareMethod(sf.fileName, c.packagePath, fd); err != nil {
+					c.errors = append(c.errors, err.(*Error))
+				}
+			}
+		}
+	}
+
+	// work out the types of all the package level symbols.
+	for _, sf := range files {
+		for _, decl := range sf.ast.(ASTTopLevel).topLevelDecls {
+			switch d := decl.(type) {
+			case ASTDataTypeDecl:
+				if sym := c.info.defs[exprKey{sf.fileName, d.ident.Pos()}]; sym != nil && sym.typ != nil {
+					c.typeDecl(sf.fileName, d)
+				}
+			case ASTConstDecl:
+				c.symbolType(c.info.defs[exprKey{sf.fileName, d.ident.Pos()}])
+			case ASTVarDecl:
+				c.symbolType(c.info.defs[exprKey{sf.fileName, d.ident.Pos()}])
+			}
+		}
+	}
+
+	// now check all the function bodies.
+	for _, sf := range files {
+		c.filename = sf.fileName
+		c.scope = c.fileScopes[sf.fileName]
+		for _, decl := range sf.ast.(ASTTopLevel).topLevelDecls {
+			if fd, ok := decl.(ASTFunctionDecl); ok {
+				c.funcDecl(fd)
+			}
+		}
+	}
+
+	c.info.initPlan = c.initOrder(files)
+	c.reachability(files)
+
+	// every import has to be used in the file which imports it.
+	for _, sf := range files {
+		c.filename = sf.fileName
+		for _, sym := range c.fileScopes[sf.fileName].Symbols() {
+			if sym.kind != SymbolPackage || sym.used || sym.name == "." {
+				continue
+			}
+			if sym.name == sym.path[strings.LastIndex(sym.path, "/")+1:] {
+				c.softErrorf(sym.pos, "\"%s\" is imported but not used", sym.path)
+			} else {
+				c.softErrorf(sym.pos, "\"%s\" is imported as %s but not used", sym.path, sym.name)
+			}
+		}
+	}
+
+	return c.errors
+}
+
+// importPath gives the path of the package an import refers to.
+func importPath(imp ASTImport) string {
+	return imp.importPath.(ASTValue).val.(ValueString).val
+}
+
+// importName gives the name an import is known by in the importing file
+// and where that name comes from - either the name it's given or the last
+// element of its path.
+func importName(imp ASTImport) (string, SrcSpan) {
+	if imp.packageName != nil {
+		ident := imp.packageName.(ASTIdentifier)
+		return ident.name, ident.pos
+	}
+
+	path := importPath(imp)
+	return path[strings.LastIndex(path, "/")+1:], imp.importPath.Pos()
+}
+
+// collectDecls declares the package level names from a file.
+func (c *Checker) collectDecls(sf *sourceFile) {
+	c.filename = sf.fileName
+	decls := sf.ast.(ASTTopLevel).topLevelDecls
+
+	// imported package names are only visible in the file which imports
+	// them.
+	fileScope := NewSymbolTable(ScopeFile, c.pkgScope)
+	c.fileScopes[sf.fileName] = fileScope
+	for _, imp := range sf.ast.(ASTTopLevel).imports {
+		name, pos := importName(imp.(ASTImport))
+		sym := &Symbol{kind: SymbolPackage, name: name, filename: sf.fileName, pos: pos, path: importPath(imp.(ASTImport))}
+		c.info.defs[exprKey{sf.fileName, pos}] = sym
+		if err := fileScope.Insert(sym); err != nil {
+			c.errors = append(c.errors, err.(*Error))
+		}
+	}
+
+	for _, decl := range decls {
+		switch d := decl.(type) {
+		case ASTDataTypeDecl:
+			ident := d.ident.(ASTIdentifier)
+			typ := c.ts.LookupName(qualifiedName(c.packagePath, ident.name))
+			c.declarePackage(&Symbol{kind: SymbolType, name: ident.name, typ: typ, filename: sf.fileName, pos: ident.pos})
+
+		case ASTConstDecl:
+			ident := d.ident.(ASTIdentifier)
+			c.declarePackage(&Symbol{kind: SymbolConst, name: ident.name, filename: sf.fileName, pos: ident.pos, decl: d})
+
+		case ASTVarDecl:
+			ident := d.ident.(ASTIdentifier)
+			c.declarePackage(&Symbol{kind: SymbolVar, name: ident.name, filename: sf.fileName, pos: ident.pos, decl: d})
+
+		case ASTFunctionDecl:
+			if d.receiver != nil || d.name == "init" || d.name == "_" {
+				continue
+			}
+			c.declarePackage(&Symbol{kind: SymbolFunc, name: d.name, filename: sf.fileName, pos: d.pos, decl: d})
+		}
+	}
+}
+
+// declarePackage adds a symbol to the package scope.
+func (c *Checker) declarePackage(obj *Symbol) {
+	c.info.defs[exprKey{obj.filename, obj.pos}] = obj
+
+	// DeclarePackageTypes has already reported types which are declared
+	// twice.
+	if other := c.pkgScope.LookupLocal(obj.name); other != nil && other.kind == SymbolType && obj.kind == SymbolType {
+		return
+	}
+
+	if err := c.pkgScope.Insert(obj); err != nil {
+		c.errors = append(c.errors, err.(*Error))
+	}
+}
+
+// declare adds a symbol to the current scope.
+func (c *Checker) declare(obj *Symbol) {
+	c.info.defs[exprKey{obj.filename, obj.pos}] = obj
+	if err := c.scope.Insert(obj); err != nil {
+		c.errors = append(c.errors, err.(*Error))
+	}
+}
+
+// declareVar adds a variable declared in a function body to the current
+// scope. It has to be used before the end of the function.
+func (c *Checker) declareVar(obj *Symbol) {
+	c.declare(obj)
+	c.localVars = append(c.localVars, obj)
+}
+
+// lookup finds the symbol an identifier refers to in the current scope,
+// records it and marks it as used. It returns nil if there isn't one.
+func (c *Checker) lookup(ident ASTIdentifier) *Symbol {
+	sym, _ := c.scope.Lookup(ident.name)
+	if sym != nil {
+		sym.used = true
+		c.info.uses[exprKey{c.filename, ident.pos}] = sym
+	}
+
+	return sym
+}
+
+// openScope starts a new nested scope of the given kind.
+func (c *Checker) openScope(kind ScopeKind) {
+	c.scope = NewSymbolTable(kind, c.scope)
+}
+
+// closeScope ends the innermost scope.
+func (c *Checker) closeScope() {
+	c.scope = c.scope.parent
+}
+
+// symbolType returns the type of a symbol, resolving package level
+// declarations on demand.
+func (c *Checker) symbolType(obj *Symbol) DataType {
+	if obj == nil || obj.typ != nil || obj.decl == nil {
+		if obj == nil {
+			return nil
+		}
+		return obj.typ
+	}
+
+	if obj.resolving {
+		c.errorf(obj.pos, "'%s' is defined in terms of itself", obj.name)
+		return nil
+	}
+	obj.resolving = true
+	defer func() { obj.resolving = false }()
+
+	// package level declarations are checked in the scope of their file.
+	savedFilename, savedScope, savedSig, savedIota, savedDecl := c.filename, c.scope, c.sig, c.iota, c.decl
+	c.filename, c.scope, c.sig, c.decl = obj.filename, c.fileScopes[obj.filename], nil, obj
+	defer func() {
+		c.filename, c.scope, c.sig, c.iota, c.decl = savedFilename, savedScope, savedSig, savedIota, savedDecl
+	}()
+
+	switch d := obj.decl.(type) {
+	case ASTConstDecl:
+		obj.typ, obj.val = c.constDecl(d)
+	case ASTVarDecl:
+		obj.typ = c.varDecl(d)
+	case ASTFunctionDecl:
+		if len(d.typeParams) > 0 {
+			// the type parameters are only in scope in the declaration.
+			c.openScope(ScopeFunc)
+			obj.typeParams = c.declareTypeParams(d.typeParams)
+			if obj.typeParams != nil {
+				obj.typ = c.signature(d.params, d.returns)
+			}
+			c.closeScope()
+			break
+		}
+		obj.typ = c.signature(d.params, d.returns)
+	}
+
+	if obj.typ == nil {
+		// don't report errors about it again.
+		obj.decl = nil
+	}
+
+	return obj.typ
+}
+
+// typeDecl resolves the names used in a package level type declaration so
+// they're recorded. The type has already been declared by
+// DeclarePackageTypes, which has reported any errors.
+func (c *Checker) typeDecl(filename string, d ASTDataTypeDecl) {
+	savedFilename, savedScope, savedDecl := c.filename, c.scope, c.decl
+	c.filename, c.scope, c.decl = filename, c.fileScopes[filename], c.info.defs[exprKey{filename, d.ident.Pos()}]
+	defer func() { c.filename, c.scope, c.decl = savedFilename, savedScope, savedDecl }()
+
+	if len(d.typeParams) > 0 {
+		c.openScope(ScopeBlock)
+		defer c.closeScope()
+		named := c.ts.LookupName(qualifiedName(c.packagePath, d.ident.(ASTIdentifier).name)).(*DataTypeNamed)
+		for i, tp := range named.typeParams {
+			ident := d.typeParams[i].(ASTTypeParam).ident.(ASTIdentifier)
+			c.declare(&Symbol{kind: SymbolType, name: tp.name, typ: tp, filename: c.filename, pos: ident.pos})
+		}
+		for _, ast := range d.typeParams {
+			c.ts.makeASTConstraint(ast.(ASTTypeParam).constraint, c.typeContext())
+		}
+	}
+
+	c.ts.makeASTTypeOrConstraint(d.typ, c.typeContext())
+}
+
+// makeType converts a type expression into a type, reporting any errors.
+// It returns nil if the type is invalid.
+func (c *Checker) makeType(ast AST) DataType {
+	dt, err := c.ts.MakeASTType(ast, c.typeContext())
+	if err != nil {
+		c.errors = append(c.errors, err.(*Error))
+		return nil
+	}
+
+	return dt
+}
+
+// signature converts the parameters and results of a function declaration
+// into a function type, reporting any errors.
+func (c *Checker) signature(params []AST, returns []AST) *DataTypeFunc {
+	sig, err := c.ts.makeASTSignature(params, returns, c.typeContext())
+	if err != nil {
+		c.errors = append(c.errors, err.(*Error))
+		return nil
+	}
+
+	return sig
+}
+
+// typeContext gives the context for converting type expressions in the
+// current scope.
+func (c *Checker) typeContext() *TypeContext {
+	ctx := NewTypeContext(c.filename, c.packagePath, c.resolveType)
+	ctx.length = c.arrayLength
+	return ctx
+}
+
+// arrayLength evaluates the length of an array type, which can be any
+// constant expression.
+func (c *Checker) arrayLength(ast AST) (int64, error) {
+	x := c.expr(ast)
+	if x.mode == modeInvalid {
+		return 0, NewError(c.filename, ast.Pos(), "this array length is invalid")
+	}
+
+	if x.mode != modeConstant {
+		return 0, NewError(c.filename, ast.Pos(), "array lengths have to be constants")
+	}
+
+	if isUntyped(x.typ) || isInteger(x.typ) {
+		if n, ok := x.val.Int64(); ok && n >= 0 {
+			return n, nil
+		}
+	}
+
+	return 0, NewError(c.filename, ast.Pos(), fmt.Sprint("array lengths have to be a non-negative whole number, not ", x.val))
+}
+
+// resolveType finds the type a type name refers to.
+func (c *Checker) resolveType(ident ASTIdentifier) (DataType, error) {
+	if ident.packageName != "" {
+		pkg, _ := c.scope.Lookup(ident.packageName)
+		if pkg != nil {
+			pkg.used = true
+		}
+		if pkg == nil || pkg.kind != SymbolPackage {
+			return nil, NewError(c.filename, ident.Pos(), fmt.Sprint("I don't know of any package called '", ident.packageName, "'"))
+		}
+
+		obj, err := c.importedSymbol(ident, pkg)
+		if err != nil {
+			return nil, err
+		}
+		if obj.kind != SymbolType {
+			return nil, NewError(c.filename, ident.Pos(), fmt.Sprint("'", ident.packageName, ".", ident.name, "' isn't a type"))
+		}
+
+		return obj.typ, nil
+	}
+
+	obj := c.lookup(ident)
+	if obj == nil {
+		return nil, NewError(c.filename, ident.Pos(), fmt.Sprint("I don't know of any type called '", ident.name, "'"))
+	}
+	c.reference(obj)
+	if obj.kind != SymbolType {
+		return nil, NewError(c.filename, ident.Pos(), fmt.Sprint("'", ident.name, "' isn't a type"))
+	}
+	if obj.typ == nil {
+		return nil, NewError(c.filename, ident.Pos(), fmt.Sprint("'", ident.name, "' is an invalid type"))
+	}
+
+	return obj.typ, nil
+}
+
+// importedSymbol finds the symbol a qualified identifier refers to in an
+// imported package and records the use. Only exported names can be used
+// from outside a package.
+func (c *Checker) importedSymbol(ident ASTIdentifier, pkg *Symbol) (*Symbol, *Error) {
+	scope := c.imports[pkg.path]
+	if scope == nil {
+		return nil, NewError(c.filename, ident.Pos(), fmt.Sprint("I don't know of any package called '", ident.packageName, "'"))
+	}
+
+	obj := scope.LookupLocal(ident.name)
+	if obj == nil || !isExported(ident.name) {
+		return nil, NewError(c.filename, ident.Pos(), fmt.Sprint("package ", ident.packageName, " doesn't export anything called '", ident.name, "'"))
+	}
+	c.info.uses[exprKey{c.filename, ident.pos}] = obj
+
+	return obj, nil
+}
+
+// constDecl checks a constant declaration and returns the constant's type
+// and value.
+func (c *Checker) constDecl(d ASTConstDecl) (DataType, Constant) {
+	savedIota := c.iota
+	c.iota = d.iota
+	defer func() { c.iota = savedIota }()
+
+	var typ DataType
+	if d.typ != nil {
+		typ = c.makeType(d.typ)
+		if typ == nil {
+			return nil, Constant{}
+		}
+		if !isConstType(typ) {
+			c.errorf(d.typ.Pos(), "constants can't be of type %s", c.typeString(typ))
+			return nil, Constant{}
+		}
+	}
+
+	if d.value == nil {
+		c.errorf(d.Pos(), "the constant '%s' needs a value", d.ident.(ASTIdentifier).name)
+		return nil, Constant{}
+	}
+
+	x := c.expr(d.value)
+	if x.mode == modeInvalid {
+		return nil, Constant{}
+	}
+	if x.mode != modeConstant {
+		c.errorf(d.value.Pos(), "the value of constant '%s' has to be a constant", d.ident.(ASTIdentifier).name)
+		return nil, Constant{}
+	}
+
+	if typ != nil {
+		if !c.assignment(&x, typ, "constant declaration") {
+			return nil, Constant{}
+		}
+		return typ, x.val
+	}
+
+	return x.typ, x.val
+}
+
+// varDecl checks a variable declaration and returns the variable's type.
+func (c *Checker) varDecl(d ASTVarDecl) DataType {
+	var typ DataType
+	if d.typ != nil {
+		typ = c.makeType(d.typ)
+		if typ == nil {
+			return nil
+		}
+	}
+
+	if d.value == nil {
+		return typ
+	}
+
+	x := c.expr(d.value)
+	if x.mode == modeInvalid {
+		return typ
+	}
+
+	if typ == nil {
+		if basicKind(x.typ) == DataTypeKindUntypedNil {
+			c.errorf(d.value.Pos(), "I can't work out the type of '%s' from nil", d.ident.(ASTIdentifier).name)
+			return nil
+		}
+		typ = defaultType(c.ts, x.typ)
+	}
+
+	c.assignment(&x, typ, "variable declaration")
+	return typ
+}
+
+// funcDecl checks a function or method declaration.
+func (c *Checker) funcDecl(d ASTFunctionDecl) {
+	c.openScope(ScopeFunc)
+	defer c.closeScope()
+
+	savedDecl := c.decl
+	c.decl = c.funcSymbol(c.filename, d)
+	defer func() { c.decl = savedDecl }()
+
+	// the type parameters of a generic function or of a method's generic
+	// receiver type are in scope in the signature and body.
+	var sig *DataTypeFunc
+	var recvType DataType
+	switch {
+	case d.receiver != nil:
+		recv := d.receiver.(ASTReceiver)
+		recvType = c.ts.LookupName(qualifiedName(c.packagePath, recv.typeName))
+		if named, ok := recvType.(*DataTypeNamed); ok && named.pkg == c.packagePath {
+			// DeclareMethod has already reported any problems with the
+			// receiver's type parameters.
+			names, err := receiverTypeParams(named, recv)
+			if err != nil {
+				return
+			}
+			for _, ast := range recv.typeParams {
+				ident := ast.(ASTIdentifier)
+				c.declare(&Symbol{kind: SymbolType, name: ident.name, typ: names[ident.name], filename: c.filename, pos: ident.pos})
+			}
+		}
+		sig = c.signature(d.params, d.returns)
+
+	case d.name != "init" && d.name != "_":
+		obj := c.pkgScope.LookupLocal(d.name)
+		sig, _ = c.symbolType(obj).(*DataTypeFunc)
+		if obj != nil {
+			for i, tp := range obj.typeParams {
+				ident := d.typeParams[i].(ASTTypeParam).ident.(ASTIdentifier)
+				c.declare(&Symbol{kind: SymbolType, name: tp.name, typ: tp, filename: c.filename, pos: ident.pos})
+			}
+		}
+
+	default:
+		if len(d.typeParams) > 0 {
+			if d.name == "init" {
+				c.errorf(d.pos, "func init can't have type parameters")
+				return
+			}
+			if c.declareTypeParams(d.typeParams) == nil {
+				return
+			}
+		}
+		sig = c.signature(d.params, d.returns)
+	}
+
+	if sig == nil || d.body == nil {
+		return
+	}
+	if c.decl.typ == nil {
+		// methods and init functions aren't resolved by symbolType.
+		c.decl.typ = sig
+	}
+
+	if d.receiver == nil && (d.name == "init" || d.name == "main" && c.packageName == "main") {
+		if len(sig.params) != 0 || len(sig.returns) != 0 || len(d.typeParams) != 0 {
+			c.errorf(d.pos, "func %s must have no arguments, type parameters or return values", d.name)
+		}
+	}
+
+	// declare the receiver.
+	if d.receiver != nil && recvType != nil {
+		recv := d.receiver.(ASTReceiver)
+		if recv.pointer {
+			recvType = c.ts.MakePointer(recvType)
+		}
+		if recv.name != "" {
+			c.declare(&Symbol{kind: SymbolVar, name: recv.name, typ: recvType, filename: c.filename, pos: recv.pos})
+		}
+	}
+
+	c.funcBody(sig, d.params, d.returns, d.body)
+}
+
+// funcBody checks the body of a function or function literal. It must be
+// called with a new scope open.
+func (c *Checker) funcBody(sig *DataTypeFunc, params []AST, returns []AST, body AST) {
+	// declare the parameters and named results.
+	for i, param := range parameterList(params) {
+		if param.name != nil {
+			ident := param.name.(ASTIdentifier)
+			c.declare(&Symbol{kind: SymbolVar, name: ident.name, typ: sig.params[i], filename: c.filename, pos: ident.pos})
+		}
+	}
+
+	namedResults := false
+	for i, ret := range returns {
+		if ident, ok := ret.(ASTParameterDecl).identifier.(ASTIdentifier); ok {
+			namedResults = true
+			c.declare(&Symbol{kind: SymbolVar, name: ident.name, typ: sig.returns[i], filename: c.filename, pos: ident.pos})
+		}
+	}
+
+	savedSig, savedNamed, savedLocals := c.sig, c.namedResults, c.localVars
+	c.sig, c.namedResults, c.localVars = sig, namedResults, nil
+	defer func() { c.sig, c.namedResults, c.localVars = savedSig, savedNamed, savedLocals }()
+
+	// labels can be branched to from anywhere in the function, even before
+	// they're declared.
+	block := body.(ASTBlock)
+	labels := c.declareLabels(block.statements, nil)
+
+	// the body shares the parameters' scope.
+	c.stmtList(block.statements)
+
+	if len(sig.returns) > 0 && !c.isTerminating(block, "") {
+		c.softErrorf(SrcSpan{block.pos.end, block.pos.end}, "this function is missing a return at the end")
+	}
+
+	for _, obj := range c.localVars {
+		if !obj.used && obj.name != "_" {
+			c.softErrorf(obj.pos, "'%s' is declared but not used", obj.name)
+		}
+	}
+	for _, label := range labels {
+		if !label.used {
+			c.softErrorf(label.pos, "label '%s' is defined but not used", label.name)
+		}
+	}
+}
+
+// declareLabels declares the labels in a list of statements and the
+// statements nested inside them, adding them to labels. Labels in function
+// literals belong to the function literal so they aren't included.
+func (c *Checker) declareLabels(stmts []AST, labels []*Symbol) []*Symbol {
+	for _, stmt := range stmts {
+		labels = c.declareStmtLabels(stmt, labels)
+	}
+
+	return labels
+}
+
+// declareStmtLabels declares the labels in a statement.
+func (c *Checker) declareStmtLabels(stmt AST, labels []*Symbol) []*Symbol {
+	switch s := stmt.(type) {
+	case ASTLabeled:
+		sym := &Symbol{kind: SymbolLabel, name: s.label, filename: c.filename, pos: s.pos}
+		c.info.defs[exprKey{c.filename, s.pos}] = sym
+		if err := c.scope.InsertLabel(sym); err != nil {
+			c.errors = append(c.errors, err.(*Error))
+		} else {
+			labels = append(labels, sym)
+		}
+		return c.declareStmtLabels(s.stmt, labels)
+
+	case ASTBlock:
+		return c.declareLabels(s.statements, labels)
+
+	case ASTIf:
+		labels = c.declareStmtLabels(s.then, labels)
+		return c.declareStmtLabels(s.els, labels)
+
+	case ASTFor:
+		return c.declareStmtLabels(s.body, labels)
+
+	case ASTRange:
+		return c.declareStmtLabels(s.body, labels)
+
+	case ASTSwitch:
+		for _, cc := range s.cases {
+			labels = c.declareLabels(cc.(ASTCaseClause).body, labels)
+		}
+
+	case ASTSelect:
+		for _, cc := range s.cases {
+			labels = c.declareLabels(cc.(ASTCommClause).body, labels)
+		}
+	}
+
+	return labels
+}
+
+// declareTypeParams creates the type parameters of a generic function and
+// declares them in the current scope. It returns nil if there's an error.
+func (c *Checker) declareTypeParams(asts []AST) []*DataTypeTypeParam {
+	typeParams, _, err := c.ts.makeASTTypeParams(asts, c.typeContext())
+	if err != nil {
+		c.errors = append(c.errors, err.(*Error))
+		return nil
+	}
+
+	for i, tp := range typeParams {
+		ident := asts[i].(ASTTypeParam).ident.(ASTIdentifier)
+		c.declare(&Symbol{kind: SymbolType, name: tp.name, typ: tp, filename: c.filename, pos: ident.pos})
+	}
+
+	return typeParams
+}
+
+// type parameter is a single parameter from a parameter list.
+type parameter struct {
+	name     AST  // the parameter's name or nil if it's unnamed
+	typ      AST  // the parameter's type
+	variadic bool // true if it's of the form '...T'
+}
+
+// parameterList tidies up a parameter list from the parser. The parser
+// gives "a ...T" as a parameter for "a" followed by a parameter for the
+// ellipsis, so these are combined into a single variadic parameter.
+func parameterList(params []AST) []parameter {
+	var result []parameter
+	for _, ast := range params {
+		param := ast.(ASTParameterDecl)
+		if _, ok := param.identifier.(ASTEllipsis); ok {
+			last := len(result) - 1
+			if last >= 0 && result[last].typ.Pos().Equals(param.typ.Pos()) {
+				result[last].variadic = true
+			} else {
+				result = append(result, parameter{nil, param.typ, true})
+			}
+			continue
+		}
+
+		result = append(result, parameter{param.identifier, param.typ, false})
+	}
+
+	return result
+}
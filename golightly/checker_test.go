@@ -0,0 +1,472 @@
+package golightly
+
+import (
+	"strings"
+	"testing"
+)
+
+// type testAST helps build ASTs for the checker tests. Every node it
+// creates has a different position so the checker can tell them apart.
+type testAST struct {
+	line int
+}
+
+func (ta *testAST) pos() SrcSpan {
+	ta.line++
+	return SrcSpan{SrcLoc{ta.line, 1}, SrcLoc{ta.line, 2}}
+}
+
+func (ta *testAST) ident(name string) AST {
+	return ASTIdentifier{ta.pos(), "", name}
+}
+
+func (ta *testAST) int(val uint64) AST {
+	return ASTValue{ta.pos(), ValueUint{nil, val}}
+}
+
+func (ta *testAST) float(val float64) AST {
+	return ASTValue{ta.pos(), ValueFloat{nil, val}}
+}
+
+func (ta *testAST) str(val string) AST {
+	return ASTValue{ta.pos(), ValueString{val}}
+}
+
+func (ta *testAST) unary(op TokenKind, param AST) AST {
+	return ASTUnaryExpr{ta.pos(), op, param}
+}
+
+func (ta *testAST) binary(op TokenKind, left AST, right AST) AST {
+	return ASTBinaryExpr{ta.pos(), op, left, right}
+}
+
+func (ta *testAST) call(function AST, args ...AST) AST {
+	return ASTCall{ta.pos(), function, args, false}
+}
+
+func (ta *testAST) index(expr AST, index AST) AST {
+	return ASTIndex{ta.pos(), expr, index}
+}
+
+func (ta *testAST) varDecl(name string, typ AST, value AST) AST {
+	return ASTVarDecl{ta.ident(name), typ, value}
+}
+
+func (ta *testAST) param(name string, typ AST) AST {
+	if name == "" {
+		return ASTParameterDecl{nil, typ}
+	}
+	return ASTParameterDecl{ta.ident(name), typ}
+}
+
+// mainUsing declares a main function which refers to some package level
+// names so they're reachable, since the passes after type checking skip
+// anything which isn't.
+func (ta *testAST) mainUsing(names ...string) AST {
+	var body []AST
+	for _, name := range names {
+		body = append(body, ASTAssign{ta.pos(), TokenKindAssign, []AST{ta.ident("_")}, []AST{ta.ident(name)}})
+	}
+	return ASTFunctionDecl{ta.pos(), "main", nil, nil, nil, nil, ASTBlock{ta.pos(), body}}
+}
+
+// checkTestFile type checks a single file made from some declarations.
+func checkTestFile(ts *DataTypeStore, decls []AST) (*Checker, []*Error) {
+	sf := &sourceFile{packageName: "main", fileName: "test.go", ast: ASTTopLevel{topLevelDecls: decls}}
+	return checkTestFiles(ts, []*sourceFile{sf})
+}
+
+// checkTestFiles type checks the files of package main.
+func checkTestFiles(ts *DataTypeStore, files []*sourceFile) (*Checker, []*Error) {
+	c := NewChecker(ts, "main")
+	return c, c.CheckFiles(files)
+}
+
+// testVarDecls declares some variables for the expression tests to use.
+func testVarDecls(ta *testAST) []AST {
+	intType := ta.ident("int")
+	return []AST{
+		ta.varDecl("i", intType, nil),
+		ta.varDecl("f", ta.ident("float64"), nil),
+		ta.varDecl("s", ASTDataTypeSlice{ta.pos(), intType}, nil),
+		ta.varDecl("str", ta.ident("string"), nil),
+		ta.varDecl("m", ASTDataTypeMap{ta.pos(), ta.ident("string"), intType}, nil),
+		ta.varDecl("ch", ASTDataTypeChan{ta.pos(), ChanDirectionIn, intType}, nil),
+		ta.varDecl("fn", ASTDataTypeFunc{ta.pos(), []AST{
+			ta.param("", intType),
+			ASTParameterDecl{ASTEllipsis{ta.pos()}, ta.ident("string")},
+		}, []AST{ta.param("", intType)}}, nil),
+	}
+}
+
+func TestCheckerExpressions(t *testing.T) {
+	ta := &testAST{}
+	ts := NewDataTypeStore()
+
+	// each expression should be fine and have the given type when it's
+	// used as the value of a variable declaration.
+	exprs := []struct {
+		expr AST
+		typ  DataType
+	}{
+		{ta.binary(TokenKindAdd, ta.ident("i"), ta.int(1)), ts.IntType()},
+		{ta.binary(TokenKindAdd, ta.int(1), ta.float(2)), ts.Float64Type()},
+		{ta.binary(TokenKindAsterisk, ta.ident("f"), ta.int(2)), ts.Float64Type()},
+		{ta.binary(TokenKindShiftLeft, ta.ident("i"), ta.int(2)), ts.IntType()},
+		{ta.binary(TokenKindShiftLeft, ta.int(1), ta.ident("i")), ts.IntType()},
+		{ta.binary(TokenKindEquals, ta.ident("i"), ta.int(1)), ts.BoolType()},
+		{ta.binary(TokenKindAdd, ta.ident("str"), ta.str("x")), ts.StringType()},
+		{ta.index(ta.ident("s"), ta.int(0)), ts.IntType()},
+		{ta.index(ta.ident("m"), ta.str("a")), ts.IntType()},
+		{ta.index(ta.ident("str"), ta.int(0)), ts.ByteType()},
+		{ta.call(ta.ident("fn"), ta.int(1)), ts.IntType()},
+		{ta.call(ta.ident("fn"), ta.int(1), ta.str("a"), ta.str("b")), ts.IntType()},
+		{ta.call(ta.ident("len"), ta.ident("s")), ts.IntType()},
+		{ta.call(ta.ident("append"), ta.ident("s"), ta.int(1), ta.int(2)), ts.MakeSlice(ts.IntType())},
+		{ta.call(ta.ident("float64"), ta.ident("i")), ts.Float64Type()},
+		{ta.call(ta.ident("string"), ta.int(65)), ts.StringType()},
+	}
+
+	decls := testVarDecls(ta)
+	for i, e := range exprs {
+		decls = append(decls, ta.varDecl(string(rune('A'+i)), nil, e.expr))
+	}
+
+	c, errs := checkTestFile(ts, decls)
+	for _, err := range errs {
+		t.Error("unexpected error: ", err)
+	}
+	for i, e := range exprs {
+		typ := c.Info().TypeOf("test.go", e.expr)
+		if typ != e.typ {
+			t.Error("expression ", i, " has type ", typeString(typ), " but should have type ", typeString(e.typ))
+		}
+	}
+}
+
+func TestCheckerExpressionErrors(t *testing.T) {
+	ta := &testAST{}
+
+	// each of these should give exactly one error.
+	exprs := []AST{
+		ta.binary(TokenKindAdd, ta.ident("i"), ta.ident("f")),
+		ta.binary(TokenKindShiftLeft, ta.ident("i"), ta.ident("f")),
+		ta.binary(TokenKindShiftLeft, ta.ident("f"), ta.int(1)),
+		ta.binary(TokenKindEquals, ta.ident("s"), ta.ident("s")),
+		ta.binary(TokenKindLess, ta.ident("m"), ta.ident("m")),
+		ta.binary(TokenKindSubtract, ta.ident("str"), ta.str("x")),
+		ta.binary(TokenKindModulus, ta.ident("f"), ta.ident("f")),
+		ta.binary(TokenKindLogicalAnd, ta.ident("i"), ta.ident("i")),
+		ta.binary(TokenKindAdd, ta.ident("i"), ta.str("x")),
+		ta.unary(TokenKindNot, ta.ident("i")),
+		ta.unary(TokenKindChannelArrow, ta.ident("ch")),
+		ta.call(ta.ident("fn"), ta.str("a")),
+		ta.call(ta.ident("fn")),
+		ta.call(ta.ident("fn"), ta.int(1), ta.int(2)),
+		ta.call(ta.ident("i"), ta.int(1)),
+		ta.call(ta.ident("int"), ta.ident("str")),
+		ta.call(ta.ident("append"), ta.ident("s"), ta.str("a")),
+		ta.index(ta.ident("m"), ta.int(1)),
+		ta.index(ta.ident("s"), ta.str("a")),
+		ta.ident("nonsense"),
+	}
+
+	for i, expr := range exprs {
+		decls := append(testVarDecls(ta), ta.varDecl("x", nil, expr))
+		_, errs := checkTestFile(NewDataTypeStore(), decls)
+		if len(errs) != 1 {
+			t.Error("expression ", i, " should give one error but gave ", len(errs), ": ", errs)
+		}
+	}
+}
+
+func TestCheckerStatements(t *testing.T) {
+	ta := &testAST{}
+	intType := ta.ident("int")
+
+	// func f(a int) (int, error) { x := a; x += 1; var y string; y = x; _ = y; return x }
+	x := ta.ident("x")
+	badValue := ta.ident("x")
+	badReturn := ASTReturn{ta.pos(), []AST{ta.ident("x")}}
+	f := ASTFunctionDecl{ta.pos(), "f", nil, nil,
+		[]AST{ta.param("a", intType)},
+		[]AST{ta.param("", intType), ta.param("", ta.ident("error"))},
+		ASTBlock{ta.pos(), []AST{
+			ASTAssign{ta.pos(), TokenKindDeclareAssign, []AST{x}, []AST{ta.ident("a")}},
+			ASTAssign{ta.pos(), TokenKindAddAssign, []AST{ta.ident("x")}, []AST{ta.int(1)}},
+			ta.varDecl("y", ta.ident("string"), nil),
+			ASTAssign{ta.pos(), TokenKindAssign, []AST{ta.ident("y")}, []AST{badValue}},
+			ASTAssign{ta.pos(), TokenKindAssign, []AST{ta.ident("_")}, []AST{ta.ident("y")}},
+			badReturn,
+		}},
+	}
+
+	// func g() int { a, b := f(1); _ = b; if a > 0 { return a }; for i := 0; i < 10; i++ {}; return 0 }
+	a := ta.ident("a")
+	i := ta.ident("i")
+	g := ASTFunctionDecl{ta.pos(), "g", nil, nil, nil,
+		[]AST{ta.param("", intType)},
+		ASTBlock{ta.pos(), []AST{
+			ASTAssign{ta.pos(), TokenKindDeclareAssign, []AST{a, ta.ident("b")}, []AST{ta.call(ta.ident("f"), ta.int(1))}},
+			ASTAssign{ta.pos(), TokenKindAssign, []AST{ta.ident("_")}, []AST{ta.ident("b")}},
+			ASTIf{ta.pos(), nil, ta.binary(TokenKindGreater, ta.ident("a"), ta.int(0)),
+				ASTBlock{ta.pos(), []AST{ASTReturn{ta.pos(), []AST{ta.ident("a")}}}}, nil},
+			ASTFor{ta.pos(),
+				ASTAssign{ta.pos(), TokenKindDeclareAssign, []AST{i}, []AST{ta.int(0)}},
+				ta.binary(TokenKindLess, ta.ident("i"), ta.int(10)),
+				ASTIncDec{ta.pos(), TokenKindIncrement, ta.ident("i")},
+				ASTBlock{ta.pos(), nil}},
+			ASTReturn{ta.pos(), []AST{ta.int(0)}},
+		}},
+	}
+
+	ts := NewDataTypeStore()
+	c, errs := checkTestFile(ts, []AST{f, g})
+	if len(errs) != 2 {
+		t.Error("expected two errors but got: ", errs)
+	} else if !errs[0].pos.Equals(badValue.Pos()) || !errs[1].pos.Equals(badReturn.pos) {
+		t.Error("errors reported in the wrong places: ", errs)
+	}
+
+	if c.Info().TypeOf("test.go", x) != ts.IntType() {
+		t.Error("x should be an int")
+	}
+	if c.Info().TypeOf("test.go", a) != ts.IntType() || c.Info().TypeOf("test.go", i) != ts.IntType() {
+		t.Error("a and i should be ints")
+	}
+}
+
+func TestCheckerInitFunctions(t *testing.T) {
+	ta := &testAST{}
+	intType := ta.ident("int")
+
+	// func init(x int) {} isn't allowed but a method named init can have
+	// any signature: type T int; func (t T) init(x int) int { return x }
+	badInit := ASTFunctionDecl{ta.pos(), "init", nil, nil, []AST{ta.param("x", intType)}, nil, ASTBlock{ta.pos(), nil}}
+	method := ASTFunctionDecl{ta.pos(), "init", nil, ASTReceiver{ta.pos(), "t", false, "T", nil},
+		[]AST{ta.param("x", intType)},
+		[]AST{ta.param("", intType)},
+		ASTBlock{ta.pos(), []AST{ASTReturn{ta.pos(), []AST{ta.ident("x")}}}},
+	}
+	typeDecl := ASTDataTypeDecl{ta.ident("T"), nil, ta.ident("int"), false}
+
+	_, errs := checkTestFile(NewDataTypeStore(), []AST{typeDecl, badInit, method})
+	if len(errs) != 1 || !errs[0].pos.Equals(badInit.pos) || !strings.Contains(errs[0].Error(), "func init must have no arguments") {
+		t.Error("expected an error for the init function only but got: ", errs)
+	}
+}
+
+func TestCheckerTypeErrors(t *testing.T) {
+	// a type with an error doesn't stop the others being declared.
+	_, errs := checkTestFile(NewDataTypeStore(), parseTestDecls(t, "type A Missing; type B int; type C struct { c C; }; type D B; var b B; var d D;"))
+	if len(errs) != 2 || !strings.Contains(errs[0].Error(), "'Missing'") || !strings.Contains(errs[1].Error(), "'C' is an invalid recursive type") {
+		t.Error("expected errors for A and C only but got: ", errs)
+	}
+}
+
+func TestCheckerParsedSelectors(t *testing.T) {
+	// the parser gives these as qualified identifiers.
+	src := "type P struct { X int; }; func (p P) M() int; var p P; var x = p.X; var m = p.M; var f = P.M; const s = 1; var bad = s.X;"
+	c, errs := checkTestFile(NewDataTypeStore(), parseTestDecls(t, src))
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "untyped int has no field or method called 'X'") {
+		t.Error("expected an error for s.X only but got: ", errs)
+	}
+
+	expected := map[string]string{"x": "int", "m": "func() int", "f": "func(P) int"}
+	for name, typ := range expected {
+		if sym := c.Scope().LookupLocal(name); sym == nil || TypeString(sym.typ, "main") != typ {
+			t.Error(name, " should be a ", typ, ": ", sym)
+		}
+	}
+}
+
+func TestCheckerMultipleFiles(t *testing.T) {
+	ta := &testAST{}
+	ts := NewDataTypeStore()
+	file := func(name string, imports []AST, decls ...AST) *sourceFile {
+		return &sourceFile{packageName: "main", fileName: name, ast: ASTTopLevel{imports: imports, topLevelDecls: decls}}
+	}
+	unsafeImport := ASTImport{ta.pos(), nil, ASTValue{ta.pos(), ValueString{"unsafe"}}}
+
+	// a.go: import "unsafe"; type A struct { b B }; var x = y + 1; var s = unsafe.Sizeof(x)
+	// b.go: type B int; var y B = 2
+	fieldType := ta.ident("B")
+	useY := ta.ident("y")
+	declY := ta.ident("y")
+	a := file("a.go", []AST{unsafeImport},
+		ASTDataTypeDecl{ta.ident("A"), nil, ASTDataTypeStruct{ta.pos(), []AST{ASTDataTypeField{ta.ident("b"), fieldType, ""}}}, false},
+		ta.varDecl("x", nil, ta.binary(TokenKindAdd, useY, ta.int(1))),
+		ta.varDecl("s", nil, ta.call(ASTIdentifier{ta.pos(), "unsafe", "Sizeof"}, ta.ident("x"))),
+	)
+	b := file("b.go", nil,
+		ASTDataTypeDecl{ta.ident("B"), nil, ta.ident("int"), false},
+		ASTVarDecl{declY, ta.ident("B"), ta.int(2)},
+	)
+
+	c := NewChecker(ts, "main")
+	if errs := c.CheckFiles([]*sourceFile{a, b}); len(errs) != 0 {
+		t.Error("unexpected errors: ", errs)
+	}
+
+	y := c.Info().ObjectOf("a.go", useY)
+	if y == nil || y != c.Info().ObjectOf("b.go", declY) || y.Filename() != "b.go" {
+		t.Error("y should refer to the variable declared in b.go")
+	}
+	if sym := c.Info().ObjectOf("a.go", fieldType); sym == nil || sym.Kind() != SymbolType || sym.Filename() != "b.go" {
+		t.Error("the field type should refer to the type declared in b.go")
+	}
+	if c.Info().TypeOf("a.go", useY) != ts.LookupName("main.B") {
+		t.Error("y should be a main.B")
+	}
+
+	// each of these should give exactly one error.
+	errorFiles := [][]*sourceFile{
+		// imports are only visible in the file which imports them.
+		{
+			file("a.go", []AST{unsafeImport}, ta.varDecl("t", nil, ta.call(ASTIdentifier{ta.pos(), "unsafe", "Sizeof"}, ta.int(1)))),
+			file("b.go", nil, ta.varDecl("s", nil, ta.call(ASTIdentifier{ta.pos(), "unsafe", "Sizeof"}, ta.int(1)))),
+		},
+		// the same name declared in two files.
+		{
+			file("a.go", nil, ta.varDecl("v", ta.ident("int"), nil)),
+			file("b.go", nil, ASTFunctionDecl{ta.pos(), "v", nil, nil, nil, nil, ASTBlock{ta.pos(), nil}}),
+		},
+		{
+			file("a.go", nil, ASTDataTypeDecl{ta.ident("T"), nil, ta.ident("int"), false}),
+			file("b.go", nil, ASTDataTypeDecl{ta.ident("T"), nil, ta.ident("string"), false}),
+		},
+	}
+
+	for i, files := range errorFiles {
+		_, errs := checkTestFiles(NewDataTypeStore(), files)
+		if len(errs) != 1 {
+			t.Error("files ", i, " should give one error but gave ", len(errs), ": ", errs)
+		} else if i > 0 && !strings.Contains(errs[0].Error(), "at a.go:") {
+			t.Error("the error should say where the other declaration is: ", errs[0])
+		}
+	}
+}
+
+func TestCheckerUnused(t *testing.T) {
+	ta := &testAST{}
+	unsafeImport := ASTImport{ta.pos(), nil, ASTValue{ta.pos(), ValueString{"unsafe"}}}
+	fn := func(returns []AST, body ...AST) AST {
+		return ASTFunctionDecl{ta.pos(), "f", nil, nil, nil, returns, ASTBlock{ta.pos(), body}}
+	}
+	define := func(name string, value AST) AST {
+		return ASTAssign{ta.pos(), TokenKindDeclareAssign, []AST{ta.ident(name)}, []AST{value}}
+	}
+	use := func(name string) AST {
+		return ASTAssign{ta.pos(), TokenKindAssign, []AST{ta.ident("_")}, []AST{ta.ident(name)}}
+	}
+	ret := ASTReturn{ta.pos(), []AST{ta.int(1)}}
+	intResult := []AST{ta.param("", ta.ident("int"))}
+
+	// these are all fine.
+	okFiles := [][]AST{
+		{fn(nil, define("x", ta.int(1)), ASTIncDec{ta.pos(), TokenKindIncrement, ta.ident("x")})},
+		{fn(nil, ta.varDecl("x", ta.ident("int"), nil), ASTAssign{ta.pos(), TokenKindAddAssign, []AST{ta.ident("x")}, []AST{ta.int(1)}})},
+		{fn(nil, ASTLabeled{ta.pos(), "L", ASTBranch{ta.pos(), TokenKindGoto, "L"}})},
+		{fn(intResult, ASTIf{ta.pos(), nil, ta.ident("true"), ASTBlock{ta.pos(), []AST{ret}}, ASTBlock{ta.pos(), []AST{ret}}})},
+		{fn(nil, define("x", ta.int(1)), ta.call(ASTFunctionLit{ta.pos(), ASTDataTypeFunc{ta.pos(), nil, nil}, ASTBlock{ta.pos(), []AST{use("x")}}}))},
+	}
+	for i, decls := range okFiles {
+		if _, errs := checkTestFile(NewDataTypeStore(), decls); len(errs) != 0 {
+			t.Error("file ", i, " shouldn't give any errors but gave: ", errs)
+		}
+	}
+
+	// each of these should give exactly one error, or one warning if the
+	// checker is lenient.
+	errorFiles := []struct {
+		imports []AST
+		decls   []AST
+		message string
+	}{
+		{[]AST{unsafeImport}, nil, `"unsafe" is imported but not used`},
+		{[]AST{ASTImport{ta.pos(), ta.ident("u"), ASTValue{ta.pos(), ValueString{"unsafe"}}}}, nil, `"unsafe" is imported as u but not used`},
+		{nil, []AST{fn(nil, ta.varDecl("x", ta.ident("int"), nil))}, "'x' is declared but not used"},
+		{nil, []AST{fn(nil, define("x", ta.int(1)), ASTAssign{ta.pos(), TokenKindAssign, []AST{ta.ident("x")}, []AST{ta.int(2)}})}, "'x' is declared but not used"},
+		{nil, []AST{fn(nil, ASTLabeled{ta.pos(), "L", nil})}, "label 'L' is defined but not used"},
+		{nil, []AST{fn(nil, define("x", ta.int(1)), use("x"), define("x", ta.int(2)))}, "there are no new variables on the left of ':='"},
+		{nil, []AST{fn(intResult)}, "this function is missing a return at the end"},
+		{nil, []AST{fn(intResult, ASTIf{ta.pos(), nil, ta.ident("true"), ASTBlock{ta.pos(), []AST{ret}}, nil})}, "this function is missing a return at the end"},
+	}
+	for i, test := range errorFiles {
+		files := func() []*sourceFile {
+			return []*sourceFile{{packageName: "main", fileName: "test.go", ast: ASTTopLevel{imports: test.imports, topLevelDecls: test.decls}}}
+		}
+
+		_, errs := checkTestFiles(NewDataTypeStore(), files())
+		if len(errs) != 1 {
+			t.Error("file ", i, " should give one error but gave ", len(errs), ": ", errs)
+		} else if errs[0].message != test.message {
+			t.Error("file ", i, " gave the wrong error: ", errs[0])
+		}
+
+		c := NewChecker(NewDataTypeStore(), "main")
+		c.SetLenient(true)
+		if errs := c.CheckFiles(files()); len(errs) != 0 || len(c.Warnings()) != 1 {
+			t.Error("file ", i, " should give one warning when lenient but gave errors ", errs, " and warnings ", c.Warnings())
+		}
+	}
+
+	// a missing label is always an error.
+	_, errs := checkTestFile(NewDataTypeStore(), []AST{fn(nil, ASTBranch{ta.pos(), TokenKindGoto, "L"})})
+	if len(errs) != 1 {
+		t.Error("expected one error for a missing label but got: ", errs)
+	}
+}
+
+func TestCheckerTerminating(t *testing.T) {
+	ta := &testAST{}
+	ret := func() AST { return ASTReturn{ta.pos(), []AST{ta.int(1)}} }
+	panicCall := func() AST { return ta.call(ta.ident("panic"), ta.str("oops")) }
+	block := func(stmts ...AST) AST { return ASTBlock{ta.pos(), stmts} }
+	forever := func(body ...AST) AST { return ASTFor{ta.pos(), nil, nil, nil, block(body...)} }
+	branch := func(tok TokenKind, label string) AST { return ASTBranch{ta.pos(), tok, label} }
+	clause := func(exprs []AST, body ...AST) AST { return ASTCaseClause{ta.pos(), exprs, body} }
+	cond := func() []AST { return []AST{ta.ident("true")} }
+	switchStmt := func(cases ...AST) AST { return ASTSwitch{ta.pos(), nil, nil, cases} }
+
+	// each body belongs to a function returning an int, which is missing a
+	// return unless the body is terminating.
+	bodies := []struct {
+		stmts       []AST
+		terminating bool
+	}{
+		{[]AST{ret()}, true},
+		{[]AST{ret(), nil}, true},
+		{[]AST{panicCall()}, true},
+		{[]AST{ASTLabeled{ta.pos(), "L", branch(TokenKindGoto, "L")}}, true},
+		{[]AST{block(block(ret()))}, true},
+		{[]AST{forever()}, true},
+		{[]AST{forever(forever(branch(TokenKindBreak, "")))}, true},
+		{[]AST{forever(switchStmt(clause(nil, branch(TokenKindBreak, ""))))}, true},
+		{[]AST{switchStmt(clause(cond(), ret()), clause(nil, panicCall()))}, true},
+		{[]AST{switchStmt(clause(cond(), branch(TokenKindFallthrough, "")), clause(nil, ret()))}, true},
+		{[]AST{ASTSelect{ta.pos(), nil}}, true},
+		{[]AST{ASTSelect{ta.pos(), []AST{ASTCommClause{ta.pos(), nil, []AST{ret()}}}}}, true},
+
+		{nil, false},
+		{[]AST{ta.call(ta.ident("f"))}, false},
+		{[]AST{forever(ASTIf{ta.pos(), nil, ta.ident("true"), block(branch(TokenKindBreak, "")), nil})}, false},
+		{[]AST{ASTLabeled{ta.pos(), "L", forever(forever(branch(TokenKindBreak, "L")))}}, false},
+		{[]AST{ASTFor{ta.pos(), nil, ta.ident("true"), nil, block()}}, false},
+		{[]AST{switchStmt(clause(cond(), ret()))}, false},
+		{[]AST{switchStmt(clause(cond(), ret()), clause(nil, branch(TokenKindBreak, "")))}, false},
+		{[]AST{switchStmt(clause(cond(), ret(), branch(TokenKindBreak, "")), clause(nil, ret()))}, false},
+		{[]AST{ASTSelect{ta.pos(), []AST{ASTCommClause{ta.pos(), nil, []AST{ta.call(ta.ident("f"))}}}}}, false},
+	}
+
+	for i, body := range bodies {
+		f := ASTFunctionDecl{ta.pos(), "f", nil, nil, nil, []AST{ta.param("", ta.ident("int"))}, ASTBlock{ta.pos(), body.stmts}}
+		_, errs := checkTestFile(NewDataTypeStore(), []AST{f})
+		if body.terminating && len(errs) != 0 {
+			t.Error("body ", i, " should be terminating but gave: ", errs)
+		}
+		if !body.terminating && (len(errs) != 1 || errs[0].message != "this function is missing a return at the end" || !errs[0].pos.start.Equals(f.body.(ASTBlock).pos.end)) {
+			t.Error("body ", i, " should be missing a return at the closing brace but gave: ", errs)
+		}
+	}
+}
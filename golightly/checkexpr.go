@@ -0,0 +1,2107 @@
+package golightly
+
+import (
+	"math/big"
+	"unicode"
+	"unicode/utf8"
+)
+
+// type operandMode describes what kind of thing an expression evaluates to.
+type operandMode int
+
+const (
+	modeInvalid  operandMode = iota // the expression has an error which has already been reported
+	modeNoValue                     // a call to a function with no results
+	modeBuiltin                     // a builtin function
+	modeType                        // a type
+	modeConstant                    // a constant
+	modeVariable                    // an addressable variable
+	modeMapIndex                    // a map element - assignable but not addressable
+	modeValue                       // any other value
+	modeCommaOk                     // a value which can also give a second bool result
+)
+
+// type operand is the result of checking an expression.
+type operand struct {
+	mode    operandMode // what kind of thing it is
+	expr    AST         // the expression
+	typ     DataType    // its type
+	tuple   []DataType  // the results of a call which returns more than one value
+	val     Constant    // the value if the mode is modeConstant
+	builtin builtinID   // which builtin it is if the mode is modeBuiltin
+	callee  operandMode // for calls, the mode of what was called
+
+	// a generic function which hasn't been instantiated yet, and any type
+	// arguments it's been given so far.
+	generic  *Symbol
+	typeArgs []DataType
+}
+
+// record stores the type of a checked expression.
+func (c *Checker) record(x *operand) {
+	if x.mode != modeInvalid && x.typ != nil && x.expr != nil {
+		key := exprKey{c.filename, x.expr.Pos()}
+		c.info.types[key] = x.typ
+		if x.mode == modeConstant {
+			c.info.values[key] = x.val
+		}
+	}
+}
+
+// invalid marks an operand as invalid.
+func (x *operand) invalid() operand {
+	x.mode = modeInvalid
+	x.typ = nil
+	x.generic = nil
+	x.typeArgs = nil
+	return *x
+}
+
+// describe gives a short description of an operand for error messages.
+func (c *Checker) describe(x *operand) string {
+	if x.mode == modeType {
+		return c.typeString(x.typ) + " (a type)"
+	}
+	if x.typ == nil {
+		return "this"
+	}
+	return "a value of type " + c.typeString(x.typ)
+}
+
+// expr checks an expression which must give a single value.
+func (c *Checker) expr(ast AST) operand {
+	x := c.rawExpr(ast)
+	c.singleValue(&x)
+	return x
+}
+
+// singleValue makes sure an operand is a single value.
+func (c *Checker) singleValue(x *operand) {
+	switch x.mode {
+	case modeNoValue:
+		c.errorf(x.expr.Pos(), "this doesn't give a value so I can't use it here")
+		x.invalid()
+	case modeBuiltin:
+		c.errorf(x.expr.Pos(), "the builtin function has to be called")
+		x.invalid()
+	case modeType:
+		c.errorf(x.expr.Pos(), "%s is a type, not a value", c.typeString(x.typ))
+		x.invalid()
+	default:
+		if x.generic != nil {
+			c.errorf(x.expr.Pos(), "'%s' is a generic function so it has to be called or given type arguments", x.generic.name)
+			x.invalid()
+		}
+		if x.tuple != nil {
+			c.errorf(x.expr.Pos(), "this gives %d values but I was expecting a single value", len(x.tuple))
+			x.invalid()
+		}
+	}
+}
+
+// exprOrType checks an expression which may also be a type.
+func (c *Checker) exprOrType(ast AST) operand {
+	x := c.rawExpr(ast)
+	if x.mode == modeType {
+		return x
+	}
+	c.singleValue(&x)
+	return x
+}
+
+// rawExpr checks any kind of expression and records its type.
+func (c *Checker) rawExpr(ast AST) operand {
+	x := c.exprInternal(ast, nil)
+	c.record(&x)
+	return x
+}
+
+// exprWithHint checks an expression where the expected type is known. It's
+// used for composite literals whose type has been left out.
+func (c *Checker) exprWithHint(ast AST, hint DataType) operand {
+	x := c.exprInternal(ast, hint)
+	c.record(&x)
+	c.singleValue(&x)
+	return x
+}
+
+// exprInternal does the work of checking an expression.
+func (c *Checker) exprInternal(ast AST, hint DataType) operand {
+	x := operand{mode: modeInvalid, expr: ast}
+
+	switch e := ast.(type) {
+	case ASTValue:
+		x.mode = modeConstant
+		switch v := e.val.(type) {
+		case ValueConstant:
+			x.typ, x.val = v.typ, v.val
+		case ValueInt:
+			x.typ, x.val = c.ts.UntypedIntType(), MakeInt64Constant(v.val)
+		case ValueUint:
+			x.typ, x.val = c.ts.UntypedIntType(), MakeUint64Constant(v.val)
+		case ValueFloat:
+			x.typ, x.val = c.ts.UntypedFloatType(), MakeFloat64Constant(v.val)
+		case ValueRune:
+			x.typ, x.val = c.ts.UntypedRuneType(), MakeInt64Constant(int64(v.val))
+		case ValueString:
+			x.typ, x.val = c.ts.UntypedStringType(), MakeStringConstant(v.val)
+		default:
+			c.errorf(e.pos, "I don't know what kind of value this is")
+			return x.invalid()
+		}
+
+	case ASTIdentifier:
+		c.ident(&x, e)
+
+	case ASTUnaryExpr:
+		c.unary(&x, e)
+
+	case ASTBinaryExpr:
+		c.binary(&x, e)
+
+	case ASTCall:
+		c.call(&x, e)
+
+	case ASTSelector:
+		c.selector(&x, e)
+
+	case ASTIndex:
+		c.index(&x, e)
+
+	case ASTInstance:
+		c.instance(&x, e.pos, c.rawExpr(e.expr), e.typeArgs)
+
+	case ASTSliceExpr:
+		c.sliceExpr(&x, e)
+
+	case ASTTypeAssertion:
+		c.typeAssertion(&x, e)
+
+	case ASTCompositeLit:
+		c.compositeLit(&x, e, hint)
+
+	case ASTFunctionLit:
+		sig, ok := c.makeType(e.typ).(*DataTypeFunc)
+		if !ok {
+			return x.invalid()
+		}
+		c.openScope(ScopeFunc)
+		c.funcBody(sig, e.typ.params, e.typ.returns, e.body)
+		c.closeScope()
+		x.mode = modeValue
+		x.typ = sig
+
+	case ASTDataTypeSlice, ASTDataTypeArray, ASTDataTypePointer, ASTDataTypeMap, ASTDataTypeChan,
+		ASTDataTypeStruct, ASTDataTypeFunc, ASTDataTypeInterface:
+		x.typ = c.makeType(e)
+		if x.typ == nil {
+			return x.invalid()
+		}
+		x.mode = modeType
+
+	default:
+		c.errorf(ast.Pos(), "I was expecting an expression here")
+		return x.invalid()
+	}
+
+	return x
+}
+
+// ident checks an identifier used in an expression.
+func (c *Checker) ident(x *operand, e ASTIdentifier) {
+	if e.packageName != "" {
+		c.qualifiedIdent(x, e)
+		return
+	}
+
+	if e.name == "_" {
+		c.errorf(e.pos, "'_' can only be assigned to, it can't be used as a value")
+		x.invalid()
+		return
+	}
+
+	obj := c.lookup(e)
+	if obj == nil {
+		c.errorf(e.pos, "I don't know of anything called '%s'", e.name)
+		x.invalid()
+		return
+	}
+
+	// iota's value depends on where it's used.
+	if obj == c.universe.syms["iota"] {
+		if c.iota < 0 {
+			c.errorf(e.pos, "iota can only be used in a constant declaration")
+			x.invalid()
+			return
+		}
+		x.mode = modeConstant
+		x.typ = obj.typ
+		x.val = MakeInt64Constant(int64(c.iota))
+		return
+	}
+
+	c.symbolOperand(x, obj)
+	if x.mode == modeInvalid || x.mode == modeBuiltin {
+		return
+	}
+	c.reference(obj)
+	if obj.kind == SymbolFunc && obj.typeParams != nil {
+		x.generic = obj
+	}
+}
+
+// symbolOperand sets an operand to the value, type or builtin a symbol
+// stands for.
+func (c *Checker) symbolOperand(x *operand, obj *Symbol) {
+	switch obj.kind {
+	case SymbolBuiltin:
+		x.mode = modeBuiltin
+		x.builtin = obj.builtin
+		return
+	case SymbolConst:
+		x.mode = modeConstant
+	case SymbolVar:
+		x.mode = modeVariable
+	case SymbolType:
+		x.mode = modeType
+	case SymbolFunc, SymbolNil:
+		x.mode = modeValue
+	}
+
+	x.typ = c.symbolType(obj)
+	x.val = obj.val
+	if x.typ == nil {
+		x.invalid()
+	}
+}
+
+// qualifiedIdent checks an identifier from another package. Package
+// unsafe is built in. Other packages have to have been checked already
+// and given to AddImport.
+//
+// The parser gives every 'x.name' as a qualified identifier since it
+// can't tell them apart, so one where x isn't a package is checked as a
+// selector instead.
+func (c *Checker) qualifiedIdent(x *operand, e ASTIdentifier) {
+	pkg, _ := c.scope.Lookup(e.packageName)
+	if pkg != nil && pkg.kind != SymbolPackage {
+		c.selector(x, ASTSelector{e.pos, ASTIdentifier{e.pos, "", e.packageName}, e.name})
+		return
+	}
+
+	// the identifier refers to something in the other package so only the
+	// package name is marked as used.
+	if pkg != nil {
+		pkg.used = true
+	}
+	if pkg == nil || pkg.kind != SymbolPackage {
+		c.errorf(e.pos, "I don't know of any package called '%s'", e.packageName)
+		x.invalid()
+		return
+	}
+
+	if pkg.path != "unsafe" {
+		obj, err := c.importedSymbol(e, pkg)
+		if err != nil {
+			c.errors = append(c.errors, err)
+			x.invalid()
+			return
+		}
+		c.symbolOperand(x, obj)
+		return
+	}
+
+	id, ok := unsafeBuiltinNames[e.name]
+	if !ok {
+		c.errorf(e.pos, "package unsafe doesn't have anything called '%s'", e.name)
+		x.invalid()
+		return
+	}
+
+	x.mode = modeBuiltin
+	x.builtin = id
+}
+
+// unary checks a unary expression.
+func (c *Checker) unary(x *operand, e ASTUnaryExpr) {
+	// '*' could be a pointer type or a dereference.
+	if e.op == TokenKindAsterisk {
+		y := c.exprOrType(e.param)
+		switch {
+		case y.mode == modeInvalid:
+			x.invalid()
+		case y.mode == modeType:
+			x.mode = modeType
+			x.typ = c.ts.MakePointer(y.typ)
+		default:
+			ptr, ok := coreType(y.typ).(*DataTypeUnary)
+			if !ok || ptr.kind != DataTypeKindPointer {
+				c.errorf(e.pos, "I can't dereference %s because it isn't a pointer", c.describe(&y))
+				x.invalid()
+				return
+			}
+			x.mode = modeVariable
+			x.typ = ptr.subType
+		}
+		return
+	}
+
+	// '&' needs something addressable.
+	if e.op == TokenKindBitwiseAnd {
+		y := c.expr(e.param)
+		if y.mode == modeInvalid {
+			x.invalid()
+			return
+		}
+		if _, isLit := e.param.(ASTCompositeLit); y.mode != modeVariable && !isLit {
+			c.errorf(e.pos, "I can't take the address of this because it isn't a variable")
+			x.invalid()
+			return
+		}
+		x.mode = modeValue
+		x.typ = c.ts.MakePointer(y.typ)
+		return
+	}
+
+	y := c.expr(e.param)
+	if y.mode == modeInvalid {
+		x.invalid()
+		return
+	}
+
+	switch e.op {
+	case TokenKindChannelArrow:
+		ch, ok := coreType(y.typ).(*DataTypeChan)
+		if !ok {
+			c.errorf(e.pos, "I can't receive from %s because it isn't a channel", c.describe(&y))
+			x.invalid()
+			return
+		}
+		if ch.dir == ChanDirectionIn {
+			c.errorf(e.pos, "I can't receive from a send-only channel")
+			x.invalid()
+			return
+		}
+		x.mode = modeCommaOk
+		x.typ = ch.elementType
+		return
+
+	case TokenKindAdd, TokenKindSubtract:
+		if !isNumeric(y.typ) {
+			c.errorf(e.pos, "the operator %s needs a number but it's been given %s", opString(e.op), c.describe(&y))
+			x.invalid()
+			return
+		}
+
+	case TokenKindBitwiseExor:
+		if !isInteger(y.typ) && basicKind(y.typ) != DataTypeKindUntypedInt && basicKind(y.typ) != DataTypeKindUntypedRune {
+			c.errorf(e.pos, "the operator ^ needs an integer but it's been given %s", c.describe(&y))
+			x.invalid()
+			return
+		}
+
+	case TokenKindNot:
+		if !isBoolean(y.typ) {
+			c.errorf(e.pos, "the operator ! needs a bool but it's been given %s", c.describe(&y))
+			x.invalid()
+			return
+		}
+
+	default:
+		c.errorf(e.pos, "%s isn't a unary operator", opString(e.op))
+		x.invalid()
+		return
+	}
+
+	x.typ = y.typ
+	x.mode = modeValue
+	if y.mode == modeConstant {
+		x.mode = modeConstant
+		x.val = constUnaryOp(e.op, y.val)
+		if e.op == TokenKindBitwiseExor && isUnsigned(y.typ) {
+			// ^x for unsigned x flips only the bits of its type.
+			bits := c.ts.Bits(Underlying(y.typ).(DataTypeSized))
+			mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(bits)), big.NewInt(1))
+			i, _ := x.val.Int()
+			x.val = MakeIntConstant(new(big.Int).And(i, mask))
+		}
+		c.checkRepresentable(x)
+	}
+}
+
+// checkRepresentable makes sure the value of a constant fits its type.
+func (c *Checker) checkRepresentable(x *operand) bool {
+	if x.mode != modeConstant {
+		return true
+	}
+
+	if isUntyped(x.typ) {
+		if x.val.tooLarge() {
+			c.errorf(x.expr.Pos(), "this constant is too large for me to work with")
+			x.invalid()
+			return false
+		}
+		return true
+	}
+
+	val, err := representable(c.ts, x.val, x.typ)
+	if err != representOk {
+		c.errorf(x.expr.Pos(), representErrorFormat(err), x.val, x.typ)
+		x.invalid()
+		return false
+	}
+
+	x.val = val
+	return true
+}
+
+// isShift returns true for the shift operators.
+func isShift(op TokenKind) bool {
+	return op == TokenKindShiftLeft || op == TokenKindShiftRight
+}
+
+// isComparison returns true for the comparison operators.
+func isComparison(op TokenKind) bool {
+	switch op {
+	case TokenKindEquals, TokenKindNotEqual, TokenKindLess, TokenKindLessEqual, TokenKindGreater, TokenKindGreaterEqual:
+		return true
+	}
+
+	return false
+}
+
+// binary checks a binary expression.
+func (c *Checker) binary(x *operand, e ASTBinaryExpr) {
+	left := c.expr(e.left)
+	right := c.expr(e.right)
+	if left.mode == modeInvalid || right.mode == modeInvalid {
+		x.invalid()
+		return
+	}
+
+	if isShift(e.op) {
+		c.shift(x, e, &left, &right)
+		return
+	}
+
+	if !c.matchTypes(&left, &right) {
+		x.invalid()
+		return
+	}
+
+	if isComparison(e.op) {
+		c.comparison(x, e, &left, &right)
+		return
+	}
+
+	if left.typ != right.typ {
+		c.errorf(e.pos, "I can't use %s with mismatched types %s and %s", opString(e.op), c.typeString(left.typ), c.typeString(right.typ))
+		x.invalid()
+		return
+	}
+
+	var ok bool
+	switch e.op {
+	case TokenKindAdd:
+		ok = isNumeric(left.typ) || isString(left.typ)
+	case TokenKindSubtract, TokenKindAsterisk, TokenKindDivide:
+		ok = isNumeric(left.typ)
+	case TokenKindModulus, TokenKindBitwiseAnd, TokenKindBitwiseOr, TokenKindBitwiseExor, TokenKindBitClear:
+		ok = isInteger(left.typ) || basicKind(left.typ) == DataTypeKindUntypedInt || basicKind(left.typ) == DataTypeKindUntypedRune
+	case TokenKindLogicalAnd, TokenKindLogicalOr:
+		ok = isBoolean(left.typ)
+	default:
+		c.errorf(e.pos, "%s isn't a binary operator", opString(e.op))
+		x.invalid()
+		return
+	}
+
+	if !ok {
+		c.errorf(e.pos, "the operator %s isn't defined for %s", opString(e.op), c.typeString(left.typ))
+		x.invalid()
+		return
+	}
+
+	// dividing integers by zero isn't allowed, nor is dividing constants.
+	if (e.op == TokenKindDivide || e.op == TokenKindModulus) && right.mode == modeConstant && right.val.isZero() &&
+		(left.mode == modeConstant || isInteger(left.typ)) {
+		c.errorf(e.right.Pos(), "I can't divide by zero")
+		x.invalid()
+		return
+	}
+
+	x.typ = left.typ
+	x.mode = modeValue
+	if left.mode == modeConstant && right.mode == modeConstant {
+		x.mode = modeConstant
+		x.val, _ = constBinaryOp(e.op, left.val, right.val)
+		c.checkRepresentable(x)
+	}
+}
+
+// matchTypes converts untyped operands of a binary operator so that both
+// sides have the same type where possible.
+func (c *Checker) matchTypes(left *operand, right *operand) bool {
+	lu := isUntyped(left.typ)
+	ru := isUntyped(right.typ)
+
+	switch {
+	case lu && !ru:
+		return c.convertUntyped(left, right.typ)
+
+	case !lu && ru:
+		return c.convertUntyped(right, left.typ)
+
+	case lu && ru:
+		// the larger untyped numeric kind wins.
+		lr, rr := untypedRank(left.typ), untypedRank(right.typ)
+		if lr > 0 && rr > 0 {
+			if lr > rr {
+				c.updateType(right, left.typ)
+			} else if rr > lr {
+				c.updateType(left, right.typ)
+			}
+		}
+	}
+
+	return true
+}
+
+// convertUntyped gives an untyped operand the type it takes in the context
+// of target. It reports an error if it can't.
+func (c *Checker) convertUntyped(x *operand, target DataType) bool {
+	if !isUntyped(x.typ) || target == nil {
+		return true
+	}
+
+	if isUntyped(target) {
+		// both untyped, so only the numeric kinds can be combined.
+		if untypedRank(x.typ) > 0 && untypedRank(target) > 0 && untypedRank(target) > untypedRank(x.typ) {
+			c.updateType(x, target)
+		}
+		return true
+	}
+
+	if isInterface(target) && basicKind(x.typ) != DataTypeKindUntypedNil {
+		// the value has to be boxed, so it takes its default type.
+		return c.convertUntyped(x, defaultType(c.ts, x.typ))
+	}
+
+	if !untypedCompatible(x.typ, target) {
+		c.errorf(x.expr.Pos(), "I can't convert this (%s) to %s", c.typeString(x.typ), c.typeString(target))
+		x.invalid()
+		return false
+	}
+
+	// a constant given a type parameter's type has to fit in every type in
+	// its type set, and isn't constant any more.
+	if tp, ok := target.(*DataTypeTypeParam); ok {
+		if x.mode == modeConstant {
+			for _, term := range tp.iface().terms {
+				if _, err := representable(c.ts, x.val, term.typ); err != representOk {
+					c.errorf(x.expr.Pos(), representErrorFormat(err), x.val, term.typ)
+					x.invalid()
+					return false
+				}
+			}
+			x.mode = modeValue
+		}
+		c.updateType(x, target)
+		return true
+	}
+
+	// the only non-constant untyped numbers come from shifts, which have
+	// to end up as integers.
+	if x.mode != modeConstant && untypedRank(x.typ) > 0 && !isInteger(target) {
+		c.errorf(x.expr.Pos(), "the shifted value has to be an integer but here it would be %s", c.typeString(target))
+		x.invalid()
+		return false
+	}
+
+	// constants have to fit in the type they're given.
+	if x.mode == modeConstant {
+		val, err := representable(c.ts, x.val, target)
+		if err != representOk {
+			c.errorf(x.expr.Pos(), representErrorFormat(err), x.val, target)
+			x.invalid()
+			return false
+		}
+		x.val = val
+	}
+
+	c.updateType(x, target)
+	return true
+}
+
+// updateType changes the type of an untyped expression once the type it
+// takes has been worked out. The types of the untyped expressions it's
+// made from are updated too.
+func (c *Checker) updateType(x *operand, typ DataType) {
+	x.typ = typ
+	c.record(x)
+	c.updateExprType(x.expr, typ)
+}
+
+// updateExprType updates the recorded types of the untyped parts of an
+// expression.
+func (c *Checker) updateExprType(ast AST, typ DataType) {
+	key := exprKey{c.filename, ast.Pos()}
+	old, ok := c.info.types[key]
+	if !ok || !isUntyped(old) {
+		return
+	}
+
+	switch e := ast.(type) {
+	case ASTUnaryExpr:
+		c.updateExprType(e.param, typ)
+	case ASTBinaryExpr:
+		if isComparison(e.op) {
+			// the operands of a comparison have their own types.
+			break
+		}
+		c.updateExprType(e.left, typ)
+		if !isShift(e.op) {
+			c.updateExprType(e.right, typ)
+		}
+	}
+
+	c.info.types[key] = typ
+}
+
+// shift checks a shift expression.
+func (c *Checker) shift(x *operand, e ASTBinaryExpr, left *operand, right *operand) {
+	// the shift count has to be an integer.
+	if isUntyped(right.typ) {
+		if untypedRank(right.typ) == 0 {
+			c.errorf(e.right.Pos(), "the shift count has to be an integer but it's %s", c.describe(right))
+			x.invalid()
+			return
+		}
+		if !c.convertUntyped(right, c.ts.UintType()) {
+			x.invalid()
+			return
+		}
+	} else if !isInteger(right.typ) {
+		c.errorf(e.right.Pos(), "the shift count has to be an integer but it's %s", c.describe(right))
+		x.invalid()
+		return
+	}
+
+	var count uint
+	if right.mode == modeConstant {
+		if right.val.Sign() < 0 {
+			c.errorf(e.right.Pos(), "the shift count %s can't be negative", right.val)
+			x.invalid()
+			return
+		}
+		n, ok := right.val.Uint64()
+		if left.mode == modeConstant && (!ok || n > maxConstBits) {
+			c.errorf(e.right.Pos(), "the shift count %s is too large", right.val)
+			x.invalid()
+			return
+		}
+		count = uint(n)
+	}
+
+	// the shifted value has to be an integer too.
+	if isUntyped(left.typ) {
+		if untypedRank(left.typ) == 0 {
+			c.errorf(e.left.Pos(), "I can only shift integers, not %s", c.describe(left))
+			x.invalid()
+			return
+		}
+		typ := left.typ
+		if left.mode == modeConstant {
+			// an untyped float constant is fine if it's a whole number.
+			if _, exact := left.val.Int(); !exact {
+				c.errorf(e.left.Pos(), "I can only shift integers, not %s", left.val)
+				x.invalid()
+				return
+			}
+			if untypedRank(typ) > untypedRank(c.ts.UntypedRuneType()) {
+				typ = c.ts.UntypedIntType()
+			}
+		}
+		if right.mode == modeConstant && left.mode == modeConstant {
+			// a constant shift of an untyped constant is an untyped integer.
+			x.mode = modeConstant
+			x.typ = typ
+			x.val = constShift(e.op, left.val, count)
+			c.checkRepresentable(x)
+			return
+		}
+		// otherwise it takes its type from the context later on.
+		x.mode = modeValue
+		x.typ = c.ts.UntypedIntType()
+		return
+	}
+
+	if !isInteger(left.typ) {
+		c.errorf(e.left.Pos(), "I can only shift integers, not %s", c.describe(left))
+		x.invalid()
+		return
+	}
+
+	x.typ = left.typ
+	x.mode = modeValue
+	if left.mode == modeConstant && right.mode == modeConstant {
+		x.mode = modeConstant
+		x.val = constShift(e.op, left.val, count)
+		c.checkRepresentable(x)
+	}
+}
+
+// comparison checks a comparison expression.
+func (c *Checker) comparison(x *operand, e ASTBinaryExpr, left *operand, right *operand) {
+	okLR, _ := assignable(c.ts, left.typ, right.typ)
+	okRL, _ := assignable(c.ts, right.typ, left.typ)
+	if !okLR && !okRL {
+		c.errorf(e.pos, "I can't compare mismatched types %s and %s", c.typeString(left.typ), c.typeString(right.typ))
+		x.invalid()
+		return
+	}
+
+	leftNil := basicKind(left.typ) == DataTypeKindUntypedNil
+	rightNil := basicKind(right.typ) == DataTypeKindUntypedNil
+
+	switch e.op {
+	case TokenKindEquals, TokenKindNotEqual:
+		switch {
+		case leftNil && rightNil:
+			c.errorf(e.pos, "I can't compare nil with nil")
+			x.invalid()
+			return
+		case leftNil || rightNil:
+			// anything which can be nil can be compared with nil.
+		case !isComparable(left.typ):
+			c.errorf(e.pos, "I can't compare values of type %s", c.typeString(left.typ))
+			x.invalid()
+			return
+		case !isComparable(right.typ):
+			c.errorf(e.pos, "I can't compare values of type %s", c.typeString(right.typ))
+			x.invalid()
+			return
+		}
+
+	default:
+		if !isOrdered(left.typ) || !isOrdered(right.typ) {
+			c.errorf(e.pos, "the operator %s isn't defined for %s", opString(e.op), c.typeString(left.typ))
+			x.invalid()
+			return
+		}
+	}
+
+	x.typ = c.ts.UntypedBoolType()
+	x.mode = modeValue
+	if left.mode == modeConstant && right.mode == modeConstant {
+		x.mode = modeConstant
+		x.val = MakeBoolConstant(constCompare(e.op, left.val, right.val))
+	}
+}
+
+// assignment checks that an operand can be assigned to a variable of type
+// t, giving untyped values their final type. context says what kind of
+// assignment it is for error messages.
+func (c *Checker) assignment(x *operand, t DataType, context string) bool {
+	if x.mode == modeInvalid || t == nil {
+		return false
+	}
+
+	if isUntyped(x.typ) {
+		if isInterface(t) && basicKind(x.typ) == DataTypeKindUntypedNil {
+			c.updateType(x, t)
+			return true
+		}
+		if !c.convertUntyped(x, t) {
+			return false
+		}
+	}
+
+	ok, reason := assignable(c.ts, x.typ, t)
+	if !ok {
+		if reason != "" {
+			c.errorf(x.expr.Pos(), "I can't use %s as type %s in %s - %s", c.describe(x), c.typeString(t), context, reason)
+		} else {
+			c.errorf(x.expr.Pos(), "I can't use %s as type %s in %s", c.describe(x), c.typeString(t), context)
+		}
+		return false
+	}
+
+	c.referenceConversion(x.typ, t)
+	return true
+}
+
+// defaultAssignment gives an untyped operand its default type, for when
+// a value is assigned to a new variable.
+func (c *Checker) defaultAssignment(x *operand) bool {
+	if !isUntyped(x.typ) {
+		return true
+	}
+
+	if basicKind(x.typ) == DataTypeKindUntypedNil {
+		c.errorf(x.expr.Pos(), "I can't work out a type from nil")
+		x.invalid()
+		return false
+	}
+
+	return c.convertUntyped(x, defaultType(c.ts, x.typ))
+}
+
+// call checks a function call, conversion or call of a builtin.
+func (c *Checker) call(x *operand, e ASTCall) {
+	fn := c.exprOrTypeOrBuiltin(e.function)
+	x.callee = fn.mode
+	x.builtin = fn.builtin
+	switch fn.mode {
+	case modeInvalid:
+		for _, arg := range e.args {
+			c.rawExpr(arg)
+		}
+		x.invalid()
+		return
+
+	case modeType:
+		c.conversion(x, e, fn.typ)
+		return
+
+	case modeBuiltin:
+		c.builtinCall(x, e, fn.builtin)
+		return
+	}
+
+	args := c.arguments(e.args)
+	var sig *DataTypeFunc
+	if fn.generic != nil {
+		// the type arguments which weren't given are worked out from the
+		// arguments.
+		sig = c.inferCall(e, &fn, args)
+		if sig == nil {
+			x.invalid()
+			return
+		}
+	} else {
+		var ok bool
+		sig, ok = coreType(fn.typ).(*DataTypeFunc)
+		if !ok {
+			c.errorf(e.function.Pos(), "I can't call %s because it isn't a function", c.describe(&fn))
+			x.invalid()
+			return
+		}
+	}
+
+	c.checkArguments(e, sig, args)
+
+	switch len(sig.returns) {
+	case 0:
+		x.mode = modeNoValue
+	case 1:
+		x.mode = modeValue
+		x.typ = sig.returns[0]
+	default:
+		x.mode = modeValue
+		x.tuple = sig.returns
+	}
+}
+
+// exprOrTypeOrBuiltin checks the function part of a call.
+func (c *Checker) exprOrTypeOrBuiltin(ast AST) operand {
+	x := c.rawExpr(ast)
+	if x.mode == modeType || x.mode == modeBuiltin || x.generic != nil {
+		return x
+	}
+	c.singleValue(&x)
+	return x
+}
+
+// arguments checks the arguments of a call. A single argument which is a
+// call giving several values is spread into several arguments.
+func (c *Checker) arguments(argASTs []AST) []operand {
+	if len(argASTs) == 1 {
+		x := c.rawExpr(argASTs[0])
+		if x.mode == modeValue && x.tuple != nil {
+			args := make([]operand, len(x.tuple))
+			for i, typ := range x.tuple {
+				args[i] = operand{mode: modeValue, expr: argASTs[0], typ: typ}
+			}
+			return args
+		}
+		c.singleValue(&x)
+		return []operand{x}
+	}
+
+	args := make([]operand, len(argASTs))
+	for i, arg := range argASTs {
+		args[i] = c.expr(arg)
+	}
+
+	return args
+}
+
+// checkArguments matches the arguments of a call against the function's
+// parameters.
+func (c *Checker) checkArguments(e ASTCall, sig *DataTypeFunc, args []operand) {
+	for _, arg := range args {
+		if arg.mode == modeInvalid {
+			return
+		}
+	}
+
+	params := sig.params
+	if e.ellipsis {
+		if !sig.variadic {
+			c.errorf(e.pos, "I can't use '...' here because the function isn't variadic")
+			return
+		}
+		if len(args) != len(params) {
+			c.errorf(e.pos, "this call has %d arguments but the function has %d parameters", len(args), len(params))
+			return
+		}
+		for i := range args {
+			c.assignment(&args[i], params[i], "argument")
+		}
+		return
+	}
+
+	if sig.variadic {
+		if len(args) < len(params)-1 {
+			c.errorf(e.pos, "this call has %d arguments but the function needs at least %d", len(args), len(params)-1)
+			return
+		}
+		elemType := params[len(params)-1].(*DataTypeUnary).subType
+		for i := range args {
+			if i < len(params)-1 {
+				c.assignment(&args[i], params[i], "argument")
+			} else {
+				c.assignment(&args[i], elemType, "argument")
+			}
+		}
+		return
+	}
+
+	if len(args) != len(params) {
+		if len(args) < len(params) {
+			c.errorf(e.pos, "there are not enough arguments in this call - it has %d but the function needs %d", len(args), len(params))
+		} else {
+			c.errorf(e.pos, "there are too many arguments in this call - it has %d but the function needs %d", len(args), len(params))
+		}
+		return
+	}
+
+	for i := range args {
+		c.assignment(&args[i], params[i], "argument")
+	}
+}
+
+// conversion checks a conversion of the form T(x).
+func (c *Checker) conversion(x *operand, e ASTCall, t DataType) {
+	if len(e.args) != 1 || e.ellipsis {
+		c.errorf(e.pos, "a conversion to %s needs exactly one value", c.typeString(t))
+		for _, arg := range e.args {
+			c.rawExpr(arg)
+		}
+		x.invalid()
+		return
+	}
+
+	y := c.expr(e.args[0])
+	if y.mode == modeInvalid {
+		x.invalid()
+		return
+	}
+
+	ok := false
+	if isUntyped(y.typ) {
+		switch {
+		case basicKind(y.typ) == DataTypeKindUntypedNil:
+			ok = hasNil(t)
+			if ok {
+				c.updateType(&y, t)
+			}
+		case untypedCompatible(y.typ, t), isInterface(t):
+			// convertUntyped reports its own errors.
+			if !c.convertUntyped(&y, t) {
+				x.invalid()
+				return
+			}
+			ok = true
+		default:
+			// eg. string(65)
+			def := defaultType(c.ts, y.typ)
+			ok = convertible(c.ts, def, t)
+			if ok {
+				c.updateType(&y, def)
+			}
+		}
+	} else {
+		ok = convertible(c.ts, y.typ, t)
+	}
+
+	if !ok {
+		c.errorf(e.pos, "I can't convert %s to type %s", c.describe(&y), c.typeString(t))
+		x.invalid()
+		return
+	}
+
+	c.referenceConversion(y.typ, t)
+	x.typ = t
+	x.mode = modeValue
+	if y.mode != modeConstant || !isConstType(t) {
+		return
+	}
+
+	// converting an integer to a string gives the UTF-8 of that rune.
+	x.mode = modeConstant
+	if isString(t) && !isString(y.typ) {
+		r := unicode.ReplacementChar
+		if i, ok := y.val.Int64(); ok && i >= 0 && i <= unicode.MaxRune && utf8.ValidRune(rune(i)) {
+			r = rune(i)
+		}
+		x.val = MakeStringConstant(string(r))
+		return
+	}
+
+	val, err := representable(c.ts, y.val, t)
+	if err != representOk {
+		c.errorf(e.pos, representErrorFormat(err), y.val, t)
+		x.invalid()
+		return
+	}
+	x.val = val
+}
+
+// builtinCall checks a call of a builtin function.
+func (c *Checker) builtinCall(x *operand, e ASTCall, id builtinID) {
+	name := ""
+	for n, i := range builtinNames {
+		if i == id {
+			name = n
+		}
+	}
+	for n, i := range unsafeBuiltinNames {
+		if i == id {
+			name = "unsafe." + n
+		}
+	}
+
+	// unsafe.Offsetof needs to see the selector it's given.
+	if id == builtinOffsetof {
+		c.offsetof(x, e)
+		return
+	}
+
+	// make and new take a type as their first argument.
+	var args []operand
+	if id == builtinMake || id == builtinNew {
+		if len(e.args) == 0 {
+			c.errorf(e.pos, "%s needs a type as its first argument", name)
+			x.invalid()
+			return
+		}
+		t := c.exprOrType(e.args[0])
+		if t.mode == modeInvalid {
+			x.invalid()
+			return
+		}
+		if t.mode != modeType {
+			c.errorf(e.args[0].Pos(), "%s needs a type as its first argument but this isn't a type", name)
+			x.invalid()
+			return
+		}
+		args = append(args, t)
+		for _, arg := range e.args[1:] {
+			args = append(args, c.expr(arg))
+		}
+	} else {
+		args = c.arguments(e.args)
+	}
+
+	for _, arg := range args {
+		if arg.mode == modeInvalid {
+			x.invalid()
+			return
+		}
+	}
+
+	if e.ellipsis && id != builtinAppend {
+		c.errorf(e.pos, "I can't use '...' with %s", name)
+		x.invalid()
+		return
+	}
+
+	// checkCount makes sure the number of arguments is in range.
+	checkCount := func(min int, max int) bool {
+		if len(args) < min {
+			c.errorf(e.pos, "there are not enough arguments in this call to %s", name)
+			x.invalid()
+			return false
+		}
+		if max >= 0 && len(args) > max {
+			c.errorf(e.pos, "there are too many arguments in this call to %s", name)
+			x.invalid()
+			return false
+		}
+		return true
+	}
+
+	x.mode = modeValue
+	switch id {
+	case builtinAppend:
+		if !checkCount(1, -1) {
+			return
+		}
+		s := &args[0]
+		slice, ok := coreType(s.typ).(*DataTypeUnary)
+		if !ok || slice.kind != DataTypeKindSlice {
+			c.errorf(s.expr.Pos(), "the first argument to append has to be a slice but it's %s", c.describe(s))
+			x.invalid()
+			return
+		}
+		x.typ = s.typ
+		if e.ellipsis {
+			if len(args) != 2 {
+				c.errorf(e.pos, "append with '...' needs exactly two arguments")
+				x.invalid()
+				return
+			}
+			// append([]byte, string...) is a special case.
+			if Underlying(slice.subType) == c.ts.Uint8Type() && isString(args[1].typ) {
+				c.convertUntyped(&args[1], c.ts.StringType())
+				return
+			}
+			c.assignment(&args[1], c.ts.MakeSlice(slice.subType), "argument to append")
+			return
+		}
+		for i := range args[1:] {
+			c.assignment(&args[i+1], slice.subType, "argument to append")
+		}
+
+	case builtinCap, builtinLen:
+		if !checkCount(1, 1) {
+			return
+		}
+		a := &args[0]
+		ok := false
+		var arr *DataTypeArray
+		typ := Underlying(a.typ)
+		if ptr, isPtr := typ.(*DataTypeUnary); isPtr && ptr.kind == DataTypeKindPointer {
+			arr, ok = Underlying(ptr.subType).(*DataTypeArray)
+		} else {
+			arr, _ = typ.(*DataTypeArray)
+			switch typ.DataTypeKind() {
+			case DataTypeKindArray, DataTypeKindSlice, DataTypeKindChan:
+				ok = true
+			case DataTypeKindString, DataTypeKindMap, DataTypeKindUntypedString:
+				ok = id == builtinLen
+			}
+		}
+		if !ok {
+			c.errorf(a.expr.Pos(), "I can't use %s as an argument to %s", c.describe(a), name)
+			x.invalid()
+			return
+		}
+		c.convertUntyped(a, c.ts.StringType())
+		x.typ = c.ts.IntType()
+		switch {
+		case a.mode == modeConstant:
+			x.mode = modeConstant
+			x.val = MakeInt64Constant(int64(len(a.val.StringVal())))
+		case arr != nil && !hasCallOrReceive(a.expr):
+			// the length of an array is known without evaluating it.
+			x.mode = modeConstant
+			x.val = MakeInt64Constant(arr.length)
+		}
+
+	case builtinClear:
+		if !checkCount(1, 1) {
+			return
+		}
+		switch Underlying(args[0].typ).DataTypeKind() {
+		case DataTypeKindMap, DataTypeKindSlice:
+		default:
+			c.errorf(args[0].expr.Pos(), "clear needs a map or a slice but it's been given %s", c.describe(&args[0]))
+			x.invalid()
+			return
+		}
+		x.mode = modeNoValue
+
+	case builtinClose:
+		if !checkCount(1, 1) {
+			return
+		}
+		ch, ok := Underlying(args[0].typ).(*DataTypeChan)
+		if !ok {
+			c.errorf(args[0].expr.Pos(), "close needs a channel but it's been given %s", c.describe(&args[0]))
+			x.invalid()
+			return
+		}
+		if ch.dir == ChanDirectionOut {
+			c.errorf(args[0].expr.Pos(), "I can't close a receive-only channel")
+			x.invalid()
+			return
+		}
+		x.mode = modeNoValue
+
+	case builtinComplex:
+		if !checkCount(2, 2) {
+			return
+		}
+		re, im := &args[0], &args[1]
+		if !c.matchTypes(re, im) {
+			x.invalid()
+			return
+		}
+		if isUntyped(re.typ) && isUntyped(im.typ) {
+			if !isNumeric(re.typ) || !isNumeric(im.typ) {
+				c.errorf(e.pos, "complex needs two floating point numbers")
+				x.invalid()
+				return
+			}
+			x.typ = c.ts.UntypedComplexType()
+		} else {
+			switch {
+			case re.typ != im.typ:
+				c.errorf(e.pos, "complex needs two numbers of the same type, not %s and %s", c.typeString(re.typ), c.typeString(im.typ))
+				x.invalid()
+				return
+			case Underlying(re.typ) == c.ts.Float32Type():
+				x.typ = c.ts.Complex64Type()
+			case Underlying(re.typ) == c.ts.Float64Type():
+				x.typ = c.ts.Complex128Type()
+			default:
+				c.errorf(e.pos, "complex needs two floating point numbers, not %s", c.typeString(re.typ))
+				x.invalid()
+				return
+			}
+		}
+		if re.mode == modeConstant && im.mode == modeConstant {
+			x.mode = modeConstant
+			x.val = MakeComplexConstant(re.val.Float(), im.val.Float())
+		}
+
+	case builtinReal, builtinImag:
+		if !checkCount(1, 1) {
+			return
+		}
+		a := &args[0]
+		switch {
+		case isUntyped(a.typ) && isNumeric(a.typ):
+			x.typ = c.ts.UntypedFloatType()
+		case Underlying(a.typ) == c.ts.Complex64Type():
+			x.typ = c.ts.Float32Type()
+		case Underlying(a.typ) == c.ts.Complex128Type():
+			x.typ = c.ts.Float64Type()
+		default:
+			c.errorf(a.expr.Pos(), "%s needs a complex number but it's been given %s", name, c.describe(a))
+			x.invalid()
+			return
+		}
+		if a.mode == modeConstant {
+			x.mode = modeConstant
+			if id == builtinReal {
+				x.val = MakeFloatConstant(a.val.Float())
+			} else {
+				x.val = MakeFloatConstant(a.val.imag())
+			}
+		}
+
+	case builtinCopy:
+		if !checkCount(2, 2) {
+			return
+		}
+		dst, ok := Underlying(args[0].typ).(*DataTypeUnary)
+		if !ok || dst.kind != DataTypeKindSlice {
+			c.errorf(args[0].expr.Pos(), "the destination of copy has to be a slice but it's %s", c.describe(&args[0]))
+			x.invalid()
+			return
+		}
+		if Underlying(dst.subType) == c.ts.Uint8Type() && isString(args[1].typ) {
+			c.convertUntyped(&args[1], c.ts.StringType())
+		} else if src, ok := Underlying(args[1].typ).(*DataTypeUnary); !ok || src.kind != DataTypeKindSlice || src.subType != dst.subType {
+			c.errorf(args[1].expr.Pos(), "the source of copy has to be a slice of %s but it's %s", c.typeString(dst.subType), c.describe(&args[1]))
+			x.invalid()
+			return
+		}
+		x.typ = c.ts.IntType()
+
+	case builtinDelete:
+		if !checkCount(2, 2) {
+			return
+		}
+		m, ok := Underlying(args[0].typ).(*DataTypeMap)
+		if !ok {
+			c.errorf(args[0].expr.Pos(), "delete needs a map but it's been given %s", c.describe(&args[0]))
+			x.invalid()
+			return
+		}
+		c.assignment(&args[1], m.keyType, "argument to delete")
+		x.mode = modeNoValue
+
+	case builtinMake:
+		t := args[0].typ
+		min := 1
+		core := coreType(t)
+		if core == nil {
+			core = t
+		}
+		switch core.DataTypeKind() {
+		case DataTypeKindSlice:
+			min = 2
+		case DataTypeKindMap, DataTypeKindChan:
+		default:
+			c.errorf(args[0].expr.Pos(), "I can only make slices, maps and channels, not %s", c.typeString(t))
+			x.invalid()
+			return
+		}
+		max := min + 1
+		if min == 1 {
+			max = 2
+		}
+		if !checkCount(min, max) {
+			return
+		}
+		for i := range args[1:] {
+			c.sizeArgument(&args[i+1])
+		}
+		x.typ = t
+
+	case builtinNew:
+		if !checkCount(1, 1) {
+			return
+		}
+		x.typ = c.ts.MakePointer(args[0].typ)
+
+	case builtinPanic:
+		if !checkCount(1, 1) {
+			return
+		}
+		c.assignment(&args[0], c.ts.AnyType(), "argument to panic")
+		x.mode = modeNoValue
+
+	case builtinPrint, builtinPrintln:
+		for i := range args {
+			c.defaultAssignment(&args[i])
+		}
+		x.mode = modeNoValue
+
+	case builtinRecover:
+		if !checkCount(0, 0) {
+			return
+		}
+		x.typ = c.ts.AnyType()
+
+	case builtinMin, builtinMax:
+		if !checkCount(1, -1) {
+			return
+		}
+		for i := range args[1:] {
+			if !c.matchTypes(&args[0], &args[i+1]) {
+				x.invalid()
+				return
+			}
+		}
+		for i := range args {
+			if !c.matchTypes(&args[i], &args[0]) {
+				x.invalid()
+				return
+			}
+			if !isOrdered(args[i].typ) {
+				c.errorf(args[i].expr.Pos(), "%s needs values which can be ordered but it's been given %s", name, c.describe(&args[i]))
+				x.invalid()
+				return
+			}
+			if args[i].typ != args[0].typ {
+				c.errorf(args[i].expr.Pos(), "%s needs values of the same type but it's been given %s and %s", name, c.typeString(args[0].typ), c.typeString(args[i].typ))
+				x.invalid()
+				return
+			}
+		}
+		x.typ = args[0].typ
+		x.mode = modeConstant
+		for _, arg := range args {
+			if arg.mode != modeConstant {
+				x.mode = modeValue
+			}
+		}
+		if x.mode == modeConstant {
+			// the operands all have the same type so the result is one of them.
+			op := TokenKindLess
+			if id == builtinMax {
+				op = TokenKindGreater
+			}
+			x.val = args[0].val
+			for _, arg := range args[1:] {
+				if constCompare(op, arg.val, x.val) {
+					x.val = arg.val
+				}
+			}
+		}
+
+	case builtinAlignof, builtinSizeof:
+		if !checkCount(1, 1) {
+			return
+		}
+		a := &args[0]
+		c.convertUntyped(a, defaultType(c.ts, a.typ))
+		if a.mode == modeInvalid {
+			x.invalid()
+			return
+		}
+		n := c.ts.Sizeof(a.typ)
+		if id == builtinAlignof {
+			n = c.ts.Alignof(a.typ)
+		}
+		x.mode = modeConstant
+		x.typ = c.ts.UintptrType()
+		x.val = MakeInt64Constant(n)
+	}
+}
+
+// offsetof checks a call to unsafe.Offsetof, which needs a selector of a
+// struct field. The field can't be reached through a pointer.
+func (c *Checker) offsetof(x *operand, e ASTCall) {
+	if len(e.args) != 1 || e.ellipsis {
+		c.errorf(e.pos, "unsafe.Offsetof needs exactly one argument")
+		for _, arg := range e.args {
+			c.rawExpr(arg)
+		}
+		x.invalid()
+		return
+	}
+
+	sel, ok := e.args[0].(ASTSelector)
+	if !ok {
+		c.errorf(e.args[0].Pos(), "unsafe.Offsetof needs a struct field like 'x.f'")
+		c.rawExpr(e.args[0])
+		x.invalid()
+		return
+	}
+
+	y := c.expr(sel)
+	if y.mode == modeInvalid {
+		x.invalid()
+		return
+	}
+
+	base := c.info.TypeOf(c.filename, sel.expr)
+	if ptr, ok := Underlying(base).(*DataTypeUnary); ok && ptr.kind == DataTypeKindPointer {
+		base = ptr.subType
+	}
+	index, indirect, found := lookupFieldPath(base, sel.name)
+	if !found {
+		c.errorf(sel.pos, "'%s' is a method, not a field", sel.name)
+		x.invalid()
+		return
+	}
+	if indirect {
+		c.errorf(sel.pos, "the field '%s' is reached through a pointer so it doesn't have an offset", sel.name)
+		x.invalid()
+		return
+	}
+
+	x.mode = modeConstant
+	x.typ = c.ts.UintptrType()
+	x.val = MakeInt64Constant(c.ts.offsetOfPath(base, index))
+}
+
+// hasCallOrReceive returns true if an expression contains a function call
+// or a channel receive. Calls inside function literals don't count since
+// they aren't run when the expression is evaluated.
+func hasCallOrReceive(ast AST) bool {
+	switch e := ast.(type) {
+	case ASTCall:
+		return true
+	case ASTUnaryExpr:
+		return e.op == TokenKindChannelArrow || hasCallOrReceive(e.param)
+	case ASTBinaryExpr:
+		return hasCallOrReceive(e.left) || hasCallOrReceive(e.right)
+	case ASTSelector:
+		return hasCallOrReceive(e.expr)
+	case ASTIndex:
+		return hasCallOrReceive(e.expr) || hasCallOrReceive(e.index)
+	case ASTSliceExpr:
+		return hasCallOrReceive(e.expr) || hasCallOrReceive(e.low) || hasCallOrReceive(e.high) || hasCallOrReceive(e.max)
+	case ASTTypeAssertion:
+		return hasCallOrReceive(e.expr)
+	case ASTKeyedElement:
+		return hasCallOrReceive(e.key) || hasCallOrReceive(e.value)
+	case ASTCompositeLit:
+		for _, elem := range e.elements {
+			if hasCallOrReceive(elem) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// sizeArgument checks a length, capacity or index argument, which has to
+// be an integer.
+func (c *Checker) sizeArgument(x *operand) bool {
+	if x.mode == modeInvalid {
+		return false
+	}
+
+	if isUntyped(x.typ) {
+		if untypedRank(x.typ) == 0 {
+			c.errorf(x.expr.Pos(), "I was expecting an integer here but this is %s", c.describe(x))
+			return false
+		}
+		if !c.convertUntyped(x, c.ts.IntType()) {
+			return false
+		}
+	} else if !isInteger(x.typ) {
+		c.errorf(x.expr.Pos(), "I was expecting an integer here but this is %s", c.describe(x))
+		return false
+	}
+
+	if x.mode == modeConstant && x.val.Sign() < 0 {
+		c.errorf(x.expr.Pos(), "this can't be negative but it's %s", x.val)
+		return false
+	}
+
+	return true
+}
+
+// selector checks a selector expression - a field, method or method
+// expression.
+func (c *Checker) selector(x *operand, e ASTSelector) {
+	y := c.exprOrType(e.expr)
+	if y.mode == modeInvalid {
+		x.invalid()
+		return
+	}
+
+	typ, isMethod, method, ptrRecv, found, ambiguous := lookupFieldOrMethod(y.typ, e.name)
+	if ambiguous {
+		c.errorf(e.pos, "'%s' is ambiguous in %s", e.name, c.typeString(y.typ))
+		x.invalid()
+		return
+	}
+	if !found {
+		c.errorf(e.pos, "%s has no field or method called '%s'", c.typeString(y.typ), e.name)
+		x.invalid()
+		return
+	}
+
+	// method expressions of the form T.m
+	if y.mode == modeType {
+		if !isMethod {
+			c.errorf(e.pos, "'%s' is a field, not a method, so I can't use it with the type %s", e.name, c.typeString(y.typ))
+			x.invalid()
+			return
+		}
+		if ptrRecv && !isPointer(y.typ) {
+			c.errorf(e.pos, "'%s' has a pointer receiver so it has to be used as (*%s).%s", e.name, c.typeString(y.typ), e.name)
+			x.invalid()
+			return
+		}
+		c.referenceMethod(method, e.name)
+		sig := typ.(*DataTypeFunc)
+		params := append([]DataType{y.typ}, sig.params...)
+		x.mode = modeValue
+		x.typ = c.ts.MakeFunc(params, sig.returns, sig.variadic)
+		return
+	}
+
+	if isMethod {
+		if ptrRecv && !isPointer(y.typ) && y.mode != modeVariable {
+			c.errorf(e.pos, "I can't call the pointer method '%s' on this because it isn't addressable", e.name)
+			x.invalid()
+			return
+		}
+		c.referenceMethod(method, e.name)
+		x.mode = modeValue
+		x.typ = typ
+		return
+	}
+
+	// fields of variables and of anything reached through a pointer are
+	// addressable.
+	x.mode = modeValue
+	if y.mode == modeVariable || isPointer(y.typ) {
+		x.mode = modeVariable
+	}
+	x.typ = typ
+}
+
+// isPointer returns true if a type is a pointer type.
+func isPointer(dt DataType) bool {
+	ptr, ok := Underlying(dt).(*DataTypeUnary)
+	return ok && ptr.kind == DataTypeKindPointer
+}
+
+// lookupFieldOrMethod finds a field or method of a type, searching through
+// embedded fields. For methods the returned type is the method's signature
+// and method is the method's declaration if it's declared on a defined type
+// rather than being from an interface. ptrRecv is true if a method needs a
+// pointer receiver.
+func lookupFieldOrMethod(dt DataType, name string) (typ DataType, isMethod bool, method *NamedMethod, ptrRecv bool, found bool, ambiguous bool) {
+	if name == "_" {
+		return nil, false, nil, false, false, false
+	}
+
+	// a pointer to a defined type or struct is automatically dereferenced.
+	viaPointer := false
+	if ptr, ok := dt.(*DataTypeUnary); ok && ptr.kind == DataTypeKindPointer {
+		dt = ptr.subType
+		viaPointer = true
+	}
+
+	seen := make(map[DataType]bool)
+	current := []embeddedType{{dt, nil, false}}
+	for len(current) > 0 {
+		var next []embeddedType
+		matches := 0
+		for _, e := range current {
+			t := e.typ
+			if seen[t] {
+				continue
+			}
+			seen[t] = true
+
+			if named, ok := t.(*DataTypeNamed); ok {
+				if nm := named.Method(name); nm != nil {
+					// a pointer method of a field embedded by pointer
+					// doesn't need the outer value to be addressable.
+					typ, isMethod, method, ptrRecv = nm.sig, true, nm, nm.pointerReceiver && !e.indirect
+					matches++
+					continue
+				}
+			}
+
+			switch u := Underlying(t).(type) {
+			case *DataTypeStruct:
+				for _, field := range u.fields {
+					if field.name == name {
+						typ, isMethod, method, ptrRecv = field.typ, false, nil, false
+						matches++
+					}
+					if field.embedded {
+						embedded, indirect := field.typ, e.indirect
+						if ptr, ok := embedded.(*DataTypeUnary); ok && ptr.kind == DataTypeKindPointer {
+							embedded, indirect = ptr.subType, true
+						}
+						next = append(next, embeddedType{embedded, nil, indirect})
+					}
+				}
+
+			case *DataTypeInterface:
+				if viaPointer && t == dt {
+					// pointers to interfaces don't have methods.
+					break
+				}
+				for _, m := range u.methods {
+					if m.name == name {
+						typ, isMethod, method, ptrRecv = m.sig, true, nil, false
+						matches++
+					}
+				}
+
+			case *DataTypeTypeParam:
+				// a type parameter has the methods of its constraint, but a
+				// pointer to one doesn't.
+				iface := u.iface()
+				if iface == nil || viaPointer && t == dt {
+					break
+				}
+				for _, m := range iface.methods {
+					if m.name == name {
+						typ, isMethod, method, ptrRecv = m.sig, true, nil, false
+						matches++
+					}
+				}
+			}
+		}
+
+		if matches > 1 {
+			return nil, false, nil, false, false, true
+		}
+		if matches == 1 {
+			return typ, isMethod, method, ptrRecv, true, false
+		}
+		current = next
+	}
+
+	return nil, false, nil, false, false, false
+}
+
+// index checks an index expression.
+func (c *Checker) index(x *operand, e ASTIndex) {
+	// it might be a generic function or type with a type argument.
+	y := c.rawExpr(e.expr)
+	if y.mode == modeType || y.generic != nil {
+		c.instance(x, e.pos, y, []AST{e.index})
+		return
+	}
+	c.singleValue(&y)
+	if y.mode == modeInvalid {
+		c.rawExpr(e.index)
+		x.invalid()
+		return
+	}
+
+	typ := coreType(y.typ)
+	if ptr, ok := typ.(*DataTypeUnary); ok && ptr.kind == DataTypeKindPointer {
+		if arr, ok := Underlying(ptr.subType).(*DataTypeArray); ok {
+			// pointers to arrays can be indexed directly.
+			typ = arr
+			y.mode = modeVariable
+		}
+	}
+
+	// the length is known for arrays and constant strings.
+	length := int64(-1)
+	switch t := typ.(type) {
+	case *DataTypeMap:
+		key := c.expr(e.index)
+		c.assignment(&key, t.keyType, "map index")
+		x.mode = modeMapIndex
+		x.typ = t.valueType
+		return
+
+	case *DataTypeArray:
+		x.mode = modeValue
+		if y.mode == modeVariable {
+			x.mode = modeVariable
+		}
+		x.typ = t.elementType
+		length = t.length
+
+	case *DataTypeUnary:
+		if t.kind != DataTypeKindSlice {
+			c.errorf(e.pos, "I can't index %s", c.describe(&y))
+			c.rawExpr(e.index)
+			x.invalid()
+			return
+		}
+		x.mode = modeVariable
+		x.typ = t.subType
+
+	default:
+		if !isString(typ) {
+			c.errorf(e.pos, "I can't index %s", c.describe(&y))
+			c.rawExpr(e.index)
+			x.invalid()
+			return
+		}
+		// the bytes of a string can't be changed.
+		c.convertUntyped(&y, c.ts.StringType())
+		x.mode = modeValue
+		x.typ = c.ts.ByteType()
+		if y.mode == modeConstant {
+			length = int64(len(y.val.StringVal()))
+		}
+	}
+
+	i := c.expr(e.index)
+	if c.sizeArgument(&i) && i.mode == modeConstant && length >= 0 {
+		if n, ok := i.val.Int64(); !ok || n >= length {
+			c.errorf(e.index.Pos(), "the index %s is out of range - it has to be less than %d", i.val, length)
+		}
+	}
+}
+
+// sliceExpr checks a slice expression.
+func (c *Checker) sliceExpr(x *operand, e ASTSliceExpr) {
+	y := c.expr(e.expr)
+	for _, bound := range []AST{e.low, e.high, e.max} {
+		if bound != nil {
+			b := c.expr(bound)
+			c.sizeArgument(&b)
+		}
+	}
+	if y.mode == modeInvalid {
+		x.invalid()
+		return
+	}
+
+	x.mode = modeValue
+	switch t := coreType(y.typ).(type) {
+	case *DataTypeArray:
+		if y.mode != modeVariable {
+			c.errorf(e.pos, "I can't slice this array because it isn't addressable")
+			x.invalid()
+			return
+		}
+		x.typ = c.ts.MakeSlice(t.elementType)
+
+	case *DataTypeUnary:
+		if t.kind == DataTypeKindSlice {
+			x.typ = y.typ
+			return
+		}
+		if arr, ok := Underlying(t.subType).(*DataTypeArray); ok {
+			x.typ = c.ts.MakeSlice(arr.elementType)
+			return
+		}
+		c.errorf(e.pos, "I can't slice %s", c.describe(&y))
+		x.invalid()
+
+	default:
+		if !isString(y.typ) {
+			c.errorf(e.pos, "I can't slice %s", c.describe(&y))
+			x.invalid()
+			return
+		}
+		if e.max != nil {
+			c.errorf(e.max.Pos(), "strings can't be sliced with a capacity")
+			x.invalid()
+			return
+		}
+		c.convertUntyped(&y, c.ts.StringType())
+		x.typ = y.typ
+	}
+}
+
+// typeAssertion checks a type assertion of the form x.(T).
+func (c *Checker) typeAssertion(x *operand, e ASTTypeAssertion) {
+	y := c.expr(e.expr)
+	if y.mode == modeInvalid {
+		x.invalid()
+		return
+	}
+
+	iface, ok := Underlying(y.typ).(*DataTypeInterface)
+	if !ok {
+		c.errorf(e.expr.Pos(), "I can only use a type assertion on an interface, not %s", c.describe(&y))
+		x.invalid()
+		return
+	}
+
+	if e.typ == nil {
+		c.errorf(e.pos, "'.(type)' can only be used in a type switch")
+		x.invalid()
+		return
+	}
+
+	t := c.makeType(e.typ)
+	if t == nil {
+		x.invalid()
+		return
+	}
+
+	c.checkAssertable(e.pos, t, iface)
+	x.mode = modeCommaOk
+	x.typ = t
+}
+
+// checkAssertable makes sure a value of interface type could hold a value
+// of type t.
+func (c *Checker) checkAssertable(pos SrcSpan, t DataType, iface *DataTypeInterface) bool {
+	if isInterface(t) {
+		return true
+	}
+
+	ok, missing := c.ts.Implements(t, iface)
+	if !ok {
+		c.errorf(pos, "%s can't be in this interface because %s", c.typeString(t), missing)
+	}
+
+	return ok
+}
+
+// compositeLit checks a composite literal. hint is the type to use if the
+// literal's type has been left out.
+func (c *Checker) compositeLit(x *operand, e ASTCompositeLit, hint DataType) {
+	var t DataType
+	switch {
+	case e.typ != nil:
+		// '[...]T' gets its length from the number of elements.
+		if arr, ok := e.typ.(ASTDataTypeArray); ok {
+			if _, ok := arr.arraySize.(ASTEllipsis); ok {
+				elem := c.makeType(arr.elementType)
+				if elem == nil {
+					x.invalid()
+					return
+				}
+				t = c.ts.MakeArray(int64(len(e.elements)), elem)
+				break
+			}
+		}
+		t = c.makeType(e.typ)
+
+	case hint != nil:
+		t = hint
+
+	default:
+		c.errorf(e.pos, "I can't work out the type of this literal")
+	}
+
+	if t == nil {
+		x.invalid()
+		return
+	}
+
+	// a literal of pointer type '&T{...}' can be elided to '{...}'.
+	base := t
+	if e.typ == nil {
+		if ptr, ok := Underlying(t).(*DataTypeUnary); ok && ptr.kind == DataTypeKindPointer {
+			base = ptr.subType
+		}
+	}
+
+	switch u := coreType(base).(type) {
+	case *DataTypeStruct:
+		c.structLit(e, u)
+
+	case *DataTypeArray:
+		c.arrayLit(e, u.elementType, u.length)
+
+	case *DataTypeUnary:
+		if u.kind != DataTypeKindSlice {
+			c.errorf(e.pos, "I can't make a literal of type %s", c.typeString(t))
+			x.invalid()
+			return
+		}
+		c.arrayLit(e, u.subType, -1)
+
+	case *DataTypeMap:
+		for _, elem := range e.elements {
+			kv, ok := elem.(ASTKeyedElement)
+			if !ok {
+				c.errorf(elem.Pos(), "every element of a map literal needs a key")
+				c.rawExpr(elem)
+				continue
+			}
+			key := c.exprWithHint(kv.key, u.keyType)
+			c.assignment(&key, u.keyType, "map literal")
+			value := c.exprWithHint(kv.value, u.valueType)
+			c.assignment(&value, u.valueType, "map literal")
+		}
+
+	default:
+		c.errorf(e.pos, "I can't make a literal of type %s", c.typeString(t))
+		x.invalid()
+		return
+	}
+
+	x.mode = modeValue
+	x.typ = t
+}
+
+// structLit checks the elements of a struct literal.
+func (c *Checker) structLit(e ASTCompositeLit, st *DataTypeStruct) {
+	if len(e.elements) == 0 {
+		return
+	}
+
+	if _, keyed := e.elements[0].(ASTKeyedElement); keyed {
+		seen := make(map[string]bool)
+		for _, elem := range e.elements {
+			kv, ok := elem.(ASTKeyedElement)
+			if !ok {
+				c.errorf(elem.Pos(), "I can't mix keyed and positional fields in a struct literal")
+				continue
+			}
+			ident, ok := kv.key.(ASTIdentifier)
+			if !ok || ident.packageName != "" {
+				c.errorf(kv.key.Pos(), "I was expecting a field name here")
+				continue
+			}
+			field := st.Field(ident.name)
+			if field == nil {
+				c.errorf(ident.pos, "there's no field called '%s' in this struct", ident.name)
+				c.rawExpr(kv.value)
+				continue
+			}
+			if seen[ident.name] {
+				c.errorf(ident.pos, "the field '%s' has already been given a value", ident.name)
+			}
+			seen[ident.name] = true
+			value := c.exprWithHint(kv.value, field.typ)
+			c.assignment(&value, field.typ, "struct literal")
+		}
+		return
+	}
+
+	for i, elem := range e.elements {
+		if _, ok := elem.(ASTKeyedElement); ok {
+			c.errorf(elem.Pos(), "I can't mix keyed and positional fields in a struct literal")
+			continue
+		}
+		if i >= len(st.fields) {
+			c.errorf(elem.Pos(), "there are too many values in this struct literal")
+			return
+		}
+		value := c.exprWithHint(elem, st.fields[i].typ)
+		c.assignment(&value, st.fields[i].typ, "struct literal")
+	}
+
+	if len(e.elements) < len(st.fields) {
+		c.errorf(e.pos, "there are too few values in this struct literal")
+	}
+}
+
+// arrayLit checks the elements of an array or slice literal. length is -1
+// for slices.
+func (c *Checker) arrayLit(e ASTCompositeLit, elemType DataType, length int64) {
+	for _, elem := range e.elements {
+		valueAST := elem
+		if kv, ok := elem.(ASTKeyedElement); ok {
+			index := c.expr(kv.key)
+			if index.mode != modeInvalid && index.mode != modeConstant {
+				c.errorf(kv.key.Pos(), "the index in an array or slice literal has to be a constant")
+			} else {
+				c.sizeArgument(&index)
+			}
+			valueAST = kv.value
+		}
+		value := c.exprWithHint(valueAST, elemType)
+		c.assignment(&value, elemType, "array or slice literal")
+	}
+
+	if length >= 0 && int64(len(e.elements)) > length {
+		c.errorf(e.pos, "there are too many values in this literal - the array only has %d elements", length)
+	}
+}
+
+// opString gives the source form of an operator for error messages.
+func opString(op TokenKind) string {
+	switch op {
+	case TokenKindAdd:
+		return "+"
+	case TokenKindSubtract:
+		return "-"
+	case TokenKindAsterisk:
+		return "*"
+	case TokenKindDivide:
+		return "/"
+	case TokenKindModulus:
+		return "%"
+	case TokenKindBitwiseAnd:
+		return "&"
+	case TokenKindBitwiseOr:
+		return "|"
+	case TokenKindBitwiseExor:
+		return "^"
+	case TokenKindShiftLeft:
+		return "<<"
+	case TokenKindShiftRight:
+		return ">>"
+	case TokenKindBitClear:
+		return "&^"
+	case TokenKindLogicalAnd:
+		return "&&"
+	case TokenKindLogicalOr:
+		return "||"
+	case TokenKindChannelArrow:
+		return "<-"
+	case TokenKindEquals:
+		return "=="
+	case TokenKindNotEqual:
+		return "!="
+	case TokenKindLess:
+		return "<"
+	case TokenKindLessEqual:
+		return "<="
+	case TokenKindGreater:
+		return ">"
+	case TokenKindGreaterEqual:
+		return ">="
+	case TokenKindNot:
+		return "!"
+	}
+
+	return "?"
+}
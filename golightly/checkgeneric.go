@@ -0,0 +1,326 @@
+package golightly
+
+// instance checks the instantiation of a generic function or type with
+// explicit type arguments, of the form 'x[A, B]'. A generic function can
+// be given fewer type arguments than it has type parameters as long as
+// it's called, so the rest can be inferred.
+func (c *Checker) instance(x *operand, pos SrcSpan, y operand, argASTs []AST) {
+	switch {
+	case y.mode == modeInvalid:
+		x.invalid()
+
+	case y.mode == modeType:
+		x.typ = c.makeType(ASTInstance{pos, y.expr, argASTs})
+		if x.typ == nil {
+			x.invalid()
+			return
+		}
+		x.mode = modeType
+
+	case y.generic != nil:
+		typeParams := y.generic.typeParams
+		if len(argASTs) > len(typeParams) {
+			c.errorf(pos, "'%s' has %d type parameters but I was given %d type arguments", y.generic.name, len(typeParams), len(argASTs))
+			x.invalid()
+			return
+		}
+
+		typeArgs := append([]DataType(nil), y.typeArgs...)
+		for _, ast := range argASTs {
+			typ := c.makeType(ast)
+			if typ == nil {
+				x.invalid()
+				return
+			}
+			typeArgs = append(typeArgs, typ)
+		}
+
+		x.mode = modeValue
+		x.typ = y.typ
+		x.generic = y.generic
+		x.typeArgs = typeArgs
+		if len(typeArgs) < len(typeParams) {
+			return
+		}
+
+		fi := c.instantiateFunc(pos, argASTs, y.generic, typeArgs)
+		if fi == nil {
+			x.invalid()
+			return
+		}
+		x.typ = fi.sig
+		x.generic = nil
+		x.typeArgs = nil
+
+	default:
+		c.errorf(pos, "%s isn't generic so it can't have type arguments", c.describe(&y))
+		x.invalid()
+	}
+}
+
+// instantiateFunc checks the type arguments of a generic function against
+// their constraints and records the instance. argASTs are the explicit
+// type arguments, if any, which errors are reported against.
+func (c *Checker) instantiateFunc(pos SrcSpan, argASTs []AST, generic *Symbol, typeArgs []DataType) *FuncInstance {
+	if i, reason := c.ts.checkTypeArgs(generic.typeParams, typeArgs); i >= 0 {
+		if i < len(argASTs) {
+			pos = argASTs[i].Pos()
+		}
+		c.errorf(pos, "%s doesn't satisfy the constraint of %s - %s", c.typeString(typeArgs[i]), generic.typeParams[i].name, reason)
+		return nil
+	}
+
+	sig := generic.typ.(*DataTypeFunc)
+	fi := c.ts.InstantiateFunc(c.packagePath, generic.name, generic.typeParams, sig, typeArgs)
+	c.info.instances[exprKey{c.filename, pos}] = fi
+	c.info.types[exprKey{c.filename, pos}] = fi.sig
+
+	return fi
+}
+
+// inferCall works out the type arguments of a call of a generic function
+// which weren't given explicitly, and gives the signature of the
+// instantiated function. It returns nil if there's an error.
+//
+// Type arguments are inferred in the same order as Go does: first from
+// the typed arguments, then from the core types of the constraints, and
+// finally from the default types of untyped constant arguments.
+func (c *Checker) inferCall(e ASTCall, fn *operand, args []operand) *DataTypeFunc {
+	for _, arg := range args {
+		if arg.mode == modeInvalid {
+			return nil
+		}
+	}
+
+	generic := fn.generic
+	sig := generic.typ.(*DataTypeFunc)
+	inf := &inference{c.ts, newTypeSubst(generic.typeParams, fn.typeArgs), make(map[*DataTypeTypeParam]bool)}
+	for _, tp := range generic.typeParams {
+		inf.params[tp] = true
+	}
+
+	// the parameter each argument is passed to.
+	paramType := func(i int) DataType {
+		if sig.variadic && !e.ellipsis && i >= len(sig.params)-1 {
+			return sig.params[len(sig.params)-1].(*DataTypeUnary).subType
+		}
+		if i < len(sig.params) {
+			return sig.params[i]
+		}
+		return nil
+	}
+
+	// typed arguments.
+	for i := range args {
+		param := paramType(i)
+		if param == nil || isUntyped(args[i].typ) {
+			continue
+		}
+		if !inf.unify(param, args[i].typ) {
+			c.errorf(args[i].expr.Pos(), "I can't use %s as %s when working out the type arguments of '%s'", c.describe(&args[i]), c.typeString(inf.ts.subst(param, inf.m)), generic.name)
+			return nil
+		}
+	}
+
+	// core types of the constraints.
+	for changed := true; changed; {
+		changed = false
+		for _, tp := range generic.typeParams {
+			iface := tp.iface()
+			if iface == nil || !iface.restricted || len(iface.terms) != 1 {
+				continue
+			}
+			core := iface.terms[0]
+			bound, ok := inf.m[tp]
+			switch {
+			case ok:
+				before := len(inf.m)
+				if !inf.unify(core.typ, Underlying(bound)) {
+					c.errorf(e.pos, "%s doesn't satisfy the constraint of %s", c.typeString(bound), tp.name)
+					return nil
+				}
+				changed = changed || len(inf.m) > before
+			case !core.tilde && inf.bound(core.typ):
+				inf.m[tp] = inf.ts.subst(core.typ, inf.m)
+				changed = true
+			}
+		}
+	}
+
+	// untyped constants take their default type.
+	for _, tp := range generic.typeParams {
+		if _, ok := inf.m[tp]; ok {
+			continue
+		}
+		var untyped DataType
+		for i := range args {
+			if paramType(i) == tp && isUntyped(args[i].typ) && basicKind(args[i].typ) != DataTypeKindUntypedNil {
+				if untyped == nil || untypedRank(args[i].typ) > untypedRank(untyped) {
+					untyped = args[i].typ
+				}
+			}
+		}
+		if untyped != nil {
+			inf.m[tp] = defaultType(c.ts, untyped)
+		}
+	}
+
+	typeArgs := make([]DataType, len(generic.typeParams))
+	for i, tp := range generic.typeParams {
+		typeArgs[i] = inf.m[tp]
+		if typeArgs[i] == nil {
+			c.errorf(e.pos, "I can't work out the type argument for %s in this call to '%s'", tp.name, generic.name)
+			return nil
+		}
+	}
+
+	fi := c.instantiateFunc(e.function.Pos(), nil, generic, typeArgs)
+	if fi == nil {
+		return nil
+	}
+
+	return fi.sig
+}
+
+// type inference works out the type arguments of a generic function by
+// matching its parameter types against the types of the arguments.
+type inference struct {
+	ts     *DataTypeStore
+	m      typeSubst                   // the type arguments worked out so far
+	params map[*DataTypeTypeParam]bool // the type parameters being inferred
+}
+
+// unify matches a type which may contain the type parameters being
+// inferred against a type which doesn't, binding type parameters as it
+// goes. It returns false if the types can't match.
+func (inf *inference) unify(x DataType, y DataType) bool {
+	if tp, ok := x.(*DataTypeTypeParam); ok && inf.params[tp] {
+		if bound, ok := inf.m[tp]; ok {
+			return bound == y
+		}
+		inf.m[tp] = y
+		return true
+	}
+
+	if x == y {
+		return true
+	}
+
+	// a defined type matches a type literal with the same structure.
+	if yn, ok := y.(*DataTypeNamed); ok {
+		xn, ok := x.(*DataTypeNamed)
+		if !ok {
+			return inf.unify(x, yn.Underlying())
+		}
+		if xn.origin == nil || xn.origin != yn.origin {
+			return isGeneric(xn) && yn.origin == xn && inf.unifyList(typeParamList(xn.typeParams), yn.typeArgs)
+		}
+		return inf.unifyList(xn.typeArgs, yn.typeArgs)
+	}
+
+	switch xt := x.(type) {
+	case *DataTypeUnary:
+		yt, ok := y.(*DataTypeUnary)
+		return ok && xt.kind == yt.kind && inf.unify(xt.subType, yt.subType)
+
+	case *DataTypeArray:
+		yt, ok := y.(*DataTypeArray)
+		return ok && xt.length == yt.length && inf.unify(xt.elementType, yt.elementType)
+
+	case *DataTypeMap:
+		yt, ok := y.(*DataTypeMap)
+		return ok && inf.unify(xt.keyType, yt.keyType) && inf.unify(xt.valueType, yt.valueType)
+
+	case *DataTypeChan:
+		yt, ok := y.(*DataTypeChan)
+		return ok && (xt.dir == yt.dir || yt.dir == ChanDirectionBi) && inf.unify(xt.elementType, yt.elementType)
+
+	case *DataTypeFunc:
+		yt, ok := y.(*DataTypeFunc)
+		return ok && xt.variadic == yt.variadic && inf.unifyList(xt.params, yt.params) && inf.unifyList(xt.returns, yt.returns)
+
+	case *DataTypeStruct:
+		yt, ok := y.(*DataTypeStruct)
+		if !ok || len(xt.fields) != len(yt.fields) {
+			return false
+		}
+		for i, field := range xt.fields {
+			other := yt.fields[i]
+			if field.name != other.name || field.pkg != other.pkg || field.embedded != other.embedded || field.tag != other.tag || !inf.unify(field.typ, other.typ) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// unifyList matches two lists of types.
+func (inf *inference) unifyList(xs []DataType, ys []DataType) bool {
+	if len(xs) != len(ys) {
+		return false
+	}
+
+	for i := range xs {
+		if !inf.unify(xs[i], ys[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// bound returns true if all the type parameters being inferred which a
+// type contains have been worked out.
+func (inf *inference) bound(dt DataType) bool {
+	switch t := dt.(type) {
+	case *DataTypeTypeParam:
+		_, ok := inf.m[t]
+		return ok || !inf.params[t]
+	case *DataTypeNamed:
+		if isGeneric(t) {
+			return inf.boundList(typeParamList(t.typeParams))
+		}
+		return inf.boundList(t.typeArgs)
+	case *DataTypeUnary:
+		return inf.bound(t.subType)
+	case *DataTypeArray:
+		return inf.bound(t.elementType)
+	case *DataTypeMap:
+		return inf.bound(t.keyType) && inf.bound(t.valueType)
+	case *DataTypeChan:
+		return inf.bound(t.elementType)
+	case *DataTypeFunc:
+		return inf.boundList(t.params) && inf.boundList(t.returns)
+	case *DataTypeStruct:
+		for _, field := range t.fields {
+			if !inf.bound(field.typ) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// boundList returns true if all the types in a list are bound.
+func (inf *inference) boundList(types []DataType) bool {
+	for _, dt := range types {
+		if !inf.bound(dt) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// typeParamList gives a list of type parameters as a list of types.
+func typeParamList(typeParams []*DataTypeTypeParam) []DataType {
+	types := make([]DataType, len(typeParams))
+	for i, tp := range typeParams {
+		types[i] = tp
+	}
+
+	return types
+}
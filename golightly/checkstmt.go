@@ -0,0 +1,758 @@
+package golightly
+
+// stmtList checks a list of statements.
+func (c *Checker) stmtList(stmts []AST) {
+	for _, stmt := range stmts {
+		c.stmt(stmt)
+	}
+}
+
+// block checks a block in its own scope.
+func (c *Checker) block(ast AST) {
+	c.openScope(ScopeBlock)
+	defer c.closeScope()
+
+	c.stmtList(ast.(ASTBlock).statements)
+}
+
+// stmt checks a single statement.
+func (c *Checker) stmt(ast AST) {
+	switch s := ast.(type) {
+	case nil:
+		// an empty statement.
+
+	case ASTBlock:
+		c.block(s)
+
+	case ASTConstDecl:
+		ident := s.ident.(ASTIdentifier)
+		typ, val := c.constDecl(s)
+		if typ != nil {
+			c.declare(&Symbol{kind: SymbolConst, name: ident.name, typ: typ, val: val, filename: c.filename, pos: ident.pos})
+		}
+
+	case ASTVarDecl:
+		ident := s.ident.(ASTIdentifier)
+		typ := c.varDecl(s)
+		if typ != nil {
+			c.declareVar(&Symbol{kind: SymbolVar, name: ident.name, typ: typ, filename: c.filename, pos: ident.pos})
+		}
+
+	case ASTDataTypeDecl:
+		c.localTypeDecl(s)
+
+	case ASTAssign:
+		c.assign(s)
+
+	case ASTIncDec:
+		x := c.expr(s.expr)
+		if x.mode == modeInvalid {
+			return
+		}
+		if !isNumeric(x.typ) {
+			c.errorf(s.pos, "I can only increment or decrement numbers, not %s", c.describe(&x))
+			return
+		}
+		c.checkAssignable(&x)
+
+	case ASTSend:
+		c.send(s)
+
+	case ASTReturn:
+		c.returnStmt(s)
+
+	case ASTBranch:
+		if s.label != "" {
+			label := c.scope.LookupLabel(s.label)
+			if label == nil {
+				c.errorf(s.pos, "I don't know of a label called '%s' in this function", s.label)
+				return
+			}
+			label.used = true
+			c.info.uses[exprKey{c.filename, s.pos}] = label
+		}
+
+	case ASTLabeled:
+		c.stmt(s.stmt)
+
+	case ASTGo:
+		c.callStmt(s.call, "go")
+
+	case ASTDefer:
+		c.callStmt(s.call, "defer")
+
+	case ASTIf:
+		c.openScope(ScopeBlock)
+		defer c.closeScope()
+		c.stmt(s.init)
+		c.condition(s.cond)
+		c.block(s.then)
+		if s.els != nil {
+			c.stmt(s.els)
+		}
+
+	case ASTFor:
+		c.openScope(ScopeBlock)
+		defer c.closeScope()
+		c.stmt(s.init)
+		if s.cond != nil {
+			c.condition(s.cond)
+		}
+		c.stmt(s.post)
+		c.block(s.body)
+
+	case ASTRange:
+		c.rangeStmt(s)
+
+	case ASTSwitch:
+		c.switchStmt(s)
+
+	case ASTSelect:
+		c.selectStmt(s)
+
+	default:
+		c.exprStmt(ast)
+	}
+}
+
+// exprStmt checks an expression used as a statement. Only calls and
+// receives can be used like this.
+func (c *Checker) exprStmt(ast AST) {
+	x := c.rawExpr(ast)
+	if x.mode == modeInvalid {
+		return
+	}
+
+	switch e := ast.(type) {
+	case ASTCall:
+		switch x.callee {
+		case modeType:
+			c.errorf(ast.Pos(), "this conversion isn't used")
+		case modeBuiltin:
+			if x.mode != modeNoValue && x.builtin != builtinCopy && x.builtin != builtinRecover {
+				c.errorf(ast.Pos(), "the result of this builtin function has to be used")
+			}
+		}
+		return
+
+	case ASTUnaryExpr:
+		if e.op == TokenKindChannelArrow {
+			return
+		}
+	}
+
+	c.errorf(ast.Pos(), "this is evaluated but not used")
+}
+
+// callStmt checks the call in a go or defer statement.
+func (c *Checker) callStmt(ast AST, keyword string) {
+	call, ok := ast.(ASTCall)
+	if !ok {
+		c.errorf(ast.Pos(), "%s needs a function call", keyword)
+		c.rawExpr(ast)
+		return
+	}
+
+	c.exprStmt(call)
+}
+
+// condition checks the condition of an if or for statement.
+func (c *Checker) condition(ast AST) {
+	x := c.expr(ast)
+	if x.mode == modeInvalid {
+		return
+	}
+
+	if !isBoolean(x.typ) {
+		c.errorf(ast.Pos(), "I was expecting a bool condition but this is %s", c.describe(&x))
+		return
+	}
+
+	c.convertUntyped(&x, c.ts.BoolType())
+}
+
+// localTypeDecl checks a type declaration inside a function.
+func (c *Checker) localTypeDecl(s ASTDataTypeDecl) {
+	ident := s.ident.(ASTIdentifier)
+	if len(s.typeParams) > 0 {
+		c.errorf(ident.pos, "generic types can't be declared inside a function")
+		return
+	}
+	if s.alias {
+		typ := c.makeType(s.typ)
+		if typ != nil {
+			c.declare(&Symbol{kind: SymbolType, name: ident.name, typ: typ, filename: c.filename, pos: ident.pos})
+		}
+		return
+	}
+
+	// the type is in scope in its own declaration so it can refer to itself.
+	named := c.ts.NewNamed("", ident.name, c.filename, ident.pos)
+	c.declare(&Symbol{kind: SymbolType, name: ident.name, typ: named, filename: c.filename, pos: ident.pos})
+	typ := c.makeType(s.typ)
+	if typ == nil {
+		return
+	}
+	if typ == named || Underlying(typ) == nil {
+		c.errorf(ident.pos, "'%s' is an invalid recursive type", ident.name)
+		return
+	}
+
+	named.SetUnderlying(typ)
+}
+
+// checkAssignable makes sure an operand can be assigned to.
+func (c *Checker) checkAssignable(x *operand) bool {
+	if x.mode == modeVariable || x.mode == modeMapIndex {
+		return true
+	}
+
+	c.errorf(x.expr.Pos(), "I can't assign to this")
+	return false
+}
+
+// lhs checks the left hand side of an assignment. It returns nil if it's
+// the blank identifier, which anything can be assigned to.
+func (c *Checker) lhs(ast AST) (DataType, bool) {
+	if ident, ok := ast.(ASTIdentifier); ok && ident.packageName == "" {
+		if ident.name == "_" {
+			return nil, true
+		}
+
+		// assigning to a variable doesn't count as using it.
+		if sym, _ := c.scope.Lookup(ident.name); sym != nil {
+			used := sym.used
+			defer func() { sym.used = used }()
+		}
+	}
+
+	x := c.expr(ast)
+	if x.mode == modeInvalid || !c.checkAssignable(&x) {
+		return nil, false
+	}
+
+	return x.typ, true
+}
+
+// assignTo checks that a value can be assigned to something of type t. A
+// nil type is the blank identifier.
+func (c *Checker) assignTo(x *operand, t DataType) {
+	if x.mode == modeInvalid {
+		return
+	}
+
+	if t == nil {
+		c.defaultAssignment(x)
+		return
+	}
+
+	c.assignment(x, t, "assignment")
+}
+
+// rhs checks the right hand side of an assignment or definition with n
+// variables, spreading multi-value calls and comma-ok expressions.
+func (c *Checker) rhs(pos SrcSpan, right []AST, n int) ([]operand, bool) {
+	if len(right) == 1 && n > 1 {
+		x := c.rawExpr(right[0])
+		if x.mode == modeInvalid {
+			return nil, false
+		}
+
+		if x.tuple != nil {
+			if len(x.tuple) != n {
+				c.errorf(pos, "I was expecting %d values but this gives %d", n, len(x.tuple))
+				return nil, false
+			}
+			values := make([]operand, n)
+			for i, typ := range x.tuple {
+				values[i] = operand{mode: modeValue, expr: right[0], typ: typ}
+			}
+			return values, true
+		}
+
+		if x.mode == modeCommaOk && n == 2 {
+			return []operand{x, {mode: modeValue, expr: right[0], typ: c.ts.UntypedBoolType()}}, true
+		}
+
+		c.singleValue(&x)
+		if x.mode != modeInvalid {
+			c.errorf(pos, "I was expecting %d values but this gives 1", n)
+		}
+		return nil, false
+	}
+
+	values := make([]operand, len(right))
+	for i, r := range right {
+		values[i] = c.expr(r)
+	}
+
+	if len(right) != n {
+		c.errorf(pos, "there are %d variables but %d values", n, len(right))
+		return nil, false
+	}
+
+	return values, true
+}
+
+// compoundOps maps the assignment operators like '+=' to their binary
+// operators.
+var compoundOps = map[TokenKind]TokenKind{
+	TokenKindAddAssign:         TokenKindAdd,
+	TokenKindSubtractAssign:    TokenKindSubtract,
+	TokenKindMultiplyAssign:    TokenKindAsterisk,
+	TokenKindDivideAssign:      TokenKindDivide,
+	TokenKindModulusAssign:     TokenKindModulus,
+	TokenKindBitwiseAndAssign:  TokenKindBitwiseAnd,
+	TokenKindBitwiseOrAssign:   TokenKindBitwiseOr,
+	TokenKindBitwiseExorAssign: TokenKindBitwiseExor,
+	TokenKindShiftLeftAssign:   TokenKindShiftLeft,
+	TokenKindShiftRightAssign:  TokenKindShiftRight,
+	TokenKindBitClearAssign:    TokenKindBitClear,
+}
+
+// assign checks an assignment or short variable declaration.
+func (c *Checker) assign(s ASTAssign) {
+	switch s.op {
+	case TokenKindAssign:
+		types := make([]DataType, len(s.left))
+		ok := true
+		for i, l := range s.left {
+			var lok bool
+			types[i], lok = c.lhs(l)
+			ok = ok && lok
+		}
+		values, rok := c.rhs(s.pos, s.right, len(s.left))
+		if !ok || !rok {
+			return
+		}
+		for i := range values {
+			c.assignTo(&values[i], types[i])
+		}
+
+	case TokenKindDeclareAssign:
+		c.shortVarDecl(s)
+
+	default:
+		binOp, ok := compoundOps[s.op]
+		if !ok {
+			c.errorf(s.pos, "I was expecting an assignment operator")
+			return
+		}
+		if len(s.left) != 1 || len(s.right) != 1 {
+			c.errorf(s.pos, "%s= can only assign one value", opString(binOp))
+			return
+		}
+		var x operand
+		c.binary(&x, ASTBinaryExpr{s.pos, binOp, s.left[0], s.right[0]})
+		if x.mode == modeInvalid {
+			return
+		}
+		left := c.expr(s.left[0])
+		if c.checkAssignable(&left) {
+			x.expr = s.right[0]
+			c.assignTo(&x, left.typ)
+		}
+	}
+}
+
+// shortVarDecl checks a short variable declaration of the form 'a, b := x, y'.
+func (c *Checker) shortVarDecl(s ASTAssign) {
+	idents := make([]ASTIdentifier, len(s.left))
+	ok := true
+	for i, l := range s.left {
+		ident, isIdent := l.(ASTIdentifier)
+		if !isIdent || ident.packageName != "" {
+			c.errorf(l.Pos(), "I was expecting a variable name on the left of ':='")
+			ok = false
+			continue
+		}
+		idents[i] = ident
+	}
+
+	values, rok := c.rhs(s.pos, s.right, len(s.left))
+	if !ok {
+		return
+	}
+
+	// work out which variables are new.
+	anyNew := false
+	var newObjs []*Symbol
+	seen := make(map[string]bool)
+	for i, ident := range idents {
+		if seen[ident.name] && ident.name != "_" {
+			c.errorf(ident.pos, "'%s' is repeated on the left of ':='", ident.name)
+		}
+		seen[ident.name] = true
+
+		existing := c.scope.LookupLocal(ident.name)
+		declared := existing != nil
+		if declared {
+			c.info.uses[exprKey{c.filename, ident.pos}] = existing
+		}
+		if ident.name == "_" || declared {
+			// assignment to something which already exists.
+			if rok {
+				var t DataType
+				if declared && existing.kind == SymbolVar {
+					t = existing.typ
+				} else if declared {
+					c.errorf(ident.pos, "I can't assign to '%s'", ident.name)
+					continue
+				}
+				c.assignTo(&values[i], t)
+				if t != nil {
+					c.record(&operand{mode: modeVariable, expr: ident, typ: t})
+				}
+			}
+			continue
+		}
+
+		anyNew = true
+		var typ DataType
+		if rok && values[i].mode != modeInvalid && c.defaultAssignment(&values[i]) {
+			typ = values[i].typ
+		}
+		obj := &Symbol{kind: SymbolVar, name: ident.name, typ: typ, filename: c.filename, pos: ident.pos}
+		newObjs = append(newObjs, obj)
+		if typ != nil {
+			c.record(&operand{mode: modeVariable, expr: ident, typ: typ})
+		}
+	}
+
+	if !anyNew {
+		c.softErrorf(s.pos, "there are no new variables on the left of ':='")
+	}
+
+	// the new variables are only in scope after the statement.
+	for _, obj := range newObjs {
+		if obj.typ == nil {
+			// stop errors cascading when the value was invalid.
+			obj.typ = c.ts.AnyType()
+		}
+		c.declareVar(obj)
+	}
+}
+
+// send checks a send statement.
+func (c *Checker) send(s ASTSend) {
+	ch := c.expr(s.channel)
+	value := c.expr(s.value)
+	if ch.mode == modeInvalid || value.mode == modeInvalid {
+		return
+	}
+
+	chanType, ok := coreType(ch.typ).(*DataTypeChan)
+	if !ok {
+		c.errorf(s.pos, "I can't send to %s because it isn't a channel", c.describe(&ch))
+		return
+	}
+	if chanType.dir == ChanDirectionOut {
+		c.errorf(s.pos, "I can't send to a receive-only channel")
+		return
+	}
+
+	c.assignment(&value, chanType.elementType, "send")
+}
+
+// returnStmt checks a return statement.
+func (c *Checker) returnStmt(s ASTReturn) {
+	if c.sig == nil {
+		c.errorf(s.pos, "return can only be used in a function")
+		return
+	}
+
+	returns := c.sig.returns
+	if len(s.results) == 0 {
+		if len(returns) > 0 && !c.namedResults {
+			c.errorf(s.pos, "this function needs to return %d values", len(returns))
+		}
+		return
+	}
+
+	if len(returns) == 0 {
+		c.errorf(s.pos, "this function doesn't return any values")
+		for _, r := range s.results {
+			c.rawExpr(r)
+		}
+		return
+	}
+
+	values, ok := c.rhs(s.pos, s.results, len(returns))
+	if !ok {
+		return
+	}
+
+	for i := range values {
+		c.assignment(&values[i], returns[i], "return statement")
+	}
+}
+
+// rangeStmt checks a for statement with a range clause.
+func (c *Checker) rangeStmt(s ASTRange) {
+	x := c.expr(s.expr)
+
+	// work out the types of the key and value.
+	var keyType, valueType DataType
+	valueOk := true
+	if x.mode != modeInvalid {
+		typ := coreType(x.typ)
+		if ptr, ok := typ.(*DataTypeUnary); ok && ptr.kind == DataTypeKindPointer {
+			if arr, ok := Underlying(ptr.subType).(*DataTypeArray); ok {
+				typ = arr
+			}
+		}
+
+		switch t := typ.(type) {
+		case *DataTypeArray:
+			keyType, valueType = c.ts.IntType(), t.elementType
+		case *DataTypeUnary:
+			if t.kind == DataTypeKindSlice {
+				keyType, valueType = c.ts.IntType(), t.subType
+			}
+		case *DataTypeMap:
+			keyType, valueType = t.keyType, t.valueType
+		case *DataTypeChan:
+			if t.dir == ChanDirectionIn {
+				c.errorf(s.expr.Pos(), "I can't range over a send-only channel")
+			}
+			keyType, valueOk = t.elementType, false
+		default:
+			switch {
+			case isString(typ):
+				c.convertUntyped(&x, c.ts.StringType())
+				keyType, valueType = c.ts.IntType(), c.ts.RuneType()
+			case isInteger(typ) || untypedRank(typ) > 0:
+				if c.convertUntyped(&x, defaultType(c.ts, x.typ)) && isInteger(x.typ) {
+					keyType, valueOk = x.typ, false
+				}
+			}
+		}
+
+		if keyType == nil {
+			c.errorf(s.expr.Pos(), "I can't range over %s", c.describe(&x))
+		}
+		if !valueOk && s.value != nil {
+			c.errorf(s.value.Pos(), "there can only be one iteration variable when ranging over %s", c.describe(&x))
+		}
+	}
+
+	c.openScope(ScopeBlock)
+	defer c.closeScope()
+
+	vars := []AST{s.key, s.value}
+	types := []DataType{keyType, valueType}
+	if s.define {
+		for i, v := range vars {
+			if v == nil {
+				continue
+			}
+			ident, ok := v.(ASTIdentifier)
+			if !ok {
+				c.errorf(v.Pos(), "I was expecting a variable name here")
+				continue
+			}
+			typ := types[i]
+			if typ == nil {
+				typ = c.ts.AnyType()
+			}
+			c.declareVar(&Symbol{kind: SymbolVar, name: ident.name, typ: typ, filename: c.filename, pos: ident.pos})
+			c.record(&operand{mode: modeVariable, expr: ident, typ: typ})
+		}
+	} else {
+		for i, v := range vars {
+			if v == nil {
+				continue
+			}
+			t, ok := c.lhs(v)
+			if ok && t != nil && types[i] != nil {
+				if ok, _ := assignable(c.ts, types[i], t); !ok {
+					c.errorf(v.Pos(), "I can't assign a value of type %s to %s in range", c.typeString(types[i]), c.typeString(t))
+				}
+			}
+		}
+	}
+
+	c.block(s.body)
+}
+
+// switchStmt checks a switch statement.
+func (c *Checker) switchStmt(s ASTSwitch) {
+	c.openScope(ScopeBlock)
+	defer c.closeScope()
+
+	c.stmt(s.init)
+
+	// type switches are of the form 'switch x.(type)' or 'switch v := x.(type)'.
+	switch tag := s.tag.(type) {
+	case ASTTypeAssertion:
+		if tag.typ == nil {
+			c.typeSwitch(s, nil, tag)
+			return
+		}
+	case ASTAssign:
+		if tag.op == TokenKindDeclareAssign && len(tag.left) == 1 && len(tag.right) == 1 {
+			if ta, ok := tag.right[0].(ASTTypeAssertion); ok && ta.typ == nil {
+				ident, ok := tag.left[0].(ASTIdentifier)
+				if !ok {
+					c.errorf(tag.left[0].Pos(), "I was expecting a variable name here")
+					return
+				}
+				c.typeSwitch(s, &ident, ta)
+				return
+			}
+		}
+		c.errorf(tag.pos, "I was expecting an expression here")
+		return
+	}
+
+	// an expression switch.
+	var x operand
+	if s.tag != nil {
+		x = c.expr(s.tag)
+		if x.mode != modeInvalid {
+			c.defaultAssignment(&x)
+		}
+		if x.mode != modeInvalid && !isComparable(x.typ) && !hasNil(x.typ) {
+			c.errorf(s.tag.Pos(), "I can't switch on %s because it can't be compared", c.describe(&x))
+			x.mode = modeInvalid
+		}
+	} else {
+		x = operand{mode: modeConstant, typ: c.ts.BoolType()}
+	}
+
+	defaultSeen := false
+	for _, clause := range s.cases {
+		cc := clause.(ASTCaseClause)
+		if cc.exprs == nil {
+			if defaultSeen {
+				c.errorf(cc.pos, "there's more than one default case in this switch")
+			}
+			defaultSeen = true
+		}
+
+		for _, e := range cc.exprs {
+			y := c.expr(e)
+			if x.mode == modeInvalid || y.mode == modeInvalid {
+				continue
+			}
+			if !c.convertUntyped(&y, x.typ) {
+				continue
+			}
+			okYX, _ := assignable(c.ts, y.typ, x.typ)
+			okXY, _ := assignable(c.ts, x.typ, y.typ)
+			if !okYX && !okXY {
+				c.errorf(e.Pos(), "I can't compare this case (type %s) with the switch value (type %s)", c.typeString(y.typ), c.typeString(x.typ))
+			}
+		}
+
+		c.openScope(ScopeBlock)
+		c.stmtList(cc.body)
+		c.closeScope()
+	}
+}
+
+// typeSwitch checks a type switch. ident is the variable declared in the
+// switch guard, if any.
+func (c *Checker) typeSwitch(s ASTSwitch, ident *ASTIdentifier, guard ASTTypeAssertion) {
+	x := c.expr(guard.expr)
+	var iface *DataTypeInterface
+	if x.mode != modeInvalid {
+		var ok bool
+		iface, ok = Underlying(x.typ).(*DataTypeInterface)
+		if !ok {
+			c.errorf(guard.expr.Pos(), "I can only use a type switch on an interface, not %s", c.describe(&x))
+		}
+	}
+
+	// the variable is declared separately in each clause and it's used if
+	// it's used in any of them.
+	used := false
+	defaultSeen := false
+	for _, clause := range s.cases {
+		cc := clause.(ASTCaseClause)
+		if cc.exprs == nil {
+			if defaultSeen {
+				c.errorf(cc.pos, "there's more than one default case in this switch")
+			}
+			defaultSeen = true
+		}
+
+		// the variable has the case's type if there's exactly one,
+		// otherwise it has the type of the switch expression.
+		var caseType DataType
+		for _, e := range cc.exprs {
+			if ident, ok := e.(ASTIdentifier); ok && ident.name == "nil" && c.lookup(ident).kind == SymbolNil {
+				caseType = nil
+				continue
+			}
+			t := c.exprOrType(e)
+			if t.mode == modeInvalid {
+				continue
+			}
+			if t.mode != modeType {
+				c.errorf(e.Pos(), "I was expecting a type in this case")
+				continue
+			}
+			if iface != nil {
+				c.checkAssertable(e.Pos(), t.typ, iface)
+			}
+			caseType = t.typ
+		}
+		if len(cc.exprs) != 1 || caseType == nil {
+			caseType = x.typ
+		}
+
+		c.openScope(ScopeBlock)
+		var obj *Symbol
+		if ident != nil && ident.name != "_" && caseType != nil {
+			obj = &Symbol{kind: SymbolVar, name: ident.name, typ: caseType, filename: c.filename, pos: ident.pos}
+			c.declare(obj)
+		}
+		c.stmtList(cc.body)
+		c.closeScope()
+		used = used || obj != nil && obj.used
+	}
+
+	if ident != nil && ident.name != "_" && !used {
+		c.softErrorf(ident.pos, "'%s' is declared but not used", ident.name)
+	}
+}
+
+// selectStmt checks a select statement.
+func (c *Checker) selectStmt(s ASTSelect) {
+	for _, clause := range s.cases {
+		cc := clause.(ASTCommClause)
+
+		c.openScope(ScopeBlock)
+		switch comm := cc.comm.(type) {
+		case nil:
+			// the default case.
+
+		case ASTSend:
+			c.send(comm)
+
+		case ASTAssign:
+			if len(comm.right) != 1 || !isReceive(comm.right[0]) {
+				c.errorf(comm.pos, "a select case has to send or receive")
+				break
+			}
+			c.assign(comm)
+
+		default:
+			if !isReceive(comm) {
+				c.errorf(comm.Pos(), "a select case has to send or receive")
+				break
+			}
+			c.rawExpr(comm)
+		}
+
+		c.stmtList(cc.body)
+		c.closeScope()
+	}
+}
+
+// isReceive returns true if an expression is a receive from a channel.
+func isReceive(ast AST) bool {
+	unary, ok := ast.(ASTUnaryExpr)
+	return ok && unary.op == TokenKindChannelArrow
+}
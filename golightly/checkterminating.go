@@ -0,0 +1,165 @@
+package golightly
+
+// isTerminating returns true if a statement is a terminating statement as
+// the spec defines it - one which always leaves the function, so nothing
+// after it can run. label is the label of the statement, if it has one.
+// It's used once the function body has been checked so calls to panic can
+// be told apart from calls to other functions called panic.
+func (c *Checker) isTerminating(ast AST, label string) bool {
+	switch s := ast.(type) {
+	case ASTReturn:
+		return true
+
+	case ASTBranch:
+		return s.tok == TokenKindGoto
+
+	case ASTCall:
+		return c.isPanic(s)
+
+	case ASTBlock:
+		return c.isTerminatingList(s.statements)
+
+	case ASTLabeled:
+		return c.isTerminating(s.stmt, s.label)
+
+	case ASTIf:
+		return s.els != nil && c.isTerminating(s.then, "") && c.isTerminating(s.els, "")
+
+	case ASTFor:
+		// a loop with a condition or a break can finish.
+		return s.cond == nil && !hasBreak(s.body, label, true)
+
+	case ASTSwitch:
+		defaultSeen := false
+		for _, clause := range s.cases {
+			cc := clause.(ASTCaseClause)
+			if cc.exprs == nil {
+				defaultSeen = true
+			}
+			if hasBreakList(cc.body, label, true) {
+				return false
+			}
+			if !c.isTerminatingList(cc.body) && !endsInFallthrough(cc.body) {
+				return false
+			}
+		}
+		return defaultSeen
+
+	case ASTSelect:
+		for _, clause := range s.cases {
+			cc := clause.(ASTCommClause)
+			if hasBreakList(cc.body, label, true) || !c.isTerminatingList(cc.body) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// isTerminatingList returns true if the last statement in a list which
+// isn't empty is a terminating statement.
+func (c *Checker) isTerminatingList(stmts []AST) bool {
+	stmt := lastStatement(stmts)
+	return stmt != nil && c.isTerminating(stmt, "")
+}
+
+// isPanic returns true if a call is to the builtin panic.
+func (c *Checker) isPanic(call ASTCall) bool {
+	ident, ok := call.function.(ASTIdentifier)
+	if !ok || ident.packageName != "" {
+		return false
+	}
+
+	sym := c.info.uses[exprKey{c.filename, ident.pos}]
+	return sym != nil && sym.kind == SymbolBuiltin && sym.builtin == builtinPanic
+}
+
+// lastStatement returns the last statement in a list which isn't empty,
+// or nil if they're all empty.
+func lastStatement(stmts []AST) AST {
+	for i := len(stmts) - 1; i >= 0; i-- {
+		if stmts[i] != nil {
+			return stmts[i]
+		}
+	}
+
+	return nil
+}
+
+// endsInFallthrough returns true if a switch clause ends with a
+// fallthrough statement.
+func endsInFallthrough(stmts []AST) bool {
+	stmt := lastStatement(stmts)
+	for {
+		labeled, ok := stmt.(ASTLabeled)
+		if !ok {
+			break
+		}
+		stmt = labeled.stmt
+	}
+
+	branch, ok := stmt.(ASTBranch)
+	return ok && branch.tok == TokenKindFallthrough
+}
+
+// hasBreak returns true if a statement has a break which leaves an
+// enclosing statement - either a break with the given label or, if
+// implicit is true, a break without a label. Breaks without labels inside
+// nested for, switch and select statements leave those instead.
+func hasBreak(ast AST, label string, implicit bool) bool {
+	switch s := ast.(type) {
+	case ASTBranch:
+		if s.tok != TokenKindBreak {
+			return false
+		}
+		if s.label == "" {
+			return implicit
+		}
+		return s.label == label
+
+	case ASTBlock:
+		return hasBreakList(s.statements, label, implicit)
+
+	case ASTLabeled:
+		return hasBreak(s.stmt, label, implicit)
+
+	case ASTIf:
+		return hasBreak(s.then, label, implicit) || hasBreak(s.els, label, implicit)
+
+	case ASTFor:
+		return label != "" && hasBreak(s.body, label, false)
+
+	case ASTRange:
+		return label != "" && hasBreak(s.body, label, false)
+
+	case ASTSwitch:
+		for _, clause := range s.cases {
+			if label != "" && hasBreakList(clause.(ASTCaseClause).body, label, false) {
+				return true
+			}
+		}
+
+	case ASTSelect:
+		for _, clause := range s.cases {
+			if label != "" && hasBreakList(clause.(ASTCommClause).body, label, false) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// hasBreakList returns true if any of a list of statements has a break
+// which leaves an enclosing statement. See hasBreak.
+func hasBreakList(stmts []AST, label string, implicit bool) bool {
+	for _, stmt := range stmts {
+		if hasBreak(stmt, label, implicit) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,1111 @@
+package golightly
+
+import "strconv"
+
+// the name of the parameter which lowered function literals are given
+// their context in. It can't clash with a name from the source.
+const closureContextName = ".ctx"
+
+// type Capture is a variable from an enclosing function which a function
+// literal uses.
+type Capture struct {
+	sym   *Symbol // the variable
+	field string  // the name of the context field it's kept in
+	byRef bool    // true if the context holds its address rather than a copy
+}
+
+// Var returns the captured variable.
+func (cp *Capture) Var() *Symbol {
+	return cp.sym
+}
+
+// Field returns the name of the field of the closure's context which
+// holds the variable.
+func (cp *Capture) Field() string {
+	return cp.field
+}
+
+// ByRef returns true if the variable is captured by reference. Variables
+// which are never changed after they're captured are captured by value.
+func (cp *Capture) ByRef() bool {
+	return cp.byRef
+}
+
+// type Closure is a function literal and the variables it captures.
+type Closure struct {
+	filename string
+	lit      ASTFunctionLit
+	name     string     // the name of the function it's lowered to, eg. "main.func1"
+	captures []*Capture // the variables it captures, in the order they're first used
+	context  DataType   // a pointer to the struct holding the captures. nil if there aren't any.
+}
+
+// Name returns the name of the function the literal is lowered to. It's
+// named after the function it's in, like "main.func1" or "T.m.func2", and
+// literals inside other literals are numbered like "main.func1.1".
+func (cl *Closure) Name() string {
+	return cl.name
+}
+
+// Captures returns the variables the literal captures, including the ones
+// which function literals inside it capture.
+func (cl *Closure) Captures() []*Capture {
+	return cl.captures
+}
+
+// Context returns the type of the lowered function's context parameter, a
+// pointer to a struct with a field for each captured variable. It's nil if
+// nothing is captured.
+func (cl *Closure) Context() DataType {
+	return cl.context
+}
+
+// type ClosureInfo holds what's known about a package's function literals.
+type ClosureInfo struct {
+	info         *TypeInfo
+	closures     []*Closure           // the function literals in the order they're found
+	byPos        map[exprKey]*Closure // the function literals by where they are
+	perIteration map[exprKey]bool     // the loop variables which are captured by reference, by where they're declared
+}
+
+// Closures returns every function literal in the package.
+func (ci *ClosureInfo) Closures() []*Closure {
+	return ci.closures
+}
+
+// Lookup finds a function literal. It returns nil if it isn't one.
+func (ci *ClosureInfo) Lookup(filename string, lit AST) *Closure {
+	return ci.byPos[exprKey{filename, lit.Pos()}]
+}
+
+// PerIteration returns true if a variable declared by a for statement
+// needs a copy of its own for each iteration, because a function literal
+// captures it by reference.
+func (ci *ClosureInfo) PerIteration(sym *Symbol) bool {
+	return ci.perIteration[exprKey{sym.filename, sym.pos}]
+}
+
+// type closureFrame is a function being analysed - a function declaration
+// or one of the function literals inside it.
+type closureFrame struct {
+	closure  *Closure             // the literal. nil for a function declaration.
+	name     string               // what function literals inside it are named after
+	count    int                  // how many function literals have been found directly inside it
+	loops    []*loopFrame         // the loops the literal is inside
+	captured map[*Symbol]*Capture // the variables the literal captures
+	labels   map[string]SrcLoc    // where each label in it is
+	gotos    []gotoStmt           // the goto statements in it
+}
+
+// type gotoStmt is a goto statement found while analysing a function.
+type gotoStmt struct {
+	pos   SrcLoc
+	label string
+}
+
+// type loopFrame is a loop being analysed.
+type loopFrame struct {
+	pos SrcSpan
+}
+
+// type varScope is where a local variable is declared.
+type varScope struct {
+	fn           *closureFrame // the function it's declared in
+	loops        []*loopFrame  // the loops it's declared inside, including the one which declares it
+	perIteration bool          // true if it's declared by a for statement
+}
+
+// type varAccess is somewhere a local variable is changed or captured.
+type varAccess struct {
+	pos   SrcLoc
+	fn    *closureFrame
+	loops []*loopFrame
+}
+
+// type closureAnalysis finds the variables function literals capture.
+//
+// A variable is captured by value if nothing can change it after a
+// function literal captures it, so the closure can have a copy of its
+// own. Otherwise it's captured by reference. A variable can change after
+// it's captured if:
+//   - it's assigned to inside a function literal,
+//   - it's assigned to after the function literal in the function it's
+//     declared in,
+//   - it's assigned to before the function literal but inside a loop which
+//     the function literal is in too, so the assignment can run again,
+//   - it's assigned to before the function literal but after a label which
+//     a goto after the function literal jumps back to, which is a loop too,
+//   - its address is taken, since it could be changed through the pointer.
+//
+// Variables declared by a for statement are a new variable each time
+// around the loop, as they are from Go 1.22, so the post statement only
+// changes the next iteration's variable.
+type closureAnalysis struct {
+	ci        *ClosureInfo
+	filename  string
+	fns       []*closureFrame         // the function being analysed and the literals it's inside, outermost first
+	loops     []*loopFrame            // the loops being analysed, outermost first
+	decls     map[exprKey]varScope    // the local variables by where they're declared
+	writes    map[*Symbol][]varAccess // where each variable is changed
+	captures  map[*Symbol][]varAccess // where each variable is first captured in the function it's declared in
+	addrTaken map[*Symbol]bool        // the variables whose addresses are taken
+	counters  map[string]int          // how many literals there are directly in the functions with each name
+	byRef     map[*Symbol]bool        // the variables which have been found to be captured by reference
+}
+
+// AnalyseClosures finds the variables each function literal in a package
+// captures and whether they're captured by value or by reference, and
+// works out the context each literal needs when it's lowered to a
+// function. The package must have been type checked without errors.
+// Declarations which can't be reached aren't analysed.
+func AnalyseClosures(ts *DataTypeStore, info *TypeInfo, packagePath string, files []*sourceFile) *ClosureInfo {
+	ca := &closureAnalysis{
+		ci:        &ClosureInfo{info: info, byPos: make(map[exprKey]*Closure), perIteration: make(map[exprKey]bool)},
+		decls:     make(map[exprKey]varScope),
+		writes:    make(map[*Symbol][]varAccess),
+		captures:  make(map[*Symbol][]varAccess),
+		addrTaken: make(map[*Symbol]bool),
+		counters:  make(map[string]int),
+		byRef:     make(map[*Symbol]bool),
+	}
+
+	for _, sf := range files {
+		ca.filename = sf.fileName
+		for _, decl := range sf.ast.(ASTTopLevel).topLevelDecls {
+			if !info.isReachableDecl(sf.fileName, decl) {
+				continue
+			}
+			switch d := decl.(type) {
+			case ASTFunctionDecl:
+				if d.body != nil {
+					ca.function(d)
+				}
+			case ASTVarDecl:
+				// package level function literals can't capture anything
+				// but they're still lowered.
+				ca.enter("glob")
+				ca.expr(d.value)
+				ca.leave()
+			}
+		}
+	}
+
+	// work out the contexts.
+	for _, cl := range ca.ci.closures {
+		var fields []DataTypeField
+		names := make(map[string]int)
+		for _, cp := range cl.captures {
+			cp.byRef = ca.capturedByRef(cp.sym)
+			cp.field = cp.sym.name
+			if n := names[cp.sym.name]; n > 0 {
+				cp.field += "." + strconv.Itoa(n)
+			}
+			names[cp.sym.name]++
+
+			typ := cp.sym.typ
+			if cp.byRef {
+				typ = ts.MakePointer(typ)
+				if ca.decls[exprKey{cp.sym.filename, cp.sym.pos}].perIteration {
+					ca.ci.perIteration[exprKey{cp.sym.filename, cp.sym.pos}] = true
+				}
+			}
+			fields = append(fields, DataTypeField{name: cp.field, pkg: packagePath, typ: typ})
+		}
+		if len(fields) > 0 {
+			cl.context = ts.MakePointer(ts.MakeStruct(fields))
+		}
+	}
+
+	return ca.ci
+}
+
+// enter starts analysing a function declaration with the given name.
+// Declarations with the same name, like init functions, share the
+// numbering of the literals in them.
+func (ca *closureAnalysis) enter(name string) {
+	ca.fns = []*closureFrame{{name: name, count: ca.counters[name], labels: make(map[string]SrcLoc)}}
+	ca.loops = nil
+}
+
+// leave finishes analysing a function declaration.
+func (ca *closureAnalysis) leave() {
+	ca.counters[ca.fns[0].name] = ca.fns[0].count
+	ca.fns = nil
+}
+
+// function analyses a function declaration.
+func (ca *closureAnalysis) function(d ASTFunctionDecl) {
+	name := d.name
+	if d.receiver != nil {
+		name = d.receiver.(ASTReceiver).typeName + "." + d.name
+	}
+
+	ca.enter(name)
+	if d.receiver != nil {
+		if recv := d.receiver.(ASTReceiver); recv.name != "" && recv.name != "_" {
+			ca.declare(recv.pos, false)
+		}
+	}
+	ca.signature(d.params, d.returns)
+	ca.stmtList(d.body.(ASTBlock).statements)
+	ca.leave()
+}
+
+// signature declares the parameters and named results of a function.
+func (ca *closureAnalysis) signature(params []AST, returns []AST) {
+	for _, p := range parameterList(params) {
+		if p.name != nil {
+			ca.declareIdent(p.name, false)
+		}
+	}
+	for _, ret := range returns {
+		if ident, ok := ret.(ASTParameterDecl).identifier.(ASTIdentifier); ok {
+			ca.declareIdent(ident, false)
+		}
+	}
+}
+
+// declare records a local variable declared in the current function.
+func (ca *closureAnalysis) declare(pos SrcSpan, perIteration bool) {
+	ca.decls[exprKey{ca.filename, pos}] = varScope{ca.fns[len(ca.fns)-1], ca.loopStack(), perIteration}
+}
+
+// declareIdent records a local variable declared by an identifier.
+func (ca *closureAnalysis) declareIdent(ast AST, perIteration bool) {
+	if ident, ok := ast.(ASTIdentifier); ok && ident.name != "_" {
+		ca.declare(ident.pos, perIteration)
+	}
+}
+
+// isNewVar returns true if an identifier on the left of a ':=' declares a
+// new variable rather than assigning to one which already exists.
+func (ca *closureAnalysis) isNewVar(ast AST) bool {
+	ident, ok := ast.(ASTIdentifier)
+	return ok && ca.ci.info.defs[exprKey{ca.filename, ident.pos}] != nil
+}
+
+// loopStack gives a copy of the loops being analysed.
+func (ca *closureAnalysis) loopStack() []*loopFrame {
+	return append([]*loopFrame(nil), ca.loops...)
+}
+
+// access gives where a variable is being accessed.
+func (ca *closureAnalysis) access(pos SrcSpan) varAccess {
+	return varAccess{pos.start, ca.fns[len(ca.fns)-1], ca.loopStack()}
+}
+
+// localVar finds the local variable an identifier refers to. It returns
+// nil if it isn't one.
+func (ca *closureAnalysis) localVar(ident ASTIdentifier) *Symbol {
+	if ident.packageName != "" {
+		return nil
+	}
+
+	sym := ca.ci.info.uses[exprKey{ca.filename, ident.pos}]
+	if sym == nil || sym.kind != SymbolVar {
+		return nil
+	}
+	if _, ok := ca.decls[exprKey{sym.filename, sym.pos}]; !ok {
+		return nil
+	}
+
+	return sym
+}
+
+// use records that a local variable is used. If it's declared outside the
+// function literals being analysed they capture it.
+func (ca *closureAnalysis) use(ident ASTIdentifier) {
+	sym := ca.localVar(ident)
+	if sym == nil {
+		return
+	}
+
+	scope := ca.decls[exprKey{sym.filename, sym.pos}]
+	for i := len(ca.fns) - 1; i > 0 && ca.fns[i] != scope.fn; i-- {
+		frame := ca.fns[i]
+		if frame.captured[sym] != nil {
+			continue
+		}
+		cp := &Capture{sym: sym}
+		frame.captured[sym] = cp
+		frame.closure.captures = append(frame.closure.captures, cp)
+		if ca.fns[i-1] == scope.fn {
+			ca.captures[sym] = append(ca.captures[sym], varAccess{frame.closure.lit.pos.start, scope.fn, frame.loops})
+		}
+	}
+}
+
+// modify records a change to the variable an expression refers to, if
+// it's a local variable. Changing a field of a struct variable or an
+// element of an array variable changes the variable. addr is true if the
+// address is taken.
+func (ca *closureAnalysis) modify(ast AST, addr bool) {
+	switch e := ast.(type) {
+	case ASTIdentifier:
+		ca.use(e)
+		if sym := ca.localVar(e); sym != nil {
+			ca.writes[sym] = append(ca.writes[sym], ca.access(e.pos))
+			if addr {
+				ca.addrTaken[sym] = true
+			}
+		}
+
+	case ASTSelector:
+		if typ := ca.typeOf(e.expr); typ != nil && !isPointer(typ) {
+			ca.modify(e.expr, addr)
+			return
+		}
+		ca.expr(e.expr)
+
+	case ASTIndex:
+		ca.expr(e.index)
+		if _, ok := coreType(ca.typeOf(e.expr)).(*DataTypeArray); ok {
+			ca.modify(e.expr, addr)
+			return
+		}
+		ca.expr(e.expr)
+
+	default:
+		ca.expr(ast)
+	}
+}
+
+// typeOf gives the type of an expression.
+func (ca *closureAnalysis) typeOf(ast AST) DataType {
+	return ca.ci.info.types[exprKey{ca.filename, ast.Pos()}]
+}
+
+// capturedByRef returns true if a variable can change after it's
+// captured, so it has to be captured by reference.
+func (ca *closureAnalysis) capturedByRef(sym *Symbol) bool {
+	if byRef, ok := ca.byRef[sym]; ok {
+		return byRef
+	}
+
+	byRef := ca.addrTaken[sym]
+	scope := ca.decls[exprKey{sym.filename, sym.pos}]
+	for _, w := range ca.writes[sym] {
+		if w.fn != scope.fn {
+			// it's changed inside a function literal.
+			byRef = true
+		}
+		for _, c := range ca.captures[sym] {
+			if c.pos.Before(w.pos) || sharedLoops(w.loops, c.loops) > len(scope.loops) || ca.jumpsBack(scope.fn, sym.pos.start, w.pos, c.pos) {
+				byRef = true
+			}
+		}
+	}
+
+	ca.byRef[sym] = byRef
+	return byRef
+}
+
+// jumpsBack returns true if a goto in a function can go from after the
+// place "from" back to before the place "to" without going back before a
+// variable's declaration at decl. That's a loop which the variable is
+// declared outside, like any other.
+func (ca *closureAnalysis) jumpsBack(fn *closureFrame, decl SrcLoc, to SrcLoc, from SrcLoc) bool {
+	for _, g := range fn.gotos {
+		label, ok := fn.labels[g.label]
+		if ok && decl.Before(label) && !to.Before(label) && from.Before(g.pos) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sharedLoops gives how many loops two places are both inside.
+func sharedLoops(a []*loopFrame, b []*loopFrame) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+
+	return n
+}
+
+// stmtList analyses a list of statements.
+func (ca *closureAnalysis) stmtList(stmts []AST) {
+	for _, stmt := range stmts {
+		ca.stmt(stmt)
+	}
+}
+
+// stmt analyses a statement.
+func (ca *closureAnalysis) stmt(ast AST) {
+	switch s := ast.(type) {
+	case nil, ASTConstDecl, ASTDataTypeDecl:
+
+	case ASTBranch:
+		if s.tok == TokenKindGoto {
+			fn := ca.fns[len(ca.fns)-1]
+			fn.gotos = append(fn.gotos, gotoStmt{s.pos.start, s.label})
+		}
+
+	case ASTBlock:
+		ca.stmtList(s.statements)
+
+	case ASTLabeled:
+		ca.fns[len(ca.fns)-1].labels[s.label] = s.pos.start
+		ca.stmt(s.stmt)
+
+	case ASTVarDecl:
+		ca.expr(s.value)
+		ca.declareIdent(s.ident, false)
+
+	case ASTAssign:
+		ca.assign(s, false)
+
+	case ASTIncDec:
+		ca.modify(s.expr, false)
+
+	case ASTSend:
+		ca.expr(s.channel)
+		ca.expr(s.value)
+
+	case ASTReturn:
+		ca.exprList(s.results)
+
+	case ASTGo:
+		ca.expr(s.call)
+
+	case ASTDefer:
+		ca.expr(s.call)
+
+	case ASTIf:
+		ca.stmt(s.init)
+		ca.expr(s.cond)
+		ca.stmt(s.then)
+		ca.stmt(s.els)
+
+	case ASTFor:
+		ca.loops = append(ca.loops, &loopFrame{s.pos})
+		if init, ok := s.init.(ASTAssign); ok {
+			ca.assign(init, true)
+		} else {
+			ca.stmt(s.init)
+		}
+		ca.expr(s.cond)
+		ca.stmt(s.post)
+		ca.stmt(s.body)
+		ca.loops = ca.loops[:len(ca.loops)-1]
+
+	case ASTRange:
+		ca.expr(s.expr)
+		ca.loops = append(ca.loops, &loopFrame{s.pos})
+		for _, v := range []AST{s.key, s.value} {
+			switch {
+			case v == nil:
+			case s.define:
+				ca.declareIdent(v, true)
+			default:
+				ca.modify(v, false)
+			}
+		}
+		ca.stmt(s.body)
+		ca.loops = ca.loops[:len(ca.loops)-1]
+
+	case ASTSwitch:
+		ca.stmt(s.init)
+		ca.stmt(s.tag)
+		for _, clause := range s.cases {
+			cc := clause.(ASTCaseClause)
+			ca.exprList(cc.exprs)
+			ca.stmtList(cc.body)
+		}
+
+	case ASTSelect:
+		for _, clause := range s.cases {
+			cc := clause.(ASTCommClause)
+			ca.stmt(cc.comm)
+			ca.stmtList(cc.body)
+		}
+
+	default:
+		ca.expr(ast)
+	}
+}
+
+// assign analyses an assignment. perIteration is true for the init
+// statement of a for statement.
+func (ca *closureAnalysis) assign(s ASTAssign, perIteration bool) {
+	ca.exprList(s.right)
+	for _, l := range s.left {
+		if s.op == TokenKindDeclareAssign && ca.isNewVar(l) {
+			ca.declareIdent(l, perIteration)
+			continue
+		}
+		if s.op == TokenKindAssign || s.op == TokenKindDeclareAssign {
+			ca.modify(l, false)
+		} else {
+			// compound assignments use the old value too.
+			ca.modify(l, false)
+			ca.expr(l)
+		}
+	}
+}
+
+// exprList analyses a list of expressions.
+func (ca *closureAnalysis) exprList(exprs []AST) {
+	for _, e := range exprs {
+		ca.expr(e)
+	}
+}
+
+// expr analyses an expression.
+func (ca *closureAnalysis) expr(ast AST) {
+	switch e := ast.(type) {
+	case ASTIdentifier:
+		ca.use(e)
+
+	case ASTUnaryExpr:
+		if e.op == TokenKindBitwiseAnd {
+			ca.modify(e.param, true)
+		} else {
+			ca.expr(e.param)
+		}
+
+	case ASTBinaryExpr:
+		ca.expr(e.left)
+		ca.expr(e.right)
+
+	case ASTCall:
+		ca.expr(e.function)
+		ca.exprList(e.args)
+
+	case ASTSelector:
+		// calling a method with a pointer receiver takes the address of
+		// the variable it's called on.
+		if typ := ca.typeOf(e.expr); typ != nil && !isPointer(typ) {
+			if _, isMethod, method, ptrRecv, _, _ := lookupFieldOrMethod(typ, e.name); isMethod && method != nil && ptrRecv {
+				ca.modify(e.expr, true)
+				return
+			}
+		}
+		ca.expr(e.expr)
+
+	case ASTIndex:
+		ca.expr(e.expr)
+		ca.expr(e.index)
+
+	case ASTInstance:
+		ca.expr(e.expr)
+
+	case ASTSliceExpr:
+		// slicing an array takes its address.
+		if _, ok := coreType(ca.typeOf(e.expr)).(*DataTypeArray); ok {
+			ca.modify(e.expr, true)
+		} else {
+			ca.expr(e.expr)
+		}
+		ca.expr(e.low)
+		ca.expr(e.high)
+		ca.expr(e.max)
+
+	case ASTTypeAssertion:
+		ca.expr(e.expr)
+
+	case ASTCompositeLit:
+		_, isStruct := coreType(ca.typeOf(e)).(*DataTypeStruct)
+		for _, elem := range e.elements {
+			if kv, ok := elem.(ASTKeyedElement); ok {
+				if !isStruct {
+					ca.expr(kv.key)
+				}
+				elem = kv.value
+			}
+			ca.expr(elem)
+		}
+
+	case ASTFunctionLit:
+		ca.functionLit(e)
+	}
+}
+
+// functionLit analyses a function literal.
+func (ca *closureAnalysis) functionLit(e ASTFunctionLit) {
+	parent := ca.fns[len(ca.fns)-1]
+	parent.count++
+	name := parent.name + ".func" + strconv.Itoa(parent.count)
+	if parent.closure != nil {
+		name = parent.name + "." + strconv.Itoa(parent.count)
+	}
+
+	cl := &Closure{filename: ca.filename, lit: e, name: name}
+	ca.ci.closures = append(ca.ci.closures, cl)
+	ca.ci.byPos[exprKey{ca.filename, e.pos}] = cl
+
+	ca.fns = append(ca.fns, &closureFrame{closure: cl, name: name, loops: ca.loopStack(), captured: make(map[*Symbol]*Capture), labels: make(map[string]SrcLoc)})
+	ca.signature(e.typ.params, e.typ.returns)
+	ca.stmtList(e.body.(ASTBlock).statements)
+	ca.fns = ca.fns[:len(ca.fns)-1]
+}
+
+// type loopCopy is a loop being lowered.
+type loopCopy struct {
+	label    string // the loop's label, if it has one
+	copyBack AST    // the assignment which passes per-iteration variables on to the next iteration. nil if there isn't one.
+}
+
+// type closureLowering rewrites function literals into functions which
+// are given an explicit context holding the variables they capture.
+//
+// A function literal becomes an ASTClosure which names the function it's
+// lowered to and gives the values of its context's fields - the variables
+// captured by value and the addresses of the ones captured by reference.
+// The function is given the context as its first parameter and uses the
+// fields in place of the variables.
+//
+// Loop variables which are captured by reference are given a copy for
+// each iteration explicitly:
+//
+//	for i := 0; i < n; i++ { body }
+//
+// becomes:
+//
+//	{
+//		i.next := 0
+//		.first := true
+//		for {
+//			i := i.next
+//			if .first { .first = false } else { i++ }
+//			if !(i < n) { break }
+//			{ body }
+//			i.next = i
+//		}
+//	}
+//
+// with "i.next = i" before each continue statement in the body too.
+type closureLowering struct {
+	ci         *ClosureInfo
+	filename   string
+	captured   map[*Symbol]*Capture // the captures of the function literal being lowered. nil outside function literals.
+	loops      []loopCopy           // the loops being lowered, outermost first
+	typeParams []AST                // the type parameters of the generic function being lowered
+	lowered    []AST                // the functions the literals are lowered to
+}
+
+// Lower rewrites the function literals in a file of the package into
+// functions which take the variables they capture in an explicit context.
+// The functions are added to the end of the file's declarations. The
+// declarations which can't be reached are left out.
+func (ci *ClosureInfo) Lower(filename string, file ASTTopLevel) ASTTopLevel {
+	lw := &closureLowering{ci: ci, filename: filename}
+
+	var decls []AST
+	for _, decl := range file.topLevelDecls {
+		if !ci.info.isReachableDecl(filename, decl) {
+			continue
+		}
+		switch d := decl.(type) {
+		case ASTFunctionDecl:
+			if d.body != nil {
+				lw.typeParams = d.typeParams
+				d.body = lw.stmt(d.body)
+			}
+			decl = d
+		case ASTVarDecl:
+			d.value = lw.expr(d.value)
+			decl = d
+		}
+		decls = append(decls, decl)
+	}
+
+	return ASTTopLevel{file.pos, file.packageName, file.imports, append(decls, lw.lowered...)}
+}
+
+// capture finds the capture an identifier refers to in the function
+// literal being lowered. It returns nil if it doesn't refer to one.
+func (lw *closureLowering) capture(ident ASTIdentifier) *Capture {
+	if lw.captured == nil || ident.packageName != "" {
+		return nil
+	}
+
+	return lw.captured[lw.ci.info.uses[exprKey{lw.filename, ident.pos}]]
+}
+
+// contextField gives the field of the context which holds a captured
+// variable. For variables captured by reference it's the variable's
+// address.
+func contextField(pos SrcSpan, cp *Capture) AST {
+	return ASTSelector{pos, ASTIdentifier{pos, "", closureContextName}, cp.field}
+}
+
+// isPerIteration returns true if an identifier declares a loop variable
+// which needs a copy for each iteration.
+func (lw *closureLowering) isPerIteration(ast AST) bool {
+	ident, ok := ast.(ASTIdentifier)
+	return ok && lw.ci.perIteration[exprKey{lw.filename, ident.pos}]
+}
+
+// stmtList rewrites a list of statements.
+func (lw *closureLowering) stmtList(stmts []AST) []AST {
+	var result []AST
+	for _, stmt := range stmts {
+		if s, ok := stmt.(ASTAssign); ok && s.op == TokenKindDeclareAssign {
+			result = append(result, lw.declareAssign(s)...)
+			continue
+		}
+		result = append(result, lw.stmt(stmt))
+	}
+
+	return result
+}
+
+// declareAssign rewrites a short variable declaration. If it assigns to a
+// captured variable that's now a context field, which ':=' can't assign
+// to, the new variables are declared separately first.
+func (lw *closureLowering) declareAssign(s ASTAssign) []AST {
+	var decls []AST
+	left := lw.exprList(s.left)
+	for i, l := range left {
+		if _, ok := l.(ASTIdentifier); !ok {
+			s.op = TokenKindAssign
+		}
+		ident := s.left[i].(ASTIdentifier)
+		if sym := lw.ci.info.defs[exprKey{lw.filename, ident.pos}]; sym != nil {
+			decls = append(decls, ASTVarDecl{ident, ASTResolvedType{ident.pos, sym.typ}, nil})
+		}
+	}
+	if s.op == TokenKindDeclareAssign {
+		return []AST{lw.stmt(s)}
+	}
+
+	s.left, s.right = left, lw.exprList(s.right)
+	return append(decls, s)
+}
+
+// stmt rewrites a statement.
+func (lw *closureLowering) stmt(ast AST) AST {
+	switch s := ast.(type) {
+	case ASTBlock:
+		s.statements = lw.stmtList(s.statements)
+		return s
+
+	case ASTLabeled:
+		switch s.stmt.(type) {
+		case ASTFor, ASTRange:
+			return lw.loop(s.stmt, s.label, s.pos)
+		}
+		s.stmt = lw.stmt(s.stmt)
+		return s
+
+	case ASTFor, ASTRange:
+		return lw.loop(s, "", SrcSpan{})
+
+	case ASTVarDecl:
+		s.value = lw.expr(s.value)
+		return s
+
+	case ASTAssign:
+		s.left = lw.exprList(s.left)
+		s.right = lw.exprList(s.right)
+		return s
+
+	case ASTIncDec:
+		s.expr = lw.expr(s.expr)
+		return s
+
+	case ASTSend:
+		s.channel = lw.expr(s.channel)
+		s.value = lw.expr(s.value)
+		return s
+
+	case ASTReturn:
+		s.results = lw.exprList(s.results)
+		return s
+
+	case ASTGo:
+		s.call = lw.expr(s.call)
+		return s
+
+	case ASTDefer:
+		s.call = lw.expr(s.call)
+		return s
+
+	case ASTIf:
+		s.init = lw.stmt(s.init)
+		s.cond = lw.expr(s.cond)
+		s.then = lw.stmt(s.then)
+		s.els = lw.stmt(s.els)
+		return s
+
+	case ASTSwitch:
+		s.init = lw.stmt(s.init)
+		s.tag = lw.stmt(s.tag)
+		var cases []AST
+		for _, clause := range s.cases {
+			cc := clause.(ASTCaseClause)
+			cc.exprs = lw.exprList(cc.exprs)
+			cc.body = lw.stmtList(cc.body)
+			cases = append(cases, cc)
+		}
+		s.cases = cases
+		return s
+
+	case ASTSelect:
+		var cases []AST
+		for _, clause := range s.cases {
+			cc := clause.(ASTCommClause)
+			cc.comm = lw.stmt(cc.comm)
+			cc.body = lw.stmtList(cc.body)
+			cases = append(cases, cc)
+		}
+		s.cases = cases
+		return s
+
+	case ASTBranch:
+		if s.tok == TokenKindContinue {
+			if copyBack := lw.continueCopy(s.label); copyBack != nil {
+				return ASTBlock{s.pos, []AST{copyBack, s}}
+			}
+		}
+		return s
+	}
+
+	return lw.expr(ast)
+}
+
+// continueCopy finds the assignment which passes per-iteration variables
+// on to the next iteration of the loop a continue statement continues. It
+// returns nil if there isn't one.
+func (lw *closureLowering) continueCopy(label string) AST {
+	for i := len(lw.loops) - 1; i >= 0; i-- {
+		if label == "" || lw.loops[i].label == label {
+			return lw.loops[i].copyBack
+		}
+	}
+
+	return nil
+}
+
+// labeled gives a loop its label back.
+func labeled(loop AST, label string, pos SrcSpan) AST {
+	if label == "" {
+		return loop
+	}
+
+	return ASTLabeled{pos, label, loop}
+}
+
+// loop rewrites a for statement, which may be labeled.
+func (lw *closureLowering) loop(ast AST, label string, labelPos SrcSpan) AST {
+	switch s := ast.(type) {
+	case ASTFor:
+		if init, ok := s.init.(ASTAssign); ok && init.op == TokenKindDeclareAssign {
+			for _, l := range init.left {
+				if lw.isPerIteration(l) {
+					return lw.perIterationFor(s, init, label, labelPos)
+				}
+			}
+		}
+		s.init = lw.stmt(s.init)
+		s.cond = lw.expr(s.cond)
+		s.post = lw.stmt(s.post)
+		lw.loops = append(lw.loops, loopCopy{label, nil})
+		s.body = lw.stmt(s.body)
+		lw.loops = lw.loops[:len(lw.loops)-1]
+		return labeled(s, label, labelPos)
+
+	case ASTRange:
+		s.expr = lw.expr(s.expr)
+		if !s.define {
+			s.key = lw.expr(s.key)
+			s.value = lw.expr(s.value)
+		}
+		lw.loops = append(lw.loops, loopCopy{label, nil})
+		body := lw.stmt(s.body)
+		lw.loops = lw.loops[:len(lw.loops)-1]
+
+		// each iteration gets its own copy of the variables.
+		if s.define && (lw.isPerIteration(s.key) || lw.isPerIteration(s.value)) {
+			var vars, iters []AST
+			for _, v := range []*AST{&s.key, &s.value} {
+				if ident, ok := (*v).(ASTIdentifier); ok && ident.name != "_" {
+					iter := ASTIdentifier{ident.pos, "", ident.name + ".iter"}
+					vars, iters = append(vars, ident), append(iters, iter)
+					*v = iter
+				}
+			}
+			body = ASTBlock{body.Pos(), []AST{ASTAssign{s.pos, TokenKindDeclareAssign, vars, iters}, body}}
+		}
+		s.body = body
+		return labeled(s, label, labelPos)
+	}
+
+	return ast
+}
+
+// perIterationFor rewrites a for statement whose variables need a copy
+// for each iteration.
+func (lw *closureLowering) perIterationFor(s ASTFor, init ASTAssign, label string, labelPos SrcSpan) AST {
+	pos := s.pos
+	var vars, nexts, allNexts []AST
+	for _, l := range init.left {
+		ident := l.(ASTIdentifier)
+		if ident.name == "_" {
+			allNexts = append(allNexts, ident)
+			continue
+		}
+		next := ASTIdentifier{ident.pos, "", ident.name + ".next"}
+		vars, nexts, allNexts = append(vars, ident), append(nexts, next), append(allNexts, next)
+	}
+
+	stmts := []AST{ASTAssign{init.pos, TokenKindDeclareAssign, allNexts, lw.exprList(init.right)}}
+	body := []AST{ASTAssign{init.pos, TokenKindDeclareAssign, vars, nexts}}
+
+	// the post statement runs at the start of every iteration but the
+	// first, on the new copies.
+	if post := lw.stmt(s.post); post != nil {
+		first := ASTIdentifier{pos, "", ".first"}
+		stmts = append(stmts, ASTAssign{pos, TokenKindDeclareAssign, []AST{first}, []AST{ASTIdentifier{pos, "", "true"}}})
+		notFirst := ASTBlock{pos, []AST{ASTAssign{pos, TokenKindAssign, []AST{first}, []AST{ASTIdentifier{pos, "", "false"}}}}}
+		body = append(body, ASTIf{pos, nil, first, notFirst, ASTBlock{pos, []AST{post}}})
+	}
+	if s.cond != nil {
+		done := ASTBlock{pos, []AST{ASTBranch{pos, TokenKindBreak, ""}}}
+		body = append(body, ASTIf{pos, nil, ASTUnaryExpr{pos, TokenKindNot, lw.expr(s.cond)}, done, nil})
+	}
+
+	copyBack := ASTAssign{pos, TokenKindAssign, nexts, vars}
+	lw.loops = append(lw.loops, loopCopy{label, copyBack})
+	body = append(body, lw.stmt(s.body), copyBack)
+	lw.loops = lw.loops[:len(lw.loops)-1]
+
+	loop := ASTFor{pos, nil, nil, nil, ASTBlock{s.body.Pos(), body}}
+	return ASTBlock{pos, append(stmts, labeled(loop, label, labelPos))}
+}
+
+// exprList rewrites a list of expressions.
+func (lw *closureLowering) exprList(exprs []AST) []AST {
+	var result []AST
+	for _, e := range exprs {
+		result = append(result, lw.expr(e))
+	}
+
+	return result
+}
+
+// expr rewrites an expression.
+func (lw *closureLowering) expr(ast AST) AST {
+	switch e := ast.(type) {
+	case ASTIdentifier:
+		if cp := lw.capture(e); cp != nil {
+			if cp.byRef {
+				return ASTUnaryExpr{e.pos, TokenKindAsterisk, contextField(e.pos, cp)}
+			}
+			return contextField(e.pos, cp)
+		}
+
+	case ASTUnaryExpr:
+		// the address of a variable captured by reference is in the
+		// context already.
+		if ident, ok := e.param.(ASTIdentifier); ok && e.op == TokenKindBitwiseAnd {
+			if cp := lw.capture(ident); cp != nil && cp.byRef {
+				return contextField(e.pos, cp)
+			}
+		}
+		e.param = lw.expr(e.param)
+		return e
+
+	case ASTBinaryExpr:
+		e.left = lw.expr(e.left)
+		e.right = lw.expr(e.right)
+		return e
+
+	case ASTCall:
+		e.function = lw.expr(e.function)
+		e.args = lw.exprList(e.args)
+		return e
+
+	case ASTSelector:
+		e.expr = lw.expr(e.expr)
+		return e
+
+	case ASTIndex:
+		e.expr = lw.expr(e.expr)
+		e.index = lw.expr(e.index)
+		return e
+
+	case ASTInstance:
+		e.expr = lw.expr(e.expr)
+		return e
+
+	case ASTSliceExpr:
+		e.expr = lw.expr(e.expr)
+		e.low = lw.expr(e.low)
+		e.high = lw.expr(e.high)
+		e.max = lw.expr(e.max)
+		return e
+
+	case ASTTypeAssertion:
+		e.expr = lw.expr(e.expr)
+		return e
+
+	case ASTCompositeLit:
+		_, isStruct := coreType(lw.ci.info.types[exprKey{lw.filename, e.pos}]).(*DataTypeStruct)
+		var elements []AST
+		for _, elem := range e.elements {
+			if kv, ok := elem.(ASTKeyedElement); ok {
+				if !isStruct {
+					kv.key = lw.expr(kv.key)
+				}
+				kv.value = lw.expr(kv.value)
+				elem = kv
+			} else {
+				elem = lw.expr(elem)
+			}
+			elements = append(elements, elem)
+		}
+		e.elements = elements
+		return e
+
+	case ASTFunctionLit:
+		return lw.functionLit(e)
+	}
+
+	return ast
+}
+
+// functionLit lowers a function literal to a function, returning the
+// closure which replaces it.
+func (lw *closureLowering) functionLit(e ASTFunctionLit) AST {
+	cl := lw.ci.byPos[exprKey{lw.filename, e.pos}]
+
+	// the context is made from the variables as they're seen here, which
+	// may be from the context of the literal this one is in.
+	var context []AST
+	for _, cp := range cl.captures {
+		switch outer := lw.captured[cp.sym]; {
+		case outer != nil:
+			context = append(context, contextField(e.pos, outer))
+		case cp.byRef:
+			context = append(context, ASTUnaryExpr{e.pos, TokenKindBitwiseAnd, ASTIdentifier{e.pos, "", cp.sym.name}})
+		default:
+			context = append(context, ASTIdentifier{e.pos, "", cp.sym.name})
+		}
+	}
+
+	// keep the function's place so functions are in the order of their
+	// literals.
+	index := len(lw.lowered)
+	lw.lowered = append(lw.lowered, nil)
+
+	savedCaptured, savedLoops := lw.captured, lw.loops
+	lw.captured, lw.loops = make(map[*Symbol]*Capture), nil
+	for _, cp := range cl.captures {
+		lw.captured[cp.sym] = cp
+	}
+	body := lw.stmt(e.body)
+	lw.captured, lw.loops = savedCaptured, savedLoops
+
+	params := e.typ.params
+	if cl.context != nil {
+		ctx := ASTParameterDecl{ASTIdentifier{e.pos, "", closureContextName}, ASTResolvedType{e.pos, cl.context}}
+		params = append([]AST{ctx}, params...)
+	}
+	lw.lowered[index] = ASTFunctionDecl{e.pos, cl.name, lw.typeParams, nil, params, e.typ.returns, body}
+
+	return ASTClosure{e.pos, cl.name, context}
+}
@@ -0,0 +1,255 @@
+package golightly
+
+import "testing"
+
+func TestClosures(t *testing.T) {
+	ta := &testAST{}
+	intType := func() AST { return ta.ident("int") }
+	intFunc := func() ASTDataTypeFunc { return ASTDataTypeFunc{ta.pos(), nil, []AST{ta.param("", ta.ident("int"))}} }
+	lit := func(typ ASTDataTypeFunc, body ...AST) ASTFunctionLit {
+		return ASTFunctionLit{ta.pos(), typ, ASTBlock{ta.pos(), body}}
+	}
+	ret := func(value AST) AST { return ASTReturn{ta.pos(), []AST{value}} }
+	assign := func(left AST, right AST) AST {
+		return ASTAssign{ta.pos(), TokenKindAssign, []AST{left}, []AST{right}}
+	}
+	discard := func(value AST) AST { return assign(ta.ident("_"), value) }
+
+	// the identifiers which declare the variables, by name.
+	vars := make(map[string]AST)
+	define := func(name string, value AST) AST {
+		ident := ta.ident(name)
+		vars[name] = ident
+		return ASTAssign{ta.pos(), TokenKindDeclareAssign, []AST{ident}, []AST{value}}
+	}
+	lits := make(map[string]ASTFunctionLit)
+	keep := func(name string, l ASTFunctionLit) AST {
+		lits[name] = l
+		return l
+	}
+	// the body is made last so the positions are in the same order as
+	// they'd be in the source.
+	loop := func(name string, body func() []AST) AST {
+		return ASTFor{ta.pos(),
+			define(name, ta.int(0)),
+			ta.binary(TokenKindLess, ta.ident(name), ta.int(3)),
+			ASTIncDec{ta.pos(), TokenKindIncrement, ta.ident(name)},
+			ASTBlock{ta.pos(), body()}}
+	}
+	appendFunc := func(f AST) []AST {
+		return []AST{assign(ta.ident("fs"), ta.call(ta.ident("append"), ta.ident("fs"), f))}
+	}
+
+	// func handlers() []func() int {
+	//	var fs []func() int
+	//	for i := 0; i < 3; i++ { fs = append(fs, func() int { return i }) }
+	//	for j := 0; j < 3; j++ { fs = append(fs, func() int { j = j + 1; return j }) }
+	//	total := 0
+	//	add := func(n int) { total = total + n }
+	//	add(1)
+	//	label := "x"
+	//	show := func() string { return label }
+	//	count := 0
+	//	get := func() int { return count }
+	//	count = 5
+	//	nested := func() func() int { return func() int { return total } }
+	//	_, _, _ = show, get, nested
+	//	return fs
+	// }
+	decls := []AST{
+		ASTFunctionDecl{ta.pos(), "handlers", nil, nil, nil, []AST{ta.param("", ASTDataTypeSlice{ta.pos(), intFunc()})}, ASTBlock{ta.pos(), []AST{
+			ta.varDecl("fs", ASTDataTypeSlice{ta.pos(), intFunc()}, nil),
+			loop("i", func() []AST {
+				return appendFunc(keep("byValueLoop", lit(intFunc(), ret(ta.ident("i")))))
+			}),
+			loop("j", func() []AST {
+				return appendFunc(keep("byRefLoop", lit(intFunc(),
+					assign(ta.ident("j"), ta.binary(TokenKindAdd, ta.ident("j"), ta.int(1))),
+					ret(ta.ident("j")))))
+			}),
+			define("total", ta.int(0)),
+			define("add", keep("add", lit(ASTDataTypeFunc{ta.pos(), []AST{ta.param("n", intType())}, nil},
+				assign(ta.ident("total"), ta.binary(TokenKindAdd, ta.ident("total"), ta.ident("n")))))),
+			ta.call(ta.ident("add"), ta.int(1)),
+			define("label", ta.str("x")),
+			define("show", keep("show", lit(ASTDataTypeFunc{ta.pos(), nil, []AST{ta.param("", ta.ident("string"))}}, ret(ta.ident("label"))))),
+			define("count", ta.int(0)),
+			define("get", keep("get", lit(intFunc(), ret(ta.ident("count"))))),
+			assign(ta.ident("count"), ta.int(5)),
+			define("nested", keep("outer", lit(ASTDataTypeFunc{ta.pos(), nil, []AST{ta.param("", intFunc())}},
+				ret(keep("inner", lit(intFunc(), ret(ta.ident("total")))))))),
+			discard(ta.ident("show")),
+			discard(ta.ident("get")),
+			discard(ta.ident("nested")),
+			ret(ta.ident("fs")),
+		}}},
+		ta.mainUsing("handlers"),
+	}
+
+	sf := &sourceFile{packageName: "main", fileName: "test.go", ast: ASTTopLevel{topLevelDecls: decls}}
+	ts := NewDataTypeStore()
+	c, errs := checkTestFiles(ts, []*sourceFile{sf})
+	if len(errs) != 0 {
+		t.Fatal("unexpected errors: ", errs)
+	}
+	ci := AnalyseClosures(ts, c.Info(), "main", []*sourceFile{sf})
+
+	type capture struct {
+		name  string
+		byRef bool
+	}
+	cases := []struct {
+		lit      AST
+		name     string
+		captures []capture
+		context  string
+	}{
+		{lits["byValueLoop"], "handlers.func1", []capture{{"i", false}}, "*struct{i int}"},
+		{lits["byRefLoop"], "handlers.func2", []capture{{"j", true}}, "*struct{j *int}"},
+		{lits["add"], "handlers.func3", []capture{{"total", true}}, "*struct{total *int}"},
+		{lits["show"], "handlers.func4", []capture{{"label", false}}, "*struct{label string}"},
+		{lits["get"], "handlers.func5", []capture{{"count", true}}, "*struct{count *int}"},
+		{lits["outer"], "handlers.func6", []capture{{"total", true}}, "*struct{total *int}"},
+		{lits["inner"], "handlers.func6.1", []capture{{"total", true}}, "*struct{total *int}"},
+	}
+
+	for i, tc := range cases {
+		cl := ci.Lookup("test.go", tc.lit)
+		if cl == nil {
+			t.Error("case ", i, ": there's no closure for ", tc.name)
+			continue
+		}
+		if cl.Name() != tc.name {
+			t.Error("case ", i, ": expected the name ", tc.name, " but got ", cl.Name())
+		}
+		if len(cl.Captures()) != len(tc.captures) {
+			t.Error("case ", i, ": expected ", len(tc.captures), " captures but got ", len(cl.Captures()))
+			continue
+		}
+		for j, cp := range cl.Captures() {
+			if cp.Var().Name() != tc.captures[j].name || cp.ByRef() != tc.captures[j].byRef {
+				t.Error("case ", i, ": expected capture ", tc.captures[j], " but got ", cp.Var().Name(), " by reference: ", cp.ByRef())
+			}
+		}
+		if context := TypeString(cl.Context(), "main"); context != tc.context {
+			t.Error("case ", i, ": expected the context ", tc.context, " but got ", context)
+		}
+	}
+
+	// only j is changed after it's captured so only it needs a copy for
+	// each iteration.
+	sym := func(name string) *Symbol { return c.Info().defs[exprKey{"test.go", vars[name].Pos()}] }
+	if !ci.PerIteration(sym("j")) || ci.PerIteration(sym("i")) {
+		t.Error("expected j to need a copy for each iteration and i not to")
+	}
+
+	lowered := ci.Lower("test.go", sf.ast.(ASTTopLevel))
+	funcs := make(map[string]ASTFunctionDecl)
+	for _, decl := range lowered.topLevelDecls {
+		d := decl.(ASTFunctionDecl)
+		funcs[d.name] = d
+	}
+	for _, tc := range cases {
+		if _, ok := funcs[tc.name]; !ok {
+			t.Error("there's no lowered function called ", tc.name)
+		}
+	}
+
+	// the literal which adds to total takes its address in its context and
+	// uses it through the context.
+	body := funcs["handlers"].body.(ASTBlock).statements
+	addPos := lits["add"].pos
+	addClosure := body[4].(ASTAssign).right[0]
+	expected := ASTClosure{addPos, "handlers.func3", []AST{ASTUnaryExpr{addPos, TokenKindBitwiseAnd, ASTIdentifier{addPos, "", "total"}}}}
+	if !addClosure.Equals(expected) {
+		t.Error("expected the closure ", expected, " but got ", addClosure)
+	}
+	addFunc := funcs["handlers.func3"]
+	if len(addFunc.params) != 2 || addFunc.params[0].(ASTParameterDecl).identifier.(ASTIdentifier).name != closureContextName {
+		t.Error("expected the context to be the first parameter of the lowered function but got ", addFunc.params)
+	}
+	total := addFunc.body.(ASTBlock).statements[0].(ASTAssign).left[0]
+	if u, ok := total.(ASTUnaryExpr); !ok || u.op != TokenKindAsterisk || u.param.(ASTSelector).name != "total" {
+		t.Error("expected total to be used through the context but got ", total)
+	}
+
+	// the inner literal gets total's address from the outer one's context.
+	outerBody := funcs["handlers.func6"].body.(ASTBlock).statements
+	innerClosure := outerBody[0].(ASTReturn).results[0].(ASTClosure)
+	if sel, ok := innerClosure.context[0].(ASTSelector); !ok || sel.name != "total" {
+		t.Error("expected the inner closure's context to come from the outer one's but got ", innerClosure.context)
+	}
+
+	// the loop over i is left alone and the loop over j is rewritten to
+	// give each iteration its own j.
+	if _, ok := body[1].(ASTFor); !ok {
+		t.Error("expected the loop over i to be left alone but got ", body[1])
+	}
+	block, ok := body[2].(ASTBlock)
+	if !ok || len(block.statements) != 3 {
+		t.Fatal("expected the loop over j to be rewritten but got ", body[2])
+	}
+	if next := block.statements[0].(ASTAssign).left[0].(ASTIdentifier); next.name != "j.next" {
+		t.Error("expected j.next to be declared before the loop but got ", next.name)
+	}
+	perIteration := block.statements[2].(ASTFor).body.(ASTBlock).statements
+	if copy := perIteration[0].(ASTAssign); copy.op != TokenKindDeclareAssign || !copy.left[0].Equals(vars["j"]) {
+		t.Error("expected each iteration to start by declaring j but got ", perIteration[0])
+	}
+	if copyBack := perIteration[len(perIteration)-1].(ASTAssign); copyBack.op != TokenKindAssign || copyBack.left[0].(ASTIdentifier).name != "j.next" {
+		t.Error("expected each iteration to end by copying j to j.next but got ", perIteration[len(perIteration)-1])
+	}
+}
+
+func TestClosuresBackwardGoto(t *testing.T) {
+	ta := &testAST{}
+	intFunc := func() ASTDataTypeFunc { return ASTDataTypeFunc{ta.pos(), nil, []AST{ta.param("", ta.ident("int"))}} }
+	define := func(ident AST, value AST) AST {
+		return ASTAssign{ta.pos(), TokenKindDeclareAssign, []AST{ident}, []AST{value}}
+	}
+
+	// func retry() func() int {
+	//	n := 0
+	// again:
+	//	n = n + 1
+	//	m := n
+	//	f := func() int { return n + m }
+	//	if n < 2 { goto again }
+	//	return f
+	// }
+	//
+	// n is changed before the literal but the goto runs the change again
+	// after it. m is declared after the label so it's a new m each time.
+	n, m := ta.ident("n"), ta.ident("m")
+	decls := []AST{
+		ASTFunctionDecl{ta.pos(), "retry", nil, nil, nil, []AST{ta.param("", intFunc())}, ASTBlock{ta.pos(), []AST{
+			define(n, ta.int(0)),
+			ASTLabeled{ta.pos(), "again", ASTAssign{ta.pos(), TokenKindAssign, []AST{ta.ident("n")}, []AST{ta.binary(TokenKindAdd, ta.ident("n"), ta.int(1))}}},
+			define(m, ta.ident("n")),
+			define(ta.ident("f"), ASTFunctionLit{ta.pos(), intFunc(), ASTBlock{ta.pos(), []AST{
+				ASTReturn{ta.pos(), []AST{ta.binary(TokenKindAdd, ta.ident("n"), ta.ident("m"))}},
+			}}}),
+			ASTIf{ta.pos(), nil, ta.binary(TokenKindLess, ta.ident("n"), ta.int(2)), ASTBlock{ta.pos(), []AST{ASTBranch{ta.pos(), TokenKindGoto, "again"}}}, nil},
+			ASTReturn{ta.pos(), []AST{ta.ident("f")}},
+		}}},
+		ta.mainUsing("retry"),
+	}
+
+	sf := &sourceFile{packageName: "main", fileName: "test.go", ast: ASTTopLevel{topLevelDecls: decls}}
+	ts := NewDataTypeStore()
+	c, errs := checkTestFiles(ts, []*sourceFile{sf})
+	if len(errs) != 0 {
+		t.Fatal("unexpected errors: ", errs)
+	}
+	ci := AnalyseClosures(ts, c.Info(), "main", []*sourceFile{sf})
+
+	if len(ci.closures) != 1 || len(ci.closures[0].Captures()) != 2 {
+		t.Fatal("expected one closure capturing n and m but got ", ci.closures)
+	}
+	for _, cp := range ci.closures[0].Captures() {
+		byRef := cp.Var().Name() == "n"
+		if cp.ByRef() != byRef {
+			t.Error("expected ", cp.Var().Name(), " to be captured by reference: ", byRef, " but got: ", cp.ByRef())
+		}
+	}
+}
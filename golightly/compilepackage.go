@@ -2,19 +2,19 @@ package golightly
 
 // type compilePackage is a package which is imported or defined by the source code.
 type compilePackage struct {
-	packageName         string                   // the name of this package.
-	symbols             SymbolTable              // the symbols in this package - only valid once symbol creation is complete for all package files.
-	waitingFileComplete map[string]bool          // the files from this package we're still waiting on.
-	fileComplete        chan completionMessage   // files tell us they're complete with a message on this channel.
-	compileSrc          chan compileSrcMessage   // we can request files to be compiled here.
-	addImport           chan importMessage       // we can request imports here.
-	completeChannel     chan completionMessage   // channel to importPackages() to notify when our symbols are complete.
-	shutdown            chan bool                // closed when the compiler is shutting down.
+	packageName         string                 // the name of this package.
+	waitingFileComplete map[string]bool        // the files from this package we're still waiting on.
+	fileComplete        chan completionMessage // files tell us they're complete with a message on this channel.
+	compileSrc          chan compileSrcMessage // we can request files to be compiled here.
+	addImport           chan importMessage     // we can request imports here.
+	completeChannel     chan completionMessage // channel to importPackages() to notify when our symbols are complete.
+	shutdown            chan bool              // closed when the compiler is shutting down.
 
 	// the following are used by Compiler.importPackages().
-	status				compileStatus            // where we are in the compilation process.
-	clientCompleteChannels    []chan completionMessage // channels back to clients for importPackages() to notify when our symbols are complete.
-	completeMessage     completionMessage        // importPackages() uses this internally.
+	status                 compileStatus            // where we are in the compilation process.
+	clientCompleteChannels []chan completionMessage // channels back to clients for importPackages() to notify when our symbols are complete.
+	completeMessage        completionMessage        // importPackages() uses this internally.
+	resolveErr             error                    // why the package's files couldn't be found.
 }
 
 // NewCompilePackage creates a new compilePackage.
@@ -25,11 +25,34 @@ func NewCompilePackage(packageName string, compileSrc chan compileSrcMessage, ad
 	sp.fileComplete = make(chan completionMessage)
 	sp.compileSrc = compileSrc
 	sp.addImport = addImport
-	sp.completeChannel = make(chan completionMessage, completionChannelDepth)
+	sp.completeChannel = completeChannel
 	sp.shutdown = shutdown
 
-	sp.clientCompleteChannels = make([]chan completionMessage, 1)
-	sp.clientCompleteChannels[0] = completeChannel
-
 	return sp
 }
+
+// waitFiles runs as a goroutine, waiting for all the files in the package
+// to finish. Then it tells importPackages() the package is complete, with
+// the problems any of the files had.
+func (sp *compilePackage) waitFiles(fileNames []string) {
+	waiting := make(map[string]bool)
+	for _, fileName := range fileNames {
+		waiting[fileName] = true
+	}
+
+	var errs ErrorList
+	for len(waiting) > 0 {
+		select {
+		case msg := <-sp.fileComplete:
+			delete(waiting, msg.fileName)
+			errs.Add(msg.err)
+		case <-sp.shutdown:
+			return
+		}
+	}
+
+	select {
+	case sp.completeChannel <- completionMessage{sp.packageName, "", errs.Err()}:
+	case <-sp.shutdown:
+	}
+}
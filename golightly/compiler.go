@@ -2,9 +2,19 @@ package golightly
 
 import (
 	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 )
 
 const (
@@ -13,8 +23,53 @@ const (
 	completionChannelDepth = 4
 )
 
-// type compileStatus
+// errShuttingDown is given by anything which stops part way through
+// because the compiler is shutting down.
+var errShuttingDown = errors.New("the compiler is shutting down")
+
+// type CancelledError is given when a compilation is cancelled or its
+// deadline passes. It has the problems which were found before it stopped.
+type CancelledError struct {
+	Err         error     // why it stopped - context.Canceled or context.DeadlineExceeded.
+	Diagnostics ErrorList // the errors and warnings found before it stopped.
+}
+
+func (e *CancelledError) Error() string {
+	var msg strings.Builder
+	msg.WriteString("the compilation stopped early: ")
+	msg.WriteString(e.Err.Error())
+	for _, err := range e.Diagnostics {
+		msg.WriteString("\n")
+		msg.WriteString(err.Error())
+	}
+
+	return msg.String()
+}
+
+// Unwrap gives the context's error so errors.Is can find it.
+func (e *CancelledError) Unwrap() error {
+	return e.Err
+}
+
+// type shutdownReader reads a source file until the compiler shuts down,
+// so a cancelled compilation doesn't wait for big files to be lexed.
+type shutdownReader struct {
+	r        io.Reader // the source file.
+	shutdown chan bool // closed when the compiler is shutting down.
+}
+
+func (sr shutdownReader) Read(p []byte) (int, error) {
+	select {
+	case <-sr.shutdown:
+		return 0, errShuttingDown
+	default:
+		return sr.r.Read(p)
+	}
+}
+
+// type compileStatus is how far through compilation a file or package is.
 type compileStatus int
+
 const (
 	compileStatusParsing = iota
 	compileStatusSymbolsAvailable
@@ -115,12 +170,30 @@ const (
 // portion is rewritten along with linkages to it.
 //
 type Compiler struct {
-	srcFiles map[string]*sourceFile    // the files we're compiling.
-	packages map[string]*compilePackage // the packages we're importing or defining.
-
-	shutdown chan bool // closed when the compiler is shutting down.
-
-	dataTypeStore *DataTypeStore // keeps a global set of data types known to the compiler.
+	srcFiles      map[string]*sourceFile     // the files we're compiling.
+	srcFilesMutex sync.Mutex                 // protects srcFiles, which parseSrcs() adds to while Compile() runs.
+	packages      map[string]*compilePackage // the packages we're importing or defining.
+	scopes        map[string]*SymbolTable    // the package scopes of the packages which have been checked, by import path.
+
+	shutdown   chan bool      // closed when the compiler is shutting down.
+	closeOnce  sync.Once      // makes sure shutdown is only closed once.
+	goroutines sync.WaitGroup // the goroutines which Close() waits for.
+
+	dataTypeStore *DataTypeStore  // keeps a global set of data types known to the compiler.
+	sources       *OverlayFS      // where source files are read from - the ones added with AddSource over the file system.
+	resolver      *ImportResolver // finds the source files of imported packages.
+	lenient       bool            // true to give warnings for unused names and missing returns instead of errors.
+	reportEscapes bool            // true to print the escape analysis decisions.
+	vet           bool            // true to run the vet checks instead of compiling.
+	printfFuncs   []string        // the functions vet checks as if they were fmt.Printf.
+	maxErrors     int             // how many errors are reported before the rest are left out, or 0 for all of them.
+	output        io.Writer       // where reports such as the escape analysis decisions go.
+	cache         *BuildCache     // what's known about packages from earlier compilations, or nil to check everything.
+	warnings      ErrorList       // the warnings from checking.
+
+	// the following are only used by Compiler.importPackages().
+	imports      *importGraph      // which packages import which.
+	filePackages map[string]string // the import path of the package each imported file is from.
 
 	addImport  chan importMessage     // new packages are queued for import using this stream.
 	compileSrc chan compileSrcMessage // new files are queued for compilation using this stream.
@@ -137,6 +210,7 @@ type importMessage struct {
 // type compileSrcMessage is sent to Compiler.compileSrc to request that a file be compiled.
 type compileSrcMessage struct {
 	fileName        string
+	packagePath     string // the import path of the package the file is part of.
 	completeChannel chan completionMessage
 }
 
@@ -148,35 +222,162 @@ type completionMessage struct {
 	err         error  // error from compilation or nil on success.
 }
 
-// NewCompiler creates a new compiler object.
+// NewCompiler creates a new compiler object. It starts goroutines which
+// run until Close is called.
 func NewCompiler() *Compiler {
 	c := new(Compiler)
-
-	c.srcFiles = make(map[string]*sourceFile)
-	c.packages = make(map[string]*compilePackage)
+	c.reset()
 
 	c.shutdown = make(chan bool)
 
-	c.dataTypeStore = NewDataTypeStore()
+	c.sources = NewOverlayFS(osFS{})
+	c.resolver = NewImportResolver(filepath.SplitList(os.Getenv("GOLIGHTLY_PATH")), runtime.GOOS, runtime.GOARCH)
+	c.resolver.SetFS(c.sources)
+	c.maxErrors = maxErrors
+	c.output = os.Stdout
 	c.addImport = make(chan importMessage, addImportChannelDepth)
 	c.compileSrc = make(chan compileSrcMessage, compileSrcChannelDepth)
 
 	// accept source files for compilation
-	go c.parseSrcs()
+	c.goroutine(c.parseSrcs)
 
 	// accept packages to import
-	go c.importPackages()
+	c.goroutine(c.importPackages)
 
 	return c
 }
 
+// reset forgets the files, packages and types from an earlier compilation
+// so everything is read and checked again - the files may have changed
+// since, eg. with AddSource. The types are in the data type store so it's
+// replaced too.
+func (c *Compiler) reset() {
+	c.srcFilesMutex.Lock()
+	c.srcFiles = make(map[string]*sourceFile)
+	c.srcFilesMutex.Unlock()
+	c.packages = make(map[string]*compilePackage)
+	c.scopes = make(map[string]*SymbolTable)
+	c.dataTypeStore = NewDataTypeStore()
+	c.imports = newImportGraph()
+	c.filePackages = make(map[string]string)
+}
+
+// Close stops all the compiler's goroutines and waits for them to finish.
+// It's safe to call more than once.
 func (c *Compiler) Close() {
+	c.closeOnce.Do(func() { close(c.shutdown) })
+	c.goroutines.Wait()
+}
+
+// goroutine runs a function as a goroutine which Close waits for.
+func (c *Compiler) goroutine(f func()) {
+	c.goroutines.Add(1)
+	go func() {
+		defer c.goroutines.Done()
+		f()
+	}()
+}
+
+// SetFS makes the compiler read source files from fsys instead of the
+// operating system's files. File names given to Compile are then names in
+// fsys, like "main.go" or "lib/lib.go". If fsys is nil there are only the
+// files added with AddSource, which has to be called after SetFS.
+func (c *Compiler) SetFS(fsys fs.FS) {
+	c.sources = NewOverlayFS(fsys)
+	c.resolver.SetFS(c.sources)
+}
+
+// AddSource adds a source file which is held in memory rather than in the
+// file system, like an editor's unsaved buffer or a script from a
+// database. It replaces any file with the same name. It can be compiled
+// and imported just like the other files in its directory.
+func (c *Compiler) AddSource(name string, src []byte) {
+	c.sources.Add(name, src)
+}
+
+// SetLenient makes the checks which gc rejects programs for but which
+// don't change what a program means give warnings instead of errors. See
+// Checker.SetLenient.
+func (c *Compiler) SetLenient(lenient bool) {
+	c.lenient = lenient
+}
+
+// SetReportEscapes makes the compiler print why each local variable and
+// allocation is or isn't moved to the heap. See AnalyseEscapes.
+func (c *Compiler) SetReportEscapes(reportEscapes bool) {
+	c.reportEscapes = reportEscapes
+}
+
+// SetVet makes the compiler look for likely mistakes in the program and
+// report them in place of compiling it. Every package the program is made
+// from is looked at, including the imported ones. See Checker.Vet.
+func (c *Compiler) SetVet(vet bool) {
+	c.vet = vet
+}
+
+// MarkPrintfLike makes vet check calls to a function as if it was
+// fmt.Printf. See Checker.MarkPrintfLike.
+func (c *Compiler) MarkPrintfLike(name string) {
+	c.printfFuncs = append(c.printfFuncs, name)
+}
+
+// SetMaxErrors sets how many errors are reported before the rest are left
+// out. It's 10 by default. 0 reports all of them.
+func (c *Compiler) SetMaxErrors(max int) {
+	c.maxErrors = max
+}
+
+// SetOutput sets where reports such as the escape analysis decisions are
+// written. It's os.Stdout by default.
+func (c *Compiler) SetOutput(output io.Writer) {
+	c.output = output
+}
+
+// SetCache makes the compiler use a build cache. Packages whose files
+// and imported APIs haven't changed since they were last compiled with it
+// aren't checked again unless a package which imports them has to be. The
+// cache isn't closed with the compiler.
+func (c *Compiler) SetCache(cache *BuildCache) {
+	c.cache = cache
+}
+
+// Warnings returns the warnings from the last compilation, sorted by file
+// and position. There are only warnings if the compiler is lenient.
+func (c *Compiler) Warnings() ErrorList {
+	return c.warnings
 }
 
 // Compile is the central point to compile a program from. It takes
 // all the files as arguments and produces a runnable program as
-// output. All passes of the compiler are run.
-func (c *Compiler) Compile(srcFiles []string) error {
+// output. All passes of the compiler are run. Directories can be given
+// instead of files, in which case the package in the directory is
+// compiled. If nothing is given the current directory is used.
+//
+// The error is an ErrorList with every problem found, in order of file
+// and position, unless the files to compile couldn't be found.
+//
+// A compiler can compile more than once, eg. after changing sources with
+// AddSource. Each time every file is read and checked again, apart from
+// the packages the build cache says haven't changed.
+func (c *Compiler) Compile(args []string) error {
+	return c.CompileContext(context.Background(), args)
+}
+
+// CompileContext is like Compile but gives up when ctx is cancelled or
+// its deadline passes. Parsing, importing and checking all stop, the
+// compiler is closed and a *CancelledError is returned with the problems
+// found so far. A compiler which has been stopped can't be used again.
+func (c *Compiler) CompileContext(ctx context.Context, args []string) error {
+	if ctx.Err() != nil {
+		return c.cancelled(ctx, nil)
+	}
+	c.reset()
+
+	srcFiles, err := c.sourceFiles(args)
+	if err != nil {
+		return err
+	}
+
 	// create a channel for source files to notify us when their symbols are ready.
 	completeChannel := make(chan completionMessage, completionChannelDepth)
 
@@ -185,115 +386,357 @@ func (c *Compiler) Compile(srcFiles []string) error {
 	waitingOn := make(map[string]bool)
 	for _, fileName := range srcFiles {
 		// are we already compiling it?
-		_, found := waitingOn[fileName]
-		if !found {
-			// need to compile it.
-			waitingOn[fileName] = true
-			c.compileSrc <- compileSrcMessage{fileName, completeChannel}
+		if waitingOn[fileName] {
+			continue
+		}
+
+		// need to compile it.
+		waitingOn[fileName] = true
+		select {
+		case c.compileSrc <- compileSrcMessage{fileName, mainPackagePath, completeChannel}:
+		case <-ctx.Done():
+			return c.cancelled(ctx, nil)
+		case <-c.shutdown:
+			return errShuttingDown
 		}
 	}
 
-	// wait for symbols ready or error.
-	var err error
-	for {
-		// get a message from a compilation.
-		msg := <-completeChannel
+	// wait until every file has finished parsing. they come back in
+	// whatever order they finish in.
+	var diagnostics ErrorList
+	for len(waitingOn) > 0 {
+		select {
+		case msg := <-completeChannel:
+			delete(waitingOn, msg.fileName)
+			diagnostics.Add(msg.err)
+		case <-ctx.Done():
+			return c.cancelled(ctx, diagnostics)
+		case <-c.shutdown:
+			return errShuttingDown
+		}
+	}
+	if diagnostics.Err() != nil {
+		return c.report(diagnostics)
+	}
+
+	// everything's parsed so the packages can be checked.
+	return c.analyse(ctx)
+}
+
+// report puts the problems found in order and leaves out any after the
+// first few errors. It gives the list as an error if there are any errors
+// in it.
+func (c *Compiler) report(diagnostics ErrorList) error {
+	diagnostics = diagnostics.Sort().Limit(c.maxErrors)
+	return diagnostics.Err()
+}
+
+// cancelled stops a compilation which has been cancelled and gives the
+// error to return for it.
+func (c *Compiler) cancelled(ctx context.Context, diagnostics ErrorList) error {
+	c.Close()
+	return &CancelledError{ctx.Err(), diagnostics.Sort().Limit(c.maxErrors)}
+}
+
+// analyse runs semantic analysis on the parsed packages. Each package is
+// checked after the packages it imports so their exported names are
+// known. ctx is looked at between packages and passes.
+func (c *Compiler) analyse(ctx context.Context) error {
+	// group the files by package.
+	c.srcFilesMutex.Lock()
+	packageFiles := make(map[string][]*sourceFile)
+	for _, sf := range c.srcFiles {
+		packageFiles[sf.packagePath] = append(packageFiles[sf.packagePath], sf)
+	}
+	c.srcFilesMutex.Unlock()
+	for _, files := range packageFiles {
+		sort.Slice(files, func(i, j int) bool { return files[i].fileName < files[j].fileName })
+	}
+
+	// a package which imports one with errors isn't checked, since it'd
+	// mostly give more errors about the same problems. a package whose
+	// summary in the cache can still be used isn't checked unless a
+	// package which imports it has to be.
+	var diagnostics ErrorList
+	failed := make(map[string]bool)
+	apis := make(map[string]string)
+	fromCache := make(map[string]bool)
+	for _, path := range packageOrder(packageFiles) {
+		if ctx.Err() != nil {
+			return c.cancelled(ctx, diagnostics)
+		}
+
+		files := packageFiles[path]
+		for _, importPath := range packageImports(files) {
+			if failed[importPath] {
+				failed[path] = true
+			}
+		}
+		if failed[path] {
+			continue
+		}
+
+		if c.cache != nil {
+			summary := c.cache.summary(path)
+			hit := summary != nil && summary.usable(c.cacheOptions(), files, apis)
+			c.cache.countLookup(hit)
+			if hit {
+				diagnostics.Add(summary.diagnostics())
+				fmt.Fprint(c.output, summary.Escapes)
+				apis[path] = summary.API
+				fromCache[path] = true
+				continue
+			}
+		}
+
+		// the packages it imports have to be checked for their scopes,
+		// even if they're in the cache.
+		for _, importPath := range packageImports(files) {
+			diagnostics.Add(c.checkFromCache(ctx, importPath, packageFiles, fromCache))
+		}
+
+		errs, escapes, warnings := c.checkPackage(ctx, path, files)
+		diagnostics.Add(warnings)
+		if ctx.Err() != nil {
+			return c.cancelled(ctx, diagnostics)
+		}
+		if len(errs) > 0 {
+			// the packages which import one with only vet problems can
+			// still be checked.
+			diagnostics.Add(errs)
+			failed[path] = c.scopes[path] == nil
+			continue
+		}
+		fmt.Fprint(c.output, escapes)
+
+		if c.cache != nil {
+			summary := newPackageSummary(c.cacheOptions(), files, c.scopes[path], path, apis, warnings)
+			summary.Escapes = escapes
+			apis[path] = summary.API
 
-		// either got "symbols ready" from a file or an error.
-		if msg.err != nil {
-			err = msg.err
-			close(c.shutdown) // tell it to shutdown.
+			// a cache which can't be written to only makes the next
+			// compilation slower.
+			c.cache.putSummary(path, summary)
 		}
+	}
+
+	c.warnings = nil
+	for _, diagnostic := range diagnostics.Sort() {
+		if diagnostic.severity == SeverityWarning {
+			c.warnings = append(c.warnings, diagnostic)
+		}
+	}
+
+	return c.report(diagnostics)
+}
+
+// checkPackage checks a package which has had its imports checked. It
+// gives the errors, the escape analysis decisions if they were asked for,
+// and the warnings. If there are no type errors the package's scope is
+// kept for the packages which import it, even if vet finds problems.
+func (c *Compiler) checkPackage(ctx context.Context, path string, files []*sourceFile) (ErrorList, string, ErrorList) {
+	checker := NewChecker(c.dataTypeStore, path)
+	checker.SetLenient(c.lenient)
+	for _, name := range c.printfFuncs {
+		checker.MarkPrintfLike(name)
+	}
+	for importPath, scope := range c.scopes {
+		checker.AddImport(importPath, scope)
+	}
+
+	errs := ErrorList(checker.CheckFiles(files))
+	warnings := ErrorList(checker.Warnings())
+	if len(errs) > 0 {
+		return errs, "", warnings
+	}
+
+	c.scopes[path] = checker.Scope()
+	if c.vet {
+		if ctx.Err() != nil {
+			return nil, "", warnings
+		}
+		if errs := checker.Vet(files); len(errs) > 0 {
+			return errs, "", warnings
+		}
+	}
+
+	var escapes string
+	if c.reportEscapes {
+		if ctx.Err() != nil {
+			return nil, "", warnings
+		}
+		escapes = AnalyseEscapes(c.dataTypeStore, checker.Info(), checker.packagePath, files).Report()
+	}
+
+	return nil, escapes, warnings
+}
+
+// checkFromCache checks a package which wasn't checked because its
+// summary in the cache could be used, along with any of its imports
+// which weren't checked either. Its warnings and escape analysis
+// decisions have already been reported from the summary so only errors
+// are given, though there shouldn't be any.
+func (c *Compiler) checkFromCache(ctx context.Context, path string, packageFiles map[string][]*sourceFile, fromCache map[string]bool) ErrorList {
+	if !fromCache[path] || c.scopes[path] != nil {
+		return nil
+	}
+
+	var errs ErrorList
+	for _, importPath := range packageImports(packageFiles[path]) {
+		errs.Add(c.checkFromCache(ctx, importPath, packageFiles, fromCache))
+	}
+
+	packageErrs, _, _ := c.checkPackage(ctx, path, packageFiles[path])
+	errs.Add(packageErrs)
+	return errs
+}
+
+// cacheOptions describes the options which change what checking a package
+// finds, so summaries from a compilation with different options aren't
+// used.
+func (c *Compiler) cacheOptions() string {
+	return fmt.Sprintf("lenient=%v vet=%v escapes=%v printf=%s", c.lenient, c.vet, c.reportEscapes, strings.Join(c.printfFuncs, ","))
+}
+
+// packageOrder gives the import paths of the packages so that each comes
+// after the packages it imports, starting from the imports of package
+// main. Import cycles have already been reported so they aren't looked
+// for here.
+func packageOrder(packageFiles map[string][]*sourceFile) []string {
+	var order []string
+	visited := make(map[string]bool)
+
+	var visit func(path string)
+	visit = func(path string) {
+		if visited[path] {
+			return
+		}
+		visited[path] = true
 
-		delete(waitingOn, msg.fileName)
-		if len(waitingOn) == 0 {
-			// we've finished all of them.
-			break
+		for _, importPath := range packageImports(packageFiles[path]) {
+			if _, ok := packageFiles[importPath]; ok {
+				visit(importPath)
+			}
 		}
+		order = append(order, path)
 	}
 
-	return err
+	visit(mainPackagePath)
+	return order
 }
 
-// parseFileAndComplete parses a single file, called from schedulePass. To compile a file
-// you should send it to the Compiler.compileSrc channel for parseSrcs() to
-// compile. After the file is parsed a completion message is sent to the client.
+// packageImports gives the import paths of the packages imported by a
+// package's files.
+func packageImports(files []*sourceFile) []string {
+	var paths []string
+	for _, sf := range files {
+		for _, imp := range sf.ast.(ASTTopLevel).imports {
+			paths = append(paths, imp.(ASTImport).importPath.(ASTValue).val.(ValueString).val)
+		}
+	}
+
+	return paths
+}
+
+// sourceFiles gives the source files to compile from the command line
+// arguments, replacing directories with the files of the package in them.
+func (c *Compiler) sourceFiles(args []string) ([]string, error) {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+
+	var srcFiles []string
+	for _, arg := range args {
+		if !isDir(c.sources, arg) {
+			srcFiles = append(srcFiles, arg)
+			continue
+		}
+
+		files, err := c.resolver.PackageFiles(arg)
+		if err != nil {
+			return nil, err
+		}
+		srcFiles = append(srcFiles, files...)
+	}
+
+	return srcFiles, nil
+}
+
+// parseFileAndComplete parses a single file, called from parseSrcs(). To
+// compile a file you should send it to the Compiler.compileSrc channel for
+// parseSrcs() to compile. After the file is parsed a completion message
+// is sent to the client.
 func (c *Compiler) parseFileAndComplete(sf *sourceFile) {
 	err := c.parseFile(sf)
-	if err != nil {
-		sf.completeChannel <- completionMessage{sf.packageName, sf.fileName, )}
+	if err == errShuttingDown {
 		return
 	}
-}
 
+	select {
+	case sf.completeChannel <- completionMessage{sf.packagePath, sf.fileName, err}:
+	case <-c.shutdown:
+	}
+}
 
-// compileFile parses a single file, called from schedulePass. To compile a file
-// you should send it to the Compiler.compileSrc channel for parseSrcs() to
-// compile.
-func (c *Compiler) compileFile(sf *sourceFile) error {
+// parseFile parses a single file and waits for the packages it imports
+// to be ready.
+func (c *Compiler) parseFile(sf *sourceFile) error {
 	// open the source file
-	srcFile, err := os.Open(sf.fileName)
+	srcFile, err := c.sources.Open(sf.fileName)
 	if err != nil {
-		return errors.New(fmt.Sprintf("I can't find ", sf.fileName, ": ", err)
+		return fmt.Errorf("I can't find %s: %v", sf.fileName, err)
 	}
 
 	defer srcFile.Close()
-	srcReader := bufio.NewReader(srcFile)
+	hash := sha256.New()
+	srcReader := bufio.NewReader(io.TeeReader(shutdownReader{srcFile, c.shutdown}, hash))
 
 	// lex and parse it.
 	lex := NewLexer()
 	lex.LexReader(srcReader, sf.fileName)
 	parser := NewParser(lex, c.dataTypeStore, sf)
 	err = parser.Parse()
-	if err != nil {
+	if err == errShuttingDown {
 		return err
 	}
 
-	// create symbols.
-	err = c.createSymbols(sf)
-	if err != nil {
-		return err
+	// the build cache knows a file hasn't changed by its hash. anything
+	// after the end of the file is part of it too.
+	if err == nil {
+		_, err = io.Copy(io.Discard, srcReader)
+		sf.contentHash = hex.EncodeToString(hash.Sum(nil))
 	}
 
-	// wait for imports to complete.
-	err = c.waitImports(sf)
-	if err != nil {
-		return err
+	// wait for imports to complete. even if this file has errors the
+	// packages it imports were asked for so their problems are reported too.
+	importErr := sf.waitImports()
+	if importErr == errShuttingDown {
+		return importErr
 	}
 
-	// say we're done.
-	return nil
-}
-
-// createSymbols creates a set of symbols from an already parsed source file.
-// when we're finished we tell our parent package that we're done.
-func (c *Compiler) createSymbols(sf *sourceFile) error {
-	return nil
+	var errs ErrorList
+	errs.Add(err)
+	errs.Add(importErr)
+	return errs.Err()
 }
 
-// compileSrcs runs as a goroutine, accepting files to parse and
-// parsing them.
-func (c *Compiler) compileSrcs() {
+// parseSrcs runs as a goroutine, accepting files to parse and starting a
+// goroutine to parse each of them.
+func (c *Compiler) parseSrcs() {
 	for {
-		// wait for something to happen.
-		var running bool
-
 		select {
 		case csm := <-c.compileSrc:
 			// add to srcFiles.
-			sf := NewSourceFile(csm.fileName, c.compileSrc, c.addImport, csm.completeChannel, c.shutdown)
+			sf := NewSourceFile(csm.fileName, csm.packagePath, c.compileSrc, c.addImport, csm.completeChannel, c.shutdown)
+			c.srcFilesMutex.Lock()
 			c.srcFiles[csm.fileName] = sf
+			c.srcFilesMutex.Unlock()
 
 			// start parsing the file
-			go c.compileFileAndComplete(sf)
+			c.goroutine(func() { c.parseFileAndComplete(sf) })
 
-		case _, running = <-c.shutdown:
-			// running is false if we're shutting down.
-		}
-
-		// are we shutting down?
-		if !running {
-			break
+		case <-c.shutdown:
+			return
 		}
 	}
 }
@@ -304,11 +747,19 @@ func (c *Compiler) importPackages() {
 	importComplete := make(chan completionMessage, completionChannelDepth)
 
 	for {
-		// wait for something to happen.
-		var running bool
-
 		select {
 		case im := <-c.addImport:
+			// waiting for a package which is waiting for us would never
+			// finish.
+			from, ok := c.filePackages[im.fromFileName]
+			if !ok {
+				from = mainPackagePath
+			}
+			if cycle := c.imports.add(importEdge{from, im.packageName, im.fromFileName, im.pos}); cycle != nil {
+				c.failImportCycle(cycle, im)
+				continue
+			}
+
 			// a new package to import. do we already know about it?
 			cp, ok := c.packages[im.packageName]
 			if ok {
@@ -316,38 +767,125 @@ func (c *Compiler) importPackages() {
 				if cp.status == compileStatusParsing {
 					// add to the list of clients to be informed when it's done.
 					cp.clientCompleteChannels = append(cp.clientCompleteChannels, im.completeChannel)
+				} else if cp.resolveErr != nil {
+					// it can't be found from this import either.
+					c.notify(im.completeChannel, c.importFailed(cp, im))
 				} else {
 					// let the client know immediate that we're done.
-					im.completeChannel <- cp.completeMessage
+					c.notify(im.completeChannel, cp.completeMessage)
 				}
 			} else {
-				// add to packages.
+				// add to packages and find its files.
 				cp = NewCompilePackage(im.packageName, c.compileSrc, c.addImport, importComplete, c.shutdown)
+				cp.clientCompleteChannels = append(cp.clientCompleteChannels, im.completeChannel)
 				c.packages[im.packageName] = cp
+				c.queuePackageFiles(cp, im)
 			}
 
 		case cm := <-importComplete:
-			// we got a completion message from a package.
+			// we got a completion message from a package. it may have
+			// already failed as part of an import cycle.
 			cp, ok := c.packages[cm.packageName]
-			if ok {
-				// keep the completion message in case we need it for a later import.
-				cp.completeMessage = cm
-
-				// tell everyone who wants to know.
-				for _, client := range cp.clientCompleteChannels {
-					client <- cm
-				}
-				cp.clientCompleteChannels = nil
-				cp.status = compileStatusSymbolsAvailable
+			if ok && cp.status == compileStatusParsing {
+				// the completion message is kept in case we need it for a
+				// later import.
+				c.completePackage(cp, cm)
 			}
 
-		case _, running = <-c.shutdown:
-			// running is false if we're shutting down.
+		case <-c.shutdown:
+			return
+		}
+	}
+}
+
+// queuePackageFiles finds the source files of a newly imported package and
+// queues them for compilation. If the package can't be found the client
+// which imported it is told straight away. Package unsafe is built in so
+// it doesn't have any files.
+func (c *Compiler) queuePackageFiles(cp *compilePackage, im importMessage) {
+	if im.packageName == "unsafe" {
+		c.completePackage(cp, completionMessage{packageName: im.packageName})
+		return
+	}
+
+	dir, err := c.resolver.Resolve(im.packageName, filepath.Dir(im.fromFileName))
+	var files []string
+	if err == nil {
+		files, err = c.resolver.PackageFiles(dir)
+	}
+	if err != nil {
+		cp.resolveErr = err
+		c.completePackage(cp, c.importFailed(cp, im))
+		cp.status = compileStatusComplete
+		return
+	}
+
+	for _, fileName := range files {
+		cp.waitingFileComplete[fileName] = true
+		c.filePackages[fileName] = im.packageName
+	}
+
+	// the package is complete when all its files are.
+	c.goroutine(func() { cp.waitFiles(files) })
+
+	// queue them from another goroutine since parseSrcs() may be waiting
+	// to send us more imports.
+	c.goroutine(func() {
+		for _, fileName := range files {
+			select {
+			case c.compileSrc <- compileSrcMessage{fileName, im.packageName, cp.fileComplete}:
+			case <-c.shutdown:
+				return
+			}
 		}
+	})
+}
+
+// importFailed gives the completion message for an import of a package
+// which couldn't be found. The error is at the import statement so each
+// file which imports the package gets its own.
+func (c *Compiler) importFailed(cp *compilePackage, im importMessage) completionMessage {
+	return completionMessage{im.packageName, im.fromFileName, NewError(im.fromFileName, im.pos, cp.resolveErr.Error())}
+}
+
+// completePackage tells everyone waiting for a package that it's done.
+func (c *Compiler) completePackage(cp *compilePackage, msg completionMessage) {
+	cp.status = compileStatusSymbolsAvailable
+	cp.completeMessage = msg
+	for _, client := range cp.clientCompleteChannels {
+		c.notify(client, msg)
+	}
+	cp.clientCompleteChannels = nil
+}
 
-		// are we shutting down?
-		if !running {
-			break
+// failImportCycle reports an import cycle to the file whose import
+// completed it. The packages in the cycle are marked as failed and anyone
+// waiting for them is told, so nothing waits forever for a package which
+// can never finish.
+func (c *Compiler) failImportCycle(cycle []importEdge, im importMessage) {
+	msg := completionMessage{im.packageName, im.fromFileName, &ImportCycleError{cycle}}
+	c.notify(im.completeChannel, msg)
+
+	for _, edge := range cycle {
+		cp, ok := c.packages[edge.to]
+		if !ok || cp.status != compileStatusParsing {
+			continue
 		}
+
+		c.completePackage(cp, completionMessage{edge.to, msg.fileName, msg.err})
+		cp.status = compileStatusComplete
 	}
 }
+
+// notify sends a completion message to a client. It's sent from another
+// goroutine so importPackages() never waits for a file which is itself
+// waiting to send us an import. It's dropped if the compiler is shutting
+// down.
+func (c *Compiler) notify(client chan completionMessage, msg completionMessage) {
+	c.goroutine(func() {
+		select {
+		case client <- msg:
+		case <-c.shutdown:
+		}
+	})
+}
@@ -0,0 +1,397 @@
+package golightly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// compileTestTree writes a module to a temporary directory and compiles
+// its main package. It checks that closing the compiler stops all of its
+// goroutines.
+func compileTestTree(t *testing.T, files map[string]string) (*Compiler, error) {
+	return compileTestTreeContext(t, context.Background(), files)
+}
+
+// compileTestTreeContext is like compileTestTree but compiles with a context.
+func compileTestTreeContext(t *testing.T, ctx context.Context, files map[string]string) (*Compiler, error) {
+	root := t.TempDir()
+	files["go.mod"] = "module example.com/app\n\ngo 1.21\n"
+	writeTestFiles(t, root, files)
+
+	before := runtime.NumGoroutine()
+	c := NewCompiler()
+	err := c.CompileContext(ctx, []string{root})
+	c.Close()
+	c.Close()
+
+	// goroutines which have finished may take a moment to go away.
+	for i := 0; runtime.NumGoroutine() > before; i++ {
+		if i == 100 {
+			t.Error("the compiler leaked ", runtime.NumGoroutine()-before, " goroutines")
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return c, err
+}
+
+func TestCompilerCompile(t *testing.T) {
+	c, err := compileTestTree(t, map[string]string{
+		"main.go": `package main;
+import (
+	"example.com/app/shapes";
+	"example.com/app/units";
+);
+var origin shapes.Point;
+const scale = units.Scale;
+const sides = shapes.Sides;
+func area(w, h units.Metres) units.Metres;
+`,
+		"shapes/point.go": `package shapes;
+import "example.com/app/units";
+type Point struct { X, Y units.Metres; };
+`,
+		"shapes/sides.go": `package shapes;
+import "example.com/app/units";
+const Sides = 4;
+func Perimeter(side units.Metres) units.Metres;
+`,
+		"units/units.go": `package units;
+type Metres float64;
+const Scale = 100;
+`,
+	})
+	if err != nil {
+		t.Fatal("error compiling: ", err)
+	}
+
+	for _, path := range []string{mainPackagePath, "example.com/app/shapes", "example.com/app/units"} {
+		if c.scopes[path] == nil {
+			t.Error("package ", path, " wasn't checked")
+		}
+	}
+	if len(c.srcFiles) != 4 {
+		t.Error("expected 4 source files but got ", len(c.srcFiles))
+	}
+
+	main := c.scopes[mainPackagePath]
+	if origin := main.LookupLocal("origin"); origin == nil || TypeString(origin.typ, "main") != "shapes.Point" {
+		t.Error("origin should be a shapes.Point: ", origin)
+	}
+	if scale := main.LookupLocal("scale"); scale == nil || scale.val.String() != "100" {
+		t.Error("scale should be 100: ", scale)
+	}
+}
+
+func TestCompilerSameNamedPackages(t *testing.T) {
+	c, err := compileTestTree(t, map[string]string{
+		"main.go": `package main;
+import (
+	"example.com/app/x/util";
+	yutil "example.com/app/y/util";
+);
+var a util.T;
+var b yutil.T;
+`,
+		"x/util/util.go": "package util;\ntype T int;\n",
+		"y/util/util.go": "package util;\ntype T string;\n",
+	})
+	if err != nil {
+		t.Fatal("error compiling: ", err)
+	}
+
+	main := c.scopes[mainPackagePath]
+	a, b := main.LookupLocal("a"), main.LookupLocal("b")
+	if a == nil || b == nil || a.typ == b.typ {
+		t.Fatal("a and b should have different types: ", a, b)
+	}
+	if TypeString(a.typ, mainPackagePath) != "util.T" || TypeString(b.typ, mainPackagePath) != "util.T" {
+		t.Error("types should be printed with their package's name, but got ", TypeString(a.typ, mainPackagePath), " and ", TypeString(b.typ, mainPackagePath))
+	}
+	if a.typ.(*DataTypeNamed).Package() != "example.com/app/x/util" || b.typ.(*DataTypeNamed).Package() != "example.com/app/y/util" {
+		t.Error("types should know the import path of their package: ", a.typ.(*DataTypeNamed).Package(), " and ", b.typ.(*DataTypeNamed).Package())
+	}
+}
+
+func TestCompilerRecompile(t *testing.T) {
+	c := NewCompiler()
+	defer c.Close()
+	c.SetFS(nil)
+	c.AddSource("go.mod", []byte("module example.com/app\n"))
+	c.AddSource("main.go", []byte("package main;\nimport \"example.com/app/lib\";\ntype B int;\nvar x lib.T;\n"))
+	c.AddSource("lib/lib.go", []byte("package lib;\ntype T int;\n"))
+	if err := c.Compile([]string{"main.go"}); err != nil {
+		t.Fatal("error compiling: ", err)
+	}
+
+	// the types are declared again, and the edits are seen.
+	c.AddSource("lib/lib.go", []byte("package lib;\ntype T string;\n"))
+	if err := c.Compile([]string{"main.go"}); err != nil {
+		t.Fatal("error compiling again: ", err)
+	}
+	if x := c.scopes[mainPackagePath].LookupLocal("x"); x == nil || TypeString(Underlying(x.typ), "") != "string" {
+		t.Error("x should be a string after lib was changed: ", x)
+	}
+}
+
+func TestCompilerCompileErrors(t *testing.T) {
+	cases := []struct {
+		files map[string]string
+		err   string
+	}{
+		{map[string]string{
+			"main.go": "package main;\nimport \"example.com/app/missing\";\n",
+		}, "I can't find package"},
+		{map[string]string{
+			"main.go":    "package main;\nimport \"example.com/app/lib\";\nconst x = lib.X;\n",
+			"lib/lib.go": "package lib;\nconst X = ;\n",
+		}, filepath.Join("lib", "lib.go") + ":2: bad expression. bad."},
+		{map[string]string{
+			"main.go":    "package main;\nimport \"example.com/app/lib\";\nconst x = lib.hidden;\n",
+			"lib/lib.go": "package lib;\nconst hidden = 1;\n",
+		}, "package lib doesn't export anything called 'hidden'"},
+		{map[string]string{
+			"main.go": "package main;\nimport \"example.com/app/a\";\nconst x = a.A;\n",
+			"a/a.go":  "package a;\nimport \"example.com/app/b\";\nconst A = b.B;\n",
+			"b/b.go":  "package b;\nimport \"example.com/app/a\";\nconst B = a.A;\n",
+		}, "import cycle"},
+	}
+
+	for _, tc := range cases {
+		_, err := compileTestTree(t, tc.files)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Error("expected an error containing \"", tc.err, "\" but got: ", err)
+		}
+	}
+}
+
+func TestCompilerVet(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{
+		"go.mod":               "module example.com/app\n",
+		"main.go":              "package main;\nimport \"example.com/app/lib\";\nconst answer = lib.Answer;\n",
+		"lib/lib.go":           "package lib;\nimport \"sync\";\nvar mu sync.Mutex;\nvar Copied = mu;\nconst Answer = 42;\n",
+		"vendor/sync/mutex.go": "package sync;\ntype Mutex struct { state int; };\n",
+	})
+
+	c := NewCompiler()
+	c.SetVet(true)
+	err := c.Compile([]string{root})
+	c.Close()
+
+	// lib's problem doesn't stop main from being checked.
+	if err == nil || !strings.Contains(err.Error(), "the declaration of 'Copied' copies a lock value: sync.Mutex") {
+		t.Error("expected lib's lock to be found but got: ", err)
+	}
+	if c.scopes[mainPackagePath] == nil {
+		t.Error("main should have been checked")
+	}
+}
+
+// type cancellingWriter cancels a context when it's written to.
+type cancellingWriter struct {
+	cancel context.CancelFunc
+}
+
+func (w cancellingWriter) Write(p []byte) (int, error) {
+	w.cancel()
+	return len(p), nil
+}
+
+func TestCompilerCompileContext(t *testing.T) {
+	files := func() map[string]string {
+		return map[string]string{"main.go": "package main;\nconst x = 1;\n"}
+	}
+
+	// a context which is already cancelled stops it before it starts.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c, err := compileTestTreeContext(t, ctx, files())
+	var cancelled *CancelledError
+	if !errors.Is(err, context.Canceled) || !errors.As(err, &cancelled) {
+		t.Error("expected the compilation to be cancelled but got: ", err)
+	}
+	if err := c.Compile([]string{"main.go"}); err == nil {
+		t.Error("a cancelled compiler shouldn't compile anything else")
+	}
+
+	// so does a deadline which has passed.
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = compileTestTreeContext(t, ctx, files())
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.As(err, &cancelled) {
+		t.Error("expected the deadline to stop the compilation but got: ", err)
+	}
+
+	// cancelling part way through keeps the problems found before then.
+	// sync is vetted first, then lib is checked and cancels it when its
+	// escape report is written, so main isn't vetted.
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{
+		"go.mod":               "module example.com/app\n",
+		"main.go":              "package main;\nimport \"example.com/app/lib\";\nvar l lib.Locked;\nvar copied = l;\n",
+		"lib/lib.go":           "package lib;\nimport \"sync\";\ntype Locked struct { mu sync.Mutex; };\n",
+		"vendor/sync/mutex.go": "package sync;\ntype Mutex struct { state int; };\nvar mu Mutex;\nvar copied = mu;\n",
+	})
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	c = NewCompiler()
+	c.SetVet(true)
+	c.SetOutput(cancellingWriter{cancel})
+	err = c.CompileContext(ctx, []string{root})
+	c.Close()
+	if !errors.Is(err, context.Canceled) || !errors.As(err, &cancelled) {
+		t.Fatal("expected the compilation to be cancelled but got: ", err)
+	}
+	if len(cancelled.Diagnostics) != 1 || !strings.Contains(cancelled.Diagnostics[0].Error(), "mutex.go:4: ") {
+		t.Error("expected only the problem in mutex.go but got: ", cancelled.Diagnostics)
+	}
+}
+
+func TestCompilerDiagnostics(t *testing.T) {
+	// every problem is reported once, in the same order every time.
+	var first string
+	for i := 0; i < 10; i++ {
+		root := t.TempDir()
+		files := map[string]string{
+			"go.mod":   "module example.com/app\n",
+			"a.go":     "package main;\nimport \"example.com/app/missing\";\nimport \"example.com/app/lib\";\nimport \"example.com/app/c1\";\n",
+			"b.go":     "package main;\nimport \"example.com/app/lib\";\nimport \"example.com/app/missing\";\nconst = 1;\n",
+			"lib/x.go": "package lib;\nconst X = ;\n",
+			"lib/y.go": "package lib;\nvar Y int = ;\n",
+			"c1/c1.go": "package c1;\nimport \"example.com/app/c2\";\n",
+			"c2/c2.go": "package c2;\nimport \"example.com/app/c1\";\n",
+		}
+		writeTestFiles(t, root, files)
+
+		c := NewCompiler()
+		err := c.Compile([]string{root})
+		c.Close()
+		if _, ok := err.(ErrorList); !ok {
+			t.Fatal("expected an ErrorList but got: ", err)
+		}
+		msg := strings.ReplaceAll(err.Error(), root+string(filepath.Separator), "")
+		if i == 0 {
+			first = msg
+		} else if msg != first {
+			t.Fatal("the errors changed from:\n", first, "\nto:\n", msg)
+		}
+	}
+
+	expected := []string{
+		"a.go:2: I can't find package 'example.com/app/missing'",
+		"b.go:3: I can't find package 'example.com/app/missing'",
+		"b.go:4: this should have been a name for a constant, but it's not",
+		filepath.FromSlash("c1/c1.go") + ":2: import cycle not allowed: example.com/app/c1 imports example.com/app/c2 imports example.com/app/c1",
+		filepath.FromSlash("c1/c1.go") + ":2: note: package example.com/app/c1 imports example.com/app/c2",
+		filepath.FromSlash("c2/c2.go") + ":2: note: package example.com/app/c2 imports example.com/app/c1",
+		filepath.FromSlash("lib/x.go") + ":2: bad expression. bad.",
+		filepath.FromSlash("lib/y.go") + ":2: bad expression. bad.",
+	}
+	lines := strings.Split(first, "\n")
+	if len(lines) != len(expected) {
+		t.Fatal("expected ", len(expected), " problems but got:\n", first)
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, expected[i]) {
+			t.Error("expected \"", expected[i], "\" but got \"", line, "\"")
+		}
+	}
+}
+
+func TestCompilerTooManyErrors(t *testing.T) {
+	var src strings.Builder
+	src.WriteString("package main;\nimport \"example.com/app/lib\";\n")
+	for i := 0; i < 12; i++ {
+		fmt.Fprintf(&src, "const c%d = x%d;\n", i, i)
+	}
+
+	for _, max := range []int{10, 0} {
+		root := t.TempDir()
+		writeTestFiles(t, root, map[string]string{
+			"go.mod":     "module example.com/app\n",
+			"main.go":    src.String(),
+			"lib/lib.go": "package lib;\nconst X = 1;\n",
+		})
+
+		c := NewCompiler()
+		c.SetLenient(true)
+		c.SetMaxErrors(max)
+		err := c.Compile([]string{root})
+		c.Close()
+
+		errs, ok := err.(ErrorList)
+		if !ok {
+			t.Fatal("expected an ErrorList but got: ", err)
+		}
+		if errs[0].Severity() != SeverityWarning || !strings.HasSuffix(errs[0].Error(), "main.go:2: warning: \"example.com/app/lib\" is imported but not used") {
+			t.Error("expected a warning about the unused import first but got: ", errs[0])
+		}
+
+		last := errs[len(errs)-1].Error()
+		if max == 10 && (len(errs) != 12 || !strings.HasSuffix(last, "main.go:13: too many errors")) {
+			t.Error("expected a warning, 10 errors and too many errors but got:\n", errs)
+		}
+		if max == 0 && (len(errs) != 13 || !strings.HasSuffix(last, "main.go:14: I don't know of anything called 'x11'")) {
+			t.Error("expected a warning and 12 errors but got:\n", errs)
+		}
+	}
+}
+
+func TestCompilerSources(t *testing.T) {
+	lib := "package lib;\nconst Answer = 42;\n"
+	main := "package main;\nimport \"example.com/app/lib\";\nconst answer = lib.Answer;\n"
+
+	// from a file system which isn't the operating system's.
+	c := NewCompiler()
+	c.SetFS(fstest.MapFS{
+		"go.mod":     {Data: []byte("module example.com/app\n")},
+		"main.go":    {Data: []byte(main)},
+		"lib/lib.go": {Data: []byte(lib)},
+	})
+	err := c.Compile(nil)
+	c.Close()
+	if err != nil {
+		t.Error("error compiling from an fs.FS: ", err)
+	} else if answer := c.scopes[mainPackagePath].LookupLocal("answer"); answer == nil || answer.val.String() != "42" {
+		t.Error("answer should be 42: ", answer)
+	}
+
+	// only from memory.
+	c = NewCompiler()
+	c.SetFS(nil)
+	c.AddSource("go.mod", []byte("module example.com/app\n"))
+	c.AddSource("main.go", []byte(main))
+	c.AddSource("lib/lib.go", []byte(lib))
+	err = c.Compile([]string{"main.go"})
+	c.Close()
+	if err != nil {
+		t.Error("error compiling from memory: ", err)
+	}
+
+	// an unsaved buffer replaces the file on disk.
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{
+		"go.mod":     "module example.com/app\n",
+		"main.go":    main,
+		"lib/lib.go": "package lib;\nconst Answer = ;\n",
+	})
+	c = NewCompiler()
+	c.AddSource(filepath.Join(root, "lib", "lib.go"), []byte(lib))
+	c.AddSource(filepath.Join(root, "lib", "more.go"), []byte("package lib;\nconst More = Answer;\n"))
+	err = c.Compile([]string{root})
+	c.Close()
+	if err != nil {
+		t.Error("error compiling with unsaved buffers: ", err)
+	} else if len(c.srcFiles) != 3 {
+		t.Error("expected the file in memory to be compiled as part of lib but there were ", len(c.srcFiles), " files")
+	}
+}
@@ -0,0 +1,637 @@
+package golightly
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// constPrecision is the number of bits of mantissa used for floating point
+// constants. The spec requires at least 256.
+const constPrecision = 512
+
+// maxConstBits is the largest integer constant allowed, in bits. The spec
+// requires at least 256.
+const maxConstBits = 512
+
+// type ConstantKind indicates which kind of value a Constant holds.
+type ConstantKind int
+
+const (
+	ConstantUnknown ConstantKind = iota
+	ConstantBool
+	ConstantString
+	ConstantInt
+	ConstantFloat
+	ConstantComplex
+)
+
+// type Constant is the exact value of a constant expression. Numbers are
+// held with arbitrary precision so that untyped constants can be folded
+// without losing accuracy. Constants are immutable - the big numbers they
+// hold are never changed once the Constant has been made.
+type Constant struct {
+	kind    ConstantKind // what kind of value it holds
+	boolVal bool         // the value of a ConstantBool
+	strVal  string       // the value of a ConstantString
+	intVal  *big.Int     // the value of a ConstantInt
+	re      *big.Float   // the value of a ConstantFloat, or the real part of a ConstantComplex
+	im      *big.Float   // the imaginary part of a ConstantComplex
+}
+
+// newFloat creates a big.Float with constant precision.
+func newFloat() *big.Float {
+	return new(big.Float).SetPrec(constPrecision)
+}
+
+// MakeBoolConstant creates a boolean constant.
+func MakeBoolConstant(b bool) Constant {
+	return Constant{kind: ConstantBool, boolVal: b}
+}
+
+// MakeStringConstant creates a string constant.
+func MakeStringConstant(s string) Constant {
+	return Constant{kind: ConstantString, strVal: s}
+}
+
+// MakeIntConstant creates an integer constant.
+func MakeIntConstant(i *big.Int) Constant {
+	return Constant{kind: ConstantInt, intVal: i}
+}
+
+// MakeInt64Constant creates an integer constant from an int64.
+func MakeInt64Constant(i int64) Constant {
+	return MakeIntConstant(big.NewInt(i))
+}
+
+// MakeUint64Constant creates an integer constant from a uint64.
+func MakeUint64Constant(i uint64) Constant {
+	return MakeIntConstant(new(big.Int).SetUint64(i))
+}
+
+// MakeFloatConstant creates a floating point constant.
+func MakeFloatConstant(f *big.Float) Constant {
+	return Constant{kind: ConstantFloat, re: f}
+}
+
+// MakeFloat64Constant creates a floating point constant from a float64.
+func MakeFloat64Constant(f float64) Constant {
+	return MakeFloatConstant(newFloat().SetFloat64(f))
+}
+
+// MakeComplexConstant creates a complex constant.
+func MakeComplexConstant(re *big.Float, im *big.Float) Constant {
+	return Constant{kind: ConstantComplex, re: re, im: im}
+}
+
+// MakeConstantFromLiteral creates a constant from the source text of a
+// numeric literal. It accepts all of Go's literal forms - decimal, hex,
+// octal and binary integers, decimal and hex floats, digits separated by
+// underscores and imaginary literals.
+func MakeConstantFromLiteral(text string, kind TokenKind) (Constant, bool) {
+	switch kind {
+	case TokenKindLiteralInt:
+		if strings.HasSuffix(text, "i") {
+			return makeImaginaryConstant(text)
+		}
+		// a leading 0 means octal in Go, which SetString doesn't know.
+		if len(text) > 1 && text[0] == '0' && strings.IndexAny(text[1:2], "xXbBoO") < 0 {
+			text = "0o" + text[1:]
+		}
+		i, ok := new(big.Int).SetString(text, 0)
+		if !ok {
+			return Constant{}, false
+		}
+		return MakeIntConstant(i), true
+
+	case TokenKindLiteralFloat:
+		if strings.HasSuffix(text, "i") {
+			return makeImaginaryConstant(text)
+		}
+		f, _, err := big.ParseFloat(text, 0, constPrecision, big.ToNearestEven)
+		if err != nil {
+			return Constant{}, false
+		}
+		return MakeFloatConstant(f), true
+	}
+
+	return Constant{}, false
+}
+
+// makeImaginaryConstant creates a constant from an imaginary literal.
+func makeImaginaryConstant(text string) (Constant, bool) {
+	text = strings.TrimSuffix(text, "i")
+	im, _, err := big.ParseFloat(text, 0, constPrecision, big.ToNearestEven)
+	if err != nil {
+		return Constant{}, false
+	}
+
+	return MakeComplexConstant(newFloat(), im), true
+}
+
+// Kind returns what kind of value the constant holds.
+func (c Constant) Kind() ConstantKind {
+	return c.kind
+}
+
+// BoolVal returns the value of a boolean constant.
+func (c Constant) BoolVal() bool {
+	return c.boolVal
+}
+
+// StringVal returns the value of a string constant.
+func (c Constant) StringVal() string {
+	return c.strVal
+}
+
+// Int returns the value of a constant as an integer. exact is false if it
+// isn't a whole number.
+func (c Constant) Int() (i *big.Int, exact bool) {
+	switch c.kind {
+	case ConstantInt:
+		return c.intVal, true
+
+	case ConstantFloat:
+		if c.re.IsInf() {
+			return nil, false
+		}
+		i, acc := c.re.Int(nil)
+		return i, acc == big.Exact
+
+	case ConstantComplex:
+		if c.im.Sign() != 0 || c.re.IsInf() {
+			return nil, false
+		}
+		i, acc := c.re.Int(nil)
+		return i, acc == big.Exact
+	}
+
+	return nil, false
+}
+
+// Int64 returns the value of an integer constant if it fits in an int64.
+func (c Constant) Int64() (int64, bool) {
+	i, exact := c.Int()
+	if !exact || !i.IsInt64() {
+		return 0, false
+	}
+
+	return i.Int64(), true
+}
+
+// Uint64 returns the value of an integer constant if it fits in a uint64.
+func (c Constant) Uint64() (uint64, bool) {
+	i, exact := c.Int()
+	if !exact || !i.IsUint64() {
+		return 0, false
+	}
+
+	return i.Uint64(), true
+}
+
+// Float returns the value of a numeric constant as a float. It's the real
+// part of a complex constant.
+func (c Constant) Float() *big.Float {
+	switch c.kind {
+	case ConstantInt:
+		return newFloat().SetInt(c.intVal)
+	case ConstantFloat, ConstantComplex:
+		return c.re
+	}
+
+	return newFloat()
+}
+
+// Float64 returns the nearest float64 to a numeric constant.
+func (c Constant) Float64() float64 {
+	f, _ := c.Float().Float64()
+	return f
+}
+
+// imag returns the imaginary part of a numeric constant.
+func (c Constant) imag() *big.Float {
+	if c.kind == ConstantComplex {
+		return c.im
+	}
+
+	return newFloat()
+}
+
+// Sign returns -1, 0 or 1 depending on the sign of a real constant.
+func (c Constant) Sign() int {
+	switch c.kind {
+	case ConstantInt:
+		return c.intVal.Sign()
+	case ConstantFloat:
+		return c.re.Sign()
+	}
+
+	return 0
+}
+
+// isZero returns true if a numeric constant is zero.
+func (c Constant) isZero() bool {
+	switch c.kind {
+	case ConstantInt:
+		return c.intVal.Sign() == 0
+	case ConstantFloat:
+		return c.re.Sign() == 0
+	case ConstantComplex:
+		return c.re.Sign() == 0 && c.im.Sign() == 0
+	}
+
+	return false
+}
+
+// String gives the constant as it'd appear in source code, for use in
+// error messages.
+func (c Constant) String() string {
+	switch c.kind {
+	case ConstantBool:
+		return strconv.FormatBool(c.boolVal)
+
+	case ConstantString:
+		s := strconv.Quote(c.strVal)
+		if len(s) > 40 {
+			s = s[:36] + `..."`
+		}
+		return s
+
+	case ConstantInt:
+		if c.intVal.BitLen() > 64 {
+			return newFloat().SetInt(c.intVal).Text('g', 6)
+		}
+		return c.intVal.String()
+
+	case ConstantFloat:
+		return floatString(c.re)
+
+	case ConstantComplex:
+		return "(" + floatString(c.re) + " + " + floatString(c.im) + "i)"
+	}
+
+	return "unknown"
+}
+
+// floatString formats a float constant readably.
+func floatString(f *big.Float) string {
+	if f.IsInt() {
+		i, _ := f.Int(nil)
+		if i.BitLen() <= 64 {
+			return i.String()
+		}
+	}
+
+	return f.Text('g', 10)
+}
+
+// promote converts a numeric constant to a more general kind - eg. an
+// integer to a float.
+func (c Constant) promote(kind ConstantKind) Constant {
+	if c.kind >= kind {
+		return c
+	}
+
+	switch kind {
+	case ConstantFloat:
+		return MakeFloatConstant(c.Float())
+	case ConstantComplex:
+		return MakeComplexConstant(c.Float(), newFloat())
+	}
+
+	return c
+}
+
+// matchConstants converts two numeric constants to the same kind.
+func matchConstants(x Constant, y Constant) (Constant, Constant) {
+	if x.kind < y.kind {
+		return x.promote(y.kind), y
+	}
+
+	return x, y.promote(x.kind)
+}
+
+// tooLarge returns true if an integer constant is too big to be handled.
+func (c Constant) tooLarge() bool {
+	switch c.kind {
+	case ConstantInt:
+		return c.intVal.BitLen() > maxConstBits
+	case ConstantFloat:
+		return c.re.IsInf()
+	case ConstantComplex:
+		return c.re.IsInf() || c.im.IsInf()
+	}
+
+	return false
+}
+
+// constUnaryOp applies a unary operator to a constant. For '^' on an
+// unsigned type the result must be masked to the type's size afterwards.
+func constUnaryOp(op TokenKind, x Constant) Constant {
+	switch op {
+	case TokenKindAdd:
+		return x
+
+	case TokenKindSubtract:
+		switch x.kind {
+		case ConstantInt:
+			return MakeIntConstant(new(big.Int).Neg(x.intVal))
+		case ConstantFloat:
+			return MakeFloatConstant(newFloat().Neg(x.re))
+		case ConstantComplex:
+			return MakeComplexConstant(newFloat().Neg(x.re), newFloat().Neg(x.im))
+		}
+
+	case TokenKindBitwiseExor:
+		if i, exact := x.Int(); exact {
+			return MakeIntConstant(new(big.Int).Not(i))
+		}
+
+	case TokenKindNot:
+		return MakeBoolConstant(!x.boolVal)
+	}
+
+	return Constant{}
+}
+
+// constBinaryOp applies an arithmetic or logical operator to two
+// constants. Division of two integers is integer division. It returns false
+// if a division by zero is attempted.
+func constBinaryOp(op TokenKind, x Constant, y Constant) (Constant, bool) {
+	switch op {
+	case TokenKindLogicalAnd:
+		return MakeBoolConstant(x.boolVal && y.boolVal), true
+	case TokenKindLogicalOr:
+		return MakeBoolConstant(x.boolVal || y.boolVal), true
+	}
+
+	if x.kind == ConstantString {
+		return MakeStringConstant(x.strVal + y.strVal), true
+	}
+
+	if (op == TokenKindDivide || op == TokenKindModulus) && y.isZero() {
+		return Constant{}, false
+	}
+
+	x, y = matchConstants(x, y)
+	switch x.kind {
+	case ConstantInt:
+		a, b := x.intVal, y.intVal
+		r := new(big.Int)
+		switch op {
+		case TokenKindAdd:
+			r.Add(a, b)
+		case TokenKindSubtract:
+			r.Sub(a, b)
+		case TokenKindAsterisk:
+			r.Mul(a, b)
+		case TokenKindDivide:
+			r.Quo(a, b)
+		case TokenKindModulus:
+			r.Rem(a, b)
+		case TokenKindBitwiseAnd:
+			r.And(a, b)
+		case TokenKindBitwiseOr:
+			r.Or(a, b)
+		case TokenKindBitwiseExor:
+			r.Xor(a, b)
+		case TokenKindBitClear:
+			r.AndNot(a, b)
+		}
+		return MakeIntConstant(r), true
+
+	case ConstantFloat:
+		a, b := x.re, y.re
+		r := newFloat()
+		switch op {
+		case TokenKindAdd:
+			r.Add(a, b)
+		case TokenKindSubtract:
+			r.Sub(a, b)
+		case TokenKindAsterisk:
+			r.Mul(a, b)
+		case TokenKindDivide:
+			r.Quo(a, b)
+		}
+		return MakeFloatConstant(r), true
+
+	case ConstantComplex:
+		a, b, c, d := x.re, x.im, y.re, y.im
+		re, im := newFloat(), newFloat()
+		switch op {
+		case TokenKindAdd:
+			re.Add(a, c)
+			im.Add(b, d)
+		case TokenKindSubtract:
+			re.Sub(a, c)
+			im.Sub(b, d)
+		case TokenKindAsterisk:
+			// (a+bi)(c+di) = (ac-bd) + (bc+ad)i
+			re.Sub(newFloat().Mul(a, c), newFloat().Mul(b, d))
+			im.Add(newFloat().Mul(b, c), newFloat().Mul(a, d))
+		case TokenKindDivide:
+			// (a+bi)/(c+di) = ((ac+bd) + (bc-ad)i) / (cc+dd)
+			denom := newFloat().Add(newFloat().Mul(c, c), newFloat().Mul(d, d))
+			re.Quo(newFloat().Add(newFloat().Mul(a, c), newFloat().Mul(b, d)), denom)
+			im.Quo(newFloat().Sub(newFloat().Mul(b, c), newFloat().Mul(a, d)), denom)
+		}
+		return MakeComplexConstant(re, im), true
+	}
+
+	return Constant{}, true
+}
+
+// constShift shifts an integer constant.
+func constShift(op TokenKind, x Constant, count uint) Constant {
+	i, _ := x.Int()
+	if op == TokenKindShiftLeft {
+		return MakeIntConstant(new(big.Int).Lsh(i, count))
+	}
+
+	return MakeIntConstant(new(big.Int).Rsh(i, count))
+}
+
+// constCompare compares two constants.
+func constCompare(op TokenKind, x Constant, y Constant) bool {
+	var cmp int
+	switch x.kind {
+	case ConstantBool:
+		cmp = 1
+		if x.boolVal == y.boolVal {
+			cmp = 0
+		}
+
+	case ConstantString:
+		cmp = strings.Compare(x.strVal, y.strVal)
+
+	default:
+		x, y = matchConstants(x, y)
+		switch x.kind {
+		case ConstantInt:
+			cmp = x.intVal.Cmp(y.intVal)
+		case ConstantFloat:
+			cmp = x.re.Cmp(y.re)
+		case ConstantComplex:
+			cmp = 1
+			if x.re.Cmp(y.re) == 0 && x.im.Cmp(y.im) == 0 {
+				cmp = 0
+			}
+		}
+	}
+
+	switch op {
+	case TokenKindEquals:
+		return cmp == 0
+	case TokenKindNotEqual:
+		return cmp != 0
+	case TokenKindLess:
+		return cmp < 0
+	case TokenKindLessEqual:
+		return cmp <= 0
+	case TokenKindGreater:
+		return cmp > 0
+	case TokenKindGreaterEqual:
+		return cmp >= 0
+	}
+
+	return false
+}
+
+// type representError describes why a constant can't be represented by
+// a type.
+type representError int
+
+const (
+	representOk        representError = iota
+	representOverflow                 // it's too big
+	representTruncated                // it isn't a whole number
+	representWrongKind                // it's the wrong kind of value entirely
+)
+
+// representable works out whether a constant can be represented by a
+// value of type dt. It returns the constant in the form dt holds it - eg.
+// rounded to the precision of a float32.
+func representable(ts *DataTypeStore, c Constant, dt DataType) (Constant, representError) {
+	switch basicKind(dt) {
+	case DataTypeKindBool, DataTypeKindUntypedBool:
+		if c.kind != ConstantBool {
+			return c, representWrongKind
+		}
+		return c, representOk
+
+	case DataTypeKindString, DataTypeKindUntypedString:
+		if c.kind != ConstantString {
+			return c, representWrongKind
+		}
+		return c, representOk
+
+	case DataTypeKindInt, DataTypeKindUint, DataTypeKindUntypedInt, DataTypeKindUntypedRune:
+		if c.kind < ConstantInt {
+			return c, representWrongKind
+		}
+		i, exact := c.Int()
+		if i == nil {
+			return c, representOverflow
+		}
+		if !exact {
+			return c, representTruncated
+		}
+		c = MakeIntConstant(i)
+		if sized, ok := Underlying(dt).(DataTypeSized); ok {
+			bits := ts.Bits(sized)
+			if sized.kind == DataTypeKindUint {
+				if i.Sign() < 0 || i.BitLen() > bits {
+					return c, representOverflow
+				}
+			} else {
+				// -1<<(bits-1) <= i < 1<<(bits-1)
+				min := new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), uint(bits-1)))
+				if i.Cmp(min) < 0 || i.BitLen() > bits-1 && i.Cmp(min) != 0 {
+					return c, representOverflow
+				}
+			}
+		} else if c.tooLarge() {
+			return c, representOverflow
+		}
+		return c, representOk
+
+	case DataTypeKindFloat, DataTypeKindUntypedFloat:
+		if c.kind < ConstantInt {
+			return c, representWrongKind
+		}
+		if c.kind == ConstantComplex {
+			if c.im.Sign() != 0 {
+				return c, representTruncated
+			}
+		}
+		f, ok := roundFloat(ts, c.Float(), dt)
+		if !ok {
+			return c, representOverflow
+		}
+		return MakeFloatConstant(f), representOk
+
+	case DataTypeKindComplex, DataTypeKindUntypedComplex:
+		if c.kind < ConstantInt {
+			return c, representWrongKind
+		}
+		partType := dt
+		if sized, ok := Underlying(dt).(DataTypeSized); ok {
+			// each part of a complex64 is a float32.
+			partType = ts.Float64Type()
+			if sized.size == DataSize64 {
+				partType = ts.Float32Type()
+			}
+		}
+		re, ok1 := roundFloat(ts, c.Float(), partType)
+		im, ok2 := roundFloat(ts, c.imag(), partType)
+		if !ok1 || !ok2 {
+			return c, representOverflow
+		}
+		return MakeComplexConstant(re, im), representOk
+	}
+
+	return c, representWrongKind
+}
+
+// roundFloat rounds a float constant to the precision of a float type. It
+// returns false if it overflows.
+func roundFloat(ts *DataTypeStore, f *big.Float, dt DataType) (*big.Float, bool) {
+	if f.IsInf() {
+		return f, false
+	}
+
+	sized, ok := Underlying(dt).(DataTypeSized)
+	if !ok {
+		// untyped floats keep their full precision.
+		return f, true
+	}
+
+	if ts.Bits(sized) == 32 {
+		f32, _ := f.Float32()
+		if math.IsInf(float64(f32), 0) {
+			return f, false
+		}
+		return newFloat().SetFloat64(float64(f32)), true
+	}
+
+	f64, _ := f.Float64()
+	if math.IsInf(f64, 0) {
+		return f, false
+	}
+	return newFloat().SetFloat64(f64), true
+}
+
+// representErrorFormat gives a format for an error message describing
+// why a constant can't be represented by a type. It takes the constant and
+// the type as arguments.
+func representErrorFormat(err representError) string {
+	switch err {
+	case representOverflow:
+		return "the constant %s overflows %s"
+	case representTruncated:
+		return "the constant %s would be truncated to make it %s"
+	}
+
+	return "I can't use the constant %s as %s"
+}
@@ -0,0 +1,178 @@
+package golightly
+
+import (
+	"testing"
+)
+
+func TestConstantLiterals(t *testing.T) {
+	literals := []struct {
+		text string
+		kind TokenKind
+		val  string
+	}{
+		{"42", TokenKindLiteralInt, "42"},
+		{"0x2a", TokenKindLiteralInt, "42"},
+		{"0b101010", TokenKindLiteralInt, "42"},
+		{"052", TokenKindLiteralInt, "42"},
+		{"0o52", TokenKindLiteralInt, "42"},
+		{"1_000_000", TokenKindLiteralInt, "1000000"},
+		{"18446744073709551616", TokenKindLiteralInt, "1.84467e+19"},
+		{"1.5", TokenKindLiteralFloat, "1.5"},
+		{"1e3", TokenKindLiteralFloat, "1000"},
+		{"0x1p-2", TokenKindLiteralFloat, "0.25"},
+		{"2i", TokenKindLiteralInt, "(0 + 2i)"},
+	}
+
+	for _, l := range literals {
+		c, ok := MakeConstantFromLiteral(l.text, l.kind)
+		if !ok {
+			t.Error("can't make a constant from ", l.text)
+		} else if c.String() != l.val {
+			t.Error("constant ", l.text, " is ", c.String(), " but should be ", l.val)
+		}
+	}
+
+	if _, ok := MakeConstantFromLiteral("0x", TokenKindLiteralInt); ok {
+		t.Error("bad literal should give an error")
+	}
+}
+
+func TestConstantOperations(t *testing.T) {
+	one := MakeInt64Constant(1)
+	three := MakeInt64Constant(3)
+
+	// integers stay exact, however large they get.
+	big := constShift(TokenKindShiftLeft, one, 100)
+	if v, ok := constShift(TokenKindShiftRight, big, 98).Int64(); !ok || v != 4 {
+		t.Error("1<<100 >> 98 should be 4 but is ", v)
+	}
+
+	if v, _ := constBinaryOp(TokenKindDivide, MakeInt64Constant(7), three); v.String() != "2" {
+		t.Error("7 / 3 should be 2 but is ", v)
+	}
+	if v, _ := constBinaryOp(TokenKindDivide, MakeFloat64Constant(3), MakeInt64Constant(2)); v.String() != "1.5" {
+		t.Error("3.0 / 2 should be 1.5 but is ", v)
+	}
+	if _, ok := constBinaryOp(TokenKindDivide, one, MakeInt64Constant(0)); ok {
+		t.Error("division by zero should fail")
+	}
+	if !constCompare(TokenKindLess, one, MakeFloat64Constant(1.5)) {
+		t.Error("1 < 1.5 should be true")
+	}
+	if v := constUnaryOp(TokenKindSubtract, three); v.Sign() >= 0 {
+		t.Error("-3 should be negative but is ", v)
+	}
+
+	// a constant has to fit the type it's used as.
+	ts := NewDataTypeStore()
+	if _, err := representable(ts, MakeInt64Constant(300), ts.Int8Type()); err != representOverflow {
+		t.Error("300 should overflow int8")
+	}
+	if _, err := representable(ts, MakeFloat64Constant(1.5), ts.IntType()); err != representTruncated {
+		t.Error("1.5 should be truncated as an int")
+	}
+	if v, err := representable(ts, MakeFloat64Constant(2), ts.IntType()); err != representOk || v.Kind() != ConstantInt {
+		t.Error("2.0 should be an int")
+	}
+	if _, err := representable(ts, MakeStringConstant("x"), ts.IntType()); err != representWrongKind {
+		t.Error("a string shouldn't be an int")
+	}
+}
+
+func TestCheckerConstants(t *testing.T) {
+	ta := &testAST{}
+	ts := NewDataTypeStore()
+
+	// each of these is a constant with the given value.
+	exprs := []struct {
+		expr AST
+		val  string
+	}{
+		{ta.binary(TokenKindAdd, ta.int(1), ta.int(2)), "3"},
+		{ta.binary(TokenKindShiftRight, ta.binary(TokenKindShiftLeft, ta.int(1), ta.int(100)), ta.int(98)), "4"},
+		{ta.binary(TokenKindDivide, ta.int(7), ta.float(2)), "3.5"},
+		{ta.binary(TokenKindLess, ta.int(1), ta.int(2)), "true"},
+		{ta.call(ta.ident("len"), ta.str("hello")), "5"},
+		{ta.call(ta.ident("int8"), ta.unary(TokenKindSubtract, ta.int(128))), "-128"},
+		{ta.call(ta.ident("string"), ta.int(65)), `"A"`},
+		{ta.unary(TokenKindBitwiseExor, ta.call(ta.ident("uint8"), ta.int(1))), "254"},
+		{ta.call(ta.ident("max"), ta.int(1), ta.float(2.5), ta.int(2)), "2.5"},
+	}
+
+	var decls []AST
+	for i, e := range exprs {
+		decls = append(decls, ASTConstDecl{ta.ident(string(rune('A' + i))), nil, e.expr, 0})
+	}
+
+	c, errs := checkTestFile(ts, decls)
+	for _, err := range errs {
+		t.Error("unexpected error: ", err)
+	}
+	for i, e := range exprs {
+		val, ok := c.Info().ValueOf("test.go", e.expr)
+		if !ok {
+			t.Error("expression ", i, " isn't constant")
+		} else if val.String() != e.val {
+			t.Error("expression ", i, " is ", val, " but should be ", e.val)
+		}
+	}
+}
+
+func TestCheckerConstantErrors(t *testing.T) {
+	ta := &testAST{}
+
+	// each of these should give exactly one error.
+	exprs := []AST{
+		ta.call(ta.ident("int8"), ta.int(300)),
+		ta.call(ta.ident("int"), ta.float(1.5)),
+		ta.call(ta.ident("uint"), ta.unary(TokenKindSubtract, ta.int(1))),
+		ta.binary(TokenKindDivide, ta.int(1), ta.int(0)),
+		ta.binary(TokenKindModulus, ta.ident("i"), ta.int(0)),
+		ta.binary(TokenKindShiftLeft, ta.int(1), ta.unary(TokenKindSubtract, ta.int(1))),
+		ta.binary(TokenKindShiftLeft, ta.float(1.5), ta.int(2)),
+		ta.index(ta.str("abc"), ta.int(3)),
+		ta.index(ta.ident("s"), ta.unary(TokenKindSubtract, ta.int(1))),
+		ta.ident("iota"),
+	}
+
+	for i, expr := range exprs {
+		decls := append(testVarDecls(ta), ta.varDecl("x", nil, expr))
+		_, errs := checkTestFile(NewDataTypeStore(), decls)
+		if len(errs) != 1 {
+			t.Error("expression ", i, " should give one error but gave ", len(errs), ": ", errs)
+		}
+	}
+
+	// a typed constant has to fit its type.
+	decls := []AST{ASTConstDecl{ta.ident("c"), ta.ident("uint8"), ta.int(256), 0}}
+	if _, errs := checkTestFile(NewDataTypeStore(), decls); len(errs) != 1 {
+		t.Error("256 should overflow uint8 but gave: ", errs)
+	}
+}
+
+func TestConstIota(t *testing.T) {
+	decls := parseTestDecls(t, `const ( a = iota; b; c uint8 = iota; d; );
+`)
+	if len(decls) != 4 {
+		t.Error("expected 4 constants but got ", len(decls))
+		return
+	}
+
+	c, errs := checkTestFile(NewDataTypeStore(), decls)
+	for _, err := range errs {
+		t.Error("unexpected error: ", err)
+	}
+
+	for i, decl := range decls {
+		d := decl.(ASTConstDecl)
+		if d.iota != i {
+			t.Error("constant ", i, " has iota ", d.iota)
+		}
+		// the repeated expressions share a position so look at the
+		// declared constants rather than the expressions.
+		obj := c.pkgScope.LookupLocal(d.ident.(ASTIdentifier).name)
+		if obj == nil || obj.val.String() != string(rune('0'+i)) {
+			t.Error("constant ", i, " has the wrong value")
+		}
+	}
+}
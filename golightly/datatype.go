@@ -1,47 +1,99 @@
 package golightly
 
-import "sync"
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
 
 // DataTypeKind indicates which type of value this is
 type DataTypeKind int
 
 const (
 	// basic types
-	DataTypeKindInt DataTypeKind = iota
+	DataTypeKindBool DataTypeKind = iota
+	DataTypeKindInt
 	DataTypeKindUint
 	DataTypeKindFloat
+	DataTypeKindComplex
 	DataTypeKindString
-	DataTypeKindRune
-	DataTypeKindImaginary
 	DataTypeKindType
 
 	// unary types
 	DataTypeKindArray
 	DataTypeKindSlice
 	DataTypeKindPointer
+	DataTypeKindChan
 
-	// struct type
+	// compound types
+	DataTypeKindMap
+	DataTypeKindFunc
 	DataTypeKindStruct
+	DataTypeKindInterface
+
+	// defined types
+	DataTypeKindNamed
+
+	// type parameters of generic functions and types
+	DataTypeKindTypeParam
+
+	// the types of untyped constants and nil
+	DataTypeKindUntypedBool
+	DataTypeKindUntypedInt
+	DataTypeKindUntypedRune
+	DataTypeKindUntypedFloat
+	DataTypeKindUntypedComplex
+	DataTypeKindUntypedString
+	DataTypeKindUntypedNil
 )
 
 // DataSize indicates which size value this is.
 type DataSize int
 
 const (
-	// operators
-	DataSize16 DataSize = iota
+	DataSize8 DataSize = iota
+	DataSize16
 	DataSize32
 	DataSize64
-	DataSizeDefault
+	DataSize128     // only used by complex128
+	DataSizeDefault // the word size of the target - used by int and uint
+	DataSizePointer // the pointer size of the target - used by uintptr
 )
 
+// Bits returns the number of bits in a value of this size. Default and
+// pointer sizes depend on the target so the target's word size must be
+// provided.
+func (ds DataSize) Bits(wordSize DataSize) int {
+	switch ds {
+	case DataSize8:
+		return 8
+	case DataSize16:
+		return 16
+	case DataSize32:
+		return 32
+	case DataSize64:
+		return 64
+	case DataSize128:
+		return 128
+	default:
+		return wordSize.Bits(DataSize64)
+	}
+}
+
 // type DataType represents any Go type.
 // It's a "sum type" implemented using an interface.
 //
-// DataType can be created using struct initialisers.
-// eg. DataTypeSimple{DataTypeKindInt}
+// Basic types can be created using struct initialisers.
+// eg. DataTypeBasic{DataTypeKindString}
+//
+// Composite types must be created using the DataTypeStore so that each
+// distinct type is only ever represented by a single value.
 type DataType interface {
 	DataTypeKind() DataTypeKind
+	String() string // the type in Go syntax
 }
 
 // type DataTypeBasic is for "basic types" - ie. simple data types which have no sub-type.
@@ -54,6 +106,8 @@ func (dtb DataTypeBasic) DataTypeKind() DataTypeKind {
 }
 
 // type DataTypeSized is for basic types which have a size - eg. int/int16/int32/int64.
+// int, uint and uintptr have DataSizeDefault or DataSizePointer so they're
+// distinct from the explicitly sized types even when they're the same size.
 type DataTypeSized struct {
 	kind DataTypeKind
 	size DataSize
@@ -63,90 +117,1036 @@ func (dts DataTypeSized) DataTypeKind() DataTypeKind {
 	return dts.kind
 }
 
-// type DataTypeUnary is for types which have a single sub-type.
+// Size returns the size of this type. It may be DataSizeDefault or
+// DataSizePointer, which depend on the target.
+func (dts DataTypeSized) Size() DataSize {
+	return dts.size
+}
+
+// type DataTypeUnary is for types which have a single sub-type - ie. slices
+// and pointers.
 type DataTypeUnary struct {
 	kind    DataTypeKind
-	subType *DataType
+	subType DataType
 }
 
-func (dtu DataTypeUnary) DataTypeKind() DataTypeKind {
+func (dtu *DataTypeUnary) DataTypeKind() DataTypeKind {
 	return dtu.kind
 }
 
+// SubType returns the element type of a slice or the base type of a pointer.
+func (dtu *DataTypeUnary) SubType() DataType {
+	return dtu.subType
+}
+
+// type DataTypeArray is a fixed length array of elements.
+type DataTypeArray struct {
+	length      int64    // the number of elements
+	elementType DataType // the type of each element
+}
+
+func (dta *DataTypeArray) DataTypeKind() DataTypeKind {
+	return DataTypeKindArray
+}
+
+// Len returns the number of elements in the array.
+func (dta *DataTypeArray) Len() int64 {
+	return dta.length
+}
+
+// ElementType returns the type of each element.
+func (dta *DataTypeArray) ElementType() DataType {
+	return dta.elementType
+}
+
+// type DataTypeMap is a map from keys to values.
+type DataTypeMap struct {
+	keyType   DataType
+	valueType DataType
+}
+
+func (dtm *DataTypeMap) DataTypeKind() DataTypeKind {
+	return DataTypeKindMap
+}
+
+// KeyType returns the type of the map's keys.
+func (dtm *DataTypeMap) KeyType() DataType {
+	return dtm.keyType
+}
+
+// ValueType returns the type of the map's values.
+func (dtm *DataTypeMap) ValueType() DataType {
+	return dtm.valueType
+}
+
+// type DataTypeChan is a channel with a direction.
+type DataTypeChan struct {
+	dir         ChanDirection // which ways data can flow
+	elementType DataType      // the type of data sent on the channel
+}
+
+func (dtc *DataTypeChan) DataTypeKind() DataTypeKind {
+	return DataTypeKindChan
+}
+
+// Dir returns the directions data can travel on the channel.
+func (dtc *DataTypeChan) Dir() ChanDirection {
+	return dtc.dir
+}
+
+// ElementType returns the type of data sent on the channel.
+func (dtc *DataTypeChan) ElementType() DataType {
+	return dtc.elementType
+}
+
+// type DataTypeFunc is a function signature. Parameter and result names
+// aren't part of a function's type so they aren't kept here.
+type DataTypeFunc struct {
+	params   []DataType // parameter types. if variadic the last is a slice.
+	returns  []DataType // result types
+	variadic bool       // true if the last parameter is "...T"
+}
+
+func (dtf *DataTypeFunc) DataTypeKind() DataTypeKind {
+	return DataTypeKindFunc
+}
+
+// Params returns the parameter types.
+func (dtf *DataTypeFunc) Params() []DataType {
+	return dtf.params
+}
+
+// Returns returns the result types.
+func (dtf *DataTypeFunc) Returns() []DataType {
+	return dtf.returns
+}
+
+// Variadic returns true if the final parameter is of the form "...T".
+func (dtf *DataTypeFunc) Variadic() bool {
+	return dtf.variadic
+}
+
+// type DataTypeField is a single field in a struct.
+type DataTypeField struct {
+	name     string   // the field name. for embedded fields it's the type name.
+	pkg      string   // the package of an unexported field, otherwise empty.
+	typ      DataType // the field type
+	embedded bool     // true if it's an embedded field
+	tag      string   // the field tag
+}
+
 // type DataTypeStruct is a compound data type with named fields.
 type DataTypeStruct struct {
-	field map[string]*DataType
+	fields []DataTypeField // the fields in declaration order
 }
 
-func (dtu DataTypeStruct) DataTypeKind() DataTypeKind {
+func (dts *DataTypeStruct) DataTypeKind() DataTypeKind {
 	return DataTypeKindStruct
 }
 
+// Fields returns the struct's fields in declaration order.
+func (dts *DataTypeStruct) Fields() []DataTypeField {
+	return dts.fields
+}
+
+// Field finds a field by name. It returns nil if there's no such field.
+func (dts *DataTypeStruct) Field(name string) *DataTypeField {
+	for i := range dts.fields {
+		if dts.fields[i].name == name {
+			return &dts.fields[i]
+		}
+	}
+
+	return nil
+}
+
+// type DataTypeMethod is a method in an interface's method set.
+type DataTypeMethod struct {
+	name string        // the method name
+	pkg  string        // the package of an unexported method, otherwise empty.
+	sig  *DataTypeFunc // the method signature, without a receiver
+}
+
+// type DataTypeInterface is an interface type. Embedded interfaces are
+// flattened so methods contains the complete method set, sorted by name.
+//
+// Interfaces used as type constraints can also limit their type set to
+// the types in a list of terms, or to comparable types.
+type DataTypeInterface struct {
+	methods    []DataTypeMethod
+	terms      []DataTypeTerm // the types allowed, if restricted is true
+	restricted bool           // true if only the types in terms are allowed
+	comparable bool           // true if only comparable types are allowed
+}
+
+func (dti *DataTypeInterface) DataTypeKind() DataTypeKind {
+	return DataTypeKindInterface
+}
+
+// Methods returns the interface's complete method set, sorted by name.
+func (dti *DataTypeInterface) Methods() []DataTypeMethod {
+	return dti.methods
+}
+
+// Terms returns the terms which limit the interface's type set. The second
+// result is false if its type set isn't limited by terms.
+func (dti *DataTypeInterface) Terms() ([]DataTypeTerm, bool) {
+	return dti.terms, dti.restricted
+}
+
+// Comparable returns true if the interface's type set is limited to
+// comparable types.
+func (dti *DataTypeInterface) Comparable() bool {
+	return dti.comparable
+}
+
 // type DataTypeStore is a store of all the data types in the system. Each
 // unique data type will be stored only once and a reference to it always
 // returns the same pointer so pointer comparison can be used on types.
+//
+// All methods are safe to call concurrently from multiple goroutines.
 type DataTypeStore struct {
 	// a map of type names to types
 	nameMap      map[string]DataType
 	nameMapMutex sync.RWMutex
 
+	// composite types are "hash consed" - they're looked up by a key
+	// describing their structure before they're created.
+	internMap     map[string]DataType      // structural keys to canonical types
+	typeID        map[DataType]int         // a unique number for each canonical type
+	funcInstances map[string]*FuncInstance // instances of generic functions
+	internMutex   sync.Mutex
+
+	// the target decides the size of int, uint and uintptr and how
+	// values are laid out in memory.
+	target      *Target
+	wordSize    DataSize
+	layouts     map[*DataTypeStruct]*structLayout // cached struct layouts
+	layoutMutex sync.Mutex
+
 	// standard types
-	intType    DataType
-	uintType   DataType
-	floatType  DataType
-	runeType   DataType
-	stringType DataType
+	boolType       DataType
+	intType        DataType
+	int8Type       DataType
+	int16Type      DataType
+	int32Type      DataType
+	int64Type      DataType
+	uintType       DataType
+	uint8Type      DataType
+	uint16Type     DataType
+	uint32Type     DataType
+	uint64Type     DataType
+	uintptrType    DataType
+	float32Type    DataType
+	float64Type    DataType
+	complex64Type  DataType
+	complex128Type DataType
+	stringType     DataType
+	errorType      DataType
+	anyType        DataType
+	comparableType DataType
+
+	// untyped types
+	untypedBoolType    DataType
+	untypedIntType     DataType
+	untypedRuneType    DataType
+	untypedFloatType   DataType
+	untypedComplexType DataType
+	untypedStringType  DataType
+	untypedNilType     DataType
 }
 
-// NewDataTypeStore creates a new data type store.
+// NewDataTypeStore creates a new data type store for the amd64 target.
 func NewDataTypeStore() *DataTypeStore {
+	return NewDataTypeStoreTarget(TargetAMD64)
+}
+
+// NewDataTypeStoreWordSize creates a new data type store for a target
+// where int, uint and uintptr are of the given size and no type is aligned
+// to more than that size.
+func NewDataTypeStoreWordSize(wordSize DataSize) *DataTypeStore {
+	return NewDataTypeStoreTarget(NewTarget("", wordSize, int64(wordSize.Bits(DataSize64)/8)))
+}
+
+// NewDataTypeStoreTarget creates a new data type store for a target.
+func NewDataTypeStoreTarget(target *Target) *DataTypeStore {
 	ts := new(DataTypeStore)
+	ts.internMap = make(map[string]DataType)
+	ts.typeID = make(map[DataType]int)
+	ts.funcInstances = make(map[string]*FuncInstance)
+	ts.target = target
+	ts.wordSize = target.wordSize
+	ts.layouts = make(map[*DataTypeStruct]*structLayout)
 
 	// add the predefined data types
+	ts.boolType = DataTypeBasic{DataTypeKindBool}
 	ts.intType = DataTypeSized{DataTypeKindInt, DataSizeDefault}
+	ts.int8Type = DataTypeSized{DataTypeKindInt, DataSize8}
+	ts.int16Type = DataTypeSized{DataTypeKindInt, DataSize16}
+	ts.int32Type = DataTypeSized{DataTypeKindInt, DataSize32}
+	ts.int64Type = DataTypeSized{DataTypeKindInt, DataSize64}
 	ts.uintType = DataTypeSized{DataTypeKindUint, DataSizeDefault}
-	ts.floatType = DataTypeSized{DataTypeKindFloat, DataSizeDefault}
-	ts.runeType = DataTypeBasic{DataTypeKindRune}
+	ts.uint8Type = DataTypeSized{DataTypeKindUint, DataSize8}
+	ts.uint16Type = DataTypeSized{DataTypeKindUint, DataSize16}
+	ts.uint32Type = DataTypeSized{DataTypeKindUint, DataSize32}
+	ts.uint64Type = DataTypeSized{DataTypeKindUint, DataSize64}
+	ts.uintptrType = DataTypeSized{DataTypeKindUint, DataSizePointer}
+	ts.float32Type = DataTypeSized{DataTypeKindFloat, DataSize32}
+	ts.float64Type = DataTypeSized{DataTypeKindFloat, DataSize64}
+	ts.complex64Type = DataTypeSized{DataTypeKindComplex, DataSize64}
+	ts.complex128Type = DataTypeSized{DataTypeKindComplex, DataSize128}
 	ts.stringType = DataTypeBasic{DataTypeKindString}
+	ts.untypedBoolType = DataTypeBasic{DataTypeKindUntypedBool}
+	ts.untypedIntType = DataTypeBasic{DataTypeKindUntypedInt}
+	ts.untypedRuneType = DataTypeBasic{DataTypeKindUntypedRune}
+	ts.untypedFloatType = DataTypeBasic{DataTypeKindUntypedFloat}
+	ts.untypedComplexType = DataTypeBasic{DataTypeKindUntypedComplex}
+	ts.untypedStringType = DataTypeBasic{DataTypeKindUntypedString}
+	ts.untypedNilType = DataTypeBasic{DataTypeKindUntypedNil}
+	ts.anyType = ts.MakeInterface(nil)
+	ts.errorType = &DataTypeNamed{name: "error", underlying: ts.MakeInterface([]DataTypeMethod{{"Error", "", ts.MakeFunc(nil, []DataType{ts.stringType}, false)}})}
+	ts.comparableType = &DataTypeNamed{name: "comparable", underlying: ts.MakeConstraint(nil, nil, true)}
 
 	ts.nameMapMutex.Lock()
 	ts.nameMap = make(map[string]DataType)
+	ts.nameMap["bool"] = ts.boolType
 	ts.nameMap["int"] = ts.intType
+	ts.nameMap["int8"] = ts.int8Type
+	ts.nameMap["int16"] = ts.int16Type
+	ts.nameMap["int32"] = ts.int32Type
+	ts.nameMap["int64"] = ts.int64Type
 	ts.nameMap["uint"] = ts.uintType
-	ts.nameMap["float"] = ts.floatType
-	ts.nameMap["rune"] = ts.runeType
+	ts.nameMap["uint8"] = ts.uint8Type
+	ts.nameMap["uint16"] = ts.uint16Type
+	ts.nameMap["uint32"] = ts.uint32Type
+	ts.nameMap["uint64"] = ts.uint64Type
+	ts.nameMap["uintptr"] = ts.uintptrType
+	ts.nameMap["float32"] = ts.float32Type
+	ts.nameMap["float64"] = ts.float64Type
+	ts.nameMap["complex64"] = ts.complex64Type
+	ts.nameMap["complex128"] = ts.complex128Type
 	ts.nameMap["string"] = ts.stringType
+	ts.nameMap["error"] = ts.errorType
+	ts.nameMap["any"] = ts.anyType
+	ts.nameMap["comparable"] = ts.comparableType
+
+	// byte and rune are aliases - they're exactly the same types.
+	ts.nameMap["byte"] = ts.uint8Type
+	ts.nameMap["rune"] = ts.int32Type
 	ts.nameMapMutex.Unlock()
 
 	return ts
 }
 
+// Target returns the target the types are laid out for.
+func (ts *DataTypeStore) Target() *Target {
+	return ts.target
+}
+
+// WordSize returns the size of int, uint and uintptr on the target.
+func (ts *DataTypeStore) WordSize() DataSize {
+	return ts.wordSize
+}
+
+// Bits returns the number of bits in a sized type on the target.
+func (ts *DataTypeStore) Bits(dts DataTypeSized) int {
+	return dts.size.Bits(ts.wordSize)
+}
+
 // methods to get all the predefined types.
+func (ts *DataTypeStore) BoolType() DataType {
+	return ts.boolType
+}
 func (ts *DataTypeStore) IntType() DataType {
 	return ts.intType
 }
+func (ts *DataTypeStore) Int8Type() DataType {
+	return ts.int8Type
+}
+func (ts *DataTypeStore) Int16Type() DataType {
+	return ts.int16Type
+}
+func (ts *DataTypeStore) Int32Type() DataType {
+	return ts.int32Type
+}
+func (ts *DataTypeStore) Int64Type() DataType {
+	return ts.int64Type
+}
 func (ts *DataTypeStore) UintType() DataType {
 	return ts.uintType
 }
-func (ts *DataTypeStore) FloatType() DataType {
-	return ts.floatType
+func (ts *DataTypeStore) Uint8Type() DataType {
+	return ts.uint8Type
+}
+func (ts *DataTypeStore) Uint16Type() DataType {
+	return ts.uint16Type
+}
+func (ts *DataTypeStore) Uint32Type() DataType {
+	return ts.uint32Type
+}
+func (ts *DataTypeStore) Uint64Type() DataType {
+	return ts.uint64Type
+}
+func (ts *DataTypeStore) UintptrType() DataType {
+	return ts.uintptrType
+}
+func (ts *DataTypeStore) Float32Type() DataType {
+	return ts.float32Type
+}
+func (ts *DataTypeStore) Float64Type() DataType {
+	return ts.float64Type
+}
+func (ts *DataTypeStore) Complex64Type() DataType {
+	return ts.complex64Type
+}
+func (ts *DataTypeStore) Complex128Type() DataType {
+	return ts.complex128Type
+}
+func (ts *DataTypeStore) ByteType() DataType {
+	return ts.uint8Type
 }
 func (ts *DataTypeStore) RuneType() DataType {
-	return ts.runeType
+	return ts.int32Type
 }
 func (ts *DataTypeStore) StringType() DataType {
 	return ts.stringType
 }
+func (ts *DataTypeStore) ErrorType() DataType {
+	return ts.errorType
+}
+func (ts *DataTypeStore) AnyType() DataType {
+	return ts.anyType
+}
+func (ts *DataTypeStore) ComparableType() DataType {
+	return ts.comparableType
+}
+
+// methods to get the types of untyped constants.
+func (ts *DataTypeStore) UntypedBoolType() DataType {
+	return ts.untypedBoolType
+}
+func (ts *DataTypeStore) UntypedIntType() DataType {
+	return ts.untypedIntType
+}
+func (ts *DataTypeStore) UntypedRuneType() DataType {
+	return ts.untypedRuneType
+}
+func (ts *DataTypeStore) UntypedFloatType() DataType {
+	return ts.untypedFloatType
+}
+func (ts *DataTypeStore) UntypedComplexType() DataType {
+	return ts.untypedComplexType
+}
+func (ts *DataTypeStore) UntypedStringType() DataType {
+	return ts.untypedStringType
+}
+func (ts *DataTypeStore) UntypedNilType() DataType {
+	return ts.untypedNilType
+}
+
+// LookupName finds a type by name. It returns nil if there's no such type.
+func (ts *DataTypeStore) LookupName(name string) DataType {
+	ts.nameMapMutex.RLock()
+	defer ts.nameMapMutex.RUnlock()
+
+	return ts.nameMap[name]
+}
+
+// id returns a number which uniquely identifies a type. Types which
+// haven't been seen before are given a new number. It must be called
+// with internMutex held.
+func (ts *DataTypeStore) id(dt DataType) string {
+	n, ok := ts.typeID[dt]
+	if !ok {
+		n = len(ts.typeID)
+		ts.typeID[dt] = n
+	}
+
+	return strconv.Itoa(n)
+}
+
+// intern returns the canonical type for a structural key. If there's no
+// such type yet it's created using newType. The key is built by keyOf,
+// which is called with internMutex held so it can use id().
+func (ts *DataTypeStore) intern(keyOf func() string, newType func() DataType) DataType {
+	ts.internMutex.Lock()
+	defer ts.internMutex.Unlock()
+
+	key := keyOf()
+	dt, ok := ts.internMap[key]
+	if !ok {
+		dt = newType()
+		ts.internMap[key] = dt
+		ts.id(dt)
+	}
+
+	return dt
+}
 
 // methods to create types from other types
 func (ts *DataTypeStore) MakeSlice(subType DataType) DataType {
-	return nil
+	return ts.intern(
+		func() string { return "[]" + ts.id(subType) },
+		func() DataType { return &DataTypeUnary{DataTypeKindSlice, subType} })
 }
 
-func (ts *DataTypeStore) MakeArray(arrayLength AST, subType DataType) DataType {
-	return nil
+func (ts *DataTypeStore) MakeArray(length int64, subType DataType) DataType {
+	return ts.intern(
+		func() string { return "[" + strconv.FormatInt(length, 10) + "]" + ts.id(subType) },
+		func() DataType { return &DataTypeArray{length, subType} })
 }
 
-func (ts *DataTypeStore) MakeASTType(ast AST) DataType {
-	return nil
+func (ts *DataTypeStore) MakePointer(subType DataType) DataType {
+	return ts.intern(
+		func() string { return "*" + ts.id(subType) },
+		func() DataType { return &DataTypeUnary{DataTypeKindPointer, subType} })
+}
+
+func (ts *DataTypeStore) MakeMap(keyType DataType, valueType DataType) DataType {
+	return ts.intern(
+		func() string { return "map[" + ts.id(keyType) + "]" + ts.id(valueType) },
+		func() DataType { return &DataTypeMap{keyType, valueType} })
+}
+
+func (ts *DataTypeStore) MakeChan(dir ChanDirection, elementType DataType) DataType {
+	return ts.intern(
+		func() string { return "chan" + strconv.Itoa(int(dir)) + " " + ts.id(elementType) },
+		func() DataType { return &DataTypeChan{dir, elementType} })
+}
+
+// MakeFunc creates a function type. If variadic is true the last
+// parameter should already be a slice type.
+func (ts *DataTypeStore) MakeFunc(params []DataType, returns []DataType, variadic bool) *DataTypeFunc {
+	dt := ts.intern(
+		func() string {
+			var key strings.Builder
+			key.WriteString("func(")
+			for _, param := range params {
+				key.WriteString(ts.id(param))
+				key.WriteString(",")
+			}
+			if variadic {
+				key.WriteString("...")
+			}
+			key.WriteString(")(")
+			for _, ret := range returns {
+				key.WriteString(ts.id(ret))
+				key.WriteString(",")
+			}
+			key.WriteString(")")
+			return key.String()
+		},
+		func() DataType {
+			return &DataTypeFunc{append([]DataType(nil), params...), append([]DataType(nil), returns...), variadic}
+		})
+
+	return dt.(*DataTypeFunc)
+}
+
+// MakeStruct creates a struct type from its fields in declaration order.
+func (ts *DataTypeStore) MakeStruct(fields []DataTypeField) DataType {
+	return ts.intern(
+		func() string {
+			var key strings.Builder
+			key.WriteString("struct{")
+			for _, field := range fields {
+				if field.embedded {
+					key.WriteString("embed ")
+				}
+				key.WriteString(strconv.Quote(field.pkg + "." + field.name))
+				key.WriteString(" ")
+				key.WriteString(ts.id(field.typ))
+				key.WriteString(" ")
+				key.WriteString(strconv.Quote(field.tag))
+				key.WriteString(";")
+			}
+			key.WriteString("}")
+			return key.String()
+		},
+		func() DataType { return &DataTypeStruct{append([]DataTypeField(nil), fields...)} })
+}
+
+// MakeInterface creates an interface type from its complete method set.
+// The methods don't need to be in any particular order but method names
+// must be unique.
+func (ts *DataTypeStore) MakeInterface(methods []DataTypeMethod) DataType {
+	return ts.makeInterface(methods, nil, false)
+}
+
+// makeInterface creates an interface type. If terms is non-nil the type
+// set is limited to the types in the terms.
+func (ts *DataTypeStore) makeInterface(methods []DataTypeMethod, terms []DataTypeTerm, comparable bool) DataType {
+	sorted := append([]DataTypeMethod(nil), methods...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].name != sorted[j].name {
+			return sorted[i].name < sorted[j].name
+		}
+		return sorted[i].pkg < sorted[j].pkg
+	})
+
+	return ts.intern(
+		func() string {
+			var key strings.Builder
+			key.WriteString("interface{")
+			for _, method := range sorted {
+				key.WriteString(strconv.Quote(method.pkg + "." + method.name))
+				key.WriteString(" ")
+				key.WriteString(ts.id(method.sig))
+				key.WriteString(";")
+			}
+			if terms != nil {
+				key.WriteString("terms ")
+				for _, term := range terms {
+					if term.tilde {
+						key.WriteString("~")
+					}
+					key.WriteString(ts.id(term.typ))
+					key.WriteString("|")
+				}
+			}
+			if comparable {
+				key.WriteString("comparable")
+			}
+			key.WriteString("}")
+			return key.String()
+		},
+		func() DataType {
+			return &DataTypeInterface{sorted, append([]DataTypeTerm(nil), terms...), terms != nil, comparable}
+		})
+}
+
+// type TypeContext describes where a type expression appears so that
+// MakeASTType can resolve the names used in it.
+type TypeContext struct {
+	filename    string                                      // the source file, for error messages.
+	packagePath string                                      // the import path of the package the type expression is in.
+	resolve     func(ident ASTIdentifier) (DataType, error) // finds named types. nil to only use the store's names.
+	length      func(ast AST) (int64, error)                // evaluates array lengths. nil to only allow literals.
+	later       func(check func() error)                    // defers checks until declarations are resolved. nil to check straight away.
+}
+
+// NewTypeContext creates a context for converting type expressions from
+// a file. resolve may be nil, in which case only names known to the
+// DataTypeStore can be used.
+func NewTypeContext(filename string, packagePath string, resolve func(ident ASTIdentifier) (DataType, error)) *TypeContext {
+	return &TypeContext{filename: filename, packagePath: packagePath, resolve: resolve}
+}
+
+// withTypeParams gives a context in which the names of some type
+// parameters refer to them.
+func (ts *DataTypeStore) withTypeParams(ctx *TypeContext, typeParams []*DataTypeTypeParam) *TypeContext {
+	names := make(map[string]DataType)
+	for _, tp := range typeParams {
+		names[tp.name] = tp
+	}
+
+	return ts.withNames(ctx, names)
+}
+
+// withNames gives a context in which some extra names refer to types.
+func (ts *DataTypeStore) withNames(ctx *TypeContext, names map[string]DataType) *TypeContext {
+	inner := *ctx
+	inner.resolve = func(ident ASTIdentifier) (DataType, error) {
+		if dt, ok := names[ident.name]; ok && ident.packageName == "" && ident.name != "_" {
+			return dt, nil
+		}
+		return ts.resolveTypeName(ident, ctx)
+	}
+
+	return &inner
+}
+
+// MakeASTType converts a data type AST, as returned by Parser.parseDataType(),
+// into its canonical DataType.
+func (ts *DataTypeStore) MakeASTType(ast AST, ctx *TypeContext) (DataType, error) {
+	dt, err := ts.makeASTTypeOrConstraint(ast, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if isConstraintInterface(dt) {
+		return nil, NewError(ctx.filename, ast.Pos(), fmt.Sprint(TypeString(dt, ctx.packagePath), " can only be used as a type constraint"))
+	}
+
+	return dt, nil
+}
+
+// makeASTTypeOrConstraint converts a data type AST, which may also be an
+// interface which can only be used as a constraint.
+func (ts *DataTypeStore) makeASTTypeOrConstraint(ast AST, ctx *TypeContext) (DataType, error) {
+	switch a := ast.(type) {
+	case ASTIdentifier:
+		dt, err := ts.resolveTypeName(a, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if isGeneric(dt) {
+			return nil, NewError(ctx.filename, a.Pos(), fmt.Sprint("'", a.name, "' is a generic type so it has to be given type arguments"))
+		}
+		return dt, nil
+
+	case ASTIndex:
+		return ts.makeASTInstance(a.pos, a.expr, []AST{a.index}, ctx)
+
+	case ASTInstance:
+		return ts.makeASTInstance(a.pos, a.expr, a.typeArgs, ctx)
+
+	case ASTDataTypeUnion, ASTDataTypeTilde:
+		return nil, NewError(ctx.filename, ast.Pos(), "type terms like this can only be used in constraints")
+
+	case ASTDataTypeSlice:
+		elementType, err := ts.MakeASTType(a.elementType, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return ts.MakeSlice(elementType), nil
+
+	case ASTDataTypeArray:
+		length, err := ts.arrayLength(a.arraySize, ctx)
+		if err != nil {
+			return nil, err
+		}
+		elementType, err := ts.MakeASTType(a.elementType, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return ts.MakeArray(length, elementType), nil
+
+	case ASTDataTypePointer:
+		elementType, err := ts.MakeASTType(a.elementType, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return ts.MakePointer(elementType), nil
+
+	case ASTDataTypeMap:
+		keyType, err := ts.MakeASTType(a.keyType, ctx)
+		if err != nil {
+			return nil, err
+		}
+		valueType, err := ts.MakeASTType(a.valueType, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return ts.MakeMap(keyType, valueType), nil
+
+	case ASTDataTypeChan:
+		elementType, err := ts.MakeASTType(a.elementType, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return ts.MakeChan(a.dir, elementType), nil
+
+	case ASTDataTypeFunc:
+		return ts.makeASTSignature(a.params, a.returns, ctx)
+
+	case ASTDataTypeStruct:
+		return ts.makeASTStruct(a, ctx)
+
+	case ASTDataTypeInterface:
+		return ts.makeASTInterface(a, ctx)
+	}
+
+	return nil, NewError(ctx.filename, ast.Pos(), "I was expecting a data type here")
+}
+
+// makeASTTypeOrGeneric converts a data type AST which may be a generic type
+// which hasn't been instantiated.
+func (ts *DataTypeStore) makeASTTypeOrGeneric(ast AST, ctx *TypeContext) (DataType, error) {
+	if ident, ok := ast.(ASTIdentifier); ok {
+		return ts.resolveTypeName(ident, ctx)
+	}
+
+	return ts.MakeASTType(ast, ctx)
+}
+
+// resolveTypeName finds the type which a type name refers to.
+func (ts *DataTypeStore) resolveTypeName(ident ASTIdentifier, ctx *TypeContext) (DataType, error) {
+	if ctx.resolve != nil {
+		return ctx.resolve(ident)
+	}
+
+	if ident.packageName == "" {
+		// it's either declared in this package or predeclared.
+		dt := ts.LookupName(qualifiedName(ctx.packagePath, ident.name))
+		if dt == nil {
+			dt = ts.LookupName(ident.name)
+		}
+		if dt != nil {
+			return dt, nil
+		}
+	} else {
+		dt := ts.LookupName(qualifiedName(ident.packageName, ident.name))
+		if dt != nil {
+			return dt, nil
+		}
+	}
+
+	return nil, NewError(ctx.filename, ident.Pos(), fmt.Sprint("I don't know of any type called '", ident.name, "'"))
+}
+
+// arrayLength evaluates the length of an array type.
+func (ts *DataTypeStore) arrayLength(ast AST, ctx *TypeContext) (int64, error) {
+	if ctx.length != nil {
+		return ctx.length(ast)
+	}
+
+	if v, ok := ast.(ASTValue); ok {
+		switch val := v.val.(type) {
+		case ValueConstant:
+			if n, ok := val.val.Int64(); ok && n >= 0 {
+				return n, nil
+			}
+		case ValueUint:
+			if val.val <= 1<<63-1 {
+				return int64(val.val), nil
+			}
+		case ValueInt:
+			if val.val >= 0 {
+				return val.val, nil
+			}
+		}
+	}
+
+	return 0, NewError(ctx.filename, ast.Pos(), "array lengths have to be a non-negative whole number")
+}
+
+// makeASTSignature converts the parameters and results of a function
+// type or method into a canonical function type.
+func (ts *DataTypeStore) makeASTSignature(paramASTs []AST, returnASTs []AST, ctx *TypeContext) (*DataTypeFunc, error) {
+	var params []DataType
+	var paramTypeASTs []AST
+	variadic := false
+	for i, ast := range paramASTs {
+		param := ast.(ASTParameterDecl)
+		if variadic {
+			return nil, NewError(ctx.filename, param.Pos(), "only the last parameter can have a '...'")
+		}
+
+		typ, err := ts.MakeASTType(param.typ, ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := param.identifier.(ASTEllipsis); ok {
+			// the parser gives "a ...T" as a parameter for "a" followed by
+			// one for the ellipsis. they're really the same parameter.
+			variadic = true
+			if i > 0 && paramTypeASTs[i-1].Pos().Equals(param.typ.Pos()) {
+				params = params[:len(params)-1]
+			}
+			typ = ts.MakeSlice(typ)
+		}
+
+		params = append(params, typ)
+		paramTypeASTs = append(paramTypeASTs, param.typ)
+	}
+
+	returns := make([]DataType, len(returnASTs))
+	for i, ast := range returnASTs {
+		typ, err := ts.MakeASTType(ast.(ASTParameterDecl).typ, ctx)
+		if err != nil {
+			return nil, err
+		}
+		returns[i] = typ
+	}
+
+	return ts.MakeFunc(params, returns, variadic), nil
+}
+
+// makeASTStruct converts a struct type AST into a canonical struct type.
+func (ts *DataTypeStore) makeASTStruct(ast ASTDataTypeStruct, ctx *TypeContext) (DataType, error) {
+	fields := make([]DataTypeField, len(ast.fields))
+	seen := make(map[string]bool)
+	for i, fieldAST := range ast.fields {
+		f := fieldAST.(ASTDataTypeField)
+		typ, err := ts.MakeASTType(f.typ, ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var name string
+		embedded := f.identifier == nil
+		if embedded {
+			// embedded fields are named after their type.
+			typeName := f.typ
+			if ptr, ok := typeName.(ASTDataTypePointer); ok {
+				typeName = ptr.elementType
+			}
+			ident, ok := typeName.(ASTIdentifier)
+			if !ok {
+				return nil, NewError(ctx.filename, f.Pos(), "an embedded field has to be a type name or a pointer to a type name")
+			}
+			name = ident.name
+		} else {
+			name = f.identifier.(ASTIdentifier).name
+		}
+
+		if name != "_" {
+			if seen[name] {
+				return nil, NewError(ctx.filename, f.Pos(), fmt.Sprint("there's already a field called '", name, "' in this struct"))
+			}
+			seen[name] = true
+		}
+
+		fields[i] = DataTypeField{name, exportPackage(name, ctx.packagePath), typ, embedded, f.tag}
+	}
+
+	return ts.MakeStruct(fields), nil
+}
+
+// makeASTInterface converts an interface type AST into a canonical
+// interface type. Embedded interfaces have their methods copied in and
+// their type sets intersected with the interface's.
+func (ts *DataTypeStore) makeASTInterface(ast ASTDataTypeInterface, ctx *TypeContext) (DataType, error) {
+	var methods []DataTypeMethod
+	var terms []DataTypeTerm // nil if the type set isn't limited by terms
+	comparable := false
+	seen := make(map[string]*DataTypeFunc)
+	add := func(method DataTypeMethod, pos SrcSpan) error {
+		if sig, ok := seen[method.name]; ok {
+			// the same method can come from several embedded interfaces.
+			if sig == method.sig {
+				return nil
+			}
+			return NewError(ctx.filename, pos, fmt.Sprint("there's more than one method called '", method.name, "' in this interface"))
+		}
+		seen[method.name] = method.sig
+		methods = append(methods, method)
+		return nil
+	}
+	restrict := func(elemTerms []DataTypeTerm) {
+		if terms == nil {
+			terms = elemTerms
+		} else {
+			terms = intersectTerms(terms, elemTerms)
+		}
+	}
+
+	for _, methodAST := range ast.methods {
+		switch m := methodAST.(type) {
+		case ASTDataTypeMethodSpec:
+			sig, err := ts.makeASTSignature(m.params, m.returns, ctx)
+			if err != nil {
+				return nil, err
+			}
+			err = add(DataTypeMethod{m.name, exportPackage(m.name, ctx.packagePath), sig}, m.pos)
+			if err != nil {
+				return nil, err
+			}
+
+		case ASTDataTypeUnion, ASTDataTypeTilde:
+			unionTerms, err := ts.makeASTUnion(methodAST, ctx)
+			if err != nil {
+				return nil, err
+			}
+			if unionTerms != nil {
+				restrict(unionTerms)
+			}
+
+		default:
+			// it's an embedded interface or a single type term.
+			dt, err := ts.makeASTTypeOrConstraint(methodAST, ctx)
+			if err != nil {
+				return nil, err
+			}
+			if named, ok := dt.(*DataTypeNamed); ok && named.Underlying() == nil {
+				return nil, NewError(ctx.filename, methodAST.Pos(), "only interfaces can be embedded in an interface")
+			}
+			embedded, ok := Underlying(dt).(*DataTypeInterface)
+			if !ok {
+				if _, ok := dt.(*DataTypeTypeParam); ok {
+					return nil, NewError(ctx.filename, methodAST.Pos(), "a type parameter can't be embedded in an interface")
+				}
+				restrict([]DataTypeTerm{{false, dt}})
+				continue
+			}
+			for _, method := range embedded.methods {
+				err = add(method, methodAST.Pos())
+				if err != nil {
+					return nil, err
+				}
+			}
+			if embedded.restricted {
+				restrict(embedded.terms)
+			}
+			comparable = comparable || embedded.comparable
+		}
+	}
+
+	if terms == nil && !comparable {
+		return ts.MakeInterface(methods), nil
+	}
+
+	return ts.MakeConstraint(methods, terms, comparable), nil
+}
+
+// makeASTUnion converts a union of type terms in an interface. It returns
+// nil if the union allows any type - eg. 'int | any'.
+func (ts *DataTypeStore) makeASTUnion(ast AST, ctx *TypeContext) ([]DataTypeTerm, error) {
+	termASTs := []AST{ast}
+	if union, ok := ast.(ASTDataTypeUnion); ok {
+		termASTs = union.terms
+	}
+
+	terms := []DataTypeTerm{}
+	unrestricted := false
+	for _, termAST := range termASTs {
+		tilde := false
+		if t, ok := termAST.(ASTDataTypeTilde); ok {
+			tilde = true
+			termAST = t.typ
+		}
+
+		dt, err := ts.makeASTTypeOrConstraint(termAST, ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if iface, ok := Underlying(dt).(*DataTypeInterface); ok && !tilde {
+			// interfaces in a union add their type sets.
+			if len(iface.methods) > 0 || iface.comparable {
+				return nil, NewError(ctx.filename, termAST.Pos(), fmt.Sprint(TypeString(dt, ctx.packagePath), " has methods or is comparable so it can't be used in a union"))
+			}
+			if !iface.restricted {
+				unrestricted = true
+			}
+			terms = append(terms, iface.terms...)
+			continue
+		}
+
+		if _, ok := dt.(*DataTypeTypeParam); ok {
+			return nil, NewError(ctx.filename, termAST.Pos(), "a type parameter can't be used as a type term")
+		}
+		if tilde && Underlying(dt) != dt {
+			return nil, NewError(ctx.filename, termAST.Pos(), fmt.Sprint("the type in a '~' term has to be its own underlying type, and ", TypeString(dt, ctx.packagePath), " isn't"))
+		}
+		terms = append(terms, DataTypeTerm{tilde, dt})
+	}
+
+	if unrestricted {
+		return nil, nil
+	}
+
+	return terms, nil
+}
+
+// exportPackage returns the package import path which qualifies an
+// identifier when comparing types. Exported names are the same in every
+// package so they're not qualified.
+func exportPackage(name string, packagePath string) string {
+	if isExported(name) {
+		return ""
+	}
+
+	return packagePath
+}
+
+// isExported returns true if a name starts with an upper case letter.
+func isExported(name string) bool {
+	for _, ch := range name {
+		return unicode.IsUpper(ch)
+	}
+
+	return false
 }
@@ -0,0 +1,164 @@
+package golightly
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestDataTypeStoreInterning(t *testing.T) {
+	ts := NewDataTypeStore()
+
+	if ts.MakeSlice(ts.IntType()) != ts.MakeSlice(ts.IntType()) {
+		t.Error("slices of the same type aren't identical")
+	}
+	if ts.MakeSlice(ts.IntType()) == ts.MakeSlice(ts.StringType()) {
+		t.Error("slices of different types are identical")
+	}
+	if ts.MakeArray(4, ts.IntType()) != ts.MakeArray(4, ts.IntType()) {
+		t.Error("arrays of the same length and type aren't identical")
+	}
+	if ts.MakeArray(4, ts.IntType()) == ts.MakeArray(5, ts.IntType()) {
+		t.Error("arrays of different lengths are identical")
+	}
+	if ts.MakeSlice(ts.IntType()) == ts.MakePointer(ts.IntType()) {
+		t.Error("a slice and a pointer are identical")
+	}
+
+	m1 := ts.MakeMap(ts.StringType(), ts.MakeSlice(ts.IntType()))
+	m2 := ts.MakeMap(ts.StringType(), ts.MakeSlice(ts.IntType()))
+	if m1 != m2 {
+		t.Error("maps of the same types aren't identical")
+	}
+
+	if ts.MakeChan(ChanDirectionIn, ts.IntType()) == ts.MakeChan(ChanDirectionBi, ts.IntType()) {
+		t.Error("channels with different directions are identical")
+	}
+
+	f1 := ts.MakeFunc([]DataType{ts.IntType(), ts.MakeSlice(ts.StringType())}, nil, true)
+	f2 := ts.MakeFunc([]DataType{ts.IntType(), ts.MakeSlice(ts.StringType())}, nil, true)
+	f3 := ts.MakeFunc([]DataType{ts.IntType(), ts.MakeSlice(ts.StringType())}, nil, false)
+	if f1 != f2 {
+		t.Error("identical function signatures aren't identical")
+	}
+	if f1 == f3 {
+		t.Error("variadic and non-variadic functions are identical")
+	}
+
+	s1 := ts.MakeStruct([]DataTypeField{{name: "a", pkg: "main", typ: ts.IntType()}, {name: "B", typ: ts.StringType()}})
+	s2 := ts.MakeStruct([]DataTypeField{{name: "a", pkg: "main", typ: ts.IntType()}, {name: "B", typ: ts.StringType()}})
+	s3 := ts.MakeStruct([]DataTypeField{{name: "B", typ: ts.StringType()}, {name: "a", pkg: "main", typ: ts.IntType()}})
+	s4 := ts.MakeStruct([]DataTypeField{{name: "a", pkg: "other", typ: ts.IntType()}, {name: "B", typ: ts.StringType()}})
+	if s1 != s2 {
+		t.Error("identical structs aren't identical")
+	}
+	if s1 == s3 {
+		t.Error("structs with different field order are identical")
+	}
+	if s1 == s4 {
+		t.Error("structs with unexported fields from different packages are identical")
+	}
+
+	i1 := ts.MakeInterface([]DataTypeMethod{{name: "B", sig: f1}, {name: "A", sig: f3}})
+	i2 := ts.MakeInterface([]DataTypeMethod{{name: "A", sig: f3}, {name: "B", sig: f1}})
+	if i1 != i2 {
+		t.Error("interfaces with the same methods in a different order aren't identical")
+	}
+}
+
+func TestDataTypeStoreConcurrent(t *testing.T) {
+	ts := NewDataTypeStore()
+
+	const workers = 8
+	results := make([]DataType, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = ts.MakeMap(ts.StringType(), ts.MakeSlice(ts.MakePointer(ts.IntType())))
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 1; i < workers; i++ {
+		if results[i] != results[0] {
+			t.Error("concurrently created types aren't identical")
+		}
+	}
+}
+
+func TestMakeASTType(t *testing.T) {
+	ts := NewDataTypeStore()
+	ctx := NewTypeContext("test.go", "main", nil)
+	pos := SrcSpan{SrcLoc{1, 1}, SrcLoc{1, 1}}
+	intAST := ASTIdentifier{pos, "", "int"}
+
+	typ, err := ts.MakeASTType(ASTDataTypeMap{pos, ASTIdentifier{pos, "", "string"}, ASTDataTypeSlice{pos, intAST}}, ctx)
+	if err != nil {
+		t.Error("error making type: ", err)
+		return
+	}
+	if typ != ts.MakeMap(ts.StringType(), ts.MakeSlice(ts.IntType())) {
+		t.Error("map type from AST isn't canonical")
+	}
+
+	typ, err = ts.MakeASTType(ASTDataTypeArray{pos, ASTValue{pos, ValueUint{ts.UintType(), 3}}, intAST}, ctx)
+	if err != nil {
+		t.Error("error making type: ", err)
+		return
+	}
+	if typ != ts.MakeArray(3, ts.IntType()) {
+		t.Error("array type from AST isn't canonical")
+	}
+
+	structAST := ASTDataTypeStruct{pos, []AST{
+		ASTDataTypeField{ASTIdentifier{pos, "", "x"}, intAST, ""},
+		ASTDataTypeField{ASTIdentifier{pos, "", "y"}, intAST, `json:"y"`},
+	}}
+	s1, err := ts.MakeASTType(structAST, ctx)
+	if err != nil {
+		t.Error("error making type: ", err)
+		return
+	}
+	s2, _ := ts.MakeASTType(structAST, ctx)
+	if s1 != s2 {
+		t.Error("struct type from AST isn't canonical")
+	}
+
+	_, err = ts.MakeASTType(ASTDataTypeSlice{pos, ASTIdentifier{pos, "", "nonsense"}}, ctx)
+	if err == nil {
+		t.Error("unknown type name wasn't reported")
+	}
+}
+
+func TestPredeclaredTypes(t *testing.T) {
+	ts := NewDataTypeStoreWordSize(DataSize32)
+
+	names := []string{"bool", "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64",
+		"uintptr", "float32", "float64", "complex64", "complex128", "byte", "rune", "string", "error", "any"}
+	for _, name := range names {
+		if ts.LookupName(name) == nil {
+			t.Error("missing predeclared type: ", name)
+		}
+	}
+
+	if ts.LookupName("float") != nil {
+		t.Error("'float' isn't a Go type")
+	}
+	if ts.LookupName("byte") != ts.LookupName("uint8") || ts.LookupName("rune") != ts.LookupName("int32") {
+		t.Error("byte and rune should be aliases")
+	}
+	if ts.IntType() == ts.Int32Type() || ts.UintType() == ts.UintptrType() {
+		t.Error("int, uint and uintptr should be distinct types")
+	}
+	if ts.LookupName("any") != ts.MakeInterface(nil) {
+		t.Error("any should be the empty interface")
+	}
+
+	if ts.Bits(ts.IntType().(DataTypeSized)) != 32 || ts.Bits(ts.UintptrType().(DataTypeSized)) != 32 {
+		t.Error("int and uintptr should follow the word size")
+	}
+	if ts.Bits(ts.Int8Type().(DataTypeSized)) != 8 || ts.Bits(ts.Complex128Type().(DataTypeSized)) != 128 {
+		t.Error("explicitly sized types have the wrong size")
+	}
+}
@@ -1,11 +1,40 @@
 package golightly
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxErrors is how many errors are reported before the rest are left out,
+// like gc does.
+const maxErrors = 10
+
+// type Severity says how serious a diagnostic is.
+type Severity int
+
+const (
+	SeverityError   Severity = iota // the program can't be compiled.
+	SeverityWarning                 // the program can be compiled but it's probably a mistake.
+	SeverityNote                    // more about a nearby error.
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityNote:
+		return "note"
+	default:
+		return "error"
+	}
+}
 
 type Error struct {
 	filename string
 	pos      SrcSpan
 	message  string
+	severity Severity
 }
 
 func NewError(filename string, pos SrcSpan, message string) *Error {
@@ -17,6 +46,123 @@ func NewError(filename string, pos SrcSpan, message string) *Error {
 	return e
 }
 
+// NewErrorf creates an error with a formatted message. Any DataType
+// arguments are written in Go syntax, qualified with their package unless
+// they're from the package relativeTo.
+func NewErrorf(filename string, pos SrcSpan, relativeTo string, format string, args ...interface{}) *Error {
+	for i, arg := range args {
+		if dt, ok := arg.(DataType); ok {
+			args[i] = TypeString(dt, relativeTo)
+		}
+	}
+
+	return NewError(filename, pos, fmt.Sprintf(format, args...))
+}
+
+// Severity says whether it's an error, a warning or a note.
+func (e *Error) Severity() Severity {
+	return e.severity
+}
+
 func (e *Error) Error() string {
-	return fmt.Sprint(e.filename, ":", e.pos.start.Line, ": ", e.message)
+	var msg strings.Builder
+	if e.filename != "" {
+		fmt.Fprint(&msg, e.filename, ":", e.pos.start.Line, ": ")
+	}
+	if e.severity != SeverityError {
+		fmt.Fprint(&msg, e.severity, ": ")
+	}
+	msg.WriteString(e.message)
+
+	return msg.String()
+}
+
+// type ErrorList is the diagnostics from compiling a program. Files and
+// packages are compiled concurrently so they can be found in any order -
+// Sort puts them in a fixed order.
+type ErrorList []*Error
+
+// Add adds the diagnostics from an error to the list. Lists and import
+// cycles are broken up into their diagnostics. Other errors have no
+// position.
+func (el *ErrorList) Add(err error) {
+	switch e := err.(type) {
+	case nil:
+	case *Error:
+		*el = append(*el, e)
+	case ErrorList:
+		*el = append(*el, e...)
+	case *ImportCycleError:
+		*el = append(*el, e.diagnostics()...)
+	default:
+		*el = append(*el, NewError("", SrcSpan{}, err.Error()))
+	}
+}
+
+// Sort sorts the list by file and position, removing any diagnostics
+// which are the same as another.
+func (el ErrorList) Sort() ErrorList {
+	sort.SliceStable(el, func(i, j int) bool {
+		a, b := el[i], el[j]
+		switch {
+		case a.filename != b.filename:
+			return a.filename < b.filename
+		case a.pos.start != b.pos.start:
+			return a.pos.start.Before(b.pos.start)
+		case a.severity != b.severity:
+			return a.severity < b.severity
+		default:
+			return a.message < b.message
+		}
+	})
+
+	var sorted ErrorList
+	for i, err := range el {
+		if i > 0 && *err == *el[i-1] {
+			continue
+		}
+		sorted = append(sorted, err)
+	}
+
+	return sorted
+}
+
+// Limit leaves out the errors after the first max and the warnings and
+// notes after them, adding a "too many errors" error in their place. If
+// max is 0 or less nothing is left out.
+func (el ErrorList) Limit(max int) ErrorList {
+	count := 0
+	for i, err := range el {
+		if err.severity != SeverityError || max <= 0 {
+			continue
+		}
+
+		count++
+		if count > max {
+			return append(el[:i:i], NewError(err.filename, err.pos, "too many errors"))
+		}
+	}
+
+	return el
+}
+
+// Err gives the list as an error if there are any errors in it. It gives
+// nil if there are only warnings and notes.
+func (el ErrorList) Err() error {
+	for _, err := range el {
+		if err.severity == SeverityError {
+			return el
+		}
+	}
+
+	return nil
+}
+
+func (el ErrorList) Error() string {
+	lines := make([]string, len(el))
+	for i, err := range el {
+		lines[i] = err.Error()
+	}
+
+	return strings.Join(lines, "\n")
 }
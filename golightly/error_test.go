@@ -0,0 +1,70 @@
+package golightly
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestErrorListSort(t *testing.T) {
+	at := func(line int, col int) SrcSpan {
+		return SrcSpan{SrcLoc{line, col}, SrcLoc{line, col + 1}}
+	}
+	warning := NewError("a.go", at(3, 1), "unused")
+	warning.severity = SeverityWarning
+	note := NewError("a.go", at(3, 1), "imported here")
+	note.severity = SeverityNote
+
+	var el ErrorList
+	el.Add(NewError("b.go", at(1, 1), "first in b"))
+	el.Add(ErrorList{note, warning, NewError("a.go", at(3, 1), "problem")})
+	el.Add(NewError("a.go", at(3, 1), "problem"))
+	el.Add(NewError("a.go", at(2, 5), "second"))
+	el.Add(NewError("a.go", at(2, 1), "first"))
+	el.Add(nil)
+
+	var messages []string
+	for _, err := range el.Sort() {
+		messages = append(messages, err.Error())
+	}
+	expected := []string{
+		"a.go:2: first",
+		"a.go:2: second",
+		"a.go:3: problem",
+		"a.go:3: warning: unused",
+		"a.go:3: note: imported here",
+		"b.go:1: first in b",
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Error("expected ", expected, " but got ", messages)
+	}
+}
+
+func TestErrorListLimit(t *testing.T) {
+	var el ErrorList
+	for line := 1; line <= 5; line++ {
+		el.Add(NewError("a.go", SrcSpan{SrcLoc{line, 1}, SrcLoc{line, 1}}, "problem"))
+	}
+
+	if limited := el.Limit(0); len(limited) != 5 {
+		t.Error("no limit should keep all the errors: ", limited)
+	}
+	if limited := el.Limit(5); len(limited) != 5 {
+		t.Error("a limit of 5 should keep all the errors: ", limited)
+	}
+	limited := el.Limit(3)
+	if len(limited) != 4 || limited[3].Error() != "a.go:4: too many errors" {
+		t.Error("expected 3 errors and too many errors but got: ", limited)
+	}
+	if len(el) != 5 || el[3].message != "problem" {
+		t.Error("Limit changed the original list: ", el)
+	}
+
+	warning := NewError("a.go", SrcSpan{}, "unused")
+	warning.severity = SeverityWarning
+	if (ErrorList{warning}).Err() != nil {
+		t.Error("a list with only warnings shouldn't be an error")
+	}
+	if (ErrorList{}).Err() != nil || el.Err() == nil {
+		t.Error("Err should only give a list with errors in it")
+	}
+}
@@ -0,0 +1,1264 @@
+package golightly
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// type EscapeDecision says whether a local variable or an allocation can
+// live in its function's stack frame or has to be allocated on the heap.
+type EscapeDecision struct {
+	filename string
+	pos      SrcSpan
+	what     string // the variable's name or a description of the allocation
+	variable bool   // true for a variable, false for an allocation
+	heap     bool   // true if it has to be on the heap
+	reason   string // why it has to be on the heap
+}
+
+// Heap returns true if the variable or allocation has to be on the heap.
+func (ed *EscapeDecision) Heap() bool {
+	return ed.heap
+}
+
+// Reason explains why the variable or allocation has to be on the heap.
+func (ed *EscapeDecision) Reason() string {
+	return ed.reason
+}
+
+func (ed *EscapeDecision) String() string {
+	var msg string
+	switch {
+	case ed.heap && ed.variable:
+		msg = fmt.Sprint("moved to heap: ", ed.what, " - ", ed.reason)
+	case ed.heap:
+		msg = fmt.Sprint(ed.what, " escapes to heap - ", ed.reason)
+	default:
+		msg = fmt.Sprint(ed.what, " does not escape")
+	}
+
+	return fmt.Sprint(ed.filename, ":", ed.pos.start.Line, ": ", msg)
+}
+
+// type EscapeInfo holds the results of escape analysis for a package.
+type EscapeInfo struct {
+	decisions []*EscapeDecision           // every decision made
+	byPos     map[exprKey]*EscapeDecision // the decisions for variables and allocations by where they are
+	boxes     map[exprKey]*EscapeDecision // the decisions for values converted to interfaces by where they are
+}
+
+// Decisions returns the decisions for every local variable and allocation,
+// in source order.
+func (ei *EscapeInfo) Decisions() []*EscapeDecision {
+	return ei.decisions
+}
+
+// Lookup finds the decision for a variable, given the identifier which
+// declares it, or for an allocation - a composite literal, a call to new
+// or make or a function literal. It returns nil if there isn't one.
+func (ei *EscapeInfo) Lookup(filename string, ast AST) *EscapeDecision {
+	return ei.byPos[exprKey{filename, ast.Pos()}]
+}
+
+// LookupConversion finds the decision for the copy of a value which is
+// made when it's converted to an interface. It returns nil if there isn't
+// one.
+func (ei *EscapeInfo) LookupConversion(filename string, ast AST) *EscapeDecision {
+	return ei.boxes[exprKey{filename, ast.Pos()}]
+}
+
+// Report explains every decision, one per line, like "gc -m" does.
+func (ei *EscapeInfo) Report() string {
+	var report strings.Builder
+	for _, ed := range ei.decisions {
+		report.WriteString(ed.String())
+		report.WriteString("\n")
+	}
+
+	return report.String()
+}
+
+// noLeak is the leak level of a parameter which doesn't leak.
+const noLeak = 1 << 30
+
+// the largest variables and allocations which can be in a stack frame.
+// they're the same as the gc compiler's limits.
+const (
+	maxStackVarSize      = 10 * 1024 * 1024 // for declared variables
+	maxImplicitStackSize = 64 * 1024        // for new, make and &T{...}
+)
+
+// type escapeLoc is somewhere a value can be stored - a variable, an
+// allocation or the heap.
+type escapeLoc struct {
+	decision  *EscapeDecision // what's decided about it. nil for the heap and temporaries.
+	name      string          // what it is, for explanations
+	edges     []escapeEdge    // the values which flow into it
+	loopDepth int             // how many loops it's declared inside
+	fnDepth   int             // how many function literals it's declared inside
+	param     int             // which parameter it is, or -1
+	escapes   bool            // true if it has to be on the heap
+	reason    string          // why it has to be on the heap
+
+	// the following are used while walking the graph from a root.
+	walkgen int    // which walk the following are from
+	derefs  int    // the fewest dereferences needed to get from the root to here
+	via     string // the reason given on the nearest edge towards the root
+}
+
+// type escapeEdge is a flow of a value into a location. derefs is how
+// many times the value is dereferenced on the way - -1 if its address is
+// taken.
+type escapeEdge struct {
+	src    *escapeLoc
+	derefs int
+	reason string
+}
+
+// type escapeHole is somewhere an expression's value is going. A nil loc
+// means the value is discarded.
+type escapeHole struct {
+	loc    *escapeLoc
+	derefs int
+	reason string
+}
+
+// addr gives a hole for the address of a value going into h.
+func (h escapeHole) addr() escapeHole {
+	h.derefs--
+	return h
+}
+
+// deref gives a hole for the value a pointer going into h points at.
+func (h escapeHole) deref() escapeHole {
+	h.derefs++
+	return h
+}
+
+// type escapeAnalysis decides where variables and allocations can live.
+//
+// Each function is turned into a graph of locations with edges for the
+// values which flow between them, counting how many times pointers are
+// dereferenced or addresses are taken on the way. Anything whose address
+// can reach the heap has to be on the heap. So does anything whose
+// address is kept by something which lives longer than it does, like a
+// variable declared outside the loop it's declared in or outside the
+// function literal it's declared in. Storing through a pointer, sending on
+// a channel, returning and calling functions which can't be seen into all
+// count as storing to the heap. Like the gc compiler, variables and
+// allocations too large for a stack frame, and slices made with a size
+// which isn't constant, are always on the heap.
+//
+// Calls to the package's own functions and methods use a summary of which
+// of their parameters leak to the heap, and how many dereferences away.
+// The summaries are worked out by analysing every function until they
+// stop changing.
+type escapeAnalysis struct {
+	ts          *DataTypeStore
+	info        *TypeInfo
+	packagePath string
+	summaries   map[exprKey][]int // how far each parameter of each function leaks, by declaration. receivers come first.
+	changed     bool              // true if a summary changed
+
+	// the following are for the function being analysed.
+	filename  string
+	heap      *escapeLoc
+	locs      []*escapeLoc
+	vars      map[exprKey]*escapeLoc // the variables by where they're declared
+	allocs    []*escapeLoc           // the allocations, including conversions to interfaces
+	boxes     map[*escapeLoc]bool    // the allocations made by conversions to interfaces
+	closures  []*escapeLoc           // the function literals being analysed, innermost last
+	captured  map[*escapeLoc]map[*escapeLoc]bool
+	sigs      []*DataTypeFunc // the signatures of the functions being analysed, innermost last
+	loopDepth int
+	walkgen   int
+}
+
+// type escapeFunc is a function declaration to analyse.
+type escapeFunc struct {
+	filename string
+	decl     ASTFunctionDecl
+}
+
+// AnalyseEscapes decides which local variables and allocations in a
+// package's functions have to be on the heap. The package must have been
+// type checked without errors. Functions which can't be reached aren't
+// analysed.
+func AnalyseEscapes(ts *DataTypeStore, info *TypeInfo, packagePath string, files []*sourceFile) *EscapeInfo {
+	ea := &escapeAnalysis{ts: ts, info: info, packagePath: packagePath, summaries: make(map[exprKey][]int)}
+
+	var funcs []escapeFunc
+	for _, sf := range files {
+		for _, decl := range sf.ast.(ASTTopLevel).topLevelDecls {
+			if fd, ok := decl.(ASTFunctionDecl); ok && fd.body != nil && info.isReachableDecl(sf.fileName, fd) {
+				funcs = append(funcs, escapeFunc{sf.fileName, fd})
+				n := len(parameterList(fd.params))
+				if fd.receiver != nil {
+					n++
+				}
+				leaks := make([]int, n)
+				for i := range leaks {
+					leaks[i] = noLeak
+				}
+				ea.summaries[exprKey{sf.fileName, fd.pos}] = leaks
+			}
+		}
+	}
+
+	// the summaries only ever get worse so this finishes.
+	for {
+		ea.changed = false
+		for _, fn := range funcs {
+			ea.function(fn, nil)
+		}
+		if !ea.changed {
+			break
+		}
+	}
+
+	ei := &EscapeInfo{byPos: make(map[exprKey]*EscapeDecision), boxes: make(map[exprKey]*EscapeDecision)}
+	for _, fn := range funcs {
+		ea.function(fn, ei)
+	}
+
+	sort.SliceStable(ei.decisions, func(i, j int) bool {
+		a, b := ei.decisions[i], ei.decisions[j]
+		if a.filename != b.filename {
+			return a.filename < b.filename
+		}
+		if a.pos.start.Line != b.pos.start.Line {
+			return a.pos.start.Line < b.pos.start.Line
+		}
+		return a.pos.start.Column < b.pos.start.Column
+	})
+
+	return ei
+}
+
+// function analyses a function declaration, updating its summary. If ei
+// isn't nil the decisions are added to it.
+func (ea *escapeAnalysis) function(fn escapeFunc, ei *EscapeInfo) {
+	ea.filename = fn.filename
+	ea.heap = &escapeLoc{name: "the heap", escapes: true, param: -1}
+	ea.locs = nil
+	ea.vars = make(map[exprKey]*escapeLoc)
+	ea.allocs = nil
+	ea.boxes = make(map[*escapeLoc]bool)
+	ea.closures = nil
+	ea.captured = make(map[*escapeLoc]map[*escapeLoc]bool)
+	ea.loopDepth = 0
+
+	d := fn.decl
+	param := 0
+	if d.receiver != nil {
+		recv := d.receiver.(ASTReceiver)
+		if recv.name != "" {
+			ea.newVar(recv.name, recv.pos).param = param
+		}
+		param++
+	}
+	for _, p := range parameterList(d.params) {
+		if p.name != nil {
+			ident := p.name.(ASTIdentifier)
+			ea.newVar(ident.name, ident.pos).param = param
+		}
+		param++
+	}
+
+	sig := ea.funcType(d)
+	ea.sigs = []*DataTypeFunc{sig}
+	ea.namedResults(d.returns)
+	ea.stmtList(d.body.(ASTBlock).statements)
+	ea.walkAll()
+
+	// update the summary.
+	leaks := ea.summaries[exprKey{fn.filename, d.pos}]
+	for loc, derefs := range ea.walkLeaks() {
+		if derefs < leaks[loc.param] {
+			leaks[loc.param] = derefs
+			ea.changed = true
+		}
+	}
+
+	if ei == nil {
+		return
+	}
+
+	for _, loc := range ea.locs {
+		if loc.decision == nil {
+			continue
+		}
+		loc.decision.heap = loc.escapes
+		loc.decision.reason = loc.reason
+		ei.decisions = append(ei.decisions, loc.decision)
+		key := exprKey{loc.decision.filename, loc.decision.pos}
+		if ea.boxes[loc] {
+			ei.boxes[key] = loc.decision
+		} else {
+			ei.byPos[key] = loc.decision
+		}
+	}
+}
+
+// funcType finds the signature of a function declaration. It's nil if the
+// declaration had errors.
+func (ea *escapeAnalysis) funcType(d ASTFunctionDecl) *DataTypeFunc {
+	sym := ea.info.defs[exprKey{ea.filename, d.pos}]
+	if sym == nil {
+		return nil
+	}
+
+	sig, _ := sym.typ.(*DataTypeFunc)
+	return sig
+}
+
+// namedResults creates variables for a function's named results. They're
+// returned so their values go to the heap.
+func (ea *escapeAnalysis) namedResults(returns []AST) {
+	for _, ret := range returns {
+		if ident, ok := ret.(ASTParameterDecl).identifier.(ASTIdentifier); ok {
+			loc := ea.newVar(ident.name, ident.pos)
+			ea.flow(ea.heapHole("returned"), loc)
+		}
+	}
+}
+
+// newLoc creates a location in the function being analysed.
+func (ea *escapeAnalysis) newLoc(name string) *escapeLoc {
+	loc := &escapeLoc{name: name, loopDepth: ea.loopDepth, fnDepth: len(ea.closures), param: -1}
+	ea.locs = append(ea.locs, loc)
+	return loc
+}
+
+// newVar creates a location for a local variable.
+func (ea *escapeAnalysis) newVar(name string, pos SrcSpan) *escapeLoc {
+	loc := ea.newLoc("'" + name + "'")
+	if name != "_" {
+		loc.decision = &EscapeDecision{filename: ea.filename, pos: pos, what: name, variable: true}
+	}
+	if sym := ea.info.defs[exprKey{ea.filename, pos}]; sym != nil && sym.typ != nil && ea.ts.Sizeof(sym.typ) > maxStackVarSize {
+		ea.forceHeap(loc, "too large for stack")
+	}
+	ea.vars[exprKey{ea.filename, pos}] = loc
+	return loc
+}
+
+// newAlloc creates a location for an allocation.
+func (ea *escapeAnalysis) newAlloc(what string, pos SrcSpan) *escapeLoc {
+	loc := ea.newLoc(what)
+	loc.decision = &EscapeDecision{filename: ea.filename, pos: pos, what: what}
+	ea.allocs = append(ea.allocs, loc)
+	return loc
+}
+
+// forceHeap puts a location on the heap whatever flows to it, like the gc
+// compiler does for things it can't or won't put in a stack frame.
+func (ea *escapeAnalysis) forceHeap(loc *escapeLoc, reason string) {
+	loc.escapes = true
+	loc.reason = reason
+}
+
+// heapHole gives a hole for values which go to the heap.
+func (ea *escapeAnalysis) heapHole(reason string) escapeHole {
+	return escapeHole{ea.heap, 0, reason}
+}
+
+// flow records that a value flows into a hole.
+func (ea *escapeAnalysis) flow(h escapeHole, src *escapeLoc) {
+	if h.loc == nil || h.loc == src && h.derefs >= 0 {
+		return
+	}
+
+	h.loc.edges = append(h.loc.edges, escapeEdge{src, h.derefs, h.reason})
+}
+
+// typeString gives a type as it's written in the package.
+func (ea *escapeAnalysis) typeString(dt DataType) string {
+	if dt == nil {
+		return "?"
+	}
+	return TypeString(dt, ea.packagePath)
+}
+
+// typeOf gives the type of an expression.
+func (ea *escapeAnalysis) typeOf(ast AST) DataType {
+	return ea.info.types[exprKey{ea.filename, ast.Pos()}]
+}
+
+// symbolOf gives the symbol an identifier refers to.
+func (ea *escapeAnalysis) symbolOf(ast AST) *Symbol {
+	ident, ok := ast.(ASTIdentifier)
+	if !ok || ident.packageName != "" {
+		return nil
+	}
+
+	return ea.info.uses[exprKey{ea.filename, ident.pos}]
+}
+
+// varOf finds the location of the local variable an identifier refers
+// to. It returns nil if it isn't a local variable. Variables declared
+// outside a function literal are captured by it.
+func (ea *escapeAnalysis) varOf(ident ASTIdentifier) *escapeLoc {
+	sym := ea.symbolOf(ident)
+	if sym == nil || sym.kind != SymbolVar {
+		return nil
+	}
+
+	loc := ea.vars[exprKey{sym.filename, sym.pos}]
+	if loc == nil {
+		return nil
+	}
+
+	// function literals hold the addresses of the variables they use.
+	for _, closure := range ea.closures {
+		if closure.fnDepth >= loc.fnDepth && !ea.captured[closure][loc] {
+			if ea.captured[closure] == nil {
+				ea.captured[closure] = make(map[*escapeLoc]bool)
+			}
+			ea.captured[closure][loc] = true
+			ea.flow(escapeHole{closure, -1, "captured by a closure"}, loc)
+		}
+	}
+
+	return loc
+}
+
+// isType returns true if an expression is a type, eg. the function in a
+// conversion.
+func (ea *escapeAnalysis) isType(ast AST) bool {
+	switch e := ast.(type) {
+	case ASTIdentifier:
+		if e.packageName != "" {
+			return ea.typeOf(e) != nil && ea.info.uses[exprKey{ea.filename, e.pos}] == nil && !isFunc(ea.typeOf(e))
+		}
+		sym := ea.symbolOf(e)
+		return sym != nil && sym.kind == SymbolType
+	case ASTUnaryExpr:
+		return e.op == TokenKindAsterisk && ea.isType(e.param)
+	case ASTIndex:
+		return ea.isType(e.expr)
+	case ASTInstance:
+		return ea.isType(e.expr)
+	case ASTDataTypeSlice, ASTDataTypeArray, ASTDataTypePointer, ASTDataTypeMap, ASTDataTypeChan, ASTDataTypeFunc, ASTDataTypeStruct, ASTDataTypeInterface:
+		return true
+	}
+
+	return false
+}
+
+// isFunc returns true for function types.
+func isFunc(dt DataType) bool {
+	_, ok := Underlying(dt).(*DataTypeFunc)
+	return ok
+}
+
+// isPointerShaped returns true for types whose values are a single
+// pointer, so converting them to an interface doesn't need a copy.
+func isPointerShaped(dt DataType) bool {
+	switch t := Underlying(dt).(type) {
+	case *DataTypeUnary:
+		return t.kind == DataTypeKindPointer
+	case *DataTypeMap, *DataTypeChan, *DataTypeFunc:
+		return true
+	}
+
+	return false
+}
+
+// stmtList analyses a list of statements.
+func (ea *escapeAnalysis) stmtList(stmts []AST) {
+	for _, stmt := range stmts {
+		ea.stmt(stmt)
+	}
+}
+
+// stmt analyses a statement.
+func (ea *escapeAnalysis) stmt(ast AST) {
+	switch s := ast.(type) {
+	case nil, ASTConstDecl, ASTDataTypeDecl, ASTBranch:
+		// nothing flows anywhere.
+
+	case ASTBlock:
+		ea.stmtList(s.statements)
+
+	case ASTLabeled:
+		ea.stmt(s.stmt)
+
+	case ASTVarDecl:
+		ident := s.ident.(ASTIdentifier)
+		loc := ea.newVar(ident.name, ident.pos)
+		if s.value != nil {
+			var typ DataType
+			if sym := ea.info.defs[exprKey{ea.filename, ident.pos}]; sym != nil {
+				typ = sym.typ
+			}
+			ea.assign(escapeHole{loc: loc}, typ, s.value)
+		}
+
+	case ASTAssign:
+		ea.assignStmt(s)
+
+	case ASTIncDec:
+		ea.expr(escapeHole{}, s.expr)
+
+	case ASTSend:
+		ea.expr(escapeHole{}, s.channel)
+		var elem DataType
+		if ch, ok := coreType(ea.typeOf(s.channel)).(*DataTypeChan); ok {
+			elem = ch.elementType
+		}
+		ea.assign(ea.heapHole("sent on a channel"), elem, s.value)
+
+	case ASTReturn:
+		sig := ea.sigs[len(ea.sigs)-1]
+		if len(s.results) == 1 && sig != nil && len(sig.returns) > 1 {
+			ea.expr(escapeHole{}, s.results[0])
+			break
+		}
+		for i, r := range s.results {
+			var typ DataType
+			if sig != nil && i < len(sig.returns) {
+				typ = sig.returns[i]
+			}
+			ea.assign(ea.heapHole("returned"), typ, r)
+		}
+
+	case ASTGo:
+		ea.goStmt(s.call)
+
+	case ASTDefer:
+		ea.expr(escapeHole{}, s.call)
+
+	case ASTIf:
+		ea.stmt(s.init)
+		ea.expr(escapeHole{}, s.cond)
+		ea.stmt(s.then)
+		ea.stmt(s.els)
+
+	case ASTFor:
+		ea.loopDepth++
+		ea.stmt(s.init)
+		if s.cond != nil {
+			ea.expr(escapeHole{}, s.cond)
+		}
+		ea.stmt(s.post)
+		ea.stmt(s.body)
+		ea.loopDepth--
+
+	case ASTRange:
+		ea.rangeStmt(s)
+
+	case ASTSwitch:
+		ea.switchStmt(s)
+
+	case ASTSelect:
+		for _, clause := range s.cases {
+			cc := clause.(ASTCommClause)
+			ea.stmt(cc.comm)
+			ea.stmtList(cc.body)
+		}
+
+	default:
+		ea.expr(escapeHole{}, ast)
+	}
+}
+
+// assignStmt analyses an assignment or short variable declaration.
+func (ea *escapeAnalysis) assignStmt(s ASTAssign) {
+	if s.op != TokenKindAssign && s.op != TokenKindDeclareAssign {
+		// compound assignments only work on values without pointers.
+		for _, l := range s.left {
+			ea.expr(escapeHole{}, l)
+		}
+		for _, r := range s.right {
+			ea.expr(escapeHole{}, r)
+		}
+		return
+	}
+
+	holes := make([]escapeHole, len(s.left))
+	types := make([]DataType, len(s.left))
+	for i, l := range s.left {
+		types[i] = ea.typeOf(l)
+		if ident, ok := l.(ASTIdentifier); ok && s.op == TokenKindDeclareAssign {
+			if sym := ea.info.defs[exprKey{ea.filename, ident.pos}]; sym != nil {
+				holes[i] = escapeHole{loc: ea.newVar(ident.name, ident.pos)}
+				types[i] = sym.typ
+				continue
+			}
+		}
+		holes[i] = ea.lhs(l)
+	}
+
+	// the values from multi-value calls and comma-ok expressions come from
+	// somewhere else, so nothing flows from here.
+	if len(s.right) != len(s.left) {
+		for _, r := range s.right {
+			ea.expr(escapeHole{}, r)
+		}
+		return
+	}
+
+	for i, r := range s.right {
+		ea.assign(holes[i], types[i], r)
+	}
+}
+
+// lhs gives the hole for the left hand side of an assignment.
+func (ea *escapeAnalysis) lhs(ast AST) escapeHole {
+	switch e := ast.(type) {
+	case ASTIdentifier:
+		if e.name == "_" && e.packageName == "" {
+			return escapeHole{}
+		}
+		if loc := ea.varOf(e); loc != nil {
+			return escapeHole{loc: loc}
+		}
+		return ea.heapHole("stored in a global variable")
+
+	case ASTUnaryExpr:
+		if e.op == TokenKindAsterisk {
+			ea.expr(escapeHole{}, e.param)
+			return ea.heapHole("stored through a pointer")
+		}
+
+	case ASTSelector:
+		if isPointer(ea.typeOf(e.expr)) {
+			ea.expr(escapeHole{}, e.expr)
+			return ea.heapHole("stored through a pointer")
+		}
+		return ea.lhs(e.expr)
+
+	case ASTIndex:
+		ea.expr(escapeHole{}, e.index)
+		switch t := coreType(ea.typeOf(e.expr)).(type) {
+		case *DataTypeArray:
+			return ea.lhs(e.expr)
+		case *DataTypeMap:
+			ea.expr(escapeHole{}, e.expr)
+			return ea.heapHole("stored in a map")
+		default:
+			_ = t
+			ea.expr(escapeHole{}, e.expr)
+			return ea.heapHole("stored through a pointer")
+		}
+	}
+
+	ea.expr(escapeHole{}, ast)
+	return escapeHole{}
+}
+
+// assign analyses a value going into a hole of type typ. Values which
+// aren't pointers are copied when they're converted to an interface, and
+// the copy is an allocation.
+func (ea *escapeAnalysis) assign(h escapeHole, typ DataType, value AST) {
+	vt := ea.typeOf(value)
+	_, constant := ea.info.values[exprKey{ea.filename, value.Pos()}]
+	if typ == nil || vt == nil || constant || !isInterface(typ) || isInterface(vt) || isPointerShaped(vt) || basicKind(vt) == DataTypeKindUntypedNil {
+		ea.expr(h, value)
+		return
+	}
+
+	what := "a " + ea.typeString(vt) + " value"
+	if ident, ok := value.(ASTIdentifier); ok {
+		what = ident.name
+	}
+	box := ea.newAlloc(what+" converted to "+ea.typeString(typ), value.Pos())
+	ea.boxes[box] = true
+	h.reason = "converted to an interface"
+	ea.flow(h.addr(), box)
+	ea.expr(escapeHole{loc: box}, value)
+}
+
+// expr analyses an expression whose value goes into a hole.
+func (ea *escapeAnalysis) expr(h escapeHole, ast AST) {
+	switch e := ast.(type) {
+	case nil, ASTValue:
+
+	case ASTIdentifier:
+		if loc := ea.varOf(e); loc != nil {
+			ea.flow(h, loc)
+		}
+
+	case ASTUnaryExpr:
+		switch e.op {
+		case TokenKindBitwiseAnd:
+			ea.expr(h.addr(), e.param)
+		case TokenKindAsterisk:
+			ea.expr(h.deref(), e.param)
+		default:
+			ea.expr(escapeHole{}, e.param)
+		}
+
+	case ASTBinaryExpr:
+		ea.expr(escapeHole{}, e.left)
+		ea.expr(escapeHole{}, e.right)
+
+	case ASTCall:
+		ea.call(h, e)
+
+	case ASTSelector:
+		ea.selector(h, e)
+
+	case ASTIndex:
+		ea.expr(escapeHole{}, e.index)
+		switch coreType(ea.typeOf(e.expr)).(type) {
+		case *DataTypeArray:
+			ea.expr(h, e.expr)
+		case *DataTypeMap, DataTypeBasic, DataTypeSized:
+			// map values and string bytes are copied out.
+			ea.expr(escapeHole{}, e.expr)
+		default:
+			ea.expr(h.deref(), e.expr)
+		}
+
+	case ASTSliceExpr:
+		ea.expr(escapeHole{}, e.low)
+		ea.expr(escapeHole{}, e.high)
+		ea.expr(escapeHole{}, e.max)
+		if _, ok := coreType(ea.typeOf(e.expr)).(*DataTypeArray); ok {
+			// slicing an array takes its address.
+			ea.expr(h.addr(), e.expr)
+		} else {
+			ea.expr(h, e.expr)
+		}
+
+	case ASTTypeAssertion:
+		ea.expr(h, e.expr)
+
+	case ASTCompositeLit:
+		ea.compositeLit(h, e)
+
+	case ASTFunctionLit:
+		ea.functionLit(h, e)
+	}
+}
+
+// selector analyses a field selector or a method value.
+func (ea *escapeAnalysis) selector(h escapeHole, e ASTSelector) {
+	typ := ea.typeOf(e.expr)
+	if typ == nil {
+		return
+	}
+
+	_, isMethod, _, _, _, _ := lookupFieldOrMethod(typ, e.name)
+	switch {
+	case ea.isType(e.expr):
+		// a method expression.
+	case isMethod:
+		// the receiver is bound to the method value.
+		ea.expr(ea.heapHole("bound to a method value"), e.expr)
+	case isPointer(typ):
+		ea.expr(h.deref(), e.expr)
+	default:
+		ea.expr(h, e.expr)
+	}
+}
+
+// compositeLit analyses a composite literal. Slice and map literals, and
+// literals whose address is taken, are allocations.
+func (ea *escapeAnalysis) compositeLit(h escapeHole, e ASTCompositeLit) {
+	typ := ea.typeOf(e)
+	into := h
+	switch coreType(typ).(type) {
+	case *DataTypeUnary, *DataTypeMap:
+		lit := ea.newAlloc(ea.typeString(typ)+"{...} literal", e.pos)
+		ea.flow(h.addr(), lit)
+		into = escapeHole{loc: lit}
+	default:
+		if h.derefs < 0 {
+			// the address of the literal is taken.
+			lit := ea.newAlloc("&"+ea.typeString(typ)+"{...}", e.pos)
+			if ea.ts.Sizeof(typ) > maxImplicitStackSize {
+				ea.forceHeap(lit, "too large for stack")
+			}
+			ea.flow(h, lit)
+			into = escapeHole{loc: lit}
+		}
+	}
+
+	for i, elem := range e.elements {
+		var key AST
+		if kv, ok := elem.(ASTKeyedElement); ok {
+			key, elem = kv.key, kv.value
+		}
+
+		var elemType DataType
+		elemHole := into
+		switch t := coreType(typ).(type) {
+		case *DataTypeStruct:
+			if ident, ok := key.(ASTIdentifier); ok {
+				for _, f := range t.fields {
+					if f.name == ident.name {
+						elemType = f.typ
+					}
+				}
+			} else if i < len(t.fields) {
+				elemType = t.fields[i].typ
+			}
+			key = nil
+		case *DataTypeArray:
+			elemType = t.elementType
+		case *DataTypeUnary:
+			elemType = t.subType
+		case *DataTypeMap:
+			ea.assign(ea.heapHole("stored in a map"), t.keyType, key)
+			key = nil
+			elemType = t.valueType
+			elemHole = ea.heapHole("stored in a map")
+		}
+
+		ea.expr(escapeHole{}, key)
+		ea.assign(elemHole, elemType, elem)
+	}
+}
+
+// functionLit analyses a function literal. It's an allocation which holds
+// the addresses of the variables it captures.
+func (ea *escapeAnalysis) functionLit(h escapeHole, e ASTFunctionLit) {
+	closure := ea.newAlloc("func literal", e.pos)
+	ea.flow(h.addr(), closure)
+
+	ea.closures = append(ea.closures, closure)
+	sig, _ := ea.typeOf(e).(*DataTypeFunc)
+	ea.sigs = append(ea.sigs, sig)
+	savedLoopDepth := ea.loopDepth
+	ea.loopDepth = 0
+	defer func() {
+		ea.closures = ea.closures[:len(ea.closures)-1]
+		ea.sigs = ea.sigs[:len(ea.sigs)-1]
+		ea.loopDepth = savedLoopDepth
+	}()
+
+	// the parameters are declared inside the literal.
+	for _, p := range parameterList(e.typ.params) {
+		if p.name != nil {
+			ident := p.name.(ASTIdentifier)
+			ea.newVar(ident.name, ident.pos)
+		}
+	}
+	ea.namedResults(e.typ.returns)
+	ea.stmtList(e.body.(ASTBlock).statements)
+}
+
+// call analyses a function call, conversion or builtin call whose result
+// goes into a hole.
+func (ea *escapeAnalysis) call(h escapeHole, e ASTCall) {
+	// conversions.
+	if ea.isType(e.function) {
+		if len(e.args) == 1 {
+			ea.assign(h, ea.typeOf(e), e.args[0])
+		}
+		return
+	}
+
+	// builtins.
+	if sym := ea.symbolOf(e.function); sym != nil && sym.kind == SymbolBuiltin {
+		ea.builtinCall(h, e, sym.builtin)
+		return
+	}
+
+	sig, _ := coreType(ea.typeOf(e.function)).(*DataTypeFunc)
+	var leaks []int
+	reason := "passed to a function value"
+
+	switch f := e.function.(type) {
+	case ASTIdentifier, ASTIndex, ASTInstance:
+		// generic functions can have explicit type arguments.
+		fn := e.function
+		if index, ok := f.(ASTIndex); ok {
+			fn = index.expr
+		} else if inst, ok := f.(ASTInstance); ok {
+			fn = inst.expr
+		}
+		if sym := ea.symbolOf(fn); sym != nil && sym.kind == SymbolFunc {
+			if decl, ok := sym.decl.(ASTFunctionDecl); ok {
+				leaks = ea.summaries[exprKey{sym.filename, decl.pos}]
+				reason = fmt.Sprint("passed to ", sym.name, ", which keeps it")
+			}
+		}
+		if leaks == nil {
+			ea.expr(escapeHole{}, e.function)
+		}
+
+	case ASTSelector:
+		recvType := ea.typeOf(f.expr)
+		_, isMethod, method, ptrRecv, _, _ := lookupFieldOrMethod(recvType, f.name)
+		if !isMethod || ea.isType(f.expr) {
+			ea.expr(escapeHole{}, f)
+			break
+		}
+		if method == nil {
+			reason = "passed to an interface method"
+		} else {
+			leaks = ea.summaries[exprKey{method.filename, method.decl.pos}]
+			reason = fmt.Sprint("passed to ", method.decl.receiver.(ASTReceiver).typeName, ".", f.name, ", which keeps it")
+		}
+
+		// the receiver is the first argument.
+		recvLeak := 0
+		if leaks != nil {
+			recvLeak, leaks = leaks[0], leaks[1:]
+		}
+		recvHole := ea.leakHole(recvLeak, reason)
+		switch {
+		case ptrRecv && !isPointer(recvType):
+			recvHole = recvHole.addr()
+		case !ptrRecv && method != nil && isPointer(recvType):
+			recvHole = recvHole.deref()
+		}
+		ea.expr(recvHole, f.expr)
+
+	default:
+		ea.expr(escapeHole{}, e.function)
+	}
+
+	// multi-value calls as arguments come from somewhere else.
+	args := e.args
+	if len(args) == 1 && sig != nil && len(sig.params) > 1 {
+		ea.expr(escapeHole{}, args[0])
+		return
+	}
+
+	for i, arg := range args {
+		var typ DataType
+		leak := 0
+		spread := false
+		if sig != nil && len(sig.params) > 0 {
+			n := i
+			if n >= len(sig.params) {
+				n = len(sig.params) - 1
+			}
+			typ = sig.params[n]
+			if sig.variadic && n == len(sig.params)-1 && !e.ellipsis {
+				if slice, ok := typ.(*DataTypeUnary); ok {
+					typ, spread = slice.subType, true
+				}
+			}
+			if leaks != nil && n < len(leaks) {
+				leak = leaks[n]
+			}
+		}
+
+		// variadic arguments are stored in a slice, so anything which leaks
+		// what the slice points at leaks them.
+		if spread && leak > 0 && leak < noLeak {
+			leak--
+		}
+		ea.assign(ea.leakHole(leak, reason), typ, arg)
+	}
+}
+
+// leakHole gives a hole for an argument which leaks to the heap at the
+// given number of dereferences.
+func (ea *escapeAnalysis) leakHole(leak int, reason string) escapeHole {
+	if leak >= noLeak {
+		return escapeHole{}
+	}
+
+	h := ea.heapHole(reason)
+	h.derefs = leak
+	return h
+}
+
+// makeSliceHeap gives the reason the backing array made by make for a
+// slice has to be on the heap, or "" if it can be in the stack frame. Its
+// size is the capacity, which is the last of the sizes.
+func (ea *escapeAnalysis) makeSliceHeap(elem DataType, sizes []AST) string {
+	var n int64
+	for _, size := range sizes {
+		val, ok := ea.info.values[exprKey{ea.filename, size.Pos()}]
+		if !ok {
+			return "non-constant size"
+		}
+		if n, ok = val.Int64(); !ok {
+			return "too large for stack"
+		}
+	}
+
+	if elemSize := ea.ts.Sizeof(elem); elemSize > 0 && n > maxImplicitStackSize/elemSize {
+		return "too large for stack"
+	}
+	return ""
+}
+
+// builtinCall analyses a call to a builtin function.
+func (ea *escapeAnalysis) builtinCall(h escapeHole, e ASTCall, id builtinID) {
+	switch id {
+	case builtinNew:
+		var elem DataType
+		if ptr, ok := ea.typeOf(e).(*DataTypeUnary); ok {
+			elem = ptr.subType
+		}
+		alloc := ea.newAlloc("new("+ea.typeString(elem)+")", e.pos)
+		if elem != nil && ea.ts.Sizeof(elem) > maxImplicitStackSize {
+			ea.forceHeap(alloc, "too large for stack")
+		}
+		ea.flow(h.addr(), alloc)
+
+	case builtinMake:
+		typ := ea.typeOf(e)
+		alloc := ea.newAlloc("make("+ea.typeString(typ)+")", e.pos)
+		if slice, ok := coreType(typ).(*DataTypeUnary); ok && slice.kind == DataTypeKindSlice && len(e.args) > 1 {
+			if reason := ea.makeSliceHeap(slice.subType, e.args[1:]); reason != "" {
+				ea.forceHeap(alloc, reason)
+			}
+		}
+		ea.flow(h.addr(), alloc)
+		for _, arg := range e.args[1:] {
+			ea.expr(escapeHole{}, arg)
+		}
+
+	case builtinAppend:
+		if len(e.args) == 0 {
+			return
+		}
+		ea.expr(h, e.args[0])
+		var elem DataType
+		if slice, ok := coreType(ea.typeOf(e.args[0])).(*DataTypeUnary); ok {
+			elem = slice.subType
+		}
+		for _, arg := range e.args[1:] {
+			if e.ellipsis {
+				ea.expr(ea.heapHole("appended to a slice").deref(), arg)
+			} else {
+				ea.assign(ea.heapHole("appended to a slice"), elem, arg)
+			}
+		}
+
+	case builtinCopy:
+		if len(e.args) == 2 {
+			ea.expr(escapeHole{}, e.args[0])
+			ea.expr(ea.heapHole("copied into a slice").deref(), e.args[1])
+		}
+
+	case builtinPanic:
+		if len(e.args) == 1 {
+			ea.assign(ea.heapHole("passed to panic"), ea.typeOf(e), e.args[0])
+		}
+
+	default:
+		for _, arg := range e.args {
+			ea.expr(escapeHole{}, arg)
+		}
+	}
+}
+
+// goStmt analyses the call in a go statement. The goroutine can outlive
+// the function so everything passed to it goes to the heap.
+func (ea *escapeAnalysis) goStmt(ast AST) {
+	call, ok := ast.(ASTCall)
+	if !ok {
+		return
+	}
+
+	h := ea.heapHole("passed to a goroutine")
+	if sel, ok := call.function.(ASTSelector); ok {
+		ea.expr(h, sel.expr)
+	} else {
+		ea.expr(h, call.function)
+	}
+
+	sig, _ := coreType(ea.typeOf(call.function)).(*DataTypeFunc)
+	for i, arg := range call.args {
+		var typ DataType
+		if sig != nil && i < len(sig.params) && !sig.variadic {
+			typ = sig.params[i]
+		}
+		ea.assign(h, typ, arg)
+	}
+}
+
+// rangeStmt analyses a for statement with a range clause.
+func (ea *escapeAnalysis) rangeStmt(s ASTRange) {
+	// the expression is evaluated once, before the loop.
+	tmp := ea.newLoc("the range expression")
+	ea.expr(escapeHole{loc: tmp}, s.expr)
+
+	ea.loopDepth++
+	defer func() { ea.loopDepth-- }()
+
+	var holes [2]escapeHole
+	for i, v := range []AST{s.key, s.value} {
+		if v == nil {
+			continue
+		}
+		if ident, ok := v.(ASTIdentifier); ok && s.define {
+			holes[i] = escapeHole{loc: ea.newVar(ident.name, ident.pos)}
+		} else {
+			holes[i] = ea.lhs(v)
+		}
+	}
+
+	// the values are the elements, which are behind a pointer unless it's
+	// an array.
+	value := holes[1]
+	switch t := coreType(ea.typeOf(s.expr)).(type) {
+	case *DataTypeArray:
+		ea.flow(value, tmp)
+	case *DataTypeUnary:
+		if t.kind == DataTypeKindSlice || t.kind == DataTypeKindPointer {
+			ea.flow(value.deref(), tmp)
+		}
+	case *DataTypeMap:
+		ea.flow(holes[0].deref(), tmp)
+		ea.flow(value.deref(), tmp)
+	case *DataTypeChan:
+		ea.flow(holes[0].deref(), tmp)
+	}
+
+	ea.stmt(s.body)
+}
+
+// switchStmt analyses a switch statement. The variable of a type switch is
+// declared once for all the clauses.
+func (ea *escapeAnalysis) switchStmt(s ASTSwitch) {
+	ea.stmt(s.init)
+
+	switch tag := s.tag.(type) {
+	case ASTAssign:
+		if tag.op == TokenKindDeclareAssign && len(tag.left) == 1 && len(tag.right) == 1 {
+			if ident, ok := tag.left[0].(ASTIdentifier); ok {
+				loc := ea.newVar(ident.name, ident.pos)
+				ea.expr(escapeHole{loc: loc}, tag.right[0])
+				break
+			}
+		}
+		ea.stmt(tag)
+	default:
+		ea.expr(escapeHole{}, s.tag)
+	}
+
+	for _, clause := range s.cases {
+		cc := clause.(ASTCaseClause)
+		if _, isTypeSwitch := s.tag.(ASTAssign); !isTypeSwitch {
+			for _, e := range cc.exprs {
+				if !ea.isType(e) {
+					ea.expr(escapeHole{}, e)
+				}
+			}
+		}
+		ea.stmtList(cc.body)
+	}
+}
+
+// walkAll finds the locations which have to be on the heap. Every
+// location is a root, since anything whose address it holds mustn't be
+// freed before it is. When a location is found to escape it's walked
+// again since everything it holds the address of escapes too.
+func (ea *escapeAnalysis) walkAll() {
+	todo := append([]*escapeLoc{ea.heap}, ea.locs...)
+	for len(todo) > 0 {
+		root := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		todo = append(todo, ea.walkOne(root)...)
+	}
+}
+
+// walkOne walks the locations whose values flow to root, marking the ones
+// whose addresses do as escaping if root lives longer than they do. It
+// returns the locations which newly escape.
+func (ea *escapeAnalysis) walkOne(root *escapeLoc) []*escapeLoc {
+	ea.walkgen++
+	root.walkgen, root.derefs, root.via = ea.walkgen, 0, ""
+
+	var escaped []*escapeLoc
+	todo := []*escapeLoc{root}
+	for len(todo) > 0 {
+		l := todo[0]
+		todo = todo[1:]
+
+		derefs := l.derefs
+		if derefs < 0 {
+			// for "root = &l; l = x" l's address flows to root but x's
+			// doesn't.
+			derefs = 0
+			if !l.escapes && ea.outlives(root, l) {
+				l.escapes = true
+				l.reason = ea.explain(root, l)
+				escaped = append(escaped, l)
+			}
+		}
+
+		for _, edge := range l.edges {
+			if edge.src.escapes {
+				continue
+			}
+			d := derefs + edge.derefs
+			if edge.src.walkgen != ea.walkgen || d < edge.src.derefs {
+				edge.src.walkgen, edge.src.derefs = ea.walkgen, d
+				edge.src.via = l.via
+				if edge.reason != "" {
+					edge.src.via = edge.reason
+				}
+				todo = append(todo, edge.src)
+			}
+		}
+	}
+
+	return escaped
+}
+
+// walkLeaks finds how many dereferences away from the heap each parameter
+// is, which is how far its argument leaks. Parameters which don't leak
+// aren't included.
+func (ea *escapeAnalysis) walkLeaks() map[*escapeLoc]int {
+	leaks := make(map[*escapeLoc]int)
+	dist := map[*escapeLoc]int{ea.heap: 0}
+	todo := []*escapeLoc{ea.heap}
+	for _, loc := range ea.locs {
+		if loc.escapes {
+			dist[loc] = 0
+			todo = append(todo, loc)
+		}
+	}
+
+	for len(todo) > 0 {
+		l := todo[0]
+		todo = todo[1:]
+
+		derefs := dist[l]
+		if derefs < 0 {
+			derefs = 0
+		}
+		if l.param >= 0 {
+			leaks[l] = derefs
+		}
+		for _, edge := range l.edges {
+			d := derefs + edge.derefs
+			if old, ok := dist[edge.src]; !ok || d < old {
+				dist[edge.src] = d
+				todo = append(todo, edge.src)
+			}
+		}
+	}
+
+	return leaks
+}
+
+// outlives returns true if root can still be used after l has gone.
+func (ea *escapeAnalysis) outlives(root *escapeLoc, l *escapeLoc) bool {
+	if root.escapes {
+		return true
+	}
+
+	// variables in function literals are gone when the literal returns,
+	// and variables in loops are new each time around.
+	return root.fnDepth < l.fnDepth || root.fnDepth == l.fnDepth && root.loopDepth < l.loopDepth
+}
+
+// explain gives the reason a location escapes because its address flows
+// to root.
+func (ea *escapeAnalysis) explain(root *escapeLoc, l *escapeLoc) string {
+	switch {
+	case root != ea.heap && root.escapes:
+		return fmt.Sprint("its address is kept by ", root.name, ", which escapes")
+	case root != ea.heap:
+		return fmt.Sprint("its address is kept by ", root.name, ", which outlives it")
+	case l.via == "captured by a closure" || l.via == "converted to an interface":
+		return "it's " + l.via + " which escapes"
+	}
+
+	return "its address is " + l.via
+}
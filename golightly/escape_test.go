@@ -0,0 +1,226 @@
+package golightly
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEscapeAnalysis(t *testing.T) {
+	ta := &testAST{}
+	intType := func() AST { return ta.ident("int") }
+	intPtr := func() AST { return ASTDataTypePointer{ta.pos(), ta.ident("int")} }
+	fn := func(name string, params []AST, returns []AST, body ...AST) AST {
+		return ASTFunctionDecl{ta.pos(), name, nil, nil, params, returns, ASTBlock{ta.pos(), body}}
+	}
+	ret := func(values ...AST) AST { return ASTReturn{ta.pos(), values} }
+	discard := func(value AST) AST {
+		return ASTAssign{ta.pos(), TokenKindAssign, []AST{ta.ident("_")}, []AST{value}}
+	}
+
+	// the identifiers which declare the variables, by name.
+	vars := make(map[string]AST)
+	define := func(name string, value AST) AST {
+		ident := ta.ident(name)
+		vars[name] = ident
+		return ASTAssign{ta.pos(), TokenKindDeclareAssign, []AST{ident}, []AST{value}}
+	}
+	declare := func(name string, typ AST, value AST) AST {
+		ident := ta.ident(name)
+		vars[name] = ident
+		return ASTVarDecl{ident, typ, value}
+	}
+
+	newInt := ta.call(ta.ident("new"), intType())
+	makeSlice := ta.call(ta.ident("make"), ASTDataTypeSlice{ta.pos(), intType()}, ta.int(10))
+	closure := ASTFunctionLit{ta.pos(), ASTDataTypeFunc{ta.pos(), nil, []AST{ta.param("", intType())}}, ASTBlock{ta.pos(), []AST{
+		ASTAssign{ta.pos(), TokenKindAssign, []AST{ta.ident("n")}, []AST{ta.binary(TokenKindAdd, ta.ident("n"), ta.int(1))}},
+		ret(ta.ident("n")),
+	}}}
+	kept := ta.ident("a")
+	boxed := ta.ident("a")
+	array := func(n uint64) AST { return ASTDataTypeArray{ta.pos(), ta.int(n), intType()} }
+	makeVarying := ta.call(ta.ident("make"), ASTDataTypeSlice{ta.pos(), intType()}, ta.ident("n"))
+	makeLarge := ta.call(ta.ident("make"), ASTDataTypeSlice{ta.pos(), intType()}, ta.int(10), ta.int(10000))
+	newLarge := ta.call(ta.ident("new"), array(10000))
+
+	decls := []AST{
+		// var global interface{}
+		ta.varDecl("global", ASTDataTypeInterface{ta.pos(), nil}, nil),
+
+		// func leak() *int { x := 1; return &x }
+		fn("leak", nil, []AST{ta.param("", intPtr())},
+			define("x", ta.int(1)),
+			ret(ta.unary(TokenKindBitwiseAnd, ta.ident("x")))),
+
+		// func local() int { y := 2; p := &y; return *p }
+		fn("local", nil, []AST{ta.param("", intType())},
+			define("y", ta.int(2)),
+			define("p", ta.unary(TokenKindBitwiseAnd, ta.ident("y"))),
+			ret(ta.unary(TokenKindAsterisk, ta.ident("p")))),
+
+		// func counter() func() int { n := 0; return func() int { n = n + 1; return n } }
+		fn("counter", nil, []AST{ta.param("", ASTDataTypeFunc{ta.pos(), nil, []AST{ta.param("", intType())}})},
+			define("n", ta.int(0)),
+			ret(closure)),
+
+		// func send(ch chan *int) { z := 3; ch <- &z }
+		fn("send", []AST{ta.param("ch", ASTDataTypeChan{ta.pos(), ChanDirectionBi, intPtr()})}, nil,
+			define("z", ta.int(3)),
+			ASTSend{ta.pos(), ta.ident("ch"), ta.unary(TokenKindBitwiseAnd, ta.ident("z"))}),
+
+		// func keep(v interface{}) { global = v }
+		fn("keep", []AST{ta.param("v", ASTDataTypeInterface{ta.pos(), nil})}, nil,
+			ASTAssign{ta.pos(), TokenKindAssign, []AST{ta.ident("global")}, []AST{ta.ident("v")}}),
+
+		// func box() { a := 4; keep(a); var b interface{} = a; _ = b }
+		fn("box", nil, nil,
+			define("a", ta.int(4)),
+			ta.call(ta.ident("keep"), kept),
+			declare("b", ASTDataTypeInterface{ta.pos(), nil}, boxed),
+			discard(ta.ident("b"))),
+
+		// func store(q **int) { w := 5; *q = &w }
+		fn("store", []AST{ta.param("q", ASTDataTypePointer{ta.pos(), intPtr()})}, nil,
+			define("w", ta.int(5)),
+			ASTAssign{ta.pos(), TokenKindAssign, []AST{ta.unary(TokenKindAsterisk, ta.ident("q"))}, []AST{ta.unary(TokenKindBitwiseAnd, ta.ident("w"))}}),
+
+		// func alloc() int { r := new(int); s := make([]int, 10); return *r + s[0] }
+		fn("alloc", nil, []AST{ta.param("", intType())},
+			define("r", newInt),
+			define("s", makeSlice),
+			ret(ta.binary(TokenKindAdd, ta.unary(TokenKindAsterisk, ta.ident("r")), ta.index(ta.ident("s"), ta.int(0))))),
+
+		// func loop() { var last *int; for i := 0; i < 3; i = i + 1 { v := i; last = &v }; _ = last }
+		fn("loop", nil, nil,
+			declare("last", intPtr(), nil),
+			ASTFor{ta.pos(),
+				define("i", ta.int(0)),
+				ta.binary(TokenKindLess, ta.ident("i"), ta.int(3)),
+				ASTAssign{ta.pos(), TokenKindAssign, []AST{ta.ident("i")}, []AST{ta.binary(TokenKindAdd, ta.ident("i"), ta.int(1))}},
+				ASTBlock{ta.pos(), []AST{
+					define("v", ta.ident("i")),
+					ASTAssign{ta.pos(), TokenKindAssign, []AST{ta.ident("last")}, []AST{ta.unary(TokenKindBitwiseAnd, ta.ident("v"))}},
+				}}},
+			discard(ta.ident("last"))),
+
+		// func sized(n int) { c := make([]int, n); d := make([]int, 10, 10000); e := new([10000]int); var huge [2000000]int; _ = c; _ = d; _ = e; _ = huge }
+		fn("sized", []AST{ta.param("n", intType())}, nil,
+			define("c", makeVarying),
+			define("d", makeLarge),
+			define("e", newLarge),
+			declare("huge", array(2000000), nil),
+			discard(ta.ident("c")),
+			discard(ta.ident("d")),
+			discard(ta.ident("e")),
+			discard(ta.ident("huge"))),
+		ta.mainUsing("leak", "local", "counter", "send", "keep", "box", "store", "alloc", "loop", "sized"),
+	}
+
+	sf := &sourceFile{packageName: "main", fileName: "test.go", ast: ASTTopLevel{topLevelDecls: decls}}
+	ts := NewDataTypeStore()
+	c, errs := checkTestFiles(ts, []*sourceFile{sf})
+	if len(errs) != 0 {
+		t.Fatal("unexpected errors: ", errs)
+	}
+	ei := AnalyseEscapes(ts, c.Info(), "main", []*sourceFile{sf})
+
+	cases := []struct {
+		decision *EscapeDecision
+		what     string
+		heap     bool
+		reason   string
+	}{
+		{ei.Lookup("test.go", vars["x"]), "x", true, "its address is returned"},
+		{ei.Lookup("test.go", vars["y"]), "y", false, ""},
+		{ei.Lookup("test.go", vars["p"]), "p", false, ""},
+		{ei.Lookup("test.go", vars["n"]), "n", true, "it's captured by a closure which escapes"},
+		{ei.Lookup("test.go", closure), "func literal", true, "its address is returned"},
+		{ei.Lookup("test.go", vars["z"]), "z", true, "its address is sent on a channel"},
+		{ei.Lookup("test.go", vars["a"]), "a", false, ""},
+		{ei.LookupConversion("test.go", kept), "a converted to any", true, "it's converted to an interface which escapes"},
+		{ei.LookupConversion("test.go", boxed), "a converted to any", false, ""},
+		{ei.Lookup("test.go", vars["w"]), "w", true, "its address is stored through a pointer"},
+		{ei.Lookup("test.go", newInt), "new(int)", false, ""},
+		{ei.Lookup("test.go", makeSlice), "make([]int)", false, ""},
+		{ei.Lookup("test.go", vars["v"]), "v", true, "its address is kept by 'last', which outlives it"},
+		{ei.Lookup("test.go", vars["last"]), "last", false, ""},
+		{ei.Lookup("test.go", makeVarying), "make([]int)", true, "non-constant size"},
+		{ei.Lookup("test.go", makeLarge), "make([]int)", true, "too large for stack"},
+		{ei.Lookup("test.go", newLarge), "new([10000]int)", true, "too large for stack"},
+		{ei.Lookup("test.go", vars["c"]), "c", false, ""},
+		{ei.Lookup("test.go", vars["huge"]), "huge", true, "too large for stack"},
+	}
+
+	for i, tc := range cases {
+		ed := tc.decision
+		switch {
+		case ed == nil:
+			t.Error("case ", i, ": there's no decision about ", tc.what)
+		case ed.what != tc.what:
+			t.Error("case ", i, ": expected a decision about ", tc.what, " but got one about ", ed.what)
+		case ed.Heap() != tc.heap:
+			t.Error("case ", i, ": expected ", tc.what, " to be on the heap: ", tc.heap, " but got: ", ed)
+		case ed.Reason() != tc.reason:
+			t.Error("case ", i, ": expected the reason \"", tc.reason, "\" but got: ", ed.Reason())
+		}
+	}
+
+	// the report has a line for each decision.
+	report := ei.Report()
+	if strings.Count(report, "\n") != len(ei.Decisions()) {
+		t.Error("expected a line per decision in the report but got: ", report)
+	}
+	if !strings.Contains(report, "test.go:") || !strings.Contains(report, "moved to heap: x - its address is returned") {
+		t.Error("the report doesn't explain that x is moved to the heap: ", report)
+	}
+	if !strings.Contains(report, "make([]int) escapes to heap - non-constant size") || !strings.Contains(report, "moved to heap: huge - too large for stack") {
+		t.Error("the report doesn't explain why sized's allocations are on the heap: ", report)
+	}
+}
+
+func TestEscapeAnalysisSummaries(t *testing.T) {
+	ta := &testAST{}
+	intPtr := func() AST { return ASTDataTypePointer{ta.pos(), ta.ident("int")} }
+	fn := func(name string, params []AST, returns []AST, body ...AST) AST {
+		return ASTFunctionDecl{ta.pos(), name, nil, nil, params, returns, ASTBlock{ta.pos(), body}}
+	}
+	ret := func(values ...AST) AST { return ASTReturn{ta.pos(), values} }
+	define := func(ident AST, value AST) AST {
+		return ASTAssign{ta.pos(), TokenKindDeclareAssign, []AST{ident}, []AST{value}}
+	}
+
+	// func identity(p *int) *int { return p }
+	// func deref(p *int) int { return *p }
+	// func callsIdentity() *int { a := 1; return identity(&a) }
+	// func callsDeref() int { b := 2; return deref(&b) }
+	a := ta.ident("a")
+	b := ta.ident("b")
+	decls := []AST{
+		fn("identity", []AST{ta.param("p", intPtr())}, []AST{ta.param("", intPtr())},
+			ret(ta.ident("p"))),
+		fn("deref", []AST{ta.param("p", intPtr())}, []AST{ta.param("", ta.ident("int"))},
+			ret(ta.unary(TokenKindAsterisk, ta.ident("p")))),
+		fn("callsIdentity", nil, []AST{ta.param("", intPtr())},
+			define(a, ta.int(1)),
+			ret(ta.call(ta.ident("identity"), ta.unary(TokenKindBitwiseAnd, ta.ident("a"))))),
+		fn("callsDeref", nil, []AST{ta.param("", ta.ident("int"))},
+			define(b, ta.int(2)),
+			ret(ta.call(ta.ident("deref"), ta.unary(TokenKindBitwiseAnd, ta.ident("b"))))),
+		ta.mainUsing("callsIdentity", "callsDeref"),
+	}
+
+	sf := &sourceFile{packageName: "main", fileName: "test.go", ast: ASTTopLevel{topLevelDecls: decls}}
+	ts := NewDataTypeStore()
+	c, errs := checkTestFiles(ts, []*sourceFile{sf})
+	if len(errs) != 0 {
+		t.Fatal("unexpected errors: ", errs)
+	}
+	ei := AnalyseEscapes(ts, c.Info(), "main", []*sourceFile{sf})
+
+	if ed := ei.Lookup("test.go", a); ed == nil || !ed.Heap() || ed.Reason() != "its address is passed to identity, which keeps it" {
+		t.Error("a should be moved to the heap because identity returns its address but got: ", ed)
+	}
+	if ed := ei.Lookup("test.go", b); ed == nil || ed.Heap() {
+		t.Error("b shouldn't be moved to the heap since deref doesn't keep its address but got: ", ed)
+	}
+}
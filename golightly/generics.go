@@ -0,0 +1,619 @@
+package golightly
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// type DataTypeTypeParam is a type parameter of a generic function or
+// type. Like defined types, every declaration creates a new type parameter
+// which is different to every other type.
+type DataTypeTypeParam struct {
+	name  string // the parameter's name
+	index int    // its position in the type parameter list
+
+	mutex      sync.RWMutex // guards the following
+	constraint DataType     // the constraint. nil until the declaration is resolved.
+}
+
+func (dtp *DataTypeTypeParam) DataTypeKind() DataTypeKind {
+	return DataTypeKindTypeParam
+}
+
+// Name returns the type parameter's name.
+func (dtp *DataTypeTypeParam) Name() string {
+	return dtp.name
+}
+
+// Index returns the type parameter's position in its parameter list.
+func (dtp *DataTypeTypeParam) Index() int {
+	return dtp.index
+}
+
+// Constraint returns the constraint type arguments have to satisfy. It's
+// always an interface type, possibly a defined one.
+func (dtp *DataTypeTypeParam) Constraint() DataType {
+	dtp.mutex.RLock()
+	defer dtp.mutex.RUnlock()
+
+	return dtp.constraint
+}
+
+// setConstraint sets the constraint once it's been worked out.
+func (dtp *DataTypeTypeParam) setConstraint(dt DataType) {
+	dtp.mutex.Lock()
+	dtp.constraint = dt
+	dtp.mutex.Unlock()
+}
+
+// iface returns the constraint's underlying interface type. It's nil if the
+// constraint isn't known yet.
+func (dtp *DataTypeTypeParam) iface() *DataTypeInterface {
+	iface, _ := Underlying(dtp.Constraint()).(*DataTypeInterface)
+	return iface
+}
+
+// all returns true if every type in the type parameter's type set has a
+// property. It's false if the type set isn't limited by type terms, since
+// then it includes types of every kind.
+func (dtp *DataTypeTypeParam) all(pred func(dt DataType) bool) bool {
+	iface := dtp.iface()
+	if iface == nil || !iface.restricted || len(iface.terms) == 0 {
+		return false
+	}
+
+	for _, term := range iface.terms {
+		if !pred(term.typ) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NewTypeParam creates a new type parameter. Its constraint must be set
+// before it's used.
+func (ts *DataTypeStore) NewTypeParam(name string, index int) *DataTypeTypeParam {
+	return &DataTypeTypeParam{name: name, index: index}
+}
+
+// type DataTypeTerm is a term in the type set of a constraint. It's either
+// a single type or, if tilde is true, all types with that underlying type.
+type DataTypeTerm struct {
+	tilde bool     // true if it's of the form '~T'
+	typ   DataType // the type
+}
+
+// Tilde returns true if the term includes all types with the term's type
+// as their underlying type.
+func (dtt DataTypeTerm) Tilde() bool {
+	return dtt.tilde
+}
+
+// Type returns the term's type.
+func (dtt DataTypeTerm) Type() DataType {
+	return dtt.typ
+}
+
+// includes returns true if a type is in the term's type set.
+func (dtt DataTypeTerm) includes(dt DataType) bool {
+	if dtt.tilde {
+		return Underlying(dt) == dtt.typ
+	}
+
+	return dt == dtt.typ
+}
+
+// intersectTerms gives the terms which are in the type sets of both a and
+// b.
+func intersectTerms(a []DataTypeTerm, b []DataTypeTerm) []DataTypeTerm {
+	result := []DataTypeTerm{}
+	for _, x := range a {
+		for _, y := range b {
+			switch {
+			case x.tilde && y.tilde:
+				if x.typ == y.typ {
+					result = append(result, x)
+				}
+			case x.tilde:
+				if x.includes(y.typ) {
+					result = append(result, y)
+				}
+			default:
+				if y.includes(x.typ) {
+					result = append(result, x)
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// MakeConstraint creates an interface type which can be used as a type
+// constraint. If terms is non-nil the type set is limited to types which
+// are in one of the terms - an empty, non-nil list of terms gives an empty
+// type set. If comparable is true it's limited to comparable types.
+// Interfaces like these can only be used as constraints.
+func (ts *DataTypeStore) MakeConstraint(methods []DataTypeMethod, terms []DataTypeTerm, comparable bool) DataType {
+	return ts.makeInterface(methods, terms, comparable)
+}
+
+// isConstraintInterface returns true for interfaces which can only be used
+// as type constraints - ie. ones with type terms or comparable.
+func isConstraintInterface(dt DataType) bool {
+	iface, ok := Underlying(dt).(*DataTypeInterface)
+	return ok && (iface.restricted || iface.comparable)
+}
+
+// coreType gives the type whose operations are allowed on values of a
+// type. For most types it's the underlying type. For a type parameter
+// it's the underlying type shared by every type in its type set, or nil if
+// there isn't one.
+func coreType(dt DataType) DataType {
+	tp, ok := dt.(*DataTypeTypeParam)
+	if !ok {
+		return Underlying(dt)
+	}
+
+	iface := tp.iface()
+	if iface == nil || !iface.restricted || len(iface.terms) == 0 {
+		return nil
+	}
+
+	var core DataType
+	for _, term := range iface.terms {
+		u := Underlying(term.typ)
+		if core != nil && u != core {
+			return nil
+		}
+		core = u
+	}
+
+	return core
+}
+
+// isGeneric returns true for a generic defined type which hasn't been
+// instantiated.
+func isGeneric(dt DataType) bool {
+	named, ok := dt.(*DataTypeNamed)
+	return ok && len(named.typeParams) > 0 && named.origin == nil
+}
+
+// Instantiate gives the instance of a generic defined type with the given
+// type arguments. Each instance is only created once so instances with the
+// same arguments are identical. The type arguments aren't checked against
+// their constraints - use Satisfies for that.
+//
+// Instantiating a generic type with its own type parameters gives the
+// generic type itself, which is how a generic type refers to itself in its
+// declaration.
+func (ts *DataTypeStore) Instantiate(generic *DataTypeNamed, typeArgs []DataType) *DataTypeNamed {
+	own := len(typeArgs) == len(generic.typeParams)
+	for i, arg := range typeArgs {
+		own = own && arg == generic.typeParams[i]
+	}
+	if own {
+		return generic
+	}
+
+	dt := ts.intern(
+		func() string {
+			var key strings.Builder
+			key.WriteString("inst ")
+			key.WriteString(ts.id(generic))
+			key.WriteString("[")
+			for _, arg := range typeArgs {
+				key.WriteString(ts.id(arg))
+				key.WriteString(",")
+			}
+			key.WriteString("]")
+			return key.String()
+		},
+		func() DataType {
+			return &DataTypeNamed{
+				name:     generic.name,
+				pkg:      generic.pkg,
+				pkgName:  generic.pkgName,
+				filename: generic.filename,
+				pos:      generic.pos,
+				origin:   generic,
+				typeArgs: append([]DataType(nil), typeArgs...),
+				store:    ts,
+			}
+		})
+
+	return dt.(*DataTypeNamed)
+}
+
+// type typeSubst maps type parameters to the types which replace them.
+type typeSubst map[*DataTypeTypeParam]DataType
+
+// newTypeSubst maps each type parameter to the type argument at the same
+// position.
+func newTypeSubst(typeParams []*DataTypeTypeParam, typeArgs []DataType) typeSubst {
+	m := make(typeSubst)
+	for i, tp := range typeParams {
+		if i < len(typeArgs) && typeArgs[i] != nil {
+			m[tp] = typeArgs[i]
+		}
+	}
+
+	return m
+}
+
+// subst replaces the type parameters in a type with their type arguments.
+// Types which don't contain any of the type parameters are returned
+// unchanged.
+func (ts *DataTypeStore) subst(dt DataType, m typeSubst) DataType {
+	if len(m) == 0 {
+		return dt
+	}
+
+	switch t := dt.(type) {
+	case *DataTypeTypeParam:
+		if arg, ok := m[t]; ok {
+			return arg
+		}
+
+	case *DataTypeNamed:
+		if t.origin != nil {
+			return ts.Instantiate(t.origin, ts.substList(t.typeArgs, m))
+		}
+		if len(t.typeParams) > 0 {
+			return ts.Instantiate(t, ts.substList(typeParamList(t.typeParams), m))
+		}
+
+	case *DataTypeUnary:
+		sub := ts.subst(t.subType, m)
+		if t.kind == DataTypeKindPointer {
+			return ts.MakePointer(sub)
+		}
+		return ts.MakeSlice(sub)
+
+	case *DataTypeArray:
+		return ts.MakeArray(t.length, ts.subst(t.elementType, m))
+
+	case *DataTypeMap:
+		return ts.MakeMap(ts.subst(t.keyType, m), ts.subst(t.valueType, m))
+
+	case *DataTypeChan:
+		return ts.MakeChan(t.dir, ts.subst(t.elementType, m))
+
+	case *DataTypeFunc:
+		return ts.substFunc(t, m)
+
+	case *DataTypeStruct:
+		fields := make([]DataTypeField, len(t.fields))
+		for i, field := range t.fields {
+			fields[i] = field
+			fields[i].typ = ts.subst(field.typ, m)
+		}
+		return ts.MakeStruct(fields)
+
+	case *DataTypeInterface:
+		methods := make([]DataTypeMethod, len(t.methods))
+		for i, method := range t.methods {
+			methods[i] = DataTypeMethod{method.name, method.pkg, ts.substFunc(method.sig, m)}
+		}
+		var terms []DataTypeTerm
+		if t.restricted {
+			terms = make([]DataTypeTerm, len(t.terms))
+			for i, term := range t.terms {
+				terms[i] = DataTypeTerm{term.tilde, ts.subst(term.typ, m)}
+			}
+		}
+		return ts.makeInterface(methods, terms, t.comparable)
+	}
+
+	return dt
+}
+
+// substFunc replaces the type parameters in a function signature.
+func (ts *DataTypeStore) substFunc(sig *DataTypeFunc, m typeSubst) *DataTypeFunc {
+	return ts.MakeFunc(ts.substList(sig.params, m), ts.substList(sig.returns, m), sig.variadic)
+}
+
+// substList replaces the type parameters in a list of types.
+func (ts *DataTypeStore) substList(types []DataType, m typeSubst) []DataType {
+	result := make([]DataType, len(types))
+	for i, dt := range types {
+		result[i] = ts.subst(dt, m)
+	}
+
+	return result
+}
+
+// Satisfies returns true if a type argument satisfies a constraint - ie.
+// it's in the constraint's type set and has all its methods. If it
+// doesn't, the reason is described.
+func (ts *DataTypeStore) Satisfies(dt DataType, constraint DataType) (bool, string) {
+	iface, ok := Underlying(constraint).(*DataTypeInterface)
+	if !ok {
+		return false, "the constraint isn't an interface"
+	}
+
+	tp, isTypeParam := dt.(*DataTypeTypeParam)
+	if iface.restricted {
+		if isTypeParam {
+			// every type the type parameter allows has to be allowed.
+			own := tp.iface()
+			if own == nil || !own.restricted {
+				return false, fmt.Sprint(tp.name, " allows any type but ", TypeString(constraint, ""), " doesn't")
+			}
+			for _, term := range own.terms {
+				if !termsInclude(iface.terms, term) {
+					return false, fmt.Sprint(tp.name, " allows ", termString(term), " but ", TypeString(constraint, ""), " doesn't")
+				}
+			}
+		} else if !termsInclude(iface.terms, DataTypeTerm{false, dt}) {
+			return false, fmt.Sprint(typeString(dt), " isn't one of the types ", TypeString(constraint, ""), " allows")
+		}
+	}
+
+	if iface.comparable && !isComparable(dt) {
+		return false, fmt.Sprint(typeString(dt), " isn't comparable")
+	}
+
+	if len(iface.methods) > 0 {
+		methods := ts.MakeInterface(iface.methods)
+		if ok, missing := ts.Implements(dt, methods); !ok {
+			return false, missing.String()
+		}
+	}
+
+	return true, ""
+}
+
+// termsInclude returns true if the type set of a term is included in the
+// type set of a list of terms.
+func termsInclude(terms []DataTypeTerm, term DataTypeTerm) bool {
+	for _, t := range terms {
+		if t.tilde && t.typ == Underlying(term.typ) || t == term {
+			return true
+		}
+	}
+
+	return false
+}
+
+// termString gives a term in Go syntax.
+func termString(term DataTypeTerm) string {
+	if term.tilde {
+		return "~" + typeString(term.typ)
+	}
+
+	return typeString(term.typ)
+}
+
+// checkTypeArgs checks type arguments against the constraints of their
+// type parameters. It returns the index of the first which doesn't
+// satisfy its constraint and why, or -1 if they're all ok.
+func (ts *DataTypeStore) checkTypeArgs(typeParams []*DataTypeTypeParam, typeArgs []DataType) (int, string) {
+	m := newTypeSubst(typeParams, typeArgs)
+	for i, tp := range typeParams {
+		// constraints can refer to the other type parameters.
+		constraint := ts.subst(tp.Constraint(), m)
+		if ok, reason := ts.Satisfies(typeArgs[i], constraint); !ok {
+			return i, reason
+		}
+	}
+
+	return -1, ""
+}
+
+// type FuncInstance is a generic function instantiated with type
+// arguments. There's only one FuncInstance for each function and set of
+// type arguments so later passes can use it as the identity of the
+// instantiated code - eg. to generate code once for each instance, or to
+// share code between instances with the same shape and pass the type
+// arguments in a dictionary.
+type FuncInstance struct {
+	pkg        string               // the import path of the package the generic function is declared in
+	name       string               // the generic function's name
+	typeParams []*DataTypeTypeParam // the generic function's type parameters
+	typeArgs   []DataType           // the type arguments
+	sig        *DataTypeFunc        // the signature with the type arguments substituted
+}
+
+// Name returns the name of the generic function.
+func (fi *FuncInstance) Name() string {
+	return fi.name
+}
+
+// Package returns the import path of the package the generic function is
+// declared in.
+func (fi *FuncInstance) Package() string {
+	return fi.pkg
+}
+
+// TypeArgs returns the type arguments.
+func (fi *FuncInstance) TypeArgs() []DataType {
+	return fi.typeArgs
+}
+
+// Signature returns the instance's signature.
+func (fi *FuncInstance) Signature() *DataTypeFunc {
+	return fi.sig
+}
+
+// Key gives a name which uniquely identifies the instance, eg.
+// "main.Map[int,string]". It's the same for every build.
+func (fi *FuncInstance) Key() string {
+	return fi.key(func(dt DataType) string { return typeString(dt) })
+}
+
+// ShapeKey gives a name which is the same for all the instances of a
+// function which can share the same code - ie. whose type arguments have
+// the same underlying types, with all pointers treated alike.
+func (fi *FuncInstance) ShapeKey() string {
+	return fi.key(func(dt DataType) string {
+		if isPointer(dt) {
+			return "*"
+		}
+		return typeString(Underlying(dt))
+	})
+}
+
+// key gives a name for the instance using the given names for the type
+// arguments.
+func (fi *FuncInstance) key(argString func(dt DataType) string) string {
+	var key strings.Builder
+	key.WriteString(qualifiedName(fi.pkg, fi.name))
+	key.WriteByte('[')
+	for i, arg := range fi.typeArgs {
+		if i > 0 {
+			key.WriteByte(',')
+		}
+		key.WriteString(argString(arg))
+	}
+	key.WriteByte(']')
+
+	return key.String()
+}
+
+// InstantiateFunc gives the instance of a generic function with the given
+// type arguments. sig is the generic function's signature. Each instance
+// is only created once.
+func (ts *DataTypeStore) InstantiateFunc(pkg string, name string, typeParams []*DataTypeTypeParam, sig *DataTypeFunc, typeArgs []DataType) *FuncInstance {
+	ts.internMutex.Lock()
+	var key strings.Builder
+	key.WriteString(qualifiedName(pkg, name))
+	for _, arg := range typeArgs {
+		key.WriteString(",")
+		key.WriteString(ts.id(arg))
+	}
+	fi, ok := ts.funcInstances[key.String()]
+	ts.internMutex.Unlock()
+	if ok {
+		return fi
+	}
+
+	// substituting interns types so it can't be done with the lock held.
+	fi = &FuncInstance{pkg, name, typeParams, append([]DataType(nil), typeArgs...), ts.substFunc(sig, newTypeSubst(typeParams, typeArgs))}
+
+	ts.internMutex.Lock()
+	defer ts.internMutex.Unlock()
+	if other, ok := ts.funcInstances[key.String()]; ok {
+		return other
+	}
+	ts.funcInstances[key.String()] = fi
+
+	return fi
+}
+
+// FuncInstances returns all the instances of generic functions created so
+// far, sorted by their keys.
+func (ts *DataTypeStore) FuncInstances() []*FuncInstance {
+	ts.internMutex.Lock()
+	instances := make([]*FuncInstance, 0, len(ts.funcInstances))
+	for _, fi := range ts.funcInstances {
+		instances = append(instances, fi)
+	}
+	ts.internMutex.Unlock()
+
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].Key() < instances[j].Key()
+	})
+
+	return instances
+}
+
+// makeASTTypeParams creates the type parameters from a type parameter
+// list. It returns a context in which the type parameters' names refer to
+// them. The constraints can refer to any of the type parameters.
+func (ts *DataTypeStore) makeASTTypeParams(asts []AST, ctx *TypeContext) ([]*DataTypeTypeParam, *TypeContext, error) {
+	typeParams := make([]*DataTypeTypeParam, len(asts))
+	seen := make(map[string]bool)
+	for i, ast := range asts {
+		ident := ast.(ASTTypeParam).ident.(ASTIdentifier)
+		if seen[ident.name] && ident.name != "_" {
+			return nil, nil, NewError(ctx.filename, ident.Pos(), fmt.Sprint("there's already a type parameter called '", ident.name, "'"))
+		}
+		seen[ident.name] = true
+		typeParams[i] = ts.NewTypeParam(ident.name, i)
+	}
+
+	inner := ts.withTypeParams(ctx, typeParams)
+	for i, ast := range asts {
+		constraint, err := ts.makeASTConstraint(ast.(ASTTypeParam).constraint, inner)
+		if err != nil {
+			return nil, nil, err
+		}
+		typeParams[i].setConstraint(constraint)
+	}
+
+	return typeParams, inner, nil
+}
+
+// makeASTConstraint converts the constraint of a type parameter. A
+// constraint which isn't an interface, like '~int | string', is short for
+// an interface with just that type set.
+func (ts *DataTypeStore) makeASTConstraint(ast AST, ctx *TypeContext) (DataType, error) {
+	switch ast.(type) {
+	case ASTDataTypeUnion, ASTDataTypeTilde:
+		return ts.makeASTInterface(ASTDataTypeInterface{ast.Pos(), []AST{ast}}, ctx)
+	}
+
+	dt, err := ts.makeASTTypeOrConstraint(ast, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// a defined type which hasn't been resolved yet is assumed to be an
+	// interface. it's checked when it's instantiated.
+	if named, ok := dt.(*DataTypeNamed); ok && named.Underlying() == nil {
+		return dt, nil
+	}
+	if _, ok := Underlying(dt).(*DataTypeInterface); ok {
+		return dt, nil
+	}
+	if _, ok := dt.(*DataTypeTypeParam); ok {
+		return nil, NewError(ctx.filename, ast.Pos(), "a type parameter can't be used as a constraint by itself")
+	}
+
+	return ts.MakeConstraint(nil, []DataTypeTerm{{false, dt}}, false), nil
+}
+
+// makeASTInstance converts an instantiation of a generic type, of the form
+// 'T[A, B]', into the instantiated type.
+func (ts *DataTypeStore) makeASTInstance(pos SrcSpan, genericAST AST, argASTs []AST, ctx *TypeContext) (DataType, error) {
+	dt, err := ts.makeASTTypeOrGeneric(genericAST, ctx)
+	if err != nil {
+		return nil, err
+	}
+	generic, ok := dt.(*DataTypeNamed)
+	if !ok || !isGeneric(generic) {
+		return nil, NewError(ctx.filename, genericAST.Pos(), fmt.Sprint(TypeString(dt, ctx.packagePath), " isn't a generic type so it can't have type arguments"))
+	}
+
+	if len(argASTs) != len(generic.typeParams) {
+		return nil, NewError(ctx.filename, pos, fmt.Sprint(generic.name, " has ", len(generic.typeParams), " type parameters but I was given ", len(argASTs), " type arguments"))
+	}
+
+	args := make([]DataType, len(argASTs))
+	for i, argAST := range argASTs {
+		args[i], err = ts.MakeASTType(argAST, ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the constraints might not be known yet if the generic type is being
+	// declared at the same time.
+	check := func() error {
+		if i, reason := ts.checkTypeArgs(generic.typeParams, args); i >= 0 {
+			return NewError(ctx.filename, argASTs[i].Pos(), fmt.Sprint(TypeString(args[i], ctx.packagePath), " doesn't satisfy the constraint of ", generic.typeParams[i].name, " - ", reason))
+		}
+		return nil
+	}
+	if ctx.later != nil {
+		ctx.later(check)
+	} else if err := check(); err != nil {
+		return nil, err
+	}
+
+	return ts.Instantiate(generic, args), nil
+}
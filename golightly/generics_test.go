@@ -0,0 +1,266 @@
+package golightly
+
+import (
+	"testing"
+)
+
+func TestSatisfies(t *testing.T) {
+	ts := NewDataTypeStore()
+	myInt := ts.NewNamed("main", "MyInt", "test.go", SrcSpan{})
+	myInt.SetUnderlying(ts.IntType())
+	number := ts.MakeConstraint(nil, []DataTypeTerm{{true, ts.IntType()}, {true, ts.Float64Type()}}, false)
+	exact := ts.MakeConstraint(nil, []DataTypeTerm{{false, ts.IntType()}}, false)
+	comparable := ts.ComparableType()
+
+	types := []struct {
+		typ        DataType
+		constraint DataType
+		ok         bool
+	}{
+		{ts.IntType(), number, true},
+		{myInt, number, true},
+		{ts.Float64Type(), number, true},
+		{ts.StringType(), number, false},
+		{ts.IntType(), exact, true},
+		{myInt, exact, false},
+		{ts.StringType(), comparable, true},
+		{ts.MakeSlice(ts.IntType()), comparable, false},
+		{ts.MakeMap(ts.IntType(), ts.IntType()), ts.AnyType(), true},
+	}
+
+	for i, tt := range types {
+		if ok, _ := ts.Satisfies(tt.typ, tt.constraint); ok != tt.ok {
+			t.Error("type ", i, " should satisfy its constraint: ", tt.ok)
+		}
+	}
+
+	if ts.MakeConstraint(nil, []DataTypeTerm{{true, ts.IntType()}, {true, ts.Float64Type()}}, false) != number {
+		t.Error("constraints with the same terms aren't identical")
+	}
+}
+
+func TestInstantiate(t *testing.T) {
+	ts := NewDataTypeStore()
+	ta := &testAST{}
+
+	// type List[T any] struct { next *List[T]; val T }
+	decls := []AST{
+		ASTDataTypeDecl{ta.ident("List"), []AST{ASTTypeParam{ta.ident("T"), ta.ident("any")}},
+			ASTDataTypeStruct{ta.pos(), []AST{
+				ASTDataTypeField{ta.ident("next"), ASTDataTypePointer{ta.pos(), ta.index(ta.ident("List"), ta.ident("T"))}, ""},
+				ASTDataTypeField{ta.ident("val"), ta.ident("T"), ""},
+			}}, false},
+	}
+	if err := ts.DeclareTypes("test.go", "main", decls); err != nil {
+		t.Error("error declaring types: ", err)
+		return
+	}
+
+	list := ts.LookupName("main.List").(*DataTypeNamed)
+	if len(list.TypeParams()) != 1 || TypeString(list, "main") != "List[T]" {
+		t.Error("List should have one type parameter but it's ", TypeString(list, "main"))
+	}
+
+	ints := ts.Instantiate(list, []DataType{ts.IntType()})
+	if ts.Instantiate(list, []DataType{ts.IntType()}) != ints {
+		t.Error("instances with the same type arguments aren't identical")
+	}
+	if ts.Instantiate(list, []DataType{ts.StringType()}) == ints {
+		t.Error("instances with different type arguments are identical")
+	}
+	if ints.Origin() != list || TypeString(ints, "main") != "List[int]" || ints.String() != "main.List[int]" {
+		t.Error("instance is written as ", TypeString(ints, "main"))
+	}
+
+	st, ok := ints.Underlying().(*DataTypeStruct)
+	if !ok {
+		t.Error("List[int] isn't a struct")
+		return
+	}
+	if st.Field("next").typ != ts.MakePointer(ints) || st.Field("val").typ != ts.IntType() {
+		t.Error("List[int] has the wrong field types")
+	}
+}
+
+// genericTestDecls declares some generic types and functions for the
+// checker tests:
+//
+//	type Number interface { ~int | ~float64 }
+//	type List[T any] struct { next *List[T]; val T }
+//	func (l *List[E]) Push(v E) *List[E] { l.val = v; return l }
+//	func Map[T, U any](s []T, f func(T) U) []U { var r []U; r = append(r, f(s[0])); return r }
+//	func Keys[M ~map[K]V, K comparable, V any](m M) []K { return nil }
+//	func Sum[T Number](s ...T) T { var t T; t = t + s[0]; return t }
+//	func Eq[T comparable](a, b T) bool { return a == b }
+func genericTestDecls(ta *testAST) []AST {
+	typeParam := func(name string, constraint AST) AST {
+		return ASTTypeParam{ta.ident(name), constraint}
+	}
+	slice := func(typ AST) AST {
+		return ASTDataTypeSlice{ta.pos(), typ}
+	}
+	listOf := func(name string) AST {
+		return ASTDataTypePointer{ta.pos(), ta.index(ta.ident("List"), ta.ident(name))}
+	}
+	sumType := ta.ident("T")
+
+	return []AST{
+		ASTDataTypeDecl{ta.ident("Number"), nil, ASTDataTypeInterface{ta.pos(), []AST{
+			ASTDataTypeUnion{ta.pos(), []AST{ASTDataTypeTilde{ta.pos(), ta.ident("int")}, ASTDataTypeTilde{ta.pos(), ta.ident("float64")}}},
+		}}, false},
+		ASTDataTypeDecl{ta.ident("List"), []AST{typeParam("T", ta.ident("any"))},
+			ASTDataTypeStruct{ta.pos(), []AST{
+				ASTDataTypeField{ta.ident("next"), listOf("T"), ""},
+				ASTDataTypeField{ta.ident("val"), ta.ident("T"), ""},
+			}}, false},
+		ASTFunctionDecl{ta.pos(), "Push", nil, ASTReceiver{ta.pos(), "l", true, "List", []AST{ta.ident("E")}},
+			[]AST{ta.param("v", ta.ident("E"))},
+			[]AST{ta.param("", listOf("E"))},
+			ASTBlock{ta.pos(), []AST{
+				ASTAssign{ta.pos(), TokenKindAssign, []AST{ASTSelector{ta.pos(), ta.ident("l"), "val"}}, []AST{ta.ident("v")}},
+				ASTReturn{ta.pos(), []AST{ta.ident("l")}},
+			}}},
+		ASTFunctionDecl{ta.pos(), "Map", []AST{typeParam("T", ta.ident("any")), typeParam("U", ta.ident("any"))}, nil,
+			[]AST{
+				ta.param("s", slice(ta.ident("T"))),
+				ta.param("f", ASTDataTypeFunc{ta.pos(), []AST{ta.param("", ta.ident("T"))}, []AST{ta.param("", ta.ident("U"))}}),
+			},
+			[]AST{ta.param("", slice(ta.ident("U")))},
+			ASTBlock{ta.pos(), []AST{
+				ta.varDecl("r", slice(ta.ident("U")), nil),
+				ASTAssign{ta.pos(), TokenKindAssign, []AST{ta.ident("r")}, []AST{
+					ta.call(ta.ident("append"), ta.ident("r"), ta.call(ta.ident("f"), ta.index(ta.ident("s"), ta.int(0)))),
+				}},
+				ASTReturn{ta.pos(), []AST{ta.ident("r")}},
+			}}},
+		ASTFunctionDecl{ta.pos(), "Keys",
+			[]AST{
+				typeParam("M", ASTDataTypeTilde{ta.pos(), ASTDataTypeMap{ta.pos(), ta.ident("K"), ta.ident("V")}}),
+				typeParam("K", ta.ident("comparable")),
+				typeParam("V", ta.ident("any")),
+			}, nil,
+			[]AST{ta.param("m", ta.ident("M"))},
+			[]AST{ta.param("", slice(ta.ident("K")))},
+			ASTBlock{ta.pos(), []AST{ASTReturn{ta.pos(), []AST{ta.ident("nil")}}}}},
+		ASTFunctionDecl{ta.pos(), "Sum", []AST{typeParam("T", ta.ident("Number"))}, nil,
+			[]AST{ta.param("s", sumType), ASTParameterDecl{ASTEllipsis{ta.pos()}, sumType}},
+			[]AST{ta.param("", ta.ident("T"))},
+			ASTBlock{ta.pos(), []AST{
+				ta.varDecl("t", ta.ident("T"), nil),
+				ASTAssign{ta.pos(), TokenKindAssign, []AST{ta.ident("t")}, []AST{
+					ta.binary(TokenKindAdd, ta.ident("t"), ta.index(ta.ident("s"), ta.int(0))),
+				}},
+				ASTReturn{ta.pos(), []AST{ta.ident("t")}},
+			}}},
+		ASTFunctionDecl{ta.pos(), "Eq", []AST{typeParam("T", ta.ident("comparable"))}, nil,
+			[]AST{ta.param("a", ta.ident("T")), ta.param("b", ta.ident("T"))},
+			[]AST{ta.param("", ta.ident("bool"))},
+			ASTBlock{ta.pos(), []AST{ASTReturn{ta.pos(), []AST{ta.binary(TokenKindEquals, ta.ident("a"), ta.ident("b"))}}}}},
+		ta.varDecl("ints", slice(ta.ident("int")), nil),
+		ta.varDecl("toStr", ASTDataTypeFunc{ta.pos(), []AST{ta.param("", ta.ident("int"))}, []AST{ta.param("", ta.ident("string"))}}, nil),
+		ta.varDecl("m", ASTDataTypeMap{ta.pos(), ta.ident("string"), ta.ident("float64")}, nil),
+	}
+}
+
+func TestCheckerGenerics(t *testing.T) {
+	ta := &testAST{}
+	ts := NewDataTypeStore()
+	str := ts.StringType()
+	mapCall := ta.call(ta.ident("Map"), ta.ident("ints"), ta.ident("toStr"))
+	mapInstance := ASTInstance{ta.pos(), ta.ident("Map"), []AST{ta.ident("int"), ta.ident("string")}}
+
+	// each expression should be fine and have the given type when it's
+	// used as the value of a variable declaration.
+	exprs := []struct {
+		expr AST
+		typ  func(ts *DataTypeStore) DataType
+	}{
+		{mapCall, func(ts *DataTypeStore) DataType { return ts.MakeSlice(str) }},
+		{mapInstance, func(ts *DataTypeStore) DataType {
+			return ts.MakeFunc([]DataType{ts.MakeSlice(ts.IntType()), ts.MakeFunc([]DataType{ts.IntType()}, []DataType{str}, false)}, []DataType{ts.MakeSlice(str)}, false)
+		}},
+		{ta.call(ta.ident("Keys"), ta.ident("m")), func(ts *DataTypeStore) DataType { return ts.MakeSlice(str) }},
+		{ta.call(ta.ident("Sum"), ta.int(1), ta.int(2)), func(ts *DataTypeStore) DataType { return ts.IntType() }},
+		{ta.call(ta.ident("Sum"), ta.float(1.5), ta.int(2)), func(ts *DataTypeStore) DataType { return ts.Float64Type() }},
+		{ta.call(ta.index(ta.ident("Sum"), ta.ident("float64")), ta.int(1)), func(ts *DataTypeStore) DataType { return ts.Float64Type() }},
+		{ta.call(ta.ident("Eq"), ta.str("a"), ta.str("b")), func(ts *DataTypeStore) DataType { return ts.BoolType() }},
+	}
+
+	var decls []AST
+	for i, expr := range exprs {
+		decls = append(decls, ta.varDecl(string(rune('a'+i)), nil, expr.expr))
+	}
+	decls = append(decls, ta.varDecl("l", ASTInstance{ta.pos(), ta.ident("List"), []AST{ta.ident("int")}}, nil))
+	push := ta.call(ASTSelector{ta.pos(), ta.ident("l"), "Push"}, ta.int(3))
+	decls = append(decls, ta.varDecl("p", nil, push))
+
+	c, errs := checkTestFile(ts, append(genericTestDecls(ta), decls...))
+	for _, err := range errs {
+		t.Error("unexpected error: ", err)
+	}
+
+	for i, expr := range exprs {
+		if typ := c.Info().TypeOf("test.go", expr.expr); typ != expr.typ(ts) {
+			t.Error("expression ", i, " has type ", typ, " but should be ", expr.typ(ts))
+		}
+	}
+
+	list := ts.LookupName("main.List").(*DataTypeNamed)
+	if typ := c.Info().TypeOf("test.go", push); typ != ts.MakePointer(ts.Instantiate(list, []DataType{ts.IntType()})) {
+		t.Error("p has type ", typ, " but should be *List[int]")
+	}
+
+	// the inferred and explicit instances of Map are the same function.
+	inferred := c.Info().InstanceOf("test.go", mapCall.(ASTCall).function)
+	if inferred == nil || inferred != c.Info().InstanceOf("test.go", mapInstance) {
+		t.Error("Map[int, string] should be a single instance")
+	} else if inferred.Key() != "main.Map[int,string]" {
+		t.Error("Map[int, string] has the key ", inferred.Key())
+	}
+
+	keys := ""
+	for _, fi := range ts.FuncInstances() {
+		keys += fi.Key() + " "
+	}
+	if keys != "main.Eq[string] main.Keys[map[string]float64,string,float64] main.Map[int,string] main.Sum[float64] main.Sum[int] " {
+		t.Error("wrong function instances: ", keys)
+	}
+}
+
+func TestCheckerGenericErrors(t *testing.T) {
+	// each of these should give exactly one error.
+	errorDecls := []func(ta *testAST) AST{
+		func(ta *testAST) AST { return ta.varDecl("x", nil, ta.call(ta.ident("Sum"), ta.str("a"))) },
+		func(ta *testAST) AST { return ta.varDecl("x", nil, ta.call(ta.ident("Keys"), ta.ident("ints"))) },
+		func(ta *testAST) AST {
+			return ta.varDecl("x", nil, ta.call(ta.ident("Eq"), ta.ident("ints"), ta.ident("ints")))
+		},
+		func(ta *testAST) AST { return ta.varDecl("x", nil, ta.ident("Map")) },
+		func(ta *testAST) AST { return ta.varDecl("x", nil, ta.index(ta.ident("Map"), ta.ident("int"))) },
+		func(ta *testAST) AST { return ta.varDecl("x", nil, ta.index(ta.ident("Sum"), ta.ident("string"))) },
+		func(ta *testAST) AST {
+			return ta.varDecl("x", nil, ASTInstance{ta.pos(), ta.ident("Eq"), []AST{ta.ident("int"), ta.ident("int")}})
+		},
+		func(ta *testAST) AST { return ta.varDecl("x", ta.ident("List"), nil) },
+		func(ta *testAST) AST { return ta.varDecl("x", ta.ident("Number"), nil) },
+		func(ta *testAST) AST {
+			return ta.varDecl("x", ASTInstance{ta.pos(), ta.ident("List"), []AST{ta.ident("int"), ta.ident("int")}}, nil)
+		},
+		func(ta *testAST) AST { return ta.varDecl("x", ta.index(ta.ident("int"), ta.ident("int")), nil) },
+		// func Less[T any](a, b T) bool { return a < b }
+		func(ta *testAST) AST {
+			return ASTFunctionDecl{ta.pos(), "Less", []AST{ASTTypeParam{ta.ident("T"), ta.ident("any")}}, nil,
+				[]AST{ta.param("a", ta.ident("T")), ta.param("b", ta.ident("T"))},
+				[]AST{ta.param("", ta.ident("bool"))},
+				ASTBlock{ta.pos(), []AST{ASTReturn{ta.pos(), []AST{ta.binary(TokenKindLess, ta.ident("a"), ta.ident("b"))}}}}}
+		},
+	}
+
+	for i, decl := range errorDecls {
+		ta := &testAST{}
+		_, errs := checkTestFile(NewDataTypeStore(), append(genericTestDecls(ta), decl(ta)))
+		if len(errs) != 1 {
+			t.Error("declaration ", i, " should give one error but gave ", len(errs), ": ", errs)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package golightly
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the import path given to the package of the files named on the command
+// line, which can't be imported.
+const mainPackagePath = "main"
+
+// type importEdge is an import of one package by a file of another.
+type importEdge struct {
+	from     string  // the import path of the importing package
+	to       string  // the import path of the imported package
+	fileName string  // the file with the import statement
+	pos      SrcSpan // where the import statement is
+}
+
+// type importGraph records which packages import which so import cycles
+// can be found as imports are added. It's only used by
+// Compiler.importPackages() so it doesn't need a lock.
+type importGraph struct {
+	edges map[string][]importEdge // the imports of each package, in the order they were found
+}
+
+// newImportGraph creates an empty import graph.
+func newImportGraph() *importGraph {
+	return &importGraph{make(map[string][]importEdge)}
+}
+
+// add records an import. If the import completes a cycle it isn't added
+// and the cycle is returned instead, starting with the new import.
+func (g *importGraph) add(edge importEdge) []importEdge {
+	if edge.from == edge.to {
+		return []importEdge{edge}
+	}
+
+	if path := g.path(edge.to, edge.from, make(map[string]bool)); path != nil {
+		return append([]importEdge{edge}, path...)
+	}
+
+	// only the first import of a package by another is kept.
+	for _, e := range g.edges[edge.from] {
+		if e.to == edge.to {
+			return nil
+		}
+	}
+	g.edges[edge.from] = append(g.edges[edge.from], edge)
+
+	return nil
+}
+
+// path finds a chain of imports leading from one package to another. It
+// returns nil if there isn't one.
+func (g *importGraph) path(from string, to string, visited map[string]bool) []importEdge {
+	visited[from] = true
+	for _, edge := range g.edges[from] {
+		if edge.to == to {
+			return []importEdge{edge}
+		}
+		if visited[edge.to] {
+			continue
+		}
+		if path := g.path(edge.to, to, visited); path != nil {
+			return append([]importEdge{edge}, path...)
+		}
+	}
+
+	return nil
+}
+
+// type ImportCycleError reports packages which import each other, directly
+// or indirectly.
+type ImportCycleError struct {
+	cycle []importEdge // the imports which make up the cycle
+}
+
+// Paths returns the import paths of the packages in the cycle, starting
+// and ending with the same package.
+func (e *ImportCycleError) Paths() []string {
+	paths := []string{e.cycle[0].from}
+	for _, edge := range e.cycle {
+		paths = append(paths, edge.to)
+	}
+
+	return paths
+}
+
+// Errors returns an error for each import statement in the cycle.
+func (e *ImportCycleError) Errors() []*Error {
+	var errs []*Error
+	for _, edge := range e.cycle {
+		errs = append(errs, NewError(edge.fileName, edge.pos, fmt.Sprint("package ", edge.from, " imports ", edge.to)))
+	}
+
+	return errs
+}
+
+func (e *ImportCycleError) Error() string {
+	var msg strings.Builder
+	msg.WriteString("import cycle not allowed: ")
+	msg.WriteString(strings.Join(e.Paths(), " imports "))
+	for _, err := range e.Errors() {
+		msg.WriteString("\n\t")
+		msg.WriteString(err.Error())
+	}
+
+	return msg.String()
+}
+
+// diagnostics gives the cycle as an error followed by a note for each
+// import in it. Which import completes the cycle depends on the order the
+// files are parsed in, so the cycle is given starting with the import
+// which comes first in the source.
+func (e *ImportCycleError) diagnostics() []*Error {
+	first := 0
+	for i, edge := range e.cycle {
+		f := e.cycle[first]
+		if edge.fileName < f.fileName || edge.fileName == f.fileName && edge.pos.start.Before(f.pos.start) {
+			first = i
+		}
+	}
+	cycle := &ImportCycleError{append(append([]importEdge{}, e.cycle[first:]...), e.cycle[:first]...)}
+
+	edge := cycle.cycle[0]
+	errs := []*Error{NewError(edge.fileName, edge.pos, "import cycle not allowed: "+strings.Join(cycle.Paths(), " imports "))}
+	for _, note := range cycle.Errors() {
+		note.severity = SeverityNote
+		errs = append(errs, note)
+	}
+
+	return errs
+}
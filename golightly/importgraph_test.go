@@ -0,0 +1,90 @@
+package golightly
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testImport makes an import edge for the tests. Each package is in a file
+// named after it.
+func testImport(from string, to string, line int) importEdge {
+	return importEdge{from, to, from + ".go", SrcSpan{SrcLoc{line, 1}, SrcLoc{line, 7}}}
+}
+
+func TestImportGraphAdd(t *testing.T) {
+	g := newImportGraph()
+	for _, edge := range []importEdge{
+		testImport("main", "a", 3),
+		testImport("main", "b", 4),
+		testImport("a", "b", 3),
+		testImport("a", "b", 4),
+		testImport("b", "c", 3),
+	} {
+		if cycle := g.add(edge); cycle != nil {
+			t.Errorf("unexpected cycle adding %s -> %s: %v", edge.from, edge.to, cycle)
+		}
+	}
+
+	if len(g.edges["a"]) != 1 {
+		t.Error("repeated import of b by a was recorded twice")
+	}
+}
+
+func TestImportGraphCycle(t *testing.T) {
+	tests := []struct {
+		edges []importEdge
+		paths []string
+	}{
+		{[]importEdge{testImport("a", "a", 3)}, []string{"a", "a"}},
+		{[]importEdge{testImport("a", "b", 3), testImport("b", "a", 4)}, []string{"b", "a", "b"}},
+		{[]importEdge{testImport("a", "b", 3), testImport("b", "c", 4), testImport("c", "d", 5), testImport("c", "a", 6)}, []string{"c", "a", "b", "c"}},
+	}
+
+	for _, test := range tests {
+		g := newImportGraph()
+		var cycle []importEdge
+		for i, edge := range test.edges {
+			cycle = g.add(edge)
+			if cycle != nil && i != len(test.edges)-1 {
+				t.Errorf("found a cycle too early: %v", cycle)
+			}
+		}
+		if cycle == nil {
+			t.Errorf("didn't find a cycle, expected %v", test.paths)
+			continue
+		}
+
+		err := &ImportCycleError{cycle}
+		if !reflect.DeepEqual(err.Paths(), test.paths) {
+			t.Errorf("wrong cycle: got %v, expected %v", err.Paths(), test.paths)
+		}
+		if len(err.Errors()) != len(test.paths)-1 {
+			t.Errorf("expected an error for each import, got %v", err.Errors())
+		}
+	}
+}
+
+func TestImportCycleErrorMessage(t *testing.T) {
+	g := newImportGraph()
+	g.add(testImport("a", "b", 3))
+	cycle := g.add(testImport("b", "a", 4))
+	if cycle == nil {
+		t.Fatal("didn't find a cycle")
+	}
+
+	msg := (&ImportCycleError{cycle}).Error()
+	lines := strings.Split(msg, "\n\t")
+	if len(lines) != 3 {
+		t.Fatalf("wrong number of lines in '%s'", msg)
+	}
+	if lines[0] != "import cycle not allowed: b imports a imports b" {
+		t.Errorf("wrong summary: '%s'", lines[0])
+	}
+	if lines[1] != NewError("b.go", SrcSpan{SrcLoc{4, 1}, SrcLoc{4, 7}}, "package b imports a").Error() {
+		t.Errorf("wrong first import: '%s'", lines[1])
+	}
+	if lines[2] != NewError("a.go", SrcSpan{SrcLoc{3, 1}, SrcLoc{3, 7}}, "package a imports b").Error() {
+		t.Errorf("wrong second import: '%s'", lines[2])
+	}
+}
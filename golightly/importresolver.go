@@ -0,0 +1,361 @@
+package golightly
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go/build/constraint"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// the operating systems and architectures which can appear in file name
+// suffixes like "_linux.go" and "_linux_amd64.go".
+var knownOS = map[string]bool{
+	"aix": true, "android": true, "darwin": true, "dragonfly": true, "freebsd": true, "hurd": true, "illumos": true,
+	"ios": true, "js": true, "linux": true, "netbsd": true, "openbsd": true, "plan9": true, "solaris": true,
+	"wasip1": true, "windows": true,
+}
+
+var knownArch = map[string]bool{
+	"386": true, "amd64": true, "arm": true, "arm64": true, "loong64": true, "mips": true, "mipsle": true,
+	"mips64": true, "mips64le": true, "ppc64": true, "ppc64le": true, "riscv64": true, "s390x": true, "wasm": true,
+}
+
+// the operating systems which satisfy the "unix" build constraint.
+var unixOS = map[string]bool{
+	"aix": true, "android": true, "darwin": true, "dragonfly": true, "freebsd": true, "hurd": true, "illumos": true,
+	"ios": true, "linux": true, "netbsd": true, "openbsd": true, "solaris": true,
+}
+
+// type goModule is a module found from a go.mod file.
+type goModule struct {
+	root string // the directory go.mod is in
+	path string // the module path declared in go.mod
+}
+
+// type ImportResolver finds the directory an import path refers to and
+// the source files which make up the package in it.
+//
+// An import path is looked for in, in order:
+//   - the vendor directories of the importing file's directory and its
+//     parents, up to the root of its module,
+//   - the importing file's module, if the path starts with the module path
+//     from its go.mod,
+//   - each directory in the search path, both directly and in its "src"
+//     directory so GOPATH style trees can be used.
+//
+// Paths starting with "./" or "../" are relative to the importing file.
+type ImportResolver struct {
+	fsys       fs.FS           // where the packages are
+	searchPath []string        // directories to look for packages in
+	goos       string          // the operating system files are built for
+	goarch     string          // the architecture files are built for
+	tags       map[string]bool // extra build tags which are satisfied
+
+	mutex   sync.Mutex
+	modules map[string]*goModule // the module each directory is in, or nil if it isn't in one
+}
+
+// NewImportResolver creates an import resolver which looks for packages
+// in the directories of searchPath, eg. from the GOLIGHTLY_PATH
+// environment variable. Files are picked for the given operating system
+// and architecture.
+func NewImportResolver(searchPath []string, goos string, goarch string) *ImportResolver {
+	ir := new(ImportResolver)
+	ir.fsys = osFS{}
+	ir.searchPath = searchPath
+	ir.goos = goos
+	ir.goarch = goarch
+	ir.tags = map[string]bool{"golightly": true}
+	ir.modules = make(map[string]*goModule)
+
+	return ir
+}
+
+// SetFS makes the resolver look for packages in fsys instead of the
+// operating system's files.
+func (ir *ImportResolver) SetFS(fsys fs.FS) {
+	ir.fsys = fsys
+}
+
+// SetTag makes a build tag satisfied, eg. so files marked
+// "//go:build debug" are included.
+func (ir *ImportResolver) SetTag(tag string) {
+	ir.tags[tag] = true
+}
+
+// Resolve finds the directory which an import path refers to. fromDir is
+// the directory of the file which has the import.
+func (ir *ImportResolver) Resolve(importPath string, fromDir string) (string, error) {
+	fromDir, err := ir.abs(fromDir)
+	if err != nil {
+		return "", err
+	}
+
+	// relative imports.
+	if strings.HasPrefix(importPath, "./") || strings.HasPrefix(importPath, "../") {
+		dir := filepath.Join(fromDir, filepath.FromSlash(importPath))
+		if !isDir(ir.fsys, dir) {
+			return "", fmt.Errorf("I can't find package '%s' - there's no directory %s", importPath, dir)
+		}
+		return dir, nil
+	}
+
+	mod, err := ir.findModule(fromDir)
+	if err != nil {
+		return "", err
+	}
+
+	// vendor directories, innermost first.
+	var tried []string
+	for dir := fromDir; ; dir = filepath.Dir(dir) {
+		vendored := filepath.Join(dir, "vendor", filepath.FromSlash(importPath))
+		if isDir(ir.fsys, vendored) {
+			return vendored, nil
+		}
+		tried = append(tried, vendored)
+		if (mod != nil && dir == mod.root) || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	// the module the import is from.
+	if mod != nil && (importPath == mod.path || strings.HasPrefix(importPath, mod.path+"/")) {
+		dir := filepath.Join(mod.root, filepath.FromSlash(strings.TrimPrefix(importPath, mod.path)))
+		if isDir(ir.fsys, dir) {
+			return dir, nil
+		}
+		tried = append(tried, dir)
+	}
+
+	// the search path.
+	for _, root := range ir.searchPath {
+		for _, dir := range []string{filepath.Join(root, "src", filepath.FromSlash(importPath)), filepath.Join(root, filepath.FromSlash(importPath))} {
+			if isDir(ir.fsys, dir) {
+				return dir, nil
+			}
+			tried = append(tried, dir)
+		}
+	}
+
+	return "", fmt.Errorf("I can't find package '%s' - I looked in %s", importPath, strings.Join(tried, ", "))
+}
+
+// findModule finds the module a directory is in by looking for a go.mod
+// file in it and its parents. It returns nil if there isn't one.
+func (ir *ImportResolver) findModule(dir string) (*goModule, error) {
+	ir.mutex.Lock()
+	defer ir.mutex.Unlock()
+
+	var visited []string
+	var mod *goModule
+	for {
+		if m, ok := ir.modules[dir]; ok {
+			mod = m
+			break
+		}
+		visited = append(visited, dir)
+
+		path, err := readModulePath(ir.fsys, filepath.Join(dir, "go.mod"))
+		if err == nil {
+			mod = &goModule{dir, path}
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	for _, dir := range visited {
+		ir.modules[dir] = mod
+	}
+
+	return mod, nil
+}
+
+// readModulePath reads the module path from a go.mod file.
+func readModulePath(fsys fs.FS, filename string) (string, error) {
+	f, err := fsys.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "module" {
+			continue
+		}
+
+		path := fields[1]
+		if unquoted, err := strconv.Unquote(path); err == nil {
+			path = unquoted
+		}
+		return path, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", fmt.Errorf("%s doesn't say what the module path is", filename)
+}
+
+// PackageFiles gives the source files of the package in a directory,
+// sorted by name. Test files, files which start with '_' or '.' and files
+// whose build constraints aren't satisfied are left out.
+func (ir *ImportResolver) PackageFiles(dir string) ([]string, error) {
+	entries, err := fs.ReadDir(ir.fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") || strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if !ir.matchFileName(name) {
+			continue
+		}
+
+		filename := filepath.Join(dir, name)
+		ok, err := ir.matchBuildConstraints(filename)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			files = append(files, filename)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("there aren't any .go files in %s", dir)
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// matchFileName checks the operating system and architecture suffixes of a
+// file name, like "_linux.go" and "_windows_amd64.go".
+func (ir *ImportResolver) matchFileName(name string) bool {
+	parts := strings.Split(strings.TrimSuffix(name, ".go"), "_")
+	n := len(parts)
+	if n >= 3 && knownOS[parts[n-2]] && knownArch[parts[n-1]] {
+		return ir.matchTag(parts[n-2]) && parts[n-1] == ir.goarch
+	}
+	if n >= 2 && knownOS[parts[n-1]] {
+		return ir.matchTag(parts[n-1])
+	}
+	if n >= 2 && knownArch[parts[n-1]] {
+		return parts[n-1] == ir.goarch
+	}
+
+	return true
+}
+
+// matchBuildConstraints checks the build constraints at the start of a
+// file. A "//go:build" line is used if there is one, otherwise all the
+// "// +build" lines must be satisfied.
+func (ir *ImportResolver) matchBuildConstraints(filename string) (bool, error) {
+	f, err := ir.fsys.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	var goBuild constraint.Expr
+	var plusBuild []constraint.Expr
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "//") {
+			// constraints have to come before the package clause.
+			break
+		}
+		if !constraint.IsGoBuild(line) && !constraint.IsPlusBuild(line) {
+			continue
+		}
+
+		expr, err := constraint.Parse(line)
+		if err != nil {
+			return false, fmt.Errorf("%s has a bad build constraint: %s", filename, err)
+		}
+		if constraint.IsGoBuild(line) {
+			goBuild = expr
+		} else {
+			plusBuild = append(plusBuild, expr)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+
+	if goBuild != nil {
+		return goBuild.Eval(ir.matchTag), nil
+	}
+	for _, expr := range plusBuild {
+		if !expr.Eval(ir.matchTag) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// matchTag returns true if a build tag is satisfied.
+func (ir *ImportResolver) matchTag(tag string) bool {
+	switch {
+	case tag == ir.goos || tag == ir.goarch || ir.tags[tag]:
+		return true
+	case tag == "unix":
+		return unixOS[ir.goos]
+	case tag == "linux":
+		return ir.goos == "android"
+	case tag == "darwin":
+		return ir.goos == "ios"
+	case tag == "solaris":
+		return ir.goos == "illumos"
+	case strings.HasPrefix(tag, "go1."):
+		// any release of the language.
+		return true
+	}
+
+	return false
+}
+
+// abs makes a directory name absolute so its parents can be looked in. In
+// a file system without a current directory names are already absolute.
+func (ir *ImportResolver) abs(dir string) (string, error) {
+	if fsys, ok := ir.fsys.(absFS); ok {
+		return fsys.Abs(dir)
+	}
+
+	return filepath.Clean(dir), nil
+}
+
+// isDir returns true if a path is a directory.
+func isDir(fsys fs.FS, path string) bool {
+	info, err := fs.Stat(fsys, path)
+	return err == nil && info.IsDir()
+}
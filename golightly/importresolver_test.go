@@ -0,0 +1,127 @@
+package golightly
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestFiles creates files under a directory, creating directories as
+// needed.
+func writeTestFiles(t *testing.T, root string, files map[string]string) {
+	for name, src := range files {
+		filename := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestImportResolverResolve(t *testing.T) {
+	root := t.TempDir()
+	libs := t.TempDir()
+	writeTestFiles(t, root, map[string]string{
+		"go.mod":                         "// the app\nmodule example.com/app\n\ngo 1.21\n",
+		"main.go":                        "package main\n",
+		"util/util.go":                   "package util\n",
+		"cmd/tool/tool.go":               "package main\n",
+		"vendor/github.com/x/y/y.go":     "package y\n",
+		"cmd/vendor/github.com/x/y/y.go": "package y\n",
+	})
+	writeTestFiles(t, libs, map[string]string{
+		"src/lib/lib.go":                   "package lib\n",
+		"flat/flat.go":                     "package flat\n",
+		"src/example.com/app/util/util.go": "package util\n",
+	})
+
+	ir := NewImportResolver([]string{libs}, "linux", "amd64")
+	paths := []struct {
+		path string
+		from string
+		dir  string
+	}{
+		{"example.com/app/util", root, filepath.Join(root, "util")},
+		{"example.com/app/util", filepath.Join(root, "cmd", "tool"), filepath.Join(root, "util")},
+		{"github.com/x/y", root, filepath.Join(root, "vendor", "github.com", "x", "y")},
+		{"github.com/x/y", filepath.Join(root, "util"), filepath.Join(root, "vendor", "github.com", "x", "y")},
+		{"github.com/x/y", filepath.Join(root, "cmd", "tool"), filepath.Join(root, "cmd", "vendor", "github.com", "x", "y")},
+		{"lib", root, filepath.Join(libs, "src", "lib")},
+		{"flat", root, filepath.Join(libs, "flat")},
+		{"./util", root, filepath.Join(root, "util")},
+		{"../../util", filepath.Join(root, "cmd", "tool"), filepath.Join(root, "util")},
+	}
+
+	for _, p := range paths {
+		dir, err := ir.Resolve(p.path, p.from)
+		if err != nil {
+			t.Error("error resolving ", p.path, ": ", err)
+		} else if dir != p.dir {
+			t.Error(p.path, " from ", p.from, " resolved to ", dir, " but should be ", p.dir)
+		}
+	}
+
+	for _, path := range []string{"nowhere", "example.com/app/missing", "./missing"} {
+		if _, err := ir.Resolve(path, root); err == nil || !strings.Contains(err.Error(), "I can't find package") {
+			t.Error("resolving ", path, " should fail but gave: ", err)
+		}
+	}
+
+	// without a go.mod the module path means nothing, but the search path
+	// still works.
+	if dir, err := ir.Resolve("example.com/app/util", libs); err != nil || dir != filepath.Join(libs, "src", "example.com", "app", "util") {
+		t.Error("example.com/app/util should be found in the search path: ", dir, err)
+	}
+}
+
+func TestImportResolverPackageFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.go":             "package p\n",
+		"a_test.go":        "package p\n",
+		"_b.go":            "package p\n",
+		"c_linux.go":       "package p\n",
+		"c_windows.go":     "package p\n",
+		"d_linux_amd64.go": "package p\n",
+		"d_linux_arm64.go": "package p\n",
+		"e_arm64.go":       "package p\n",
+		"f.go":             "// a comment\n\n//go:build linux && !cgo\n\npackage p\n",
+		"g.go":             "//go:build ignore\n\npackage p\n",
+		"h.go":             "// +build windows\n\npackage p\n",
+		"i.go":             "// +build linux darwin\n// +build amd64\n\npackage p\n",
+		"j.go":             "//go:build unix && go1.18\n\npackage p\n",
+		"k.go":             "package p\n\n//go:build ignore\n",
+		"notes.txt":        "not go\n",
+	})
+
+	ir := NewImportResolver(nil, "linux", "amd64")
+	files, err := ir.PackageFiles(dir)
+	if err != nil {
+		t.Error("unexpected error: ", err)
+		return
+	}
+
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	if got := strings.Join(names, " "); got != "a.go c_linux.go d_linux_amd64.go f.go i.go j.go k.go" {
+		t.Error("wrong files: ", got)
+	}
+
+	ir.SetTag("ignore")
+	if files, _ := ir.PackageFiles(dir); len(files) != 8 {
+		t.Error("the ignore tag should add g.go")
+	}
+
+	writeTestFiles(t, dir, map[string]string{"bad.go": "//go:build linux &&\n\npackage p\n"})
+	if _, err := ir.PackageFiles(dir); err == nil {
+		t.Error("a bad build constraint should be an error")
+	}
+	if _, err := ir.PackageFiles(t.TempDir()); err == nil {
+		t.Error("an empty directory should be an error")
+	}
+}
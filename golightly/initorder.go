@@ -0,0 +1,241 @@
+package golightly
+
+import "strings"
+
+// type InitPlan is the order a package is initialised in. First its
+// variables are initialised, each one after all the variables its
+// initialiser depends on, then its init functions are run in the order
+// they're declared.
+type InitPlan struct {
+	vars  []*Symbol // the package level variables with initialisers, in the order they're initialised
+	inits []*Symbol // the init functions, in declaration order
+}
+
+// Vars returns the package level variables which have initialisers, in
+// the order they're initialised.
+func (ip *InitPlan) Vars() []*Symbol {
+	return ip.vars
+}
+
+// Inits returns the package's init functions in the order they're run.
+func (ip *InitPlan) Inits() []*Symbol {
+	return ip.inits
+}
+
+// funcSymbol returns the symbol of a function declaration. Methods and
+// init functions aren't declared in the package scope so they're given
+// symbols of their own, with methods named like "T.m". They're recorded
+// as the declarations' definitions too.
+func (c *Checker) funcSymbol(filename string, d ASTFunctionDecl) *Symbol {
+	key := exprKey{filename, d.pos}
+	if d.receiver == nil {
+		if sym := c.info.defs[key]; sym != nil {
+			return sym
+		}
+	}
+
+	sym, ok := c.funcs[key]
+	if !ok {
+		name := d.name
+		if d.receiver != nil {
+			name = d.receiver.(ASTReceiver).typeName + "." + d.name
+		}
+		sym = &Symbol{kind: SymbolFunc, name: name, filename: filename, pos: d.pos, decl: d}
+		c.funcs[key] = sym
+		c.info.defs[key] = sym
+	}
+
+	return sym
+}
+
+// methodSymbol returns the symbol of a method.
+func (c *Checker) methodSymbol(method *NamedMethod) *Symbol {
+	return c.funcSymbol(method.filename, method.decl)
+}
+
+// reference records that the package level declaration being checked
+// refers to a package level variable, function or type, or to a method.
+// References to anything else are ignored.
+func (c *Checker) reference(obj *Symbol) {
+	if c.decl == nil {
+		return
+	}
+	switch obj.kind {
+	case SymbolVar, SymbolType:
+		if c.pkgScope.LookupLocal(obj.name) != obj {
+			return
+		}
+	case SymbolFunc:
+	default:
+		return
+	}
+
+	for _, dep := range c.decl.deps {
+		if dep == obj {
+			return
+		}
+	}
+	c.decl.deps = append(c.decl.deps, obj)
+}
+
+// initOrder works out the order the package is initialised in and reports
+// any initialisation cycles. The spec says that the next variable to be
+// initialised is the earliest one in declaration order which doesn't
+// depend on any variables which haven't been initialised yet. A variable
+// depends on the variables its initialiser refers to, and on the ones
+// which are referred to by the functions and methods it refers to.
+func (c *Checker) initOrder(files []*sourceFile) *InitPlan {
+	plan := new(InitPlan)
+	var vars []*Symbol
+	for _, sf := range files {
+		for _, decl := range sf.ast.(ASTTopLevel).topLevelDecls {
+			switch d := decl.(type) {
+			case ASTVarDecl:
+				sym := c.info.defs[exprKey{sf.fileName, d.ident.Pos()}]
+				if sym != nil && d.value != nil {
+					vars = append(vars, sym)
+				}
+
+			case ASTFunctionDecl:
+				if d.name == "init" && d.receiver == nil {
+					plan.inits = append(plan.inits, c.funcSymbol(sf.fileName, d))
+				}
+			}
+		}
+	}
+
+	// each cycle is reported once, at the first variable in it.
+	inCycle := make(map[*Symbol]bool)
+	for _, v := range vars {
+		if inCycle[v] {
+			continue
+		}
+		path := referencePath(v, v, make(map[*Symbol]bool))
+		if path == nil {
+			continue
+		}
+
+		cycle := append([]*Symbol{v}, path...)
+		for _, sym := range cycle {
+			inCycle[sym] = true
+		}
+		c.filename = v.filename
+		c.errorf(v.pos, "initialization cycle: %s", describeReferences(cycle))
+	}
+
+	// the variables in cycles can't be initialised, and neither can
+	// anything which depends on them, directly or not.
+	deps := make(map[*Symbol]map[*Symbol]bool)
+	for _, v := range vars {
+		deps[v] = make(map[*Symbol]bool)
+		varDeps(v, deps[v], make(map[*Symbol]bool))
+	}
+
+	blocked := make(map[*Symbol]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, v := range vars {
+			if !blocked[v] && (inCycle[v] || !ready(deps[v], blocked)) {
+				blocked[v] = true
+				changed = true
+			}
+		}
+	}
+
+	pending := make(map[*Symbol]bool)
+	for _, v := range vars {
+		pending[v] = !blocked[v]
+	}
+
+	for {
+		var next *Symbol
+		for _, v := range vars {
+			if pending[v] && ready(deps[v], pending) {
+				next = v
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+
+		plan.vars = append(plan.vars, next)
+		pending[next] = false
+	}
+
+	return plan
+}
+
+// ready returns true if none of a variable's dependencies are still
+// waiting to be initialised.
+func ready(deps map[*Symbol]bool, pending map[*Symbol]bool) bool {
+	for dep := range deps {
+		if pending[dep] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// varDeps finds the variables a declaration depends on, either directly or
+// through the functions and methods it refers to.
+func varDeps(sym *Symbol, deps map[*Symbol]bool, visited map[*Symbol]bool) {
+	for _, dep := range sym.deps {
+		if visited[dep] {
+			continue
+		}
+		visited[dep] = true
+
+		switch dep.kind {
+		case SymbolVar:
+			deps[dep] = true
+		case SymbolFunc:
+			varDeps(dep, deps, visited)
+		}
+	}
+}
+
+// referencePath finds a chain of references from one declaration to
+// another, not including the first. It returns nil if there isn't one.
+func referencePath(from *Symbol, to *Symbol, visited map[*Symbol]bool) []*Symbol {
+	visited[from] = true
+	for _, dep := range from.deps {
+		if dep.kind == SymbolType {
+			// types don't run any code.
+			continue
+		}
+		if dep == to {
+			return []*Symbol{dep}
+		}
+		if visited[dep] {
+			continue
+		}
+		if path := referencePath(dep, to, visited); path != nil {
+			return append([]*Symbol{dep}, path...)
+		}
+	}
+
+	return nil
+}
+
+// describeReferences describes a chain of references like "'a' refers to
+// 'f', which refers to 'a'".
+func describeReferences(chain []*Symbol) string {
+	if len(chain) == 2 && chain[0] == chain[1] {
+		return "'" + chain[0].name + "' refers to itself"
+	}
+
+	var msg strings.Builder
+	for i, sym := range chain {
+		switch {
+		case i == 1:
+			msg.WriteString(" refers to ")
+		case i > 1:
+			msg.WriteString(", which refers to ")
+		}
+		msg.WriteString("'" + sym.name + "'")
+	}
+
+	return msg.String()
+}
@@ -0,0 +1,108 @@
+package golightly
+
+import "testing"
+
+func TestInitOrder(t *testing.T) {
+	ta := &testAST{}
+	intResult := []AST{ta.param("", ta.ident("int"))}
+	file := func(name string, decls ...AST) *sourceFile {
+		return &sourceFile{packageName: "main", fileName: name, ast: ASTTopLevel{topLevelDecls: decls}}
+	}
+	fn := func(name string, returns []AST, body ...AST) AST {
+		return ASTFunctionDecl{ta.pos(), name, nil, nil, nil, returns, ASTBlock{ta.pos(), body}}
+	}
+	ret := func(value AST) AST {
+		return ASTReturn{ta.pos(), []AST{value}}
+	}
+
+	// a.go: var a = b + c; var b = f(); func init() {}
+	// b.go: var c = 1; var d int; func f() int { return c }; func init() {}
+	initA := fn("init", nil)
+	initB := fn("init", nil)
+	a := file("a.go",
+		ta.varDecl("a", nil, ta.binary(TokenKindAdd, ta.ident("b"), ta.ident("c"))),
+		ta.varDecl("b", nil, ta.call(ta.ident("f"))),
+		initA,
+	)
+	b := file("b.go",
+		ta.varDecl("c", nil, ta.int(1)),
+		ta.varDecl("d", ta.ident("int"), nil),
+		fn("f", intResult, ret(ta.ident("c"))),
+		initB,
+	)
+
+	c, errs := checkTestFiles(NewDataTypeStore(), []*sourceFile{a, b})
+	if len(errs) != 0 {
+		t.Fatal("unexpected errors: ", errs)
+	}
+
+	plan := c.Info().InitPlan()
+	var order []string
+	for _, sym := range plan.Vars() {
+		order = append(order, sym.Name())
+	}
+	if len(order) != 3 || order[0] != "c" || order[1] != "b" || order[2] != "a" {
+		t.Error("the variables should be initialised in the order c, b, a but are ", order)
+	}
+	inits := plan.Inits()
+	if len(inits) != 2 || inits[0].Filename() != "a.go" || inits[1].Filename() != "b.go" {
+		t.Error("the init functions should be in declaration order: ", inits)
+	}
+
+	// each of these has a single cycle.
+	cycles := []struct {
+		decls   []AST
+		message string
+	}{
+		{
+			// var x = g(); func g() int { return x }
+			[]AST{ta.varDecl("x", nil, ta.call(ta.ident("g"))), fn("g", intResult, ret(ta.ident("x")))},
+			"initialization cycle: 'x' refers to 'g', which refers to 'x'",
+		},
+		{
+			// var y = T(0).m(); type T int; func (T) m() int { return y }
+			[]AST{
+				ta.varDecl("y", nil, ta.call(ASTSelector{ta.pos(), ta.call(ta.ident("T"), ta.int(0)), "m"})),
+				ASTDataTypeDecl{ta.ident("T"), nil, ta.ident("int"), false},
+				ASTFunctionDecl{ta.pos(), "m", nil, ASTReceiver{ta.pos(), "", false, "T", nil}, nil, intResult, ASTBlock{ta.pos(), []AST{ret(ta.ident("y"))}}},
+			},
+			"initialization cycle: 'y' refers to 'T.m', which refers to 'y'",
+		},
+		{
+			// var p = q; var q = h(); func h() int { return p }
+			[]AST{
+				ta.varDecl("p", nil, ta.ident("q")),
+				ta.varDecl("q", nil, ta.call(ta.ident("h"))),
+				fn("h", intResult, ret(ta.ident("p"))),
+			},
+			"initialization cycle: 'p' refers to 'q', which refers to 'h', which refers to 'p'",
+		},
+	}
+
+	for i, test := range cycles {
+		c, errs := checkTestFile(NewDataTypeStore(), test.decls)
+		if len(errs) != 1 || errs[0].message != test.message {
+			t.Error("cycle ", i, " should give '", test.message, "' but gave: ", errs)
+		}
+		if len(c.Info().InitPlan().Vars()) != 0 {
+			t.Error("cycle ", i, " shouldn't initialise anything but has ", c.Info().InitPlan().Vars())
+		}
+	}
+
+	// a variable which depends on a cycle can't be initialised either, even
+	// through another variable, but one which doesn't can be.
+	// var x = g(); func g() int { return x }; var z = x; var w = z; var v = 1
+	c, errs = checkTestFile(NewDataTypeStore(), []AST{
+		ta.varDecl("x", nil, ta.call(ta.ident("g"))),
+		fn("g", intResult, ret(ta.ident("x"))),
+		ta.varDecl("z", nil, ta.ident("x")),
+		ta.varDecl("w", nil, ta.ident("z")),
+		ta.varDecl("v", nil, ta.int(1)),
+	})
+	if len(errs) != 1 {
+		t.Error("expected one cycle but got: ", errs)
+	}
+	if vars := c.Info().InitPlan().Vars(); len(vars) != 1 || vars[0].Name() != "v" {
+		t.Error("only v should be initialised but the plan has ", vars)
+	}
+}
@@ -0,0 +1,263 @@
+package golightly
+
+// type Target describes the machine code is generated for. It decides the
+// sizes and alignments of types, which match those used by the gc
+// compiler so structs can be shared with host Go code without copying.
+type Target struct {
+	name     string   // the GOARCH name, eg. "amd64"
+	wordSize DataSize // the size of int, uint, uintptr and pointers
+	maxAlign int64    // the largest alignment of any type, in bytes
+}
+
+// the standard targets.
+var (
+	TargetAMD64 = NewTarget("amd64", DataSize64, 8)
+	Target386   = NewTarget("386", DataSize32, 4)
+	TargetARM64 = NewTarget("arm64", DataSize64, 8)
+)
+
+// NewTarget creates a description of a target machine. Use this for
+// targets other than the standard ones.
+func NewTarget(name string, wordSize DataSize, maxAlign int64) *Target {
+	return &Target{name, wordSize, maxAlign}
+}
+
+// LookupTarget finds a standard target by its GOARCH name. It returns nil
+// if there's no such target.
+func LookupTarget(name string) *Target {
+	for _, t := range []*Target{TargetAMD64, Target386, TargetARM64} {
+		if t.name == name {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// Name returns the target's GOARCH name.
+func (t *Target) Name() string {
+	return t.name
+}
+
+// WordSize returns the size of int, uint, uintptr and pointers.
+func (t *Target) WordSize() DataSize {
+	return t.wordSize
+}
+
+// MaxAlign returns the largest alignment any type has, in bytes.
+func (t *Target) MaxAlign() int64 {
+	return t.maxAlign
+}
+
+// word returns the size of a pointer in bytes.
+func (t *Target) word() int64 {
+	return int64(t.wordSize.Bits(DataSize64) / 8)
+}
+
+// type structLayout is the layout of a struct on the target.
+type structLayout struct {
+	offsets []int64 // the offset of each field
+	size    int64   // the size of the whole struct, including padding
+	align   int64   // the alignment of the struct
+}
+
+// alignTo rounds an offset up to a multiple of an alignment.
+func alignTo(offset int64, align int64) int64 {
+	return (offset + align - 1) / align * align
+}
+
+// Sizeof returns the size in bytes of a value of a type on the target.
+// Untyped values have the size of their default type.
+func (ts *DataTypeStore) Sizeof(dt DataType) int64 {
+	word := ts.target.word()
+	switch t := Underlying(dt).(type) {
+	case DataTypeBasic:
+		switch t.kind {
+		case DataTypeKindBool:
+			return 1
+		case DataTypeKindString:
+			return 2 * word
+		case DataTypeKindUntypedNil:
+			return word
+		}
+		if isUntyped(t) {
+			return ts.Sizeof(defaultType(ts, t))
+		}
+
+	case DataTypeSized:
+		return int64(ts.Bits(t) / 8)
+
+	case *DataTypeUnary:
+		if t.kind == DataTypeKindSlice {
+			return 3 * word
+		}
+		return word
+
+	case *DataTypeArray:
+		return t.length * ts.Sizeof(t.elementType)
+
+	case *DataTypeMap, *DataTypeChan, *DataTypeFunc:
+		return word
+
+	case *DataTypeInterface:
+		return 2 * word
+
+	case *DataTypeStruct:
+		return ts.structLayout(t).size
+	}
+
+	return 0
+}
+
+// Alignof returns the alignment in bytes of a value of a type on the
+// target.
+func (ts *DataTypeStore) Alignof(dt DataType) int64 {
+	var align int64
+	switch t := Underlying(dt).(type) {
+	case DataTypeBasic:
+		switch {
+		case t.kind == DataTypeKindBool:
+			align = 1
+		case isUntyped(t) && t.kind != DataTypeKindUntypedNil:
+			align = ts.Alignof(defaultType(ts, t))
+		default:
+			align = ts.target.word()
+		}
+
+	case DataTypeSized:
+		// complex numbers are aligned like their parts.
+		align = int64(ts.Bits(t) / 8)
+		if t.kind == DataTypeKindComplex {
+			align /= 2
+		}
+
+	case *DataTypeArray:
+		align = ts.Alignof(t.elementType)
+
+	case *DataTypeStruct:
+		align = ts.structLayout(t).align
+
+	default:
+		align = ts.target.word()
+	}
+
+	if align > ts.target.maxAlign {
+		align = ts.target.maxAlign
+	}
+
+	return align
+}
+
+// Offsetsof returns the offset in bytes of each field of a struct.
+func (ts *DataTypeStore) Offsetsof(st *DataTypeStruct) []int64 {
+	return ts.structLayout(st).offsets
+}
+
+// Offsetof returns the offset in bytes of a field within a struct. The
+// field can be in an embedded struct as long as it isn't reached through
+// a pointer. The second result is false if there's no such field.
+func (ts *DataTypeStore) Offsetof(dt DataType, name string) (int64, bool) {
+	index, indirect, found := lookupFieldPath(dt, name)
+	if !found || indirect {
+		return 0, false
+	}
+
+	return ts.offsetOfPath(dt, index), true
+}
+
+// offsetOfPath adds up the offsets of the fields along a path of embedded
+// fields.
+func (ts *DataTypeStore) offsetOfPath(dt DataType, index []int) int64 {
+	var offset int64
+	for _, i := range index {
+		st := Underlying(dt).(*DataTypeStruct)
+		offset += ts.Offsetsof(st)[i]
+		dt = st.fields[i].typ
+	}
+
+	return offset
+}
+
+// structLayout works out the layout of a struct. Layouts are cached since
+// types are never changed once they're created.
+func (ts *DataTypeStore) structLayout(st *DataTypeStruct) *structLayout {
+	ts.layoutMutex.Lock()
+	layout := ts.layouts[st]
+	ts.layoutMutex.Unlock()
+	if layout != nil {
+		return layout
+	}
+
+	layout = &structLayout{offsets: make([]int64, len(st.fields)), align: 1}
+	var offset, lastSize int64
+	for i, field := range st.fields {
+		align := ts.Alignof(field.typ)
+		if align > layout.align {
+			layout.align = align
+		}
+		offset = alignTo(offset, align)
+		layout.offsets[i] = offset
+		lastSize = ts.Sizeof(field.typ)
+		offset += lastSize
+	}
+
+	// gc doesn't let a zero sized final field point past the end of a
+	// struct which has a size.
+	if n := len(st.fields); n > 0 && layout.offsets[n-1] > 0 && lastSize == 0 {
+		offset++
+	}
+	layout.size = alignTo(offset, layout.align)
+
+	ts.layoutMutex.Lock()
+	ts.layouts[st] = layout
+	ts.layoutMutex.Unlock()
+
+	return layout
+}
+
+// lookupFieldPath finds a field of a struct type, searching through
+// embedded fields. It returns the index of the field in each struct along
+// the way and whether a pointer has to be followed to get to it.
+func lookupFieldPath(dt DataType, name string) (index []int, indirect bool, found bool) {
+	seen := make(map[DataType]bool)
+	current := []embeddedType{{dt, nil, false}}
+	for len(current) > 0 {
+		var next []embeddedType
+		matches := 0
+		for _, e := range current {
+			if seen[e.typ] {
+				continue
+			}
+			seen[e.typ] = true
+
+			st, ok := Underlying(e.typ).(*DataTypeStruct)
+			if !ok {
+				continue
+			}
+			for i, field := range st.fields {
+				path := append(append([]int(nil), e.index...), i)
+				if field.name == name {
+					index, indirect = path, e.indirect
+					matches++
+				}
+				if field.embedded {
+					typ, ptr := field.typ, false
+					if p, ok := typ.(*DataTypeUnary); ok && p.kind == DataTypeKindPointer {
+						typ, ptr = p.subType, true
+					}
+					next = append(next, embeddedType{typ, path, e.indirect || ptr})
+				}
+			}
+		}
+
+		if matches == 1 {
+			return index, indirect, true
+		}
+		if matches > 1 {
+			return nil, false, false
+		}
+		current = next
+	}
+
+	return nil, false, false
+}
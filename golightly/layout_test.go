@@ -0,0 +1,134 @@
+package golightly
+
+import (
+	"testing"
+)
+
+// layoutTestStruct makes a struct with unnamed fields of the given types.
+func layoutTestStruct(ts *DataTypeStore, types ...DataType) *DataTypeStruct {
+	fields := make([]DataTypeField, len(types))
+	for i, typ := range types {
+		fields[i] = DataTypeField{name: string(rune('a' + i)), pkg: "main", typ: typ}
+	}
+
+	return ts.MakeStruct(fields).(*DataTypeStruct)
+}
+
+func TestLayout(t *testing.T) {
+	// the sizes and alignments gc uses on each target.
+	targets := []struct {
+		target       *Target
+		str, slice   int64
+		complexAlign int64
+		mixed        []int64 // offsets of struct { bool; int64; bool }
+		mixedSize    int64
+	}{
+		{TargetAMD64, 16, 24, 8, []int64{0, 8, 16}, 24},
+		{TargetARM64, 16, 24, 8, []int64{0, 8, 16}, 24},
+		{Target386, 8, 12, 4, []int64{0, 4, 12}, 16},
+	}
+
+	for _, tt := range targets {
+		ts := NewDataTypeStoreTarget(tt.target)
+		name := tt.target.Name()
+		if ts.Sizeof(ts.StringType()) != tt.str || ts.Sizeof(ts.MakeSlice(ts.IntType())) != tt.slice {
+			t.Error(name, ": wrong string or slice size")
+		}
+		if ts.Sizeof(ts.Complex128Type()) != 16 || ts.Alignof(ts.Complex128Type()) != tt.complexAlign || ts.Alignof(ts.Complex64Type()) != 4 {
+			t.Error(name, ": wrong complex layout")
+		}
+		if ts.Sizeof(ts.MakeArray(3, ts.Int16Type())) != 6 || ts.Alignof(ts.MakeArray(3, ts.Int16Type())) != 2 {
+			t.Error(name, ": wrong array layout")
+		}
+
+		mixed := layoutTestStruct(ts, ts.BoolType(), ts.Int64Type(), ts.BoolType())
+		offsets := ts.Offsetsof(mixed)
+		for i := range offsets {
+			if offsets[i] != tt.mixed[i] {
+				t.Error(name, ": field ", i, " has offset ", offsets[i], " but should have ", tt.mixed[i])
+			}
+		}
+		if ts.Sizeof(mixed) != tt.mixedSize {
+			t.Error(name, ": struct has size ", ts.Sizeof(mixed), " but should have ", tt.mixedSize)
+		}
+	}
+
+	ts := NewDataTypeStore()
+	empty := layoutTestStruct(ts)
+	if ts.Sizeof(empty) != 0 || ts.Alignof(empty) != 1 {
+		t.Error("an empty struct should have size 0")
+	}
+	if ts.Sizeof(layoutTestStruct(ts, ts.Int64Type(), empty)) != 16 {
+		t.Error("a zero sized final field should be padded")
+	}
+	if ts.Sizeof(layoutTestStruct(ts, ts.Int8Type(), ts.Int16Type(), ts.Int32Type())) != 8 {
+		t.Error("small fields should be packed")
+	}
+
+	// fields of embedded structs have offsets too, unless they're
+	// reached through a pointer.
+	named := func(name string, st *DataTypeStruct) DataType {
+		dt := ts.NewNamed("main", name, "test.go", SrcSpan{})
+		dt.SetUnderlying(st)
+		return dt
+	}
+	inner := named("Inner", layoutTestStruct(ts, ts.Int32Type(), ts.Int64Type()))
+	pointed := named("P", ts.MakeStruct([]DataTypeField{{name: "c", pkg: "main", typ: ts.BoolType()}}).(*DataTypeStruct))
+	outer := ts.MakeStruct([]DataTypeField{
+		{name: "x", pkg: "main", typ: ts.BoolType()},
+		{name: "Inner", typ: inner, embedded: true},
+		{name: "P", typ: ts.MakePointer(pointed), embedded: true},
+	})
+	if offset, ok := ts.Offsetof(outer, "b"); !ok || offset != 16 {
+		t.Error("embedded field has offset ", offset, " but should have 16")
+	}
+	if _, ok := ts.Offsetof(outer, "c"); ok {
+		t.Error("a field reached through a pointer shouldn't have an offset")
+	}
+}
+
+func TestCheckerUnsafe(t *testing.T) {
+	ta := &testAST{}
+	unsafeIdent := func(name string) AST {
+		return ASTIdentifier{ta.pos(), "unsafe", name}
+	}
+
+	// var s string; var p struct { a bool; b int64 }
+	exprs := []struct {
+		expr AST
+		val  string
+	}{
+		{ta.call(unsafeIdent("Sizeof"), ta.ident("s")), "16"},
+		{ta.call(unsafeIdent("Sizeof"), ta.int(1)), "8"},
+		{ta.call(unsafeIdent("Alignof"), ta.ident("p")), "8"},
+		{ta.call(unsafeIdent("Offsetof"), ASTSelector{ta.pos(), ta.ident("p"), "b"}), "8"},
+	}
+
+	decls := []AST{
+		ta.varDecl("s", ta.ident("string"), nil),
+		ta.varDecl("p", ASTDataTypeStruct{ta.pos(), []AST{
+			ASTDataTypeField{ta.ident("a"), ta.ident("bool"), ""},
+			ASTDataTypeField{ta.ident("b"), ta.ident("int64"), ""},
+		}}, nil),
+	}
+	for i, e := range exprs {
+		decls = append(decls, ASTConstDecl{ta.ident(string(rune('A' + i))), nil, e.expr, 0})
+	}
+
+	imports := []AST{ASTImport{ta.pos(), nil, ASTValue{ta.pos(), ValueString{"unsafe"}}}}
+	sf := &sourceFile{packageName: "main", fileName: "test.go", ast: ASTTopLevel{imports: imports, topLevelDecls: decls}}
+	c := NewChecker(NewDataTypeStore(), "main")
+	for _, err := range c.CheckFiles([]*sourceFile{sf}) {
+		t.Error("unexpected error: ", err)
+	}
+
+	for i, e := range exprs {
+		val, ok := c.Info().ValueOf("test.go", e.expr)
+		if !ok || val.String() != e.val {
+			t.Error("expression ", i, " is ", val, " but should be ", e.val)
+		}
+		if c.Info().TypeOf("test.go", e.expr) != c.ts.UintptrType() {
+			t.Error("expression ", i, " should be a uintptr")
+		}
+	}
+}
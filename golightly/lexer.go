@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
 	"unicode"
 )
 
@@ -37,27 +38,8 @@ var keywords map[string]TokenKind = map[string]TokenKind{
 	"type":        TokenKindTypeKeyword,
 	"var":         TokenKindVar,
 
-	// pre-declared identifiers - XXX move these to declarations in "universe" later.
-	"bool":       TokenKindBool,
-	"byte":       TokenKindByte,
-	"complex64":  TokenKindComplex64,
-	"complex128": TokenKindComplex128,
-	"error":      TokenKindError,
-	"float32":    TokenKindFloat32,
-	"float64":    TokenKindFloat64,
-	"int":        TokenKindInt,
-	"int8":       TokenKindInt8,
-	"int16":      TokenKindInt16,
-	"int32":      TokenKindInt32,
-	"int64":      TokenKindInt64,
-	"rune":       TokenKindRune,
-	"string":     TokenKindString,
-	"uint":       TokenKindUint,
-	"uint8":      TokenKindUint8,
-	"uint16":     TokenKindUint16,
-	"uint32":     TokenKindUint32,
-	"uint64":     TokenKindUint64,
-	"uintptr":    TokenKindUintPtr,
+	// pre-declared identifiers aren't keywords. the pre-declared types are
+	// names in the DataTypeStore and the rest are yet to be done.
 	/*
 		"true":       TokenKindTrue,
 		"false":      TokenKindFalse,
@@ -84,7 +66,8 @@ var keywords map[string]TokenKind = map[string]TokenKind{
 // the running state of the lexical analyser
 type Lexer struct {
 	sourceFile string  // name of the source file
-	pos        SrcSpan // where we are in the source file
+	pos        SrcSpan // where we are in the source file. the end is where the next rune is.
+	last       SrcLoc  // where the last rune which was read is
 
 	reader          *bufio.Reader         // used to read the input file
 	nextRune        rune                  // the next rune in input
@@ -149,22 +132,9 @@ func (l *Lexer) getBufferedRune() (rune, error) {
 }
 
 // getUntrackedRune gets a rune while removing comments from the stream.
-// it doesn't change the line/column tracking.
+// it doesn't change the line/column tracking. it's designed to be called
+// from peekRune() and getRune() only.
 func (l *Lexer) getUntrackedRune() (rune, error) {
-	// do we have a buffered rune with comments already removed?
-	if l.ncNextRuneCount > 0 {
-		// get it from the nc (non-commented) buffer
-		r := l.ncNextRunes[0]
-
-		// remove it from the buffer
-		for i := l.ncNextRuneCount - 1; i > 0; i-- {
-			l.ncNextRunes[i-1] = l.ncNextRunes[i]
-		}
-		l.ncNextRuneCount--
-
-		return r, nil
-	}
-
 	// get a rune
 	r, err := l.getBufferedRune()
 	if err != nil {
@@ -255,7 +225,7 @@ func (l *Lexer) peekRune(ahead int) (rune, error) {
 	// make sure the buffer is full enough
 	for l.ncNextRuneCount <= ahead {
 		// get a character
-		r, err := l.getRune()
+		r, err := l.getUntrackedRune()
 		if err != nil {
 			return 0, err
 		}
@@ -272,13 +242,27 @@ func (l *Lexer) peekRune(ahead int) (rune, error) {
 // getRune gets a rune while removing comments from the stream and tracking
 // line/column counts.
 func (l *Lexer) getRune() (rune, error) {
-	// get the next character
-	ch, err := l.getUntrackedRune()
-	if err != nil {
-		return 0, err
+	// get the next character, either from the peek buffer or the input
+	var ch rune
+	if l.ncNextRuneCount > 0 {
+		// get it from the nc (non-commented) buffer
+		ch = l.ncNextRunes[0]
+
+		// remove it from the buffer
+		for i := 1; i < l.ncNextRuneCount; i++ {
+			l.ncNextRunes[i-1] = l.ncNextRunes[i]
+		}
+		l.ncNextRuneCount--
+	} else {
+		var err error
+		ch, err = l.getUntrackedRune()
+		if err != nil {
+			return 0, err
+		}
 	}
 
 	// count columns and lines
+	l.last = l.pos.end
 	if ch == '\n' {
 		l.pos.end.Line++
 		l.pos.end.Column = 1
@@ -328,6 +312,17 @@ func (l *Lexer) skipWhitespace() error {
 	}
 }
 
+// tokenPos gives the span of the token which is being read, from its
+// first rune to its last.
+func (l *Lexer) tokenPos() SrcSpan {
+	if l.last.Before(l.pos.start) {
+		// nothing has been read, like at the end of the source.
+		return SrcSpan{l.pos.start, l.pos.start}
+	}
+
+	return SrcSpan{l.pos.start, l.last}
+}
+
 // GetToken gets the next token from the buffer.
 // returns the token and an error.
 func (l *Lexer) GetToken() (Token, error) {
@@ -337,7 +332,7 @@ func (l *Lexer) GetToken() (Token, error) {
 		t := l.nextTokens[0]
 
 		// remove it from the buffer
-		for i := l.nextTokenCount - 1; i > 0; i-- {
+		for i := 1; i < l.nextTokenCount; i++ {
 			l.nextTokens[i-1] = l.nextTokens[i]
 		}
 		l.nextTokens[l.nextTokenCount-1] = nil
@@ -383,6 +378,9 @@ func (l *Lexer) lexToken() (Token, error) {
 
 	// get the next character
 	ch, err := l.peekRune(0)
+	if err == io.EOF {
+		return SimpleToken{l.tokenPos(), TokenKindEndOfSource}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -395,11 +393,11 @@ func (l *Lexer) lexToken() (Token, error) {
 		// is it a keyword?
 		token, ok := keywords[word]
 		if ok {
-			return SimpleToken{l.pos, token}, nil
+			return SimpleToken{l.tokenPos(), token}, nil
 		}
 
 		// it must be an identifier
-		return StringToken{SimpleToken{l.pos, TokenKindIdentifier}, word}, nil
+		return StringToken{SimpleToken{l.tokenPos(), TokenKindIdentifier}, word}, nil
 	}
 
 	// is it a numeric literal?
@@ -419,7 +417,7 @@ func (l *Lexer) lexToken() (Token, error) {
 	token, runes, isOp := l.getOperator(ch)
 	if isOp {
 		l.tossRunes(runes)
-		return SimpleToken{l.pos, token}, nil
+		return SimpleToken{l.tokenPos(), token}, nil
 	}
 
 	// is it a string literal?
@@ -609,8 +607,8 @@ func (l *Lexer) getWord() string {
 			return word
 		}
 
-		// done at end of word
-		if !unicode.IsLetter(ch) && ch != '_' {
+		// done at end of word. digits can't start a word but can follow.
+		if !unicode.IsLetter(ch) && ch != '_' && (word == "" || !unicode.IsDigit(ch)) {
 			return word
 		}
 
@@ -620,12 +618,13 @@ func (l *Lexer) getWord() string {
 	}
 }
 
-// getNumeric gets a number.
-// XXX - this is currently a quickie version. This should be reimplemented fully according to spec later.
+// getNumeric gets a number. It accepts all of Go's numeric literal forms.
+// The literal's text is kept in the token so its exact value can be
+// worked out later.
 func (l *Lexer) getNumeric() (Token, error) {
 	// get characters until the end
 	var word string
-	var isFloat bool
+	isHex := false
 	for {
 		// get the next rune
 		ch, err := l.peekRune(0)
@@ -633,39 +632,43 @@ func (l *Lexer) getNumeric() (Token, error) {
 			break
 		}
 
-		// done at end of word
-		if !unicode.IsDigit(ch) && ch != '.' && ch != 'e' {
+		// a sign can only be part of a number straight after an exponent.
+		if ch == '+' || ch == '-' {
+			last := word[len(word)-1]
+			if last != 'p' && last != 'P' && (isHex || last != 'e' && last != 'E') {
+				break
+			}
+		} else if !unicode.IsDigit(ch) && !unicode.IsLetter(ch) && ch != '_' && ch != '.' {
 			break
 		}
 
-		// take note if it looks like a float
-		if ch == '.' || ch == 'e' {
-			isFloat = true
-		}
-
 		// add the character to our word and move to the next character
 		word += string(ch)
 		l.getRune()
+		if word == "0x" || word == "0X" {
+			isHex = true
+		}
 	}
 
-	// is the next character a "." or "e"? If so, it's a float.
-	if isFloat {
-		// parse the float
-		v, err := strconv.ParseFloat(word, 128)
-		if err != nil {
-			return nil, NewError(l.sourceFile, l.pos, err.Error())
-		}
+	// it's a float if it has a decimal point or an exponent.
+	kind := TokenKindLiteralInt
+	if strings.ContainsAny(word, ".pP") || !isHex && strings.ContainsAny(word, "eE") {
+		kind = TokenKindLiteralFloat
+	}
 
-		return FloatToken{SimpleToken{l.pos, TokenKindLiteralFloat}, v}, nil
-	} else {
-		// it's an int, parse it
-		v, err := strconv.ParseUint(word, 10, 64)
-		if err != nil {
-			return nil, NewError(l.sourceFile, l.pos, err.Error())
-		}
+	if _, ok := MakeConstantFromLiteral(word, kind); !ok {
+		return nil, NewError(l.sourceFile, l.tokenPos(), fmt.Sprint("I don't understand the number '", word, "'"))
+	}
 
-		return UintToken{SimpleToken{l.pos, TokenKindLiteralInt}, v}, nil
+	if kind == TokenKindLiteralFloat {
+		// this is only approximate - the exact value comes from the text.
+		v, _ := strconv.ParseFloat(strings.TrimSuffix(word, "i"), 64)
+		return FloatToken{SimpleToken{l.tokenPos(), kind}, v, word}, nil
 	}
+
+	// this is only set if it fits in a uint64.
+	v, _ := strconv.ParseUint(strings.TrimSuffix(word, "i"), 0, 64)
+	return UintToken{SimpleToken{l.tokenPos(), kind}, v, word}, nil
 }
 
 // getRuneLiteral gets a single character rune literal.
@@ -677,10 +680,10 @@ func (l *Lexer) getRuneLiteral() (Token, error) {
 	}
 
 	if len(str) != 1 {
-		return nil, NewError(l.sourceFile, l.pos, "this rune should be a single character")
+		return nil, NewError(l.sourceFile, l.tokenPos(), "this rune should be a single character")
 	}
 
-	return UintToken{SimpleToken{l.pos, TokenKindLiteralRune}, uint64(str[0])}, nil
+	return UintToken{SimpleToken{l.tokenPos(), TokenKindLiteralRune}, uint64(str[0]), ""}, nil
 }
 
 // getStringLiteral gets a string literal.
@@ -692,7 +695,7 @@ func (l *Lexer) getStringLiteral() (Token, error) {
 	}
 
 	// we're at the end of the string
-	return StringToken{SimpleToken{l.pos, TokenKindLiteralString}, string(str)}, nil
+	return StringToken{SimpleToken{l.tokenPos(), TokenKindLiteralString}, string(str)}, nil
 }
 
 // getStringLiteralSimple gets a string literal, returning it as a []rune.
@@ -707,7 +710,7 @@ func (l *Lexer) getStringLiteralSimple() ([]rune, error) {
 		ch, err := l.getRune()
 		if err != nil {
 			// just return what we've got
-			return nil, NewError(l.sourceFile, l.pos, "no closing quote")
+			return nil, NewError(l.sourceFile, l.tokenPos(), "no closing quote")
 		}
 
 		if ch == quote {
@@ -0,0 +1,236 @@
+package golightly
+
+import (
+	"fmt"
+	"sort"
+)
+
+// type MethodSetEntry is a method in the method set of a type. The method
+// is either declared on the type itself or promoted from a field embedded
+// in it.
+type MethodSetEntry struct {
+	DataTypeMethod
+	index    []int        // the embedded fields it's promoted through, outermost first
+	indirect bool         // true if a pointer is followed to get to the receiver
+	method   *NamedMethod // the declared method, or nil for an interface method
+}
+
+// Name returns the method name.
+func (mse *MethodSetEntry) Name() string {
+	return mse.name
+}
+
+// Signature returns the method signature, without a receiver.
+func (mse *MethodSetEntry) Signature() *DataTypeFunc {
+	return mse.sig
+}
+
+// Index returns the indexes of the embedded fields the method is promoted
+// through. It's empty for methods declared on the type itself.
+func (mse *MethodSetEntry) Index() []int {
+	return mse.index
+}
+
+// Method returns the declaration of the method, or nil if it's a method
+// of an interface.
+func (mse *MethodSetEntry) Method() *NamedMethod {
+	return mse.method
+}
+
+// key gives the name used to match the method with an interface method.
+// Unexported methods from different packages never match.
+func (mse *MethodSetEntry) key() string {
+	return qualifiedName(mse.pkg, mse.name)
+}
+
+// type embeddedType is a type found while searching through embedded fields.
+type embeddedType struct {
+	typ      DataType // the embedded type, with any pointer removed
+	index    []int    // the fields it's embedded through
+	indirect bool     // true if a pointer was followed to get here
+}
+
+// MethodSet returns the method set of a type, sorted by name.
+//
+// The method set of a defined type T contains the methods declared with a
+// receiver of type T. The method set of *T also has the methods declared
+// with a receiver of type *T. Methods of embedded fields are promoted to
+// the struct they're embedded in as long as a shallower field or method
+// doesn't have the same name. Names which are found more than once at the
+// same depth cancel each other out.
+func (ts *DataTypeStore) MethodSet(dt DataType) []*MethodSetEntry {
+	indirect := false
+	if ptr, ok := dt.(*DataTypeUnary); ok && ptr.kind == DataTypeKindPointer {
+		if isInterface(ptr.subType) {
+			// pointers to interfaces don't have any methods.
+			return nil
+		}
+		dt = ptr.subType
+		indirect = true
+	}
+
+	// search the embedded types a level at a time. a nil entry is a name
+	// which is ambiguous or is hidden by a field.
+	found := make(map[string]*MethodSetEntry)
+	seen := make(map[DataType]bool)
+	current := []embeddedType{{dt, nil, indirect}}
+	for len(current) > 0 {
+		var next []embeddedType
+		level := make(map[string]*MethodSetEntry)
+		count := make(map[string]int)
+		add := func(key string, entry *MethodSetEntry) {
+			level[key] = entry
+			count[key]++
+		}
+
+		for _, e := range current {
+			// a type embedded twice at the same depth is counted twice so
+			// its names are ambiguous.
+			if seen[e.typ] {
+				continue
+			}
+
+			if named, ok := e.typ.(*DataTypeNamed); ok {
+				for _, method := range named.Methods() {
+					entry := &MethodSetEntry{method.DataTypeMethod, e.index, e.indirect, method}
+					add(entry.key(), entry)
+				}
+			}
+
+			switch u := Underlying(e.typ).(type) {
+			case *DataTypeStruct:
+				for i, field := range u.fields {
+					// fields hide methods with the same name.
+					add(qualifiedName(field.pkg, field.name), nil)
+					if !field.embedded {
+						continue
+					}
+
+					typ, ptr := field.typ, false
+					if p, ok := typ.(*DataTypeUnary); ok && p.kind == DataTypeKindPointer {
+						typ, ptr = p.subType, true
+					}
+					index := append(append([]int(nil), e.index...), i)
+					next = append(next, embeddedType{typ, index, e.indirect || ptr})
+				}
+
+			case *DataTypeInterface:
+				for _, method := range u.methods {
+					entry := &MethodSetEntry{method, e.index, e.indirect, nil}
+					add(entry.key(), entry)
+				}
+
+			case *DataTypeTypeParam:
+				// a type parameter has the methods of its constraint.
+				if iface := u.iface(); iface != nil && !e.indirect {
+					for _, method := range iface.methods {
+						entry := &MethodSetEntry{method, e.index, e.indirect, nil}
+						add(entry.key(), entry)
+					}
+				}
+			}
+		}
+
+		// shallower names win over deeper ones.
+		for key, entry := range level {
+			if _, ok := found[key]; ok {
+				continue
+			}
+			if count[key] > 1 {
+				entry = nil
+			}
+			found[key] = entry
+		}
+
+		for _, e := range current {
+			seen[e.typ] = true
+		}
+		current = next
+	}
+
+	// methods with pointer receivers need a pointer to call them.
+	var methods []*MethodSetEntry
+	for _, entry := range found {
+		if entry != nil && (entry.method == nil || !entry.method.pointerReceiver || entry.indirect) {
+			methods = append(methods, entry)
+		}
+	}
+
+	sort.Slice(methods, func(i, j int) bool {
+		return methods[i].name < methods[j].name
+	})
+
+	return methods
+}
+
+// type MissingMethod describes why a type doesn't implement an interface.
+type MissingMethod struct {
+	name            string        // the name of the method
+	have            *DataTypeFunc // the signature the type's method has, if it has the wrong type
+	want            *DataTypeFunc // the signature the interface needs
+	pointerReceiver bool          // true if only the pointer type has the method
+}
+
+// Name returns the name of the method.
+func (mm *MissingMethod) Name() string {
+	return mm.name
+}
+
+// WrongType returns true if the type has the method but it has the wrong
+// signature.
+func (mm *MissingMethod) WrongType() bool {
+	return mm.have != nil
+}
+
+// String gives a readable reason for the type not implementing the
+// interface.
+func (mm *MissingMethod) String() string {
+	switch {
+	case mm.have != nil:
+		return fmt.Sprint("it has the wrong type for method ", mm.name, " - it's ", mm.name, signatureString(mm.have), " but it should be ", mm.name, signatureString(mm.want))
+	case mm.pointerReceiver:
+		return fmt.Sprint("method ", mm.name, " has a pointer receiver so only a pointer has it")
+	}
+
+	return fmt.Sprint("it's missing method ", mm.name)
+}
+
+// Implements returns true if the type dt implements the interface type
+// iface. If it doesn't, the first method which is missing or has the wrong
+// type is described.
+func (ts *DataTypeStore) Implements(dt DataType, iface DataType) (bool, *MissingMethod) {
+	it, ok := Underlying(iface).(*DataTypeInterface)
+	if !ok {
+		return false, nil
+	}
+	if len(it.methods) == 0 {
+		return true, nil
+	}
+
+	methods := make(map[string]*MethodSetEntry)
+	for _, entry := range ts.MethodSet(dt) {
+		methods[entry.key()] = entry
+	}
+
+	for _, want := range it.methods {
+		entry, ok := methods[qualifiedName(want.pkg, want.name)]
+		switch {
+		case !ok:
+			mm := &MissingMethod{name: want.name, want: want.sig}
+			if !isPointer(dt) && !isInterface(dt) {
+				// it might be there with a pointer receiver.
+				for _, entry := range ts.MethodSet(ts.MakePointer(dt)) {
+					if entry.name == want.name && entry.pkg == want.pkg {
+						mm.pointerReceiver = true
+					}
+				}
+			}
+			return false, mm
+
+		case entry.sig != want.sig:
+			return false, &MissingMethod{want.name, entry.sig, want.sig, false}
+		}
+	}
+
+	return true, nil
+}
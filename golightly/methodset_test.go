@@ -0,0 +1,175 @@
+package golightly
+
+import (
+	"testing"
+)
+
+// methodSetTestDecls declares some types and methods for the method set
+// tests. Celsius and Kelvin have a String method. Celsius also has a Set
+// method with a pointer receiver. The declarations are built directly
+// since the parser can't handle method signatures or embedded fields yet.
+func methodSetTestDecls(ta *testAST) []AST {
+	str := ta.ident("string")
+	typeDecl := func(name string, typ AST) AST {
+		return ASTDataTypeDecl{ta.ident(name), nil, typ, false}
+	}
+	iface := func(method string, params []AST, returns []AST) AST {
+		return ASTDataTypeInterface{ta.pos(), []AST{ASTDataTypeMethodSpec{ta.pos(), method, params, returns}}}
+	}
+	structOf := func(fields ...AST) AST {
+		return ASTDataTypeStruct{ta.pos(), fields}
+	}
+	embed := func(typ AST) AST {
+		return ASTDataTypeField{nil, typ, ""}
+	}
+	method := func(recv string, pointer bool, name string, params []AST, returns []AST, body []AST) AST {
+		return ASTFunctionDecl{ta.pos(), name, nil, ASTReceiver{ta.pos(), "r", pointer, recv, nil}, params, returns, ASTBlock{ta.pos(), body}}
+	}
+
+	return []AST{
+		typeDecl("Stringer", iface("String", nil, []AST{ta.param("", str)})),
+		typeDecl("Setter", iface("Set", []AST{ta.param("f", ta.ident("float64"))}, nil)),
+		typeDecl("WrongStringer", iface("String", nil, []AST{ta.param("", ta.ident("int"))})),
+		typeDecl("Celsius", ta.ident("float64")),
+		typeDecl("Kelvin", ta.ident("float64")),
+		typeDecl("Temp", structOf(embed(ta.ident("Celsius")))),
+		typeDecl("PTemp", structOf(embed(ASTDataTypePointer{ta.pos(), ta.ident("Celsius")}))),
+		typeDecl("Both", structOf(embed(ta.ident("Celsius")), embed(ta.ident("Kelvin")))),
+		typeDecl("Hidden", structOf(embed(ta.ident("Temp")), ASTDataTypeField{ta.ident("String"), ta.ident("int"), ""})),
+		method("Celsius", false, "String", nil, []AST{ta.param("", str)}, []AST{ASTReturn{ta.pos(), []AST{ta.str("C")}}}),
+		method("Celsius", true, "Set", []AST{ta.param("f", ta.ident("float64"))}, nil, nil),
+		method("Kelvin", false, "String", nil, []AST{ta.param("", str)}, []AST{ASTReturn{ta.pos(), []AST{ta.str("K")}}}),
+	}
+}
+
+// methodNames gives the names of the methods in a method set.
+func methodNames(methods []*MethodSetEntry) string {
+	names := ""
+	for _, method := range methods {
+		names += method.Name() + " "
+	}
+
+	return names
+}
+
+func TestMethodSet(t *testing.T) {
+	ta := &testAST{}
+	ts := NewDataTypeStore()
+	_, errs := checkTestFile(ts, methodSetTestDecls(ta))
+	for _, err := range errs {
+		t.Error("unexpected error: ", err)
+	}
+
+	lookup := func(name string) DataType {
+		return ts.LookupName("main." + name)
+	}
+
+	sets := []struct {
+		typ     DataType
+		methods string
+	}{
+		{lookup("Celsius"), "String "},
+		{ts.MakePointer(lookup("Celsius")), "Set String "},
+		{lookup("Temp"), "String "},
+		{ts.MakePointer(lookup("Temp")), "Set String "},
+		{lookup("PTemp"), "Set String "},
+		{lookup("Both"), ""},
+		{ts.MakePointer(lookup("Hidden")), "Set "},
+		{lookup("Stringer"), "String "},
+		{ts.MakePointer(lookup("Stringer")), ""},
+		{ts.IntType(), ""},
+	}
+
+	for _, set := range sets {
+		names := methodNames(ts.MethodSet(set.typ))
+		if names != set.methods {
+			t.Error("method set of ", typeString(set.typ), " is '", names, "' but should be '", set.methods, "'")
+		}
+	}
+
+	methods := ts.MethodSet(lookup("PTemp"))
+	if len(methods) != 2 || len(methods[0].Index()) != 1 || methods[0].Method() == nil {
+		t.Error("promoted method has the wrong path")
+	}
+}
+
+func TestImplements(t *testing.T) {
+	ta := &testAST{}
+	ts := NewDataTypeStore()
+	checkTestFile(ts, methodSetTestDecls(ta))
+
+	lookup := func(name string) DataType {
+		return ts.LookupName("main." + name)
+	}
+
+	good := []struct {
+		typ   DataType
+		iface string
+	}{
+		{lookup("Celsius"), "Stringer"},
+		{ts.MakePointer(lookup("Celsius")), "Setter"},
+		{lookup("Temp"), "Stringer"},
+		{lookup("PTemp"), "Setter"},
+		{lookup("Stringer"), "Stringer"},
+		{ts.IntType(), "any"},
+	}
+
+	for _, g := range good {
+		iface := lookup(g.iface)
+		if g.iface == "any" {
+			iface = ts.AnyType()
+		}
+		if ok, missing := ts.Implements(g.typ, iface); !ok {
+			t.Error(typeString(g.typ), " should implement ", g.iface, " but ", missing)
+		}
+	}
+
+	bad := []struct {
+		typ             DataType
+		iface           string
+		wrongType       bool
+		pointerReceiver bool
+	}{
+		{lookup("Celsius"), "Setter", false, true},
+		{lookup("Temp"), "Setter", false, true},
+		{lookup("Celsius"), "WrongStringer", true, false},
+		{lookup("Both"), "Stringer", false, false},
+		{ts.IntType(), "Stringer", false, false},
+	}
+
+	for _, b := range bad {
+		ok, missing := ts.Implements(b.typ, lookup(b.iface))
+		if ok {
+			t.Error(typeString(b.typ), " shouldn't implement ", b.iface)
+		} else if missing.WrongType() != b.wrongType || missing.pointerReceiver != b.pointerReceiver {
+			t.Error("wrong reason for ", typeString(b.typ), " not implementing ", b.iface, ": ", missing)
+		}
+	}
+}
+
+func TestCheckerInterfaces(t *testing.T) {
+	// each of these should give exactly one error.
+	errorDecls := []func(ta *testAST) []AST{
+		// var c Celsius; var s Setter = c
+		func(ta *testAST) []AST {
+			return []AST{ta.varDecl("c", ta.ident("Celsius"), nil), ta.varDecl("s", ta.ident("Setter"), ta.ident("c"))}
+		},
+		// var s Stringer; var x = s.(Kelvin); var y = s.(Setter); var z = s.(int)
+		func(ta *testAST) []AST {
+			return []AST{
+				ta.varDecl("s", ta.ident("Stringer"), nil),
+				ta.varDecl("x", nil, ASTTypeAssertion{ta.pos(), ta.ident("s"), ta.ident("Kelvin")}),
+				ta.varDecl("y", nil, ASTTypeAssertion{ta.pos(), ta.ident("s"), ta.ident("Setter")}),
+				ta.varDecl("z", nil, ASTTypeAssertion{ta.pos(), ta.ident("s"), ta.ident("int")}),
+			}
+		},
+	}
+
+	for i, decls := range errorDecls {
+		ta := &testAST{}
+		_, errs := checkTestFile(NewDataTypeStore(), append(methodSetTestDecls(ta), decls(ta)...))
+		if len(errs) != 1 {
+			t.Error("declarations ", i, " should give one error but gave ", len(errs), ": ", errs)
+		}
+	}
+}
@@ -0,0 +1,666 @@
+package golightly
+
+import (
+	"fmt"
+	"sync"
+)
+
+// type DataTypeNamed is a defined type - ie. one declared using
+// 'type X Y'. Every declaration creates a new type which is different to
+// every other type, even if they have the same underlying type.
+//
+// A generic type has type parameters. Each instance of it with a different
+// set of type arguments is a separate defined type which has the generic
+// type as its origin. An instance's underlying type and methods are those
+// of its origin with the type arguments substituted.
+type DataTypeNamed struct {
+	name     string  // the type's name
+	pkg      string  // the import path of the package it's declared in. empty for predeclared types.
+	pkgName  string  // the name of that package, which the type is qualified with when it's printed.
+	filename string  // where it was declared
+	pos      SrcSpan // where it was declared
+
+	typeParams []*DataTypeTypeParam // the type parameters of a generic type
+	origin     *DataTypeNamed       // the generic type this is an instance of
+	typeArgs   []DataType           // the type arguments of an instance
+	store      *DataTypeStore       // the store an instance is expanded in
+
+	mutex      sync.RWMutex   // guards the following
+	underlying DataType       // the underlying type. nil until the declaration is resolved.
+	methods    []*NamedMethod // methods declared with this type as the receiver base type
+}
+
+func (dtn *DataTypeNamed) DataTypeKind() DataTypeKind {
+	return DataTypeKindNamed
+}
+
+// Name returns the type's name.
+func (dtn *DataTypeNamed) Name() string {
+	return dtn.name
+}
+
+// Package returns the import path of the package the type was declared
+// in.
+func (dtn *DataTypeNamed) Package() string {
+	return dtn.pkg
+}
+
+// TypeParams returns the type parameters of a generic type.
+func (dtn *DataTypeNamed) TypeParams() []*DataTypeTypeParam {
+	return dtn.typeParams
+}
+
+// Origin returns the generic type an instance was instantiated from. It's
+// nil for types which aren't instances.
+func (dtn *DataTypeNamed) Origin() *DataTypeNamed {
+	return dtn.origin
+}
+
+// TypeArgs returns the type arguments of an instance of a generic type.
+func (dtn *DataTypeNamed) TypeArgs() []DataType {
+	return dtn.typeArgs
+}
+
+// Underlying returns the type's underlying type. It's never another
+// defined type.
+func (dtn *DataTypeNamed) Underlying() DataType {
+	dtn.mutex.RLock()
+	underlying := dtn.underlying
+	dtn.mutex.RUnlock()
+	if underlying != nil || dtn.origin == nil {
+		return underlying
+	}
+
+	// instances are expanded when they're first needed since their origin
+	// might not have been resolved when they were created.
+	underlying = dtn.origin.Underlying()
+	if underlying == nil {
+		return nil
+	}
+	underlying = dtn.store.subst(underlying, newTypeSubst(dtn.origin.typeParams, dtn.typeArgs))
+
+	dtn.mutex.Lock()
+	defer dtn.mutex.Unlock()
+	if dtn.underlying == nil {
+		dtn.underlying = underlying
+	}
+
+	return dtn.underlying
+}
+
+// Methods returns the methods declared on this type. For an instance of a
+// generic type they're the generic type's methods with the type arguments
+// substituted.
+func (dtn *DataTypeNamed) Methods() []*NamedMethod {
+	if dtn.origin != nil {
+		methods := dtn.origin.Methods()
+		instances := make([]*NamedMethod, len(methods))
+		for i, method := range methods {
+			instances[i] = dtn.instanceMethod(method)
+		}
+		return instances
+	}
+
+	dtn.mutex.RLock()
+	defer dtn.mutex.RUnlock()
+
+	return dtn.methods
+}
+
+// Method finds a method declared on this type. It returns nil if there's
+// no such method.
+func (dtn *DataTypeNamed) Method(name string) *NamedMethod {
+	if dtn.origin != nil {
+		method := dtn.origin.Method(name)
+		if method == nil {
+			return nil
+		}
+		return dtn.instanceMethod(method)
+	}
+
+	dtn.mutex.RLock()
+	defer dtn.mutex.RUnlock()
+
+	for _, method := range dtn.methods {
+		if method.name == name {
+			return method
+		}
+	}
+
+	return nil
+}
+
+// instanceMethod gives a method of a generic type with the instance's type
+// arguments substituted into its signature.
+func (dtn *DataTypeNamed) instanceMethod(method *NamedMethod) *NamedMethod {
+	instance := *method
+	instance.sig = dtn.store.substFunc(method.sig, newTypeSubst(method.typeParams, dtn.typeArgs))
+	return &instance
+}
+
+// type NamedMethod is a method declared on a defined type.
+type NamedMethod struct {
+	DataTypeMethod
+	pointerReceiver bool                 // true if the receiver is of the form '*T'
+	typeParams      []*DataTypeTypeParam // the receiver's type parameters, if it's a generic type
+	filename        string               // where it was declared
+	decl            ASTFunctionDecl      // the method declaration
+}
+
+// PointerReceiver returns true if the method has a receiver of the form '*T'.
+func (nm *NamedMethod) PointerReceiver() bool {
+	return nm.pointerReceiver
+}
+
+// Underlying returns the underlying type of any type. For types other
+// than defined types it's the type itself.
+func Underlying(dt DataType) DataType {
+	if named, ok := dt.(*DataTypeNamed); ok {
+		return named.Underlying()
+	}
+
+	return dt
+}
+
+// qualifiedName gives the key used in the DataTypeStore's name map for a
+// name declared in the package with the given import path.
+func qualifiedName(packagePath string, name string) string {
+	if packagePath == "" {
+		return name
+	}
+
+	return packagePath + "." + name
+}
+
+// NewNamed creates a new defined type. Its underlying type must be set
+// with SetUnderlying before it's used.
+func (ts *DataTypeStore) NewNamed(packagePath string, name string, filename string, pos SrcSpan) *DataTypeNamed {
+	return &DataTypeNamed{name: name, pkg: packagePath, filename: filename, pos: pos}
+}
+
+// SetUnderlying sets the underlying type of a defined type. If dt is
+// itself a defined type its underlying type is used.
+func (dtn *DataTypeNamed) SetUnderlying(dt DataType) {
+	dt = Underlying(dt)
+
+	dtn.mutex.Lock()
+	dtn.underlying = dt
+	dtn.mutex.Unlock()
+}
+
+// declareName adds a package level type name to the store. It fails if the
+// name's already declared.
+func (ts *DataTypeStore) declareName(packagePath string, name string, dt DataType) bool {
+	ts.nameMapMutex.Lock()
+	defer ts.nameMapMutex.Unlock()
+
+	key := qualifiedName(packagePath, name)
+	if _, ok := ts.nameMap[key]; ok {
+		return false
+	}
+
+	ts.nameMap[key] = dt
+	return true
+}
+
+// type typeDeclResolver resolves a set of type declarations from a
+// package. Declarations can refer to each other in any order and defined
+// types can refer to themselves through pointers, slices and so on.
+type typeDeclResolver struct {
+	ts          *DataTypeStore
+	packagePath string
+	filename    string                       // the file of the declaration being resolved
+	decls       map[string]ASTDataTypeDecl   // declarations by name
+	filenames   map[string]string            // the file each declaration is in
+	named       map[string]*DataTypeNamed    // the defined types being declared
+	imports     map[string]map[string]string // the import path of each package name, by the file which imports it
+	aliases     map[string]DataType          // aliases which have been resolved
+	resolving   map[string]bool              // declarations we're in the middle of resolving
+	failed      map[string]error             // the error each declaration which can't be resolved has
+	later       func(check func() error)     // defers a check until everything's resolved
+}
+
+// DeclareTypes declares all the type declarations from a single file
+// package. decls may contain other kinds of declaration, which are
+// ignored.
+func (ts *DataTypeStore) DeclareTypes(filename string, packagePath string, decls []AST) ErrorList {
+	return ts.DeclarePackageTypes(packagePath, []*sourceFile{{fileName: filename, ast: ASTTopLevel{topLevelDecls: decls}}})
+}
+
+// DeclarePackageTypes declares all the type declarations from the files of
+// a package. A declaration can refer to types declared in any of the
+// files, in any order. It gives all the errors found. The types which
+// have errors, or which are declared using one which does, aren't
+// declared but the rest are.
+func (ts *DataTypeStore) DeclarePackageTypes(packagePath string, files []*sourceFile) ErrorList {
+	r := &typeDeclResolver{
+		ts:          ts,
+		packagePath: packagePath,
+		decls:       make(map[string]ASTDataTypeDecl),
+		filenames:   make(map[string]string),
+		named:       make(map[string]*DataTypeNamed),
+		aliases:     make(map[string]DataType),
+		resolving:   make(map[string]bool),
+		failed:      make(map[string]error),
+		imports:     make(map[string]map[string]string),
+	}
+
+	// each error is only reported once, even though a declaration which
+	// can't be resolved makes the ones which use it fail too.
+	var errs ErrorList
+	reported := make(map[error]bool)
+	report := func(err error) {
+		if !reported[err] {
+			reported[err] = true
+			errs.Add(err)
+		}
+	}
+
+	// create all the defined types first so they can refer to each other.
+	var order []string
+	for _, sf := range files {
+		r.imports[sf.fileName] = make(map[string]string)
+		for _, imp := range sf.ast.(ASTTopLevel).imports {
+			name, _ := importName(imp.(ASTImport))
+			r.imports[sf.fileName][name] = importPath(imp.(ASTImport))
+		}
+		for _, ast := range sf.ast.(ASTTopLevel).topLevelDecls {
+			decl, ok := ast.(ASTDataTypeDecl)
+			if !ok {
+				continue
+			}
+
+			ident := decl.ident.(ASTIdentifier)
+			if other, ok := r.decls[ident.name]; ok {
+				report(NewError(sf.fileName, ident.Pos(), fmt.Sprint("'", ident.name, "' has already been declared in this package, at ", declaredAt(sf.fileName, r.filenames[ident.name], other.ident.Pos()))))
+				continue
+			}
+			if ts.LookupName(qualifiedName(packagePath, ident.name)) != nil {
+				report(NewError(sf.fileName, ident.Pos(), fmt.Sprint("'", ident.name, "' has already been declared in this package")))
+				continue
+			}
+
+			r.decls[ident.name] = decl
+			r.filenames[ident.name] = sf.fileName
+			order = append(order, ident.name)
+			if decl.alias && len(decl.typeParams) > 0 {
+				r.failed[ident.name] = NewError(sf.fileName, ident.Pos(), fmt.Sprint("the alias '", ident.name, "' can't have type parameters"))
+				report(r.failed[ident.name])
+				continue
+			}
+			if !decl.alias {
+				named := ts.NewNamed(packagePath, ident.name, sf.fileName, ident.Pos())
+				named.pkgName = sf.ast.(ASTTopLevel).packageName
+				for i, tp := range decl.typeParams {
+					named.typeParams = append(named.typeParams, ts.NewTypeParam(tp.(ASTTypeParam).ident.(ASTIdentifier).name, i))
+				}
+				r.named[ident.name] = named
+			}
+		}
+	}
+
+	// now work out what they all are. instances of generic types are checked
+	// against their constraints once everything's resolved.
+	var checks []func() error
+	r.later = func(check func() error) {
+		checks = append(checks, check)
+	}
+	for _, name := range order {
+		var err error
+		if r.decls[name].alias {
+			_, err = r.resolveAlias(name)
+		} else {
+			err = r.resolveNamed(name)
+		}
+		if err != nil {
+			report(err)
+		}
+	}
+
+	for _, name := range order {
+		if named, ok := r.named[name]; ok && r.failed[name] == nil {
+			if err := r.checkRecursion(named); err != nil {
+				r.failed[name] = err
+				report(err)
+			}
+		}
+	}
+
+	for _, check := range checks {
+		if err := check(); err != nil {
+			report(err)
+		}
+	}
+
+	// a type can use one which was still being resolved when it was, and
+	// which failed after.
+	for _, name := range order {
+		if r.failed[name] != nil {
+			continue
+		}
+		dt, ok := r.aliases[name]
+		if !ok {
+			dt = r.named[name].Underlying()
+		}
+		if err := r.usesFailed(dt, make(map[DataType]bool)); err != nil {
+			r.failed[name] = err
+		}
+	}
+
+	// make the ones which are all right visible.
+	for _, name := range order {
+		if r.failed[name] != nil {
+			continue
+		}
+		dt, ok := r.aliases[name]
+		if !ok {
+			dt = r.named[name]
+		}
+		ts.declareName(packagePath, name, dt)
+	}
+
+	return errs
+}
+
+// resolve finds the type a name refers to while declarations are being
+// resolved.
+func (r *typeDeclResolver) resolve(ident ASTIdentifier) (DataType, error) {
+	if ident.packageName == "" {
+		if _, ok := r.decls[ident.name]; ok {
+			if named, ok := r.named[ident.name]; ok {
+				// resolve it now if we can, in case its underlying type is
+				// needed - eg. to embed an interface.
+				if !r.resolving[ident.name] {
+					if err := r.resolveNamed(ident.name); err != nil {
+						return nil, err
+					}
+				}
+				return named, nil
+			}
+			return r.resolveAlias(ident.name)
+		}
+	} else if path, ok := r.imports[r.filename][ident.packageName]; ok {
+		// the store knows imported packages by their path.
+		ident.packageName = path
+	}
+
+	return r.ts.resolveTypeName(ident, NewTypeContext(r.filename, r.packagePath, nil))
+}
+
+// typeContext gives the context for the type expressions in the
+// declaration being resolved.
+func (r *typeDeclResolver) typeContext() *TypeContext {
+	ctx := NewTypeContext(r.filename, r.packagePath, r.resolve)
+	ctx.later = r.later
+	return ctx
+}
+
+// resolveNamed works out the underlying type of a defined type. A type
+// which fails gives the same error every time it's resolved.
+func (r *typeDeclResolver) resolveNamed(name string) (err error) {
+	named := r.named[name]
+	if named.Underlying() != nil {
+		return nil
+	}
+	if err := r.failed[name]; err != nil {
+		return err
+	}
+
+	decl := r.decls[name]
+	if r.resolving[name] {
+		return NewError(r.filenames[name], decl.ident.Pos(), fmt.Sprint("'", name, "' is an invalid recursive type"))
+	}
+	r.resolving[name] = true
+	savedFilename := r.filename
+	r.filename = r.filenames[name]
+	defer func() {
+		delete(r.resolving, name)
+		r.filename = savedFilename
+		if err != nil {
+			r.failed[name] = err
+		}
+	}()
+
+	ctx := r.typeContext()
+	if len(named.typeParams) > 0 {
+		ctx = r.ts.withTypeParams(ctx, named.typeParams)
+		for i, ast := range decl.typeParams {
+			constraint, err := r.ts.makeASTConstraint(ast.(ASTTypeParam).constraint, ctx)
+			if err != nil {
+				return err
+			}
+			named.typeParams[i].setConstraint(constraint)
+		}
+	}
+
+	dt, err := r.ts.makeASTTypeOrConstraint(decl.typ, ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := dt.(*DataTypeTypeParam); ok {
+		return NewError(r.filename, decl.typ.Pos(), fmt.Sprint("'", name, "' can't be defined as just a type parameter"))
+	}
+
+	// if it's defined in terms of another type from this group we need to
+	// know that type's underlying type first.
+	if other, ok := dt.(*DataTypeNamed); ok && other.Underlying() == nil {
+		if _, ours := r.named[other.name]; ours && other.pkg == r.packagePath {
+			err = r.resolveNamed(other.name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	named.SetUnderlying(dt)
+	return nil
+}
+
+// resolveAlias works out what type an alias refers to. An alias which
+// fails gives the same error every time it's resolved.
+func (r *typeDeclResolver) resolveAlias(name string) (dt DataType, err error) {
+	if dt, ok := r.aliases[name]; ok {
+		return dt, nil
+	}
+	if err := r.failed[name]; err != nil {
+		return nil, err
+	}
+
+	decl := r.decls[name]
+	if r.resolving[name] {
+		return nil, NewError(r.filenames[name], decl.ident.Pos(), fmt.Sprint("'", name, "' is an invalid recursive type alias"))
+	}
+	r.resolving[name] = true
+	savedFilename := r.filename
+	r.filename = r.filenames[name]
+	defer func() {
+		delete(r.resolving, name)
+		r.filename = savedFilename
+		if err != nil {
+			r.failed[name] = err
+		}
+	}()
+
+	dt, err = r.ts.makeASTTypeOrConstraint(decl.typ, r.typeContext())
+	if err != nil {
+		return nil, err
+	}
+
+	r.aliases[name] = dt
+	return dt, nil
+}
+
+// checkRecursion makes sure a defined type doesn't contain itself other
+// than by reference - eg. 'type T struct { t T }' would be infinitely large.
+func (r *typeDeclResolver) checkRecursion(named *DataTypeNamed) error {
+	visiting := make(map[DataType]bool)
+	var contains func(dt DataType) bool
+	contains = func(dt DataType) bool {
+		if dt == named {
+			return true
+		}
+		if visiting[dt] {
+			return false
+		}
+		visiting[dt] = true
+
+		switch t := dt.(type) {
+		case *DataTypeNamed:
+			return contains(t.Underlying())
+		case *DataTypeArray:
+			return contains(t.elementType)
+		case *DataTypeStruct:
+			for _, field := range t.fields {
+				if contains(field.typ) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	if contains(named.Underlying()) {
+		return NewError(named.filename, named.pos, fmt.Sprint("'", named.name, "' is an invalid recursive type - it contains itself"))
+	}
+
+	return nil
+}
+
+// usesFailed gives the error of a type from this package which couldn't
+// be resolved if dt is made from one.
+func (r *typeDeclResolver) usesFailed(dt DataType, seen map[DataType]bool) error {
+	if dt == nil || seen[dt] {
+		return nil
+	}
+	seen[dt] = true
+
+	var parts []DataType
+	switch t := dt.(type) {
+	case *DataTypeNamed:
+		if t.pkg != r.packagePath {
+			return nil
+		}
+		if t.origin != nil {
+			parts = append([]DataType{t.origin}, t.typeArgs...)
+		} else if r.named[t.name] == t && r.failed[t.name] != nil {
+			return r.failed[t.name]
+		} else {
+			parts = []DataType{t.Underlying()}
+		}
+	case *DataTypeUnary:
+		parts = []DataType{t.subType}
+	case *DataTypeArray:
+		parts = []DataType{t.elementType}
+	case *DataTypeMap:
+		parts = []DataType{t.keyType, t.valueType}
+	case *DataTypeChan:
+		parts = []DataType{t.elementType}
+	case *DataTypeFunc:
+		parts = append(append(parts, t.params...), t.returns...)
+	case *DataTypeStruct:
+		for _, field := range t.fields {
+			parts = append(parts, field.typ)
+		}
+	case *DataTypeInterface:
+		for _, method := range t.methods {
+			parts = append(parts, method.sig)
+		}
+		for _, term := range t.terms {
+			parts = append(parts, term.typ)
+		}
+	}
+
+	for _, part := range parts {
+		if err := r.usesFailed(part, seen); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// declaredAt describes where something was declared for an error in the
+// file filename. The file is only given if it's a different file.
+func declaredAt(filename string, declFilename string, pos SrcSpan) string {
+	if declFilename != filename {
+		return fmt.Sprint(declFilename, ":", pos.start.Line)
+	}
+
+	return fmt.Sprint("line ", pos.start.Line)
+}
+
+// DeclareMethod attaches a method declaration to its receiver's type.
+// The receiver's type must already have been declared with DeclareTypes.
+func (ts *DataTypeStore) DeclareMethod(filename string, packagePath string, decl ASTFunctionDecl) error {
+	receiver := decl.receiver.(ASTReceiver)
+
+	// the receiver has to be a defined type from this package.
+	dt := ts.LookupName(qualifiedName(packagePath, receiver.typeName))
+	named, ok := dt.(*DataTypeNamed)
+	if !ok || named.pkg != packagePath {
+		return NewError(filename, receiver.Pos(), fmt.Sprint("methods can only be declared on types defined in this package, and '", receiver.typeName, "' isn't one"))
+	}
+
+	switch Underlying(named).DataTypeKind() {
+	case DataTypeKindInterface:
+		return NewError(filename, receiver.Pos(), fmt.Sprint("'", receiver.typeName, "' is an interface so it can't have methods declared on it"))
+	case DataTypeKindPointer:
+		return NewError(filename, receiver.Pos(), fmt.Sprint("'", receiver.typeName, "' is a pointer type so it can't have methods declared on it"))
+	}
+
+	// the receiver names the type parameters of a generic type, which can
+	// be used in the method's signature.
+	ctx := NewTypeContext(filename, packagePath, nil)
+	names, err := receiverTypeParams(named, receiver)
+	if err != nil {
+		return NewError(filename, receiver.Pos(), err.Error())
+	}
+	if len(names) > 0 {
+		ctx = ts.withNames(ctx, names)
+	}
+
+	sig, err := ts.makeASTSignature(decl.params, decl.returns, ctx)
+	if err != nil {
+		return err
+	}
+
+	named.mutex.Lock()
+	defer named.mutex.Unlock()
+
+	if decl.name != "_" {
+		for _, method := range named.methods {
+			if method.name == decl.name {
+				return NewError(filename, decl.pos, fmt.Sprint("'", receiver.typeName, "' already has a method called '", decl.name, "'"))
+			}
+		}
+	}
+
+	if st, ok := named.underlying.(*DataTypeStruct); ok && st.Field(decl.name) != nil {
+		return NewError(filename, decl.pos, fmt.Sprint("'", receiver.typeName, "' has a field and a method both called '", decl.name, "'"))
+	}
+
+	method := &NamedMethod{DataTypeMethod{decl.name, exportPackage(decl.name, packagePath), sig}, receiver.pointer, named.typeParams, filename, decl}
+	named.methods = append(named.methods, method)
+
+	return nil
+}
+
+// receiverTypeParams gives the names the receiver of a method of a
+// generic type gives to the type's type parameters. They don't have to be
+// the same names as in the type's declaration.
+func receiverTypeParams(named *DataTypeNamed, receiver ASTReceiver) (map[string]DataType, error) {
+	if len(receiver.typeParams) != len(named.typeParams) {
+		return nil, fmt.Errorf("'%s' has %d type parameters but the receiver has %d", named.name, len(named.typeParams), len(receiver.typeParams))
+	}
+
+	names := make(map[string]DataType)
+	for i, ast := range receiver.typeParams {
+		ident := ast.(ASTIdentifier)
+		if _, ok := names[ident.name]; ok && ident.name != "_" {
+			return nil, fmt.Errorf("there's already a type parameter called '%s'", ident.name)
+		}
+		names[ident.name] = named.typeParams[i]
+	}
+
+	return names, nil
+}
@@ -0,0 +1,142 @@
+package golightly
+
+import (
+	"strings"
+	"testing"
+)
+
+// parseTestDecls parses a source file and returns its top level declarations.
+func parseTestDecls(t *testing.T, src string) []AST {
+	lex := NewLexer()
+	lex.LexReader(strings.NewReader(src), "test.go")
+	parser := NewParser(lex, NewDataTypeStore(), &sourceFile{})
+
+	var decls []AST
+	for {
+		tok, err := lex.PeekToken(0)
+		if err != nil || tok.TokenKind() == TokenKindEndOfSource {
+			break
+		}
+
+		_, topLevelDecls, err := parser.parseTopLevelDecl()
+		if err == nil {
+			err = parser.expectToken(TokenKindSemicolon, "semicolon expected")
+		}
+		if err != nil {
+			t.Error("error parsing: ", err)
+			break
+		}
+		decls = append(decls, topLevelDecls...)
+	}
+
+	return decls
+}
+
+func TestDeclareTypes(t *testing.T) {
+	ts := NewDataTypeStore()
+	decls := parseTestDecls(t, `type List struct { next *List; val Celsius; };
+type Celsius float64;
+type Fahrenheit float64;
+type Temp = Celsius;
+`)
+	err := ts.DeclareTypes("test.go", "main", decls)
+	if err != nil {
+		t.Error("error declaring types: ", err)
+		return
+	}
+
+	celsius, ok := ts.LookupName("main.Celsius").(*DataTypeNamed)
+	if !ok {
+		t.Error("Celsius isn't a defined type")
+		return
+	}
+	fahrenheit := ts.LookupName("main.Fahrenheit")
+	if celsius == fahrenheit {
+		t.Error("two defined types are identical")
+	}
+	if celsius.Underlying() != ts.Float64Type() || Underlying(fahrenheit) != ts.Float64Type() {
+		t.Error("wrong underlying type")
+	}
+	if ts.LookupName("main.Temp") != celsius {
+		t.Error("an alias isn't identical to its type")
+	}
+
+	list := ts.LookupName("main.List").(*DataTypeNamed)
+	st, ok := list.Underlying().(*DataTypeStruct)
+	if !ok {
+		t.Error("List isn't a struct")
+		return
+	}
+	if st.Field("next").typ != ts.MakePointer(list) || st.Field("val").typ != celsius {
+		t.Error("recursive struct has the wrong field types")
+	}
+}
+
+func TestDeclareTypesErrors(t *testing.T) {
+	bad := []string{
+		"type T struct { t T; };",
+		"type A B; type B A;",
+		"type A = B; type B = A;",
+		"type T int; type T string;",
+	}
+
+	for _, src := range bad {
+		ts := NewDataTypeStore()
+		err := ts.DeclareTypes("test.go", "main", parseTestDecls(t, src))
+		if err == nil {
+			t.Error("no error for: ", src)
+		}
+	}
+}
+
+func TestDeclareTypesKeepsGoing(t *testing.T) {
+	ts := NewDataTypeStore()
+	src := "type A Missing; type B int; type C struct { c C; }; type D *A; type E B; type P struct { q *Q; x Missing; }; type Q struct { p *P; };"
+	errs := ts.DeclareTypes("test.go", "main", parseTestDecls(t, src))
+
+	// each problem is reported once, and the types which use one with a
+	// problem don't get their own errors.
+	if len(errs) != 3 {
+		t.Error("expected errors for A, C and P but got: ", errs)
+	}
+	for _, name := range []string{"B", "E"} {
+		if ts.LookupName("main."+name) == nil {
+			t.Error(name, " should have been declared")
+		}
+	}
+	for _, name := range []string{"A", "C", "D", "P", "Q"} {
+		if ts.LookupName("main."+name) != nil {
+			t.Error(name, " shouldn't have been declared")
+		}
+	}
+}
+
+func TestDeclareMethod(t *testing.T) {
+	ts := NewDataTypeStore()
+	pos := SrcSpan{SrcLoc{1, 1}, SrcLoc{1, 1}}
+	if errs := ts.DeclareTypes("test.go", "main", parseTestDecls(t, "type Celsius float64;")); errs != nil {
+		t.Error("error declaring types: ", errs)
+		return
+	}
+
+	method := ASTFunctionDecl{pos, "String", nil, ASTReceiver{pos, "c", true, "Celsius", nil}, nil, []AST{ASTParameterDecl{nil, ASTIdentifier{pos, "", "string"}}}, nil}
+	err := ts.DeclareMethod("test.go", "main", method)
+	if err != nil {
+		t.Error("error declaring method: ", err)
+		return
+	}
+
+	m := ts.LookupName("main.Celsius").(*DataTypeNamed).Method("String")
+	if m == nil || !m.PointerReceiver() || m.sig != ts.MakeFunc(nil, []DataType{ts.StringType()}, false) {
+		t.Error("method wasn't attached correctly")
+	}
+
+	if ts.DeclareMethod("test.go", "main", method) == nil {
+		t.Error("duplicate method wasn't reported")
+	}
+
+	method.receiver = ASTReceiver{pos, "i", false, "int", nil}
+	if ts.DeclareMethod("test.go", "main", method) == nil {
+		t.Error("method on a predeclared type wasn't reported")
+	}
+}
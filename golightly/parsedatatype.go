@@ -8,10 +8,12 @@ package golightly
 // TypeName  = identifier | QualifiedIdent .
 func (p *Parser) parseDataType() (bool, AST, error) {
 	// what token do we have?
-	tok, _ := p.lexer.PeekToken(0)
+	tok, err := p.lexer.PeekToken(0)
+	if err != nil {
+		return false, nil, err
+	}
 
 	var ast AST
-	var err error
 
 	switch tok.TokenKind() {
 	case TokenKindIdentifier:
@@ -356,7 +358,7 @@ func (p *Parser) parseDataTypeMap() (AST, error) {
 	if err != nil {
 		return nil, err
 	}
-	if openSquareBracketToken.TokenKind() == TokenKindOpenSquareBracket {
+	if openSquareBracketToken.TokenKind() != TokenKindOpenSquareBracket {
 		return nil, NewError(p.filename, mapToken.Pos().Add(openSquareBracketToken.Pos()), "map types should look like 'map[key_type]element_type'")
 	}
 
@@ -379,7 +381,7 @@ func (p *Parser) parseDataTypeMap() (AST, error) {
 	if err != nil {
 		return nil, err
 	}
-	if closeSquareBracketToken.TokenKind() == TokenKindCloseSquareBracket {
+	if closeSquareBracketToken.TokenKind() != TokenKindCloseSquareBracket {
 		return nil, NewError(p.filename, closeSquareBracketToken.Pos(), "map types should look like 'map[key_type]element_type'")
 	}
 
@@ -398,7 +400,7 @@ func (p *Parser) parseDataTypeMap() (AST, error) {
 // parseDataTypeChannel parses a channel data type.
 // ChannelType = ( "chan" [ "<-" ] | "<-" "chan" ) ElementType .
 func (p *Parser) parseDataTypeChannel() (AST, error) {
-	var dir ChanDirection
+	dir := ChanDirectionBi
 	tok, _ := p.lexer.GetToken()
 	chanSpan := tok.Pos()
 	if tok.TokenKind() == TokenKindChan {
@@ -416,7 +418,7 @@ func (p *Parser) parseDataTypeChannel() (AST, error) {
 		}
 	} else {
 		// starts with '<-', we need a 'chan' now
-		p.lexer.GetToken()
+		dir = ChanDirectionOut
 		tok2pos, err := p.expectTokenPos(TokenKindChan, "channels should look like 'chan', '<- chan' or 'chan <-'")
 		if err != nil {
 			return nil, err
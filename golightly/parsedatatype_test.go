@@ -10,17 +10,7 @@ func setupDataTypeTest(src string) *Parser {
 	reader := strings.NewReader(src)
 	lex.LexReader(reader, "test.go")
 	ts := NewDataTypeStore()
-	addSrcFile := make(chan string)
-	parser := NewParser(lex, ts, addSrcFile)
-
-	// just throw away anything we get on the addSrcFile channel.
-	go func() {
-		for {
-			<-addSrcFile
-		}
-	}()
-
-	return parser
+	return NewParser(lex, ts, &sourceFile{})
 }
 
 func compareAST(a, b AST) bool {
@@ -40,12 +40,22 @@ func (p *Parser) parseExpressionList() ([]AST, error) {
 }
 
 // parseExpression parses an expression.
+// XXX - only operands which are literals or identifiers so far.
 func (p *Parser) parseExpression() (AST, error) {
-	tok, _ := p.lexer.GetToken()
+	tok, err := p.lexer.PeekToken(0)
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok.TokenKind() {
+	case TokenKindLiteralInt, TokenKindLiteralFloat, TokenKindLiteralRune, TokenKindLiteralString:
+		p.lexer.GetToken()
+		return NewASTValueFromToken(tok, p.ts), nil
 
-	if tok.TokenKind() == TokenKindLiteralInt {
-		return ASTValue{tok.Pos(), NewValueFromToken(tok, p.ts)}, nil
+	case TokenKindIdentifier:
+		return p.parseOptionallyQualifiedIdentifier()
 	}
 
+	p.lexer.GetToken()
 	return nil, NewError(p.filename, tok.Pos(), "bad expression. bad.")
 }
@@ -12,6 +12,11 @@ type Parser struct {
 
 	filename    string // the name of the file being parsed.
 	packageName string // the name of the package this file is a part of.
+
+	// the following track the const declaration being parsed.
+	iota       int   // the index of the current spec in its group.
+	constType  AST   // the type from the last spec which had values.
+	constExprs []AST // the values from the last spec which had values.
 }
 
 // NewParser creates a new parser object.
@@ -26,10 +31,12 @@ func NewParser(lexer *Lexer, ts *DataTypeStore, sf *sourceFile) *Parser {
 
 // Parse runs the parser and breaks the program down into an Abstract Syntax Tree.
 func (p *Parser) Parse() error {
+	p.filename = p.lexer.sourceFile
 	return p.parseSourceFile()
 }
 
-// parseSourceFile parses the contents of an entire source file.
+// parseSourceFile parses the contents of an entire source file. The AST
+// is kept in the sourceFile.
 // SourceFile       = PackageClause ";" { ImportDecl ";" } { TopLevelDecl ";" } .
 func (p *Parser) parseSourceFile() error {
 	// get the package declaration.
@@ -39,6 +46,7 @@ func (p *Parser) parseSourceFile() error {
 		return err
 	}
 	ast.packageName = packageName
+	p.packageName = packageName
 
 	// get a semicolon separator.
 	err = p.expectToken(TokenKindSemicolon, "I'm gonna be needing a semicolon after this 'package' declaration")
@@ -47,46 +55,46 @@ func (p *Parser) parseSourceFile() error {
 	}
 
 	// get a number of import declarations.
-	tok, err := p.lexer.PeekToken(0)
-	if err != nil {
-		return err
-	}
+	for {
+		tok, err := p.lexer.PeekToken(0)
+		if err != nil {
+			return err
+		}
+		if tok.TokenKind() != TokenKindImport {
+			break
+		}
 
-	if tok.TokenKind() == TokenKindImport {
-		for {
-			// get an import.
-			imports, err := p.parseImport()
-			if err != nil {
-				return err
-			}
+		// get an import.
+		imports, err := p.parseImport()
+		if err != nil {
+			return err
+		}
 
-			ast.imports = append(ast.imports, imports...)
+		ast.imports = append(ast.imports, imports...)
 
-			// get a semicolon separator.
-			err = p.expectToken(TokenKindSemicolon, "I'm gonna be needing a semicolon after this 'import' declaration")
-			if err != nil {
-				return err
-			}
+		// get a semicolon separator.
+		err = p.expectToken(TokenKindSemicolon, "I'm gonna be needing a semicolon after this 'import' declaration")
+		if err != nil {
+			return err
 		}
 	}
 
 	// get a number of top-level declarations.
-	tok, err = p.lexer.PeekToken(0)
-	if err != nil {
-		return err
-	}
-
 	for {
-		// get a top-level declaration.
-		match, topLevelDecls, err := p.parseTopLevelDecl()
+		tok, err := p.lexer.PeekToken(0)
 		if err != nil {
 			return err
 		}
-
-		if !match {
+		if tok.TokenKind() == TokenKindEndOfSource {
 			break
 		}
 
+		// get a top-level declaration.
+		_, topLevelDecls, err := p.parseTopLevelDecl()
+		if err != nil {
+			return err
+		}
+
 		ast.topLevelDecls = append(ast.topLevelDecls, topLevelDecls...)
 
 		// get a semicolon separator.
@@ -102,6 +110,9 @@ func (p *Parser) parseSourceFile() error {
 		return err
 	}
 
+	p.sf.packageName = packageName
+	p.sf.ast = *ast
+
 	return nil
 }
 
@@ -186,22 +197,28 @@ func (p *Parser) parseImportSpec() (AST, error) {
 		if err != nil {
 			return nil, err
 		}
-		if pathToken.TokenKind() != TokenKindString {
+		if pathToken.TokenKind() != TokenKindLiteralString {
 			return nil, NewError(p.filename, pathToken.Pos(), "this should have been a string. eg. 'import fred \"github.com/fred/thefredpackage\"'")
 		}
 
-		// tell the compiler to read the imported file
-		p.sf.addImport <- importMessage{pathToken.(StringToken).strVal, p.filename, pathToken.Pos(), nil} // XXX - need to give a completion channel.
+		// tell the compiler to read the imported package.
+		err = p.sf.requestImport(pathToken.(StringToken).strVal, pathToken.Pos())
+		if err != nil {
+			return nil, err
+		}
 
 		// return the import spec
 		return ASTImport{pathToken.Pos(), ASTIdentifier{nextToken.Pos(), "", strPackageName.strVal}, NewASTValueFromToken(pathToken, p.ts)}, nil
 
-	case TokenKindString:
+	case TokenKindLiteralString:
 		// it's of the form 'import "frod"' - just get the import path.
 		p.lexer.GetToken()
 
-		// tell the compiler to read the imported file
-		p.sf.addImport <- importMessage{nextToken.(StringToken).strVal, p.filename, nextToken.Pos(), nil} // XXX - need to give a completion channel.
+		// tell the compiler to read the imported package.
+		err = p.sf.requestImport(nextToken.(StringToken).strVal, nextToken.Pos())
+		if err != nil {
+			return nil, err
+		}
 
 		// return the import spec
 		return ASTImport{nextToken.Pos(), nil, NewASTValueFromToken(nextToken, p.ts)}, nil
@@ -223,6 +240,7 @@ func (p *Parser) parseTopLevelDecl() (bool, []AST, error) {
 
 	switch nextToken.TokenKind() {
 	case TokenKindConst:
+		p.iota, p.constType, p.constExprs = 0, nil, nil
 		asts, err := p.parseDecl(p.parseConstSpec, "const")
 		return true, asts, err
 
@@ -300,9 +318,9 @@ func (p *Parser) parseConstSpec() ([]AST, error) {
 
 	// handle optional part.
 	var exprList []AST
-	if matchTyp || equalsToken.TokenKind() == TokenKindEquals {
+	if matchTyp || equalsToken.TokenKind() == TokenKindAssign {
 		// there must be an '=' and expression list after a type.
-		if equalsToken.TokenKind() != TokenKindEquals {
+		if equalsToken.TokenKind() != TokenKindAssign {
 			return nil, NewError(p.filename, equalsToken.Pos(), "after a data type I expected to see '=' here")
 		}
 
@@ -312,6 +330,10 @@ func (p *Parser) parseConstSpec() ([]AST, error) {
 		if err != nil {
 			return nil, err
 		}
+		p.constType, p.constExprs = typeAST, exprList
+	} else {
+		// in a group, leaving out the values repeats the previous ones.
+		typeAST, exprList = p.constType, p.constExprs
 	}
 
 	// are the two lists the same length?
@@ -325,14 +347,17 @@ func (p *Parser) parseConstSpec() ([]AST, error) {
 	// make a set of consts out of all this.
 	asts := make([]AST, len(identList))
 	for i := 0; i < len(identList); i++ {
-		asts[i] = ASTConstDecl{identList[i], typeAST, exprList[i]}
+		asts[i] = ASTConstDecl{identList[i], typeAST, exprList[i], p.iota}
 	}
+	p.iota++
 
 	return asts, nil
 }
 
 // parseTypeSpec parses a type declaration specification.
-// TypeSpec     = identifier Type .
+// TypeSpec     = AliasDecl | TypeDef .
+// AliasDecl    = identifier "=" Type .
+// TypeDef      = identifier Type .
 func (p *Parser) parseTypeSpec() ([]AST, error) {
 	// get an identifier
 	ident, err := p.lexer.GetToken()
@@ -346,6 +371,17 @@ func (p *Parser) parseTypeSpec() ([]AST, error) {
 
 	identAST := ASTIdentifier{ident.Pos(), "", ident.(StringToken).strVal}
 
+	// an '=' makes it an alias.
+	assignToken, err := p.lexer.PeekToken(0)
+	if err != nil {
+		return nil, err
+	}
+
+	alias := assignToken.TokenKind() == TokenKindAssign
+	if alias {
+		p.lexer.GetToken()
+	}
+
 	// get the data type
 	matchTyp, typeAST, err := p.parseDataType()
 	if err != nil {
@@ -362,7 +398,7 @@ func (p *Parser) parseTypeSpec() ([]AST, error) {
 		return nil, NewError(p.filename, fail.Pos(), fmt.Sprint("this should have been a name for a type, but it's not"))
 	}
 
-	return []AST{ASTDataTypeDecl{identAST, typeAST}}, nil
+	return []AST{ASTDataTypeDecl{identAST, nil, typeAST, alias}}, nil
 }
 
 // parseVarSpec parses a variable declaration specification.
@@ -388,7 +424,7 @@ func (p *Parser) parseVarSpec() ([]AST, error) {
 			return nil, err
 		}
 
-		if equalsToken.TokenKind() == TokenKindEquals {
+		if equalsToken.TokenKind() == TokenKindAssign {
 			// get the expression list.
 			p.lexer.GetToken()
 			exprList, err = p.parseExpressionList()
@@ -398,13 +434,12 @@ func (p *Parser) parseVarSpec() ([]AST, error) {
 		}
 	} else {
 		// required equals.
-		err := p.expectToken(TokenKindEquals, "I was expecting to see an '=' here")
+		err := p.expectToken(TokenKindAssign, "I was expecting to see an '=' here")
 		if err != nil {
 			return nil, err
 		}
 
 		// get the expression list.
-		p.lexer.GetToken()
 		exprList, err = p.parseExpressionList()
 		if err != nil {
 			return nil, err
@@ -425,7 +460,11 @@ func (p *Parser) parseVarSpec() ([]AST, error) {
 	// make a set of variable declarations out of all this.
 	asts := make([]AST, len(identList))
 	for i := 0; i < len(identList); i++ {
-		asts[i] = ASTVarDecl{identList[i], typeAST, exprList[i]}
+		var value AST
+		if exprList != nil {
+			value = exprList[i]
+		}
+		asts[i] = ASTVarDecl{identList[i], typeAST, value}
 	}
 
 	return asts, nil
@@ -484,6 +523,9 @@ func (p *Parser) parseFunctionDecl() (AST, error) {
 	if tok.TokenKind() == TokenKindOpenBracket {
 		// it's a receiver.
 		receiver, err = p.parseReceiver()
+		if err != nil {
+			return nil, err
+		}
 
 		// take a look at the next token.
 		tok, err = p.lexer.PeekToken(0)
@@ -506,6 +548,10 @@ func (p *Parser) parseFunctionDecl() (AST, error) {
 
 	// this might be followed by a function body.
 	bodyToken, err := p.lexer.PeekToken(0)
+	if err != nil {
+		return nil, err
+	}
+
 	var body AST
 	if bodyToken.TokenKind() == TokenKindOpenBrace {
 		// parse a function body.
@@ -515,7 +561,7 @@ func (p *Parser) parseFunctionDecl() (AST, error) {
 		}
 	}
 
-	return ASTFunctionDecl{funcToken.Pos().Add(tok.Pos()), funcName, receiver, params, returns, body}, nil
+	return ASTFunctionDecl{funcToken.Pos().Add(tok.Pos()), funcName, nil, receiver, params, returns, body}, nil
 }
 
 // parseReceiver parses a method receiver.
@@ -534,7 +580,7 @@ func (p *Parser) parseReceiver() (AST, error) {
 	if err != nil {
 		return nil, err
 	}
-	tok2, err := p.lexer.PeekToken(1)
+	tok2, err := p.lexer.PeekToken(0)
 	if err != nil {
 		return nil, err
 	}
@@ -569,8 +615,11 @@ func (p *Parser) parseReceiver() (AST, error) {
 
 	// now get the closing bracket.
 	endBracketPos, err := p.expectTokenPos(TokenKindCloseBracket, "I'd like a ')' to finish this receiver... thanks")
+	if err != nil {
+		return nil, err
+	}
 
-	return ASTReceiver{bracketPos.Add(endBracketPos), ident, pointer, baseTypeName}, nil
+	return ASTReceiver{bracketPos.Add(endBracketPos), ident, pointer, baseTypeName, nil}, nil
 }
 
 // parseGroupSingle parses a group of some other clause, surrounded by brackets and
@@ -582,7 +631,6 @@ func (p *Parser) parseGroupSingle(parseClause func() (AST, error), verbName stri
 	}
 
 	// get a series of sub-clauses.
-	p.lexer.GetToken()
 	var asts []AST
 	semiErrorMessage := fmt.Sprint("I really wanted a semicolon between these '", verbName, "'s")
 	for {
@@ -592,6 +640,7 @@ func (p *Parser) parseGroupSingle(parseClause func() (AST, error), verbName stri
 			return nil, err
 		}
 		if closeBracketToken.TokenKind() == TokenKindCloseBracket {
+			p.lexer.GetToken()
 			break
 		}
 
@@ -622,7 +671,6 @@ func (p *Parser) parseGroupMulti(parseClause func() ([]AST, error), verbName str
 	}
 
 	// get a series of sub-clauses.
-	p.lexer.GetToken()
 	var asts []AST
 	semiErrorMessage := fmt.Sprint("I really wanted a semicolon between these '", verbName, "'s")
 	for {
@@ -632,6 +680,7 @@ func (p *Parser) parseGroupMulti(parseClause func() ([]AST, error), verbName str
 			return nil, err
 		}
 		if closeBracketToken.TokenKind() == TokenKindCloseBracket {
+			p.lexer.GetToken()
 			break
 		}
 
@@ -670,10 +719,17 @@ func (p *Parser) parseOptionallyQualifiedIdentifier() (AST, error) {
 
 	// might be followed by a '.'
 	tok, err = p.lexer.PeekToken(0)
+	if err != nil {
+		return nil, err
+	}
 	if tok.TokenKind() == TokenKindDot {
 		p.lexer.GetToken()
 
 		// get a following identifier.
+		tok, err = p.lexer.GetToken()
+		if err != nil {
+			return nil, err
+		}
 		if tok.TokenKind() != TokenKindIdentifier {
 			return nil, NewError(p.filename, tok.Pos(), "if you could just put an identifier here that'd be greeeat")
 		}
@@ -736,55 +792,132 @@ func (p *Parser) parseBracketedParameterList() ([]AST, error) {
 
 	// get a series of parameter declarations.
 	var params []AST
+	named := false
 	for {
+		// is it a terminating ')'?
+		tok, err := p.lexer.PeekToken(0)
+		if err != nil {
+			return nil, err
+		}
+		if tok.TokenKind() == TokenKindCloseBracket {
+			p.lexer.GetToken()
+			break
+		}
+
 		// get a parameter declaration.
-		newParams, err := p.parseParameterDecl()
+		newParams, isNamed, err := p.parseParameterDecl()
 		if err != nil {
 			return nil, err
 		}
 
 		params = append(params, newParams...)
+		named = named || isNamed
+
+		// parameters are separated by commas.
+		tok, err = p.lexer.PeekToken(0)
+		if err != nil {
+			return nil, err
+		}
+		if tok.TokenKind() != TokenKindComma {
+			err = p.expectToken(TokenKindCloseBracket, "I was expecting a ',' or a ')' here")
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		p.lexer.GetToken()
+	}
+
+	if named {
+		// in "(a, b int)" the "a" looks like a type on its own until we
+		// get to the "int".
+		return p.nameParameters(params)
 	}
 
 	return params, nil
 }
 
-// parseBracketedParameterList parses a parameter list surrounded by brackets.
+// parseParameterDecl parses a single parameter declaration. It's either a
+// type on its own or a name followed by a type. The bool is true if it
+// has a name.
 // ParameterDecl  = [ IdentifierList ] [ "..." ] Type .
-func (p *Parser) parseParameterDecl() ([]AST, error) {
-	// get a list of identifiers
-	idents, err := p.parseIdentifierList("parameter")
+func (p *Parser) parseParameterDecl() ([]AST, bool, error) {
+	// an identifier followed by something other than ',' ')' or '.' is a name.
+	tok, err := p.lexer.PeekToken(0)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+
+	var ident AST
+	if tok.TokenKind() == TokenKindIdentifier {
+		next, err := p.lexer.PeekToken(1)
+		if err != nil {
+			return nil, false, err
+		}
+
+		switch next.TokenKind() {
+		case TokenKindComma, TokenKindCloseBracket, TokenKindDot:
+		default:
+			p.lexer.GetToken()
+			ident = ASTIdentifier{tok.Pos(), "", tok.(StringToken).strVal}
+		}
 	}
 
 	// see if there's a "...".
-	tok, err := p.lexer.PeekToken(0)
+	tok, err = p.lexer.PeekToken(0)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
+	var ellipsis AST
 	if tok.TokenKind() == TokenKindEllipsis {
-		idents = append(idents, ASTEllipsis{tok.Pos()})
+		p.lexer.GetToken()
+		ellipsis = ASTEllipsis{tok.Pos()}
 	}
 
 	// the next thing should be a type declaration.
 	typeToken, err := p.lexer.PeekToken(0)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
 	match, typ, err := p.parseDataType()
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	if !match {
-		return nil, NewError(p.filename, typeToken.Pos(), "there's a missing type in this parameter list")
+		return nil, false, NewError(p.filename, typeToken.Pos(), "there's a missing type in this parameter list")
+	}
+
+	// "a ...T" is given as a parameter for "a" followed by one for the ellipsis.
+	var params []AST
+	if ident != nil || ellipsis == nil {
+		params = append(params, ASTParameterDecl{ident, typ})
 	}
+	if ellipsis != nil {
+		params = append(params, ASTParameterDecl{ellipsis, typ})
+	}
+
+	return params, ident != nil, nil
+}
+
+// nameParameters fixes up a parameter list where some of the parameters
+// are named. The parameters which look like types on their own are really
+// names which share the type of the named parameter following them.
+func (p *Parser) nameParameters(params []AST) ([]AST, error) {
+	var typ AST
+	for i := len(params) - 1; i >= 0; i-- {
+		param := params[i].(ASTParameterDecl)
+		if param.identifier != nil {
+			typ = param.typ
+			continue
+		}
+
+		ident, ok := param.typ.(ASTIdentifier)
+		if !ok || ident.packageName != "" || typ == nil {
+			return nil, NewError(p.filename, param.typ.Pos(), "either all the parameters in this list should have names or none of them should")
+		}
 
-	// return all the parameters, expanded.
-	params := make([]AST, len(idents))
-	for i, ident := range idents {
 		params[i] = ASTParameterDecl{ident, typ}
 	}
 
@@ -803,7 +936,7 @@ func (p *Parser) expectTokenPos(tk TokenKind, message string) (SrcSpan, error) {
 	// get a token
 	tok, err := p.lexer.GetToken()
 	if err != nil {
-		return tok.Pos(), err
+		return SrcSpan{}, err
 	}
 	if tok.TokenKind() != tk {
 		return tok.Pos(), NewError(p.filename, tok.Pos(), message)
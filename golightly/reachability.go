@@ -0,0 +1,212 @@
+package golightly
+
+// IsReachable returns true if a package level declaration or a method can
+// be reached from the package's entry points. Code generation and linking
+// can leave out anything which isn't reachable.
+func (ti *TypeInfo) IsReachable(sym *Symbol) bool {
+	return ti.reachable[sym]
+}
+
+// Reachable returns the package level declarations and methods which can
+// be reached from the package's entry points, in the order they were
+// found.
+func (ti *TypeInfo) Reachable() []*Symbol {
+	return ti.reached
+}
+
+// isReachableDecl returns true if a top level declaration from a file
+// can be reached. The passes after type checking use it to skip the
+// declarations which can't.
+func (ti *TypeInfo) isReachableDecl(filename string, decl AST) bool {
+	var pos SrcSpan
+	switch d := decl.(type) {
+	case ASTFunctionDecl:
+		pos = d.pos
+	case ASTVarDecl:
+		pos = d.ident.Pos()
+	case ASTConstDecl:
+		pos = d.ident.Pos()
+	case ASTDataTypeDecl:
+		pos = d.ident.Pos()
+	default:
+		return true
+	}
+
+	return ti.reachable[ti.defs[exprKey{filename, pos}]]
+}
+
+// referenceMethod records that the declaration being checked refers to a
+// method. If method is nil the method is called through an interface so
+// it could be the method of that name of any type which is reachable.
+func (c *Checker) referenceMethod(method *NamedMethod, name string) {
+	if method != nil {
+		c.reference(c.methodSymbol(method))
+		return
+	}
+	if c.decl == nil {
+		return
+	}
+
+	for _, call := range c.calls[c.decl] {
+		if call == name {
+			return
+		}
+	}
+	c.calls[c.decl] = append(c.calls[c.decl], name)
+}
+
+// referenceConversion records that the declaration being checked converts
+// a value of type from to type to. Once a value is in an interface its
+// methods can be called from anywhere, including other packages, so all
+// of them are needed if the declaration is.
+func (c *Checker) referenceConversion(from DataType, to DataType) {
+	if c.decl == nil || !isInterface(to) || isInterface(from) {
+		return
+	}
+
+	for _, typ := range c.converted[c.decl] {
+		if typ == from {
+			return
+		}
+	}
+	c.converted[c.decl] = append(c.converted[c.decl], from)
+}
+
+// reachability works out which package level declarations and methods can
+// be reached. The analysis starts from main.main, the init functions and
+// the variables which have initialisers, since they're always run, and
+// for packages other than main from everything exported since an
+// importer could use any of it. Everything a reachable declaration refers
+// to is reachable too.
+//
+// A method called through an interface could be the method of any type
+// which has one of that name. Types which can't be reached can't have any
+// values so only the methods of the reachable types are included. Method
+// sets aren't matched exactly - a method with the right name is enough.
+// The interface could be from another package which calls the methods
+// itself, so the exported methods of a type which is converted to an
+// interface are reachable too.
+//
+// Everything is still type checked because the spec requires it, and
+// vetted since dead code can still have mistakes in it, but only the
+// reachable declarations need to be analysed and lowered.
+func (c *Checker) reachability(files []*sourceFile) {
+	var work []*Symbol
+	reach := func(sym *Symbol) {
+		if !c.info.reachable[sym] {
+			c.info.reachable[sym] = true
+			c.info.reached = append(c.info.reached, sym)
+			work = append(work, sym)
+		}
+	}
+
+	// the entry points.
+	if c.packageName == "main" {
+		if main := c.pkgScope.LookupLocal("main"); main != nil && main.kind == SymbolFunc {
+			reach(main)
+		}
+	}
+	for _, sym := range c.info.initPlan.inits {
+		reach(sym)
+	}
+	for _, sym := range c.info.initPlan.vars {
+		reach(sym)
+	}
+	if c.packageName != "main" {
+		for _, sf := range files {
+			for _, decl := range sf.ast.(ASTTopLevel).topLevelDecls {
+				switch d := decl.(type) {
+				case ASTFunctionDecl:
+					if isExported(d.name) {
+						reach(c.funcSymbol(sf.fileName, d))
+					}
+				case ASTVarDecl:
+					if sym := c.info.defs[exprKey{sf.fileName, d.ident.Pos()}]; sym != nil && isExported(sym.name) {
+						reach(sym)
+					}
+				case ASTConstDecl:
+					if sym := c.info.defs[exprKey{sf.fileName, d.ident.Pos()}]; sym != nil && isExported(sym.name) {
+						reach(sym)
+					}
+				case ASTDataTypeDecl:
+					if sym := c.info.defs[exprKey{sf.fileName, d.ident.Pos()}]; sym != nil && isExported(sym.name) {
+						reach(sym)
+					}
+				}
+			}
+		}
+	}
+
+	// follow the references, keeping track of the types whose methods
+	// could be called through interfaces.
+	var types []*DataTypeNamed
+	var calledNames []string
+	called := make(map[string]bool)
+	for len(work) > 0 {
+		sym := work[0]
+		work = work[1:]
+
+		for _, dep := range sym.deps {
+			reach(dep)
+		}
+
+		for _, typ := range c.converted[sym] {
+			c.convertedMethods(typ, reach, make(map[*DataTypeNamed]bool))
+		}
+
+		if named, ok := sym.typ.(*DataTypeNamed); ok && sym.kind == SymbolType && named.pkg == c.packagePath {
+			types = append(types, named)
+			for _, name := range calledNames {
+				if method := named.Method(name); method != nil {
+					reach(c.methodSymbol(method))
+				}
+			}
+		}
+
+		for _, name := range c.calls[sym] {
+			if called[name] {
+				continue
+			}
+			called[name] = true
+			calledNames = append(calledNames, name)
+			for _, named := range types {
+				if method := named.Method(name); method != nil {
+					reach(c.methodSymbol(method))
+				}
+			}
+		}
+	}
+}
+
+// convertedMethods reaches the exported methods of a type from this
+// package which is converted to an interface, including the ones promoted
+// from its embedded fields. The unexported ones can only be called through
+// an interface from this package, which is found from the calls.
+func (c *Checker) convertedMethods(typ DataType, reach func(sym *Symbol), seen map[*DataTypeNamed]bool) {
+	if ptr, ok := typ.(*DataTypeUnary); ok && ptr.kind == DataTypeKindPointer {
+		typ = ptr.subType
+	}
+	named, ok := typ.(*DataTypeNamed)
+	if ok && named.origin != nil {
+		named = named.origin
+	}
+
+	if ok && !seen[named] {
+		seen[named] = true
+		if named.pkg == c.packagePath {
+			for _, method := range named.Methods() {
+				if isExported(method.name) {
+					reach(c.methodSymbol(method))
+				}
+			}
+		}
+	}
+
+	if st, ok := Underlying(typ).(*DataTypeStruct); ok {
+		for _, field := range st.fields {
+			if field.embedded {
+				c.convertedMethods(field.typ, reach, seen)
+			}
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package golightly
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReachability(t *testing.T) {
+	ta := &testAST{}
+	intResult := func() []AST { return []AST{ta.param("", ta.ident("int"))} }
+	ret := func(value AST) []AST { return []AST{ASTReturn{ta.pos(), []AST{value}}} }
+	method := func(recv string, name string, body []AST) AST {
+		return ASTFunctionDecl{ta.pos(), name, nil, ASTReceiver{ta.pos(), "", false, recv, nil}, nil, intResult(), ASTBlock{ta.pos(), body}}
+	}
+	fn := func(name string, returns []AST, body ...AST) AST {
+		return ASTFunctionDecl{ta.pos(), name, nil, nil, nil, returns, ASTBlock{ta.pos(), body}}
+	}
+	discard := func(value AST) AST {
+		return ASTAssign{ta.pos(), TokenKindAssign, []AST{ta.ident("_")}, []AST{value}}
+	}
+
+	// type Shape interface { Area() int }
+	// type Square int; func (Square) Area() int { return 1 }; func (Square) Perimeter() int { return 4 }; func (Square) sides() int { return 4 }
+	// type Circle int; func (Circle) Area() int { return 2 }
+	// var count = 0
+	// func helper() int { return count }
+	// func unused() int { return 0 }
+	// func main() { var s Shape = Square(1); _ = s.Area(); _ = helper() }
+	decls := []AST{
+		ASTDataTypeDecl{ta.ident("Shape"), nil, ASTDataTypeInterface{ta.pos(), []AST{ASTDataTypeMethodSpec{ta.pos(), "Area", nil, intResult()}}}, false},
+		ASTDataTypeDecl{ta.ident("Square"), nil, ta.ident("int"), false},
+		method("Square", "Area", ret(ta.int(1))),
+		method("Square", "Perimeter", ret(ta.int(4))),
+		method("Square", "sides", ret(ta.int(4))),
+		ASTDataTypeDecl{ta.ident("Circle"), nil, ta.ident("int"), false},
+		method("Circle", "Area", ret(ta.int(2))),
+		ta.varDecl("count", nil, ta.int(0)),
+		fn("helper", intResult(), ret(ta.ident("count"))...),
+		fn("unused", intResult(), ret(ta.int(0))...),
+		fn("main", nil,
+			ta.varDecl("s", ta.ident("Shape"), ta.call(ta.ident("Square"), ta.int(1))),
+			discard(ta.call(ASTSelector{ta.pos(), ta.ident("s"), "Area"})),
+			discard(ta.call(ta.ident("helper"))),
+		),
+	}
+
+	c, errs := checkTestFile(NewDataTypeStore(), decls)
+	if len(errs) != 0 {
+		t.Fatal("unexpected errors: ", errs)
+	}
+
+	reachable := make(map[string]bool)
+	for _, sym := range c.Info().Reachable() {
+		if !c.Info().IsReachable(sym) {
+			t.Error(sym.Name(), " is in the reachable list but isn't reachable")
+		}
+		reachable[sym.Name()] = true
+	}
+	// Square is converted to an interface, so anything which gets hold of
+	// it could call its exported methods.
+	for _, name := range []string{"main", "count", "helper", "Shape", "Square", "Square.Area", "Square.Perimeter"} {
+		if !reachable[name] {
+			t.Error(name, " should be reachable")
+		}
+	}
+	for _, name := range []string{"unused", "Circle", "Circle.Area", "Square.sides"} {
+		if reachable[name] {
+			t.Error(name, " shouldn't be reachable")
+		}
+	}
+
+	// everything exported from other packages could be used by an importer.
+	lib := &sourceFile{packageName: "lib", fileName: "lib.go", ast: ASTTopLevel{topLevelDecls: []AST{
+		fn("Exported", intResult(), ret(ta.call(ta.ident("internal")))...),
+		fn("internal", intResult(), ret(ta.int(1))...),
+		fn("hidden", intResult(), ret(ta.int(2))...),
+		ASTConstDecl{ta.ident("Limit"), nil, ta.int(3), 0},
+		ASTConstDecl{ta.ident("limit"), nil, ta.int(4), 0},
+	}}}
+	c = NewChecker(NewDataTypeStore(), "lib")
+	if errs := c.CheckFiles([]*sourceFile{lib}); len(errs) != 0 {
+		t.Fatal("unexpected errors: ", errs)
+	}
+	reachable = make(map[string]bool)
+	for _, sym := range c.Info().Reachable() {
+		reachable[sym.Name()] = true
+	}
+	if !reachable["Exported"] || !reachable["internal"] || reachable["hidden"] || !reachable["Limit"] || reachable["limit"] {
+		t.Error("wrong reachable declarations in lib: ", reachable)
+	}
+}
+
+func TestReachabilityAcrossPackages(t *testing.T) {
+	ta := &testAST{}
+	stringResult := func() []AST { return []AST{ta.param("", ta.ident("string"))} }
+
+	// package lib
+	// type Stringer interface { String() string }
+	// func Show(s Stringer) string { return s.String() }
+	ts := NewDataTypeStore()
+	lib := NewChecker(ts, "lib")
+	libFile := &sourceFile{packageName: "lib", fileName: "lib.go", ast: ASTTopLevel{topLevelDecls: []AST{
+		ASTDataTypeDecl{ta.ident("Stringer"), nil, ASTDataTypeInterface{ta.pos(), []AST{ASTDataTypeMethodSpec{ta.pos(), "String", nil, stringResult()}}}, false},
+		ASTFunctionDecl{ta.pos(), "Show", nil, nil, []AST{ta.param("s", ta.ident("Stringer"))}, stringResult(), ASTBlock{ta.pos(), []AST{
+			ASTReturn{ta.pos(), []AST{ta.call(ASTSelector{ta.pos(), ta.ident("s"), "String"})}},
+		}}},
+	}}}
+	if errs := lib.CheckFiles([]*sourceFile{libFile}); len(errs) != 0 {
+		t.Fatal("unexpected errors in lib: ", errs)
+	}
+
+	// package main
+	// import "lib"
+	// type S int
+	// func (S) String() string { return "s" }
+	// func main() { _ = lib.Show(S(1)) }
+	c := NewChecker(ts, "main")
+	c.AddImport("lib", lib.Scope())
+	sf := &sourceFile{packageName: "main", fileName: "main.go", ast: ASTTopLevel{
+		imports: []AST{ASTImport{ta.pos(), nil, ASTValue{ta.pos(), ValueString{"lib"}}}},
+		topLevelDecls: []AST{
+			ASTDataTypeDecl{ta.ident("S"), nil, ta.ident("int"), false},
+			ASTFunctionDecl{ta.pos(), "String", nil, ASTReceiver{ta.pos(), "", false, "S", nil}, nil, stringResult(), ASTBlock{ta.pos(), []AST{
+				ASTReturn{ta.pos(), []AST{ta.str("s")}},
+			}}},
+			ASTFunctionDecl{ta.pos(), "main", nil, nil, nil, nil, ASTBlock{ta.pos(), []AST{
+				ASTAssign{ta.pos(), TokenKindAssign, []AST{ta.ident("_")}, []AST{
+					ta.call(ASTIdentifier{ta.pos(), "lib", "Show"}, ta.call(ta.ident("S"), ta.int(1))),
+				}},
+			}}},
+		},
+	}}
+	if errs := c.CheckFiles([]*sourceFile{sf}); len(errs) != 0 {
+		t.Fatal("unexpected errors: ", errs)
+	}
+
+	// lib calls String, so lowering mustn't leave it out.
+	lowered := AnalyseClosures(ts, c.Info(), "main", []*sourceFile{sf}).Lower("main.go", sf.ast.(ASTTopLevel))
+	found := false
+	for _, decl := range lowered.topLevelDecls {
+		if fd, ok := decl.(ASTFunctionDecl); ok && fd.name == "String" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("S.String should be reachable since lib calls it through an interface")
+	}
+}
+
+func TestUnreachableSkipped(t *testing.T) {
+	ta := &testAST{}
+	intPtr := func() AST { return ASTDataTypePointer{ta.pos(), ta.ident("int")} }
+	fn := func(name string, body ...AST) AST {
+		return ASTFunctionDecl{ta.pos(), name, nil, nil, nil, []AST{ta.param("", intPtr())}, ASTBlock{ta.pos(), body}}
+	}
+	escaping := func(ident AST) []AST {
+		name := ident.(ASTIdentifier).name
+		return []AST{
+			ASTAssign{ta.pos(), TokenKindDeclareAssign, []AST{ident}, []AST{ta.int(1)}},
+			ASTAssign{ta.pos(), TokenKindAssign, []AST{ta.ident(name)}, []AST{ta.ident(name)}},
+			ASTReturn{ta.pos(), []AST{ta.unary(TokenKindBitwiseAnd, ta.ident(name))}},
+		}
+	}
+
+	// func live() *int { x := 1; x = x; return &x }
+	// func dead() *int { y := 1; y = y; return &y }
+	// func main() { _ = live }
+	x, y := ta.ident("x"), ta.ident("y")
+	decls := []AST{fn("live", escaping(x)...), fn("dead", escaping(y)...), ta.mainUsing("live")}
+	sf := &sourceFile{packageName: "main", fileName: "test.go", ast: ASTTopLevel{topLevelDecls: decls}}
+	ts := NewDataTypeStore()
+	c, errs := checkTestFiles(ts, []*sourceFile{sf})
+	if len(errs) != 0 {
+		t.Fatal("unexpected errors: ", errs)
+	}
+
+	ei := AnalyseEscapes(ts, c.Info(), "main", []*sourceFile{sf})
+	if ed := ei.Lookup("test.go", x); ed == nil || !ed.Heap() {
+		t.Error("x should be moved to the heap but got: ", ed)
+	}
+	if ed := ei.Lookup("test.go", y); ed != nil {
+		t.Error("dead shouldn't have been analysed but y has: ", ed)
+	}
+
+	// like go vet, everything is vetted.
+	errs = c.Vet([]*sourceFile{sf})
+	if len(errs) != 2 || !strings.Contains(errs[0].Error(), "this assigns x to itself") || !strings.Contains(errs[1].Error(), "this assigns y to itself") {
+		t.Error("both live and dead should be vetted but got: ", errs)
+	}
+
+	lowered := AnalyseClosures(ts, c.Info(), "main", []*sourceFile{sf}).Lower("test.go", sf.ast.(ASTTopLevel))
+	for _, decl := range lowered.topLevelDecls {
+		if decl.(ASTFunctionDecl).name == "dead" {
+			t.Error("dead should have been left out of the lowered file")
+		}
+	}
+}
@@ -2,10 +2,11 @@ package golightly
 
 // type sourceFile is a single file which has to be compiled.
 type sourceFile struct {
+	packagePath            string                 // the import path of the package this file is part of.
 	packageName            string                 // the package name of this file.
 	fileName               string                 // the name of this file. unique system-wide.
+	contentHash            string                 // the hash of the file's contents, once it's been parsed.
 	ast                    AST                    // the AST result of parsing.
-	symbols                SymbolTable            // the symbols in this file.
 	waitingPackageComplete map[string]bool        // the import packages we're waiting on before we can do symbol resolution.
 	packageComplete        chan completionMessage // packages tell us they're complete with a message on this channel.
 	compileSrc             chan compileSrcMessage // we can request files to be compiled here.
@@ -14,18 +15,55 @@ type sourceFile struct {
 	shutdown               chan bool              // closed when the compiler is shutting down.
 
 	// the following are used by Compiler.parseSrcs().
-	status				compileStatus            // where we are in the compilation process.
+	status compileStatus // where we are in the compilation process.
 }
 
 // NewSourceFile creates a new sourceFile.
-func NewSourceFile(fileName string, compileSrc chan compileSrcMessage, addImport chan importMessage, completeChannel chan completionMessage, shutdown chan bool) *sourceFile {
+func NewSourceFile(fileName string, packagePath string, compileSrc chan compileSrcMessage, addImport chan importMessage, completeChannel chan completionMessage, shutdown chan bool) *sourceFile {
 	sf := new(sourceFile)
+	sf.packagePath = packagePath
 	sf.fileName = fileName
 	sf.waitingPackageComplete = make(map[string]bool)
 	sf.packageComplete = make(chan completionMessage)
+	sf.compileSrc = compileSrc
 	sf.addImport = addImport
 	sf.completeChannel = completeChannel
 	sf.shutdown = shutdown
 
 	return sf
 }
+
+// requestImport asks the compiler to import a package this file needs.
+// The package tells us when it's done on packageComplete. A file which
+// isn't being compiled by a Compiler, such as in the parser tests, has
+// nowhere to send imports so they're ignored.
+func (sf *sourceFile) requestImport(packagePath string, pos SrcSpan) error {
+	if sf.addImport == nil || sf.waitingPackageComplete[packagePath] {
+		return nil
+	}
+
+	sf.waitingPackageComplete[packagePath] = true
+	select {
+	case sf.addImport <- importMessage{packagePath, sf.fileName, pos, sf.packageComplete}:
+		return nil
+	case <-sf.shutdown:
+		return errShuttingDown
+	}
+}
+
+// waitImports waits until every package this file imports is complete.
+// It gives the problems any of them had.
+func (sf *sourceFile) waitImports() error {
+	var errs ErrorList
+	for len(sf.waitingPackageComplete) > 0 {
+		select {
+		case msg := <-sf.packageComplete:
+			delete(sf.waitingPackageComplete, msg.packageName)
+			errs.Add(msg.err)
+		case <-sf.shutdown:
+			return errShuttingDown
+		}
+	}
+
+	return errs.Err()
+}
@@ -0,0 +1,325 @@
+package golightly
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// type absFS is an fs.FS with a current directory which relative names
+// are relative to, so they can be made absolute. The import resolver
+// needs absolute names to look for go.mod files and vendor directories
+// in the parents of a directory.
+type absFS interface {
+	fs.FS
+	Abs(name string) (string, error)
+}
+
+// type osFS is an fs.FS for the operating system's files. Unlike os.DirFS
+// it takes names the way they're given on the command line - absolute or
+// relative to the current directory.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(filepath.FromSlash(name))
+}
+
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(filepath.FromSlash(name))
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(filepath.FromSlash(name))
+}
+
+func (osFS) Abs(name string) (string, error) {
+	return filepath.Abs(filepath.FromSlash(name))
+}
+
+// type OverlayFS is an fs.FS which puts files held in memory over the
+// files of another fs.FS. It's used for sources which aren't saved in the
+// file system the compiler reads from, like an editor's unsaved buffers
+// or scripts loaded from a database. The files in memory are in
+// directories like any others so they can be imported.
+type OverlayFS struct {
+	base fs.FS // the files underneath, or nil for none.
+
+	mutex sync.RWMutex
+	files map[string][]byte // the files in memory, by absolute slash-separated name if base has a current directory.
+}
+
+// NewOverlayFS creates an overlay over base. If base is nil only the files
+// which are added are in it.
+func NewOverlayFS(base fs.FS) *OverlayFS {
+	o := new(OverlayFS)
+	o.base = base
+	o.files = make(map[string][]byte)
+
+	return o
+}
+
+// Add adds a file, replacing any file with the same name in the overlay or
+// in the file system underneath.
+func (o *OverlayFS) Add(name string, src []byte) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.files[o.key(name)] = src
+}
+
+// validName checks a name can be looked up. If the file system underneath
+// has a current directory names are operating system paths, otherwise
+// they have to be valid fs.FS names.
+func (o *OverlayFS) validName(name string) bool {
+	_, ok := o.base.(absFS)
+	return ok || fs.ValidPath(filepath.ToSlash(name))
+}
+
+// key gives the name a file is kept in the overlay with.
+func (o *OverlayFS) key(name string) string {
+	if base, ok := o.base.(absFS); ok {
+		if abs, err := base.Abs(name); err == nil {
+			return filepath.ToSlash(abs)
+		}
+	}
+
+	return cleanName(name)
+}
+
+// Abs makes a name absolute if the file system underneath has a current
+// directory. Otherwise the name is just cleaned.
+func (o *OverlayFS) Abs(name string) (string, error) {
+	return filepath.FromSlash(o.key(name)), nil
+}
+
+func (o *OverlayFS) Open(name string) (fs.File, error) {
+	if !o.validName(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	key := o.key(name)
+	o.mutex.RLock()
+	src, ok := o.files[key]
+	o.mutex.RUnlock()
+	if ok {
+		return &memFile{memInfo{path.Base(key), int64(len(src)), false}, bytes.NewReader(src)}, nil
+	}
+
+	info, err := o.Stat(name)
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: pathErr.Err}
+	} else if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return o.base.Open(cleanName(name))
+	}
+
+	// directories have the files from both.
+	entries, err := o.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	return &memDir{name, info, entries, 0}, nil
+}
+
+func (o *OverlayFS) Stat(name string) (fs.FileInfo, error) {
+	if !o.validName(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	key := o.key(name)
+	o.mutex.RLock()
+	src, ok := o.files[key]
+	isDir := o.hasFilesIn(key)
+	o.mutex.RUnlock()
+
+	switch {
+	case ok:
+		return memInfo{path.Base(key), int64(len(src)), false}, nil
+	case o.base != nil:
+		info, err := fs.Stat(o.base, cleanName(name))
+		if err == nil || !isDir {
+			return info, err
+		}
+	}
+	if isDir {
+		return memInfo{path.Base(key), 0, true}, nil
+	}
+
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (o *OverlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !o.validName(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	// start with the directory underneath, if there is one.
+	entries := make(map[string]fs.DirEntry)
+	found := false
+	err := error(&fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist})
+	if o.base != nil {
+		var baseEntries []fs.DirEntry
+		baseEntries, err = fs.ReadDir(o.base, cleanName(name))
+		found = err == nil
+		for _, entry := range baseEntries {
+			entries[entry.Name()] = entry
+		}
+	}
+
+	// then the files in memory, and the directories they're in.
+	key := o.key(name)
+	o.mutex.RLock()
+	for fileKey, src := range o.files {
+		rest, ok := pathInDir(fileKey, key)
+		if !ok {
+			continue
+		}
+
+		found = true
+		if i := strings.Index(rest, "/"); i >= 0 {
+			if _, ok := entries[rest[:i]]; !ok {
+				entries[rest[:i]] = memInfo{rest[:i], 0, true}
+			}
+		} else {
+			entries[rest] = memInfo{rest, int64(len(src)), false}
+		}
+	}
+	o.mutex.RUnlock()
+	if !found {
+		return nil, err
+	}
+
+	list := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+
+	return list, nil
+}
+
+// hasFilesIn returns true if there are files in memory in a directory or
+// the directories in it. The mutex has to be held.
+func (o *OverlayFS) hasFilesIn(dir string) bool {
+	for fileKey := range o.files {
+		if _, ok := pathInDir(fileKey, dir); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pathInDir gives the rest of a slash-separated name after a directory it's
+// in. The bool is false if it isn't in the directory.
+func pathInDir(name string, dir string) (string, bool) {
+	prefix := dir + "/"
+	switch {
+	case dir == ".":
+		prefix = ""
+	case strings.HasSuffix(dir, "/"):
+		prefix = dir
+	}
+
+	if name == dir || !strings.HasPrefix(name, prefix) {
+		return "", false
+	}
+
+	return name[len(prefix):], true
+}
+
+// cleanName tidies a name into the slash-separated form an fs.FS takes.
+func cleanName(name string) string {
+	return filepath.ToSlash(filepath.Clean(name))
+}
+
+// type memInfo describes a file or directory in memory. It's both an
+// fs.FileInfo and an fs.DirEntry.
+type memInfo struct {
+	name string // the base name.
+	size int64  // the length of the file.
+	dir  bool   // true if it's a directory.
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) ModTime() time.Time { return time.Time{} }
+func (i memInfo) IsDir() bool        { return i.dir }
+func (i memInfo) Sys() interface{}   { return nil }
+
+func (i memInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (i memInfo) Type() fs.FileMode {
+	return i.Mode().Type()
+}
+
+func (i memInfo) Info() (fs.FileInfo, error) {
+	return i, nil
+}
+
+// type memFile is an open file from memory.
+type memFile struct {
+	info memInfo       // what it is.
+	r    *bytes.Reader // reads the source.
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	return f.r.Read(p)
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+// type memDir is an open directory which has files in memory.
+type memDir struct {
+	name    string        // the name it was opened with.
+	info    fs.FileInfo   // what it is.
+	entries []fs.DirEntry // the files and directories in it.
+	offset  int           // how many entries ReadDir has given so far.
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *memDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *memDir) Close() error {
+	return nil
+}
+
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(rest) {
+		rest = rest[:n]
+	}
+	d.offset += len(rest)
+
+	return rest, nil
+}
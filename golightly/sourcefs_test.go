@@ -0,0 +1,75 @@
+package golightly
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestOverlayFS(t *testing.T) {
+	base := fstest.MapFS{
+		"main.go":    {Data: []byte("package main\n")},
+		"lib/lib.go": {Data: []byte("package lib\n")},
+	}
+	o := NewOverlayFS(base)
+	o.Add("main.go", []byte("package main // unsaved\n"))
+	o.Add("lib/extra.go", []byte("package lib\n"))
+	o.Add("gen/deep/gen.go", []byte("package deep\n"))
+
+	if err := fstest.TestFS(o, "main.go", "lib/lib.go", "lib/extra.go", "gen/deep/gen.go"); err != nil {
+		t.Error(err)
+	}
+
+	if src, err := fs.ReadFile(o, "main.go"); err != nil || string(src) != "package main // unsaved\n" {
+		t.Error("the file in memory should replace main.go but got: ", string(src), err)
+	}
+
+	var names []string
+	entries, err := fs.ReadDir(o, "lib")
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if got := strings.Join(names, " "); err != nil || got != "extra.go lib.go" {
+		t.Error("lib should have the files from both but has: ", got, err)
+	}
+
+	if info, err := fs.Stat(o, "gen"); err != nil || !info.IsDir() {
+		t.Error("the directories of files in memory should exist: ", info, err)
+	}
+	if _, err := fs.Stat(o, "missing.go"); err == nil {
+		t.Error("a missing file shouldn't exist")
+	}
+
+	// with nothing underneath there are only the files in memory.
+	o = NewOverlayFS(nil)
+	o.Add("main.go", []byte("package main\n"))
+	if err := fstest.TestFS(o, "main.go"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOverlayFSOverOS(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"main.go": "package main\n"})
+
+	o := NewOverlayFS(osFS{})
+	o.Add(filepath.Join(dir, "unsaved.go"), []byte("package main\n"))
+
+	var names []string
+	entries, err := fs.ReadDir(o, dir)
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if got := strings.Join(names, " "); err != nil || got != "main.go unsaved.go" {
+		t.Error("the directory should have the files from both but has: ", got, err)
+	}
+
+	ir := NewImportResolver(nil, "linux", "amd64")
+	ir.SetFS(o)
+	files, err := ir.PackageFiles(dir)
+	if err != nil || len(files) != 2 || files[1] != filepath.Join(dir, "unsaved.go") {
+		t.Error("the package should have both files but has: ", files, err)
+	}
+}
@@ -26,3 +26,9 @@ func (ss SrcSpan) Equals(to SrcSpan) bool {
 func (ss SrcLoc) Equals(to SrcLoc) bool {
 	return ss.Line == to.Line && ss.Column == to.Column
 }
+
+// Before returns true if a source location is earlier in the file than
+// another.
+func (ss SrcLoc) Before(to SrcLoc) bool {
+	return ss.Line < to.Line || ss.Line == to.Line && ss.Column < to.Column
+}
@@ -1,5 +1,252 @@
 package golightly
 
+import (
+	"fmt"
+	"sort"
+)
+
+// type SymbolKind indicates what kind of thing a symbol refers to.
+type SymbolKind int
+
+const (
+	SymbolConst SymbolKind = iota
+	SymbolVar
+	SymbolType
+	SymbolFunc
+	SymbolPackage
+	SymbolLabel
+	SymbolBuiltin
+	SymbolNil
+)
+
+// the names of the symbol kinds, for error messages.
+var symbolKindNames = map[SymbolKind]string{
+	SymbolConst:   "constant",
+	SymbolVar:     "variable",
+	SymbolType:    "type",
+	SymbolFunc:    "function",
+	SymbolPackage: "package",
+	SymbolLabel:   "label",
+	SymbolBuiltin: "builtin function",
+	SymbolNil:     "nil",
+}
+
+func (sk SymbolKind) String() string {
+	return symbolKindNames[sk]
+}
+
+// type Symbol is something a name refers to - a constant, variable, type,
+// function, imported package, label or builtin.
+type Symbol struct {
+	kind       SymbolKind           // what kind of thing it is
+	name       string               // the name it's declared as
+	typ        DataType             // its type. for package level symbols it's nil until resolved.
+	val        Constant             // the value of a constant
+	filename   string               // the file it's declared in
+	pos        SrcSpan              // where it's declared
+	decl       AST                  // the declaration of a package level symbol
+	builtin    builtinID            // which builtin it is if kind is SymbolBuiltin
+	path       string               // the import path if kind is SymbolPackage
+	typeParams []*DataTypeTypeParam // the type parameters of a generic function
+	resolving  bool                 // true while a package level symbol is being resolved
+	used       bool                 // true once the symbol has been referred to
+	deps       []*Symbol            // the package level variables and functions and the methods a package level declaration refers to
+}
+
+// Kind returns what kind of thing the symbol is.
+func (sym *Symbol) Kind() SymbolKind {
+	return sym.kind
+}
+
+// Name returns the name the symbol is declared as.
+func (sym *Symbol) Name() string {
+	return sym.name
+}
+
+// Type returns the symbol's type, or nil if it doesn't have one or it
+// hasn't been worked out yet.
+func (sym *Symbol) Type() DataType {
+	return sym.typ
+}
+
+// Filename returns the file the symbol is declared in. It's empty for
+// predeclared symbols.
+func (sym *Symbol) Filename() string {
+	return sym.filename
+}
+
+// Pos returns where the symbol is declared.
+func (sym *Symbol) Pos() SrcSpan {
+	return sym.pos
+}
+
+// Path returns the import path of a package name.
+func (sym *Symbol) Path() string {
+	return sym.path
+}
+
+// Used returns true if the symbol has been referred to.
+func (sym *Symbol) Used() bool {
+	return sym.used
+}
+
+// type ScopeKind indicates what kind of scope a SymbolTable is.
+type ScopeKind int
+
+const (
+	ScopeUniverse ScopeKind = iota // the predeclared names
+	ScopePackage                   // the package level names
+	ScopeFile                      // the imported package names of a file
+	ScopeFunc                      // the parameters and results of a function
+	ScopeBlock                     // the names declared in a block
+)
+
+// type SymbolTable is a scope - a set of names declared together. Scopes
+// form a tree from the universe down through the package, each file, each
+// function and each block. A name can be declared once in each scope, and
+// names in inner scopes hide the same names in outer ones.
+//
+// Labels are kept separately in the function scope since they're visible
+// throughout the function body, whichever block they're in.
 type SymbolTable struct {
-	syms map[string]DataType
+	kind     ScopeKind          // what kind of scope it is
+	parent   *SymbolTable       // the enclosing scope
+	children []*SymbolTable     // the scopes nested directly inside this one
+	syms     map[string]*Symbol // the names declared in this scope
+	labels   map[string]*Symbol // the labels of a function scope
+}
+
+// NewSymbolTable creates a scope nested inside another. The parent is nil
+// for the universe scope.
+func NewSymbolTable(kind ScopeKind, parent *SymbolTable) *SymbolTable {
+	st := &SymbolTable{kind: kind, parent: parent, syms: make(map[string]*Symbol)}
+	if parent != nil {
+		parent.children = append(parent.children, st)
+	}
+
+	return st
+}
+
+// Kind returns what kind of scope it is.
+func (st *SymbolTable) Kind() ScopeKind {
+	return st.kind
+}
+
+// Parent returns the enclosing scope, or nil for the universe scope.
+func (st *SymbolTable) Parent() *SymbolTable {
+	return st.parent
+}
+
+// Children returns the scopes nested directly inside this one, in the
+// order they were created.
+func (st *SymbolTable) Children() []*SymbolTable {
+	return st.children
+}
+
+// Symbols returns the symbols declared in this scope, sorted by name.
+func (st *SymbolTable) Symbols() []*Symbol {
+	syms := make([]*Symbol, 0, len(st.syms))
+	for _, sym := range st.syms {
+		syms = append(syms, sym)
+	}
+
+	sort.Slice(syms, func(i, j int) bool {
+		return syms[i].name < syms[j].name
+	})
+
+	return syms
+}
+
+// LookupLocal finds a name declared in this scope only.
+func (st *SymbolTable) LookupLocal(name string) *Symbol {
+	return st.syms[name]
+}
+
+// Lookup finds a name in this scope or the nearest enclosing scope which
+// declares it. It also returns the scope it was found in.
+func (st *SymbolTable) Lookup(name string) (*Symbol, *SymbolTable) {
+	for ; st != nil; st = st.parent {
+		if sym, ok := st.syms[name]; ok {
+			return sym, st
+		}
+	}
+
+	return nil, nil
+}
+
+// Insert declares a symbol in this scope. It's an error if the name has
+// already been declared in the same scope. The blank identifier '_' is
+// never declared.
+func (st *SymbolTable) Insert(sym *Symbol) error {
+	if sym.name == "_" {
+		return nil
+	}
+
+	if other, ok := st.syms[sym.name]; ok {
+		return redeclaredError(st, sym, other)
+	}
+
+	st.syms[sym.name] = sym
+	return nil
+}
+
+// funcScope finds the innermost function scope enclosing this scope.
+func (st *SymbolTable) funcScope() *SymbolTable {
+	for ; st != nil; st = st.parent {
+		if st.kind == ScopeFunc {
+			return st
+		}
+	}
+
+	return nil
+}
+
+// InsertLabel declares a label in the enclosing function. It's an error if
+// the label has already been declared in the function.
+func (st *SymbolTable) InsertLabel(sym *Symbol) error {
+	fs := st.funcScope()
+	if fs == nil {
+		return NewError(sym.filename, sym.pos, "labels can only be used inside a function")
+	}
+	if fs.labels == nil {
+		fs.labels = make(map[string]*Symbol)
+	}
+
+	if other, ok := fs.labels[sym.name]; ok {
+		return redeclaredError(fs, sym, other)
+	}
+
+	fs.labels[sym.name] = sym
+	return nil
+}
+
+// LookupLabel finds a label declared in the enclosing function. Labels
+// from enclosing functions aren't visible inside function literals.
+func (st *SymbolTable) LookupLabel(name string) *Symbol {
+	fs := st.funcScope()
+	if fs == nil {
+		return nil
+	}
+
+	return fs.labels[name]
+}
+
+// redeclaredError reports a symbol which has the same name as another
+// symbol in the same scope.
+func redeclaredError(st *SymbolTable, sym *Symbol, other *Symbol) error {
+	where := "in this block"
+	switch {
+	case sym.kind == SymbolLabel:
+		where = "in this function"
+	case st.kind == ScopePackage:
+		where = "in this package"
+	case st.kind == ScopeFile:
+		where = "in this file"
+	}
+
+	if other.filename == "" {
+		return NewError(sym.filename, sym.pos, fmt.Sprintf("'%s' has already been declared %s", sym.name, where))
+	}
+
+	return NewError(sym.filename, sym.pos, fmt.Sprintf("'%s' has already been declared %s, at %s", sym.name, where, declaredAt(sym.filename, other.filename, other.pos)))
 }
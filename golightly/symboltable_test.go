@@ -0,0 +1,148 @@
+package golightly
+
+import (
+	"testing"
+)
+
+func TestSymbolTable(t *testing.T) {
+	pos := func(line int) SrcSpan {
+		return SrcSpan{SrcLoc{line, 1}, SrcLoc{line, 2}}
+	}
+
+	universe := NewSymbolTable(ScopeUniverse, nil)
+	pkg := NewSymbolTable(ScopePackage, universe)
+	file := NewSymbolTable(ScopeFile, pkg)
+	fn := NewSymbolTable(ScopeFunc, file)
+	block := NewSymbolTable(ScopeBlock, fn)
+
+	intSym := &Symbol{kind: SymbolType, name: "int"}
+	outer := &Symbol{kind: SymbolVar, name: "x", filename: "test.go", pos: pos(1)}
+	inner := &Symbol{kind: SymbolConst, name: "x", filename: "test.go", pos: pos(2)}
+	fmtSym := &Symbol{kind: SymbolPackage, name: "fmt", filename: "test.go", pos: pos(3), path: "fmt"}
+	for _, insert := range []struct {
+		st  *SymbolTable
+		sym *Symbol
+	}{{universe, intSym}, {pkg, outer}, {file, fmtSym}, {block, inner}, {block, &Symbol{kind: SymbolVar, name: "_"}}} {
+		if err := insert.st.Insert(insert.sym); err != nil {
+			t.Error("unexpected error: ", err)
+		}
+	}
+
+	// inner names hide outer ones.
+	if sym, st := block.Lookup("x"); sym != inner || st != block {
+		t.Error("x should be found in the block")
+	}
+	if sym, st := fn.Lookup("x"); sym != outer || st != pkg {
+		t.Error("x should be found in the package")
+	}
+	if sym, _ := block.Lookup("int"); sym != intSym {
+		t.Error("int should be found in the universe")
+	}
+	if sym, _ := pkg.Lookup("fmt"); sym != nil {
+		t.Error("fmt should only be visible in the file")
+	}
+	if block.LookupLocal("int") != nil || block.LookupLocal("_") != nil {
+		t.Error("LookupLocal shouldn't look in enclosing scopes")
+	}
+
+	// names can only be declared once in each scope.
+	err := block.Insert(&Symbol{kind: SymbolVar, name: "x", filename: "test.go", pos: pos(4)})
+	if err == nil || err.Error() != "test.go:4: 'x' has already been declared in this block, at line 2" {
+		t.Error("wrong redeclaration error: ", err)
+	}
+	err = pkg.Insert(&Symbol{kind: SymbolFunc, name: "x", filename: "other.go", pos: pos(5)})
+	if err == nil || err.Error() != "other.go:5: 'x' has already been declared in this package, at test.go:1" {
+		t.Error("wrong redeclaration error: ", err)
+	}
+
+	// labels belong to the function, not the block.
+	label := &Symbol{kind: SymbolLabel, name: "loop", filename: "test.go", pos: pos(6)}
+	if err := block.InsertLabel(label); err != nil {
+		t.Error("unexpected error: ", err)
+	}
+	if fn.LookupLabel("loop") != label || block.LookupLabel("loop") != label {
+		t.Error("the label should be visible in the whole function")
+	}
+	if sym, _ := block.Lookup("loop"); sym != nil {
+		t.Error("labels shouldn't be mixed up with other names")
+	}
+	if err := fn.InsertLabel(&Symbol{kind: SymbolLabel, name: "loop", filename: "test.go", pos: pos(7)}); err == nil {
+		t.Error("a label can't be declared twice in a function")
+	}
+	lit := NewSymbolTable(ScopeFunc, block)
+	if lit.LookupLabel("loop") != nil {
+		t.Error("labels shouldn't be visible in function literals")
+	}
+	if pkg.InsertLabel(&Symbol{kind: SymbolLabel, name: "top", filename: "test.go", pos: pos(8)}) == nil {
+		t.Error("labels can't be declared outside a function")
+	}
+
+	if len(pkg.Children()) != 1 || pkg.Children()[0] != file || block.Parent() != fn {
+		t.Error("scopes are linked wrongly")
+	}
+}
+
+func TestCheckerScopes(t *testing.T) {
+	ta := &testAST{}
+	ts := NewDataTypeStore()
+
+	// import "unsafe"; var a int; var b = a; var c int; func f(p int) { var q int; _ = unsafe.Sizeof(q) }
+	unsafeImport := ASTImport{ta.pos(), nil, ASTValue{ta.pos(), ValueString{"unsafe"}}}
+	f := ASTFunctionDecl{ta.pos(), "f", nil, nil, []AST{ta.param("p", ta.ident("int"))}, nil,
+		ASTBlock{ta.pos(), []AST{
+			ta.varDecl("q", ta.ident("int"), nil),
+			ASTAssign{ta.pos(), TokenKindAssign, []AST{ta.ident("_")}, []AST{ta.call(ASTIdentifier{ta.pos(), "unsafe", "Sizeof"}, ta.ident("q"))}},
+		}}}
+	sf := &sourceFile{packageName: "main", fileName: "test.go", ast: ASTTopLevel{
+		imports: []AST{unsafeImport},
+		topLevelDecls: []AST{
+			ta.varDecl("a", ta.ident("int"), nil),
+			ta.varDecl("b", nil, ta.ident("a")),
+			ta.varDecl("c", ta.ident("int"), nil),
+			f,
+		},
+	}}
+	c := NewChecker(ts, "main")
+	if errs := c.CheckFiles([]*sourceFile{sf}); len(errs) != 0 {
+		t.Error("unexpected errors: ", errs)
+	}
+
+	pkg := c.Scope()
+	if pkg.Kind() != ScopePackage || pkg.Parent().Kind() != ScopeUniverse {
+		t.Error("the package scope should be inside the universe")
+	}
+	if !pkg.LookupLocal("a").Used() || pkg.LookupLocal("c").Used() {
+		t.Error("a should be used and c shouldn't")
+	}
+	if pkg.LookupLocal("f").Kind() != SymbolFunc || pkg.LookupLocal("b").Type() != ts.IntType() {
+		t.Error("wrong package symbols")
+	}
+
+	file := c.FileScope("test.go")
+	if file == nil || file.Parent() != pkg {
+		t.Error("the file scope should be inside the package scope")
+		return
+	}
+	if sym := file.LookupLocal("unsafe"); sym == nil || sym.Kind() != SymbolPackage || sym.Path() != "unsafe" || !sym.Used() {
+		t.Error("unsafe should be a used package name in the file scope")
+	}
+
+	// the function scope has the parameters and the body's names.
+	if len(file.Children()) != 1 {
+		t.Error("there should be one function scope")
+		return
+	}
+	fn := file.Children()[0]
+	if fn.Kind() != ScopeFunc || fn.LookupLocal("p") == nil || !fn.LookupLocal("q").Used() || fn.LookupLocal("p").Used() {
+		t.Error("wrong function scope")
+	}
+
+	// names can't be both imported and declared in the package. the import
+	// isn't used either but that's only a warning here.
+	sf.ast = ASTTopLevel{imports: []AST{unsafeImport}, topLevelDecls: []AST{ta.varDecl("unsafe", ta.ident("int"), nil)}}
+	c = NewChecker(NewDataTypeStore(), "main")
+	c.SetLenient(true)
+	if errs := c.CheckFiles([]*sourceFile{sf}); len(errs) != 1 {
+		t.Error("expected one error but got: ", errs)
+	}
+}
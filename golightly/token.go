@@ -80,28 +80,6 @@ const (
 	TokenKindTypeKeyword
 	TokenKindVar
 
-	// data type keywords
-	TokenKindBool
-	TokenKindUint
-	TokenKindUint8
-	TokenKindUint16
-	TokenKindUint32
-	TokenKindUint64
-	TokenKindUintPtr
-	TokenKindInt
-	TokenKindInt8
-	TokenKindInt16
-	TokenKindInt32
-	TokenKindInt64
-	TokenKindFloat32
-	TokenKindFloat64
-	TokenKindComplex64
-	TokenKindComplex128
-	TokenKindByte
-	TokenKindRune
-	TokenKindString
-	TokenKindError
-
 	// identifiers
 	TokenKindIdentifier
 
@@ -157,6 +135,7 @@ func (st StringToken) Pos() SrcSpan {
 type UintToken struct {
 	s       SimpleToken
 	uintVal uint64
+	text    string // the source text of a numeric literal
 }
 
 func (ut UintToken) TokenKind() TokenKind {
@@ -170,6 +149,7 @@ func (ut UintToken) Pos() SrcSpan {
 type FloatToken struct {
 	s        SimpleToken
 	floatVal float64
+	text     string // the source text of the literal
 }
 
 func (ft FloatToken) TokenKind() TokenKind {
@@ -0,0 +1,389 @@
+package golightly
+
+// this file has the rules from the language spec about how types relate
+// to each other - identity, assignability, convertibility and so on.
+
+// basicKind returns the kind of a type's underlying type.
+func basicKind(dt DataType) DataTypeKind {
+	u := Underlying(dt)
+	if u == nil {
+		return DataTypeKindType
+	}
+
+	return u.DataTypeKind()
+}
+
+// isBoolean returns true for boolean types, typed or untyped.
+func isBoolean(dt DataType) bool {
+	if tp, ok := dt.(*DataTypeTypeParam); ok {
+		return tp.all(isBoolean)
+	}
+
+	kind := basicKind(dt)
+	return kind == DataTypeKindBool || kind == DataTypeKindUntypedBool
+}
+
+// isInteger returns true for integer types, typed or untyped.
+func isInteger(dt DataType) bool {
+	if tp, ok := dt.(*DataTypeTypeParam); ok {
+		return tp.all(isInteger)
+	}
+
+	switch basicKind(dt) {
+	case DataTypeKindInt, DataTypeKindUint, DataTypeKindUntypedInt, DataTypeKindUntypedRune:
+		return true
+	}
+
+	return false
+}
+
+// isUnsigned returns true for unsigned integer types.
+func isUnsigned(dt DataType) bool {
+	if tp, ok := dt.(*DataTypeTypeParam); ok {
+		return tp.all(isUnsigned)
+	}
+
+	return basicKind(dt) == DataTypeKindUint
+}
+
+// isFloat returns true for floating point types, typed or untyped.
+func isFloat(dt DataType) bool {
+	if tp, ok := dt.(*DataTypeTypeParam); ok {
+		return tp.all(isFloat)
+	}
+
+	kind := basicKind(dt)
+	return kind == DataTypeKindFloat || kind == DataTypeKindUntypedFloat
+}
+
+// isComplex returns true for complex types, typed or untyped.
+func isComplex(dt DataType) bool {
+	if tp, ok := dt.(*DataTypeTypeParam); ok {
+		return tp.all(isComplex)
+	}
+
+	kind := basicKind(dt)
+	return kind == DataTypeKindComplex || kind == DataTypeKindUntypedComplex
+}
+
+// isNumeric returns true for integer, floating point and complex types.
+func isNumeric(dt DataType) bool {
+	if tp, ok := dt.(*DataTypeTypeParam); ok {
+		return tp.all(isNumeric)
+	}
+
+	return isInteger(dt) || isFloat(dt) || isComplex(dt)
+}
+
+// isString returns true for string types, typed or untyped.
+func isString(dt DataType) bool {
+	if tp, ok := dt.(*DataTypeTypeParam); ok {
+		return tp.all(isString)
+	}
+
+	kind := basicKind(dt)
+	return kind == DataTypeKindString || kind == DataTypeKindUntypedString
+}
+
+// isUntyped returns true for the types of untyped constants and nil.
+func isUntyped(dt DataType) bool {
+	return dt != nil && dt.DataTypeKind() >= DataTypeKindUntypedBool
+}
+
+// isConstType returns true for the types which constants can have. Values
+// of a type parameter's type are never constant, whatever its type set.
+func isConstType(dt DataType) bool {
+	if _, ok := dt.(*DataTypeTypeParam); ok {
+		return false
+	}
+
+	return isBoolean(dt) || isNumeric(dt) || isString(dt)
+}
+
+// isOrdered returns true for types which can be compared with '<' etc.
+func isOrdered(dt DataType) bool {
+	if tp, ok := dt.(*DataTypeTypeParam); ok {
+		return tp.all(isOrdered)
+	}
+
+	return isInteger(dt) || isFloat(dt) || isString(dt)
+}
+
+// isInterface returns true for interface types.
+func isInterface(dt DataType) bool {
+	return basicKind(dt) == DataTypeKindInterface
+}
+
+// isNamed returns true for types which have a name - ie. defined and
+// predeclared types. Composite type literals have no name.
+func isNamed(dt DataType) bool {
+	switch dt.(type) {
+	case *DataTypeNamed, *DataTypeTypeParam, DataTypeSized:
+		return true
+	case DataTypeBasic:
+		return !isUntyped(dt)
+	}
+
+	return false
+}
+
+// hasNil returns true for types which nil can be assigned to.
+func hasNil(dt DataType) bool {
+	if tp, ok := dt.(*DataTypeTypeParam); ok {
+		return tp.all(hasNil)
+	}
+
+	switch basicKind(dt) {
+	case DataTypeKindPointer, DataTypeKindFunc, DataTypeKindSlice, DataTypeKindMap, DataTypeKindChan, DataTypeKindInterface, DataTypeKindUntypedNil:
+		return true
+	}
+
+	return false
+}
+
+// isComparable returns true for types which can be compared with '=='.
+func isComparable(dt DataType) bool {
+	switch t := Underlying(dt).(type) {
+	case *DataTypeTypeParam:
+		if iface := t.iface(); iface != nil && iface.comparable {
+			return true
+		}
+		return t.all(isComparable)
+
+	case *DataTypeStruct:
+		for _, field := range t.fields {
+			if !isComparable(field.typ) {
+				return false
+			}
+		}
+		return true
+
+	case *DataTypeArray:
+		return isComparable(t.elementType)
+	}
+
+	switch basicKind(dt) {
+	case DataTypeKindSlice, DataTypeKindMap, DataTypeKindFunc, DataTypeKindUntypedNil:
+		return false
+	}
+
+	return true
+}
+
+// defaultType returns the type an untyped constant takes when a type is
+// needed but there's nothing else to determine it - eg. in 'x := 1'.
+func defaultType(ts *DataTypeStore, dt DataType) DataType {
+	switch basicKind(dt) {
+	case DataTypeKindUntypedBool:
+		return ts.BoolType()
+	case DataTypeKindUntypedInt:
+		return ts.IntType()
+	case DataTypeKindUntypedRune:
+		return ts.RuneType()
+	case DataTypeKindUntypedFloat:
+		return ts.Float64Type()
+	case DataTypeKindUntypedComplex:
+		return ts.Complex128Type()
+	case DataTypeKindUntypedString:
+		return ts.StringType()
+	}
+
+	return dt
+}
+
+// untypedRank orders the untyped numeric kinds so that mixing two untyped
+// constants gives the "larger" kind - eg. 1 + 2.0 is an untyped float.
+func untypedRank(dt DataType) int {
+	switch basicKind(dt) {
+	case DataTypeKindUntypedInt:
+		return 1
+	case DataTypeKindUntypedRune:
+		return 2
+	case DataTypeKindUntypedFloat:
+		return 3
+	case DataTypeKindUntypedComplex:
+		return 4
+	}
+
+	return 0
+}
+
+// untypedCompatible returns true if an untyped constant of type from
+// can be given the type to. It only considers the kinds of the types -
+// whether the constant's value fits is checked separately.
+func untypedCompatible(from DataType, to DataType) bool {
+	if tp, ok := to.(*DataTypeTypeParam); ok {
+		return tp.all(func(dt DataType) bool { return untypedCompatible(from, dt) })
+	}
+
+	switch basicKind(from) {
+	case DataTypeKindUntypedBool:
+		return isBoolean(to)
+	case DataTypeKindUntypedInt, DataTypeKindUntypedRune:
+		return isNumeric(to)
+	case DataTypeKindUntypedFloat:
+		return isFloat(to) || isComplex(to) || isInteger(to)
+	case DataTypeKindUntypedComplex:
+		return isNumeric(to)
+	case DataTypeKindUntypedString:
+		return isString(to)
+	case DataTypeKindUntypedNil:
+		return hasNil(to)
+	}
+
+	return false
+}
+
+// assignable returns true if a value of type v can be assigned to a
+// variable of type t. If it can't, a reason may be given.
+func assignable(ts *DataTypeStore, v DataType, t DataType) (bool, string) {
+	// identical types are always assignable.
+	if v == t {
+		return true, ""
+	}
+
+	vu := Underlying(v)
+	tu := Underlying(t)
+
+	// untyped values.
+	if isUntyped(v) {
+		if isInterface(t) {
+			if basicKind(v) == DataTypeKindUntypedNil {
+				return true, ""
+			}
+			return assignable(ts, defaultType(ts, v), t)
+		}
+		return untypedCompatible(v, t), ""
+	}
+
+	// identical underlying types where at least one isn't named.
+	if vu == tu && (!isNamed(v) || !isNamed(t)) {
+		return true, ""
+	}
+
+	// interfaces are assignable from anything which implements them.
+	if iface, ok := tu.(*DataTypeInterface); ok {
+		ok, missing := ts.Implements(v, iface)
+		if ok {
+			return true, ""
+		}
+		return false, missing.String()
+	}
+
+	// bidirectional channels are assignable to directional channels.
+	if vc, ok := vu.(*DataTypeChan); ok && vc.dir == ChanDirectionBi {
+		if tc, ok := tu.(*DataTypeChan); ok && vc.elementType == tc.elementType && (!isNamed(v) || !isNamed(t)) {
+			return true, ""
+		}
+	}
+
+	return false, ""
+}
+
+// identicalIgnoreTags returns true if two types are identical when
+// struct tags are ignored. It's used for conversions.
+func identicalIgnoreTags(a DataType, b DataType) bool {
+	if a == b {
+		return true
+	}
+
+	switch at := a.(type) {
+	case *DataTypeStruct:
+		bt, ok := b.(*DataTypeStruct)
+		if !ok || len(at.fields) != len(bt.fields) {
+			return false
+		}
+		for i, field := range at.fields {
+			other := bt.fields[i]
+			if field.name != other.name || field.pkg != other.pkg || field.embedded != other.embedded || !identicalIgnoreTags(field.typ, other.typ) {
+				return false
+			}
+		}
+		return true
+
+	case *DataTypeUnary:
+		bt, ok := b.(*DataTypeUnary)
+		return ok && at.kind == bt.kind && identicalIgnoreTags(at.subType, bt.subType)
+
+	case *DataTypeArray:
+		bt, ok := b.(*DataTypeArray)
+		return ok && at.length == bt.length && identicalIgnoreTags(at.elementType, bt.elementType)
+	}
+
+	return false
+}
+
+// isByteOrRuneSlice returns true for []byte and []rune types.
+func isByteOrRuneSlice(ts *DataTypeStore, dt DataType) bool {
+	slice, ok := Underlying(dt).(*DataTypeUnary)
+	if !ok || slice.kind != DataTypeKindSlice {
+		return false
+	}
+
+	elem := Underlying(slice.subType)
+	return elem == ts.Uint8Type() || elem == ts.Int32Type()
+}
+
+// convertible returns true if a non-constant value of type v can be
+// converted to type t.
+func convertible(ts *DataTypeStore, v DataType, t DataType) bool {
+	if ok, _ := assignable(ts, v, t); ok {
+		return true
+	}
+
+	// with type parameters every type in the type set has to be
+	// convertible.
+	if tp, ok := v.(*DataTypeTypeParam); ok {
+		return tp.all(func(dt DataType) bool { return convertible(ts, dt, t) })
+	}
+	if tp, ok := t.(*DataTypeTypeParam); ok {
+		return tp.all(func(dt DataType) bool { return convertible(ts, v, dt) })
+	}
+
+	vu := Underlying(v)
+	tu := Underlying(t)
+
+	// identical underlying types, ignoring tags.
+	if identicalIgnoreTags(vu, tu) {
+		return true
+	}
+
+	// unnamed pointers to identical underlying types.
+	vp, vok := vu.(*DataTypeUnary)
+	tp, tok := tu.(*DataTypeUnary)
+	if vok && tok && vp.kind == DataTypeKindPointer && tp.kind == DataTypeKindPointer && !isNamed(v) && !isNamed(t) {
+		if identicalIgnoreTags(Underlying(vp.subType), Underlying(tp.subType)) {
+			return true
+		}
+	}
+
+	// numeric conversions.
+	if (isInteger(v) || isFloat(v)) && (isInteger(t) || isFloat(t)) {
+		return true
+	}
+	if isComplex(v) && isComplex(t) {
+		return true
+	}
+
+	// string conversions.
+	if isString(t) && (isInteger(v) || isByteOrRuneSlice(ts, v)) {
+		return true
+	}
+	if isString(v) && isByteOrRuneSlice(ts, t) {
+		return true
+	}
+
+	// slices to arrays or array pointers.
+	if vok && vp.kind == DataTypeKindSlice {
+		if ta, ok := tu.(*DataTypeArray); ok {
+			return ta.elementType == vp.subType
+		}
+		if tok && tp.kind == DataTypeKindPointer {
+			if ta, ok := Underlying(tp.subType).(*DataTypeArray); ok {
+				return ta.elementType == vp.subType
+			}
+		}
+	}
+
+	return false
+}
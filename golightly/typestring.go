@@ -0,0 +1,284 @@
+package golightly
+
+import (
+	"strconv"
+	"strings"
+)
+
+// type typeWriter writes types out in Go syntax.
+type typeWriter struct {
+	buf        strings.Builder
+	relativeTo string // types from the package with this import path aren't qualified with its name
+}
+
+// TypeString gives a type in Go syntax, eg. "[]map[string]int". Defined
+// types are qualified with their package's name unless they're from the
+// package with the import path relativeTo.
+func TypeString(dt DataType, relativeTo string) string {
+	w := &typeWriter{relativeTo: relativeTo}
+	w.typ(dt)
+	return w.buf.String()
+}
+
+// typeString gives a type in Go syntax with every defined type qualified
+// with its package.
+func typeString(dt DataType) string {
+	return TypeString(dt, "")
+}
+
+// signatureString gives a function signature in Go syntax without the
+// "func".
+func signatureString(sig *DataTypeFunc) string {
+	w := &typeWriter{}
+	w.signature(sig)
+	return w.buf.String()
+}
+
+// typ writes out a type.
+func (w *typeWriter) typ(dt DataType) {
+	switch t := dt.(type) {
+	case nil:
+		w.buf.WriteString("invalid type")
+
+	case *DataTypeNamed:
+		if t.pkg != "" && t.pkg != w.relativeTo {
+			// types declared without any files, eg. in tests, only have
+			// the package's path.
+			if t.pkgName != "" {
+				w.buf.WriteString(t.pkgName)
+			} else {
+				w.buf.WriteString(t.pkg)
+			}
+			w.buf.WriteByte('.')
+		}
+		w.buf.WriteString(t.name)
+		if len(t.typeArgs) > 0 {
+			w.typeList(t.typeArgs)
+		} else if len(t.typeParams) > 0 {
+			// inside its own declaration a generic type is instantiated
+			// with its type parameters.
+			w.typeList(typeParamList(t.typeParams))
+		}
+
+	case *DataTypeTypeParam:
+		w.buf.WriteString(t.name)
+
+	case DataTypeBasic:
+		w.buf.WriteString(basicNames[t.kind])
+
+	case DataTypeSized:
+		switch t.size {
+		case DataSizeDefault:
+			w.buf.WriteString(basicNames[t.kind])
+		case DataSizePointer:
+			w.buf.WriteString("uintptr")
+		default:
+			w.buf.WriteString(basicNames[t.kind])
+			w.buf.WriteString(strconv.Itoa(t.size.Bits(DataSize64)))
+		}
+
+	case *DataTypeUnary:
+		if t.kind == DataTypeKindPointer {
+			w.buf.WriteByte('*')
+		} else {
+			w.buf.WriteString("[]")
+		}
+		w.typ(t.subType)
+
+	case *DataTypeArray:
+		w.buf.WriteByte('[')
+		w.buf.WriteString(strconv.FormatInt(t.length, 10))
+		w.buf.WriteByte(']')
+		w.typ(t.elementType)
+
+	case *DataTypeMap:
+		w.buf.WriteString("map[")
+		w.typ(t.keyType)
+		w.buf.WriteByte(']')
+		w.typ(t.valueType)
+
+	case *DataTypeChan:
+		switch t.dir {
+		case ChanDirectionIn:
+			w.buf.WriteString("chan<- ")
+		case ChanDirectionOut:
+			w.buf.WriteString("<-chan ")
+		default:
+			w.buf.WriteString("chan ")
+		}
+		// "chan <-chan T" would be read as "chan<- chan T".
+		elem, ok := t.elementType.(*DataTypeChan)
+		parens := ok && t.dir == ChanDirectionBi && elem.dir == ChanDirectionOut
+		if parens {
+			w.buf.WriteByte('(')
+		}
+		w.typ(t.elementType)
+		if parens {
+			w.buf.WriteByte(')')
+		}
+
+	case *DataTypeFunc:
+		w.buf.WriteString("func")
+		w.signature(t)
+
+	case *DataTypeStruct:
+		w.buf.WriteString("struct{")
+		for i, field := range t.fields {
+			if i > 0 {
+				w.buf.WriteString("; ")
+			}
+			if !field.embedded {
+				w.buf.WriteString(field.name)
+				w.buf.WriteByte(' ')
+			}
+			w.typ(field.typ)
+			if field.tag != "" {
+				w.buf.WriteByte(' ')
+				w.buf.WriteString(strconv.Quote(field.tag))
+			}
+		}
+		w.buf.WriteByte('}')
+
+	case *DataTypeInterface:
+		if len(t.methods) == 0 && !t.restricted && !t.comparable {
+			w.buf.WriteString("any")
+			break
+		}
+		w.buf.WriteString("interface{")
+		sep := ""
+		if t.comparable {
+			w.buf.WriteString("comparable")
+			sep = "; "
+		}
+		if t.restricted {
+			w.buf.WriteString(sep)
+			for i, term := range t.terms {
+				if i > 0 {
+					w.buf.WriteString(" | ")
+				}
+				if term.tilde {
+					w.buf.WriteByte('~')
+				}
+				w.typ(term.typ)
+			}
+			sep = "; "
+		}
+		for _, method := range t.methods {
+			w.buf.WriteString(sep)
+			w.buf.WriteString(method.name)
+			w.signature(method.sig)
+			sep = "; "
+		}
+		w.buf.WriteByte('}')
+
+	default:
+		w.buf.WriteString("invalid type")
+	}
+}
+
+// the names of the basic kinds of type. sized types have their number of
+// bits added.
+var basicNames = map[DataTypeKind]string{
+	DataTypeKindBool:           "bool",
+	DataTypeKindInt:            "int",
+	DataTypeKindUint:           "uint",
+	DataTypeKindFloat:          "float",
+	DataTypeKindComplex:        "complex",
+	DataTypeKindString:         "string",
+	DataTypeKindUntypedBool:    "untyped bool",
+	DataTypeKindUntypedInt:     "untyped int",
+	DataTypeKindUntypedRune:    "untyped rune",
+	DataTypeKindUntypedFloat:   "untyped float",
+	DataTypeKindUntypedComplex: "untyped complex",
+	DataTypeKindUntypedString:  "untyped string",
+	DataTypeKindUntypedNil:     "untyped nil",
+}
+
+// typeList writes out a list of type arguments in square brackets.
+func (w *typeWriter) typeList(types []DataType) {
+	w.buf.WriteByte('[')
+	for i, dt := range types {
+		if i > 0 {
+			w.buf.WriteString(", ")
+		}
+		w.typ(dt)
+	}
+	w.buf.WriteByte(']')
+}
+
+// signature writes out the parameters and results of a function.
+func (w *typeWriter) signature(sig *DataTypeFunc) {
+	w.buf.WriteByte('(')
+	for i, param := range sig.params {
+		if i > 0 {
+			w.buf.WriteString(", ")
+		}
+		if sig.variadic && i == len(sig.params)-1 {
+			w.buf.WriteString("...")
+			w.typ(param.(*DataTypeUnary).subType)
+		} else {
+			w.typ(param)
+		}
+	}
+	w.buf.WriteByte(')')
+
+	switch len(sig.returns) {
+	case 0:
+	case 1:
+		w.buf.WriteByte(' ')
+		w.typ(sig.returns[0])
+	default:
+		w.buf.WriteString(" (")
+		for i, ret := range sig.returns {
+			if i > 0 {
+				w.buf.WriteString(", ")
+			}
+			w.typ(ret)
+		}
+		w.buf.WriteByte(')')
+	}
+}
+
+func (dtb DataTypeBasic) String() string {
+	return TypeString(dtb, "")
+}
+
+func (dts DataTypeSized) String() string {
+	return TypeString(dts, "")
+}
+
+func (dtu *DataTypeUnary) String() string {
+	return TypeString(dtu, "")
+}
+
+func (dta *DataTypeArray) String() string {
+	return TypeString(dta, "")
+}
+
+func (dtm *DataTypeMap) String() string {
+	return TypeString(dtm, "")
+}
+
+func (dtc *DataTypeChan) String() string {
+	return TypeString(dtc, "")
+}
+
+func (dtf *DataTypeFunc) String() string {
+	return TypeString(dtf, "")
+}
+
+func (dts *DataTypeStruct) String() string {
+	return TypeString(dts, "")
+}
+
+func (dti *DataTypeInterface) String() string {
+	return TypeString(dti, "")
+}
+
+func (dtn *DataTypeNamed) String() string {
+	return TypeString(dtn, "")
+}
+
+func (dtp *DataTypeTypeParam) String() string {
+	return TypeString(dtp, "")
+}
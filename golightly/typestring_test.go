@@ -0,0 +1,45 @@
+package golightly
+
+import (
+	"testing"
+)
+
+func TestTypeString(t *testing.T) {
+	ts := NewDataTypeStore()
+	named := ts.NewNamed("main", "T", "test.go", SrcSpan{})
+	named.SetUnderlying(ts.IntType())
+	str := ts.StringType()
+
+	types := []struct {
+		typ  DataType
+		main string // relative to main
+		str  string // relative to nothing
+	}{
+		{ts.MakeSlice(ts.MakeMap(str, ts.IntType())), "[]map[string]int", "[]map[string]int"},
+		{ts.MakePointer(ts.MakeArray(3, ts.UintptrType())), "*[3]uintptr", "*[3]uintptr"},
+		{ts.MakeMap(named, ts.Complex64Type()), "map[T]complex64", "map[main.T]complex64"},
+		{ts.MakeChan(ChanDirectionBi, ts.MakeChan(ChanDirectionOut, ts.IntType())), "chan (<-chan int)", "chan (<-chan int)"},
+		{ts.MakeChan(ChanDirectionIn, ts.MakeChan(ChanDirectionBi, ts.IntType())), "chan<- chan int", "chan<- chan int"},
+		{ts.MakeFunc([]DataType{ts.IntType(), ts.MakeSlice(str)}, []DataType{ts.BoolType(), ts.ErrorType()}, true), "func(int, ...string) (bool, error)", "func(int, ...string) (bool, error)"},
+		{ts.MakeFunc(nil, []DataType{named}, false), "func() T", "func() main.T"},
+		{ts.MakeStruct([]DataTypeField{{name: "a", pkg: "main", typ: ts.Int8Type()}, {name: "T", typ: named, embedded: true, tag: `json:"t"`}}), "struct{a int8; T \"json:\\\"t\\\"\"}", "struct{a int8; main.T \"json:\\\"t\\\"\"}"},
+		{ts.MakeInterface([]DataTypeMethod{{"M", "", ts.MakeFunc([]DataType{ts.Float32Type()}, nil, false)}}), "interface{M(float32)}", "interface{M(float32)}"},
+		{ts.AnyType(), "any", "any"},
+		{ts.UntypedRuneType(), "untyped rune", "untyped rune"},
+		{nil, "invalid type", "invalid type"},
+	}
+
+	for _, tt := range types {
+		if s := TypeString(tt.typ, "main"); s != tt.main {
+			t.Error("type is written as ", s, " but should be ", tt.main)
+		}
+		if tt.typ != nil && tt.typ.String() != tt.str {
+			t.Error("type is written as ", tt.typ.String(), " but should be ", tt.str)
+		}
+	}
+
+	err := NewErrorf("test.go", SrcSpan{SrcLoc{3, 1}, SrcLoc{3, 2}}, "other", "I can't use %s as %s", ts.MakeSlice(named), str)
+	if err.Error() != "test.go:3: I can't use []main.T as string" {
+		t.Error("error message is wrong: ", err)
+	}
+}
@@ -99,16 +99,46 @@ func (v ValueString) Equals(to Value) bool {
 	return v.val == too.val
 }
 
+// type ValueConstant is for untyped constants, such as numeric literals.
+// The value is exact, however large it is.
+type ValueConstant struct {
+	typ DataType // one of the untyped types
+	val Constant
+}
+
+func (v ValueConstant) isValue() {
+}
+
+func (v ValueConstant) DataType(ts *DataTypeStore) DataType {
+	return v.typ
+}
+
+func (v ValueConstant) Equals(to Value) bool {
+	too := to.(ValueConstant)
+	return v.typ == too.typ && v.val.kind == too.val.kind && constCompare(TokenKindEquals, v.val, too.val)
+}
+
 // NewValueFromToken creates a Value from a lexer Token. It assumes the
 // token is a literal value type.
 func NewValueFromToken(tok Token, ts *DataTypeStore) Value {
 	switch tok.TokenKind() {
-	case TokenKindLiteralInt:
-		return ValueUint{ts.UintType(), tok.(UintToken).uintVal}
-	case TokenKindLiteralFloat:
-		return ValueFloat{ts.FloatType(), tok.(FloatToken).floatVal}
+	case TokenKindLiteralInt, TokenKindLiteralFloat:
+		var text string
+		if ut, ok := tok.(UintToken); ok {
+			text = ut.text
+		} else {
+			text = tok.(FloatToken).text
+		}
+		c, _ := MakeConstantFromLiteral(text, tok.TokenKind())
+		switch c.kind {
+		case ConstantInt:
+			return ValueConstant{ts.UntypedIntType(), c}
+		case ConstantFloat:
+			return ValueConstant{ts.UntypedFloatType(), c}
+		}
+		return ValueConstant{ts.UntypedComplexType(), c}
 	case TokenKindLiteralRune:
-		return ValueRune{rune(tok.(UintToken).uintVal)}
+		return ValueConstant{ts.UntypedRuneType(), MakeUint64Constant(tok.(UintToken).uintVal)}
 	case TokenKindLiteralString:
 		return ValueString{tok.(StringToken).strVal}
 	}
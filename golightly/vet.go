@@ -0,0 +1,1033 @@
+package golightly
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// this file has the vet checks, which look for code which is legal but
+// is very likely to be a mistake - format strings which don't match their
+// arguments, malformed struct tags, code which can't be reached, variables
+// assigned to themselves and locks which are copied.
+
+// knownPrintfFuncs are the printf-like functions of other packages, with
+// the index of their format argument.
+var knownPrintfFuncs = map[string]int{
+	"fmt.Printf":  0,
+	"fmt.Sprintf": 0,
+	"fmt.Errorf":  0,
+	"fmt.Fprintf": 1,
+	"fmt.Appendf": 1,
+	"log.Printf":  0,
+	"log.Fatalf":  0,
+	"log.Panicf":  0,
+}
+
+// syncLockNames are the types in package sync which mustn't be copied.
+var syncLockNames = map[string]bool{
+	"Mutex":     true,
+	"RWMutex":   true,
+	"WaitGroup": true,
+	"Cond":      true,
+	"Once":      true,
+	"Map":       true,
+	"Pool":      true,
+}
+
+// type printfArgKind is a set of the kinds of argument a format verb can
+// print.
+type printfArgKind int
+
+const (
+	printfArgBool printfArgKind = 1 << iota
+	printfArgInt
+	printfArgFloat
+	printfArgComplex
+	printfArgString
+	printfArgPointer
+	printfArgAny printfArgKind = -1
+)
+
+// printfVerbs are the kinds of argument each format verb can print.
+var printfVerbs = map[rune]printfArgKind{
+	'b': printfArgInt | printfArgFloat | printfArgComplex | printfArgPointer,
+	'c': printfArgInt,
+	'd': printfArgInt | printfArgPointer,
+	'e': printfArgFloat | printfArgComplex,
+	'E': printfArgFloat | printfArgComplex,
+	'f': printfArgFloat | printfArgComplex,
+	'F': printfArgFloat | printfArgComplex,
+	'g': printfArgFloat | printfArgComplex,
+	'G': printfArgFloat | printfArgComplex,
+	'o': printfArgInt | printfArgPointer,
+	'O': printfArgInt | printfArgPointer,
+	'p': printfArgPointer,
+	'q': printfArgInt | printfArgString,
+	's': printfArgString,
+	't': printfArgBool,
+	'T': printfArgAny,
+	'U': printfArgInt,
+	'v': printfArgAny,
+	'x': printfArgInt | printfArgFloat | printfArgComplex | printfArgString | printfArgPointer,
+	'X': printfArgInt | printfArgFloat | printfArgComplex | printfArgString | printfArgPointer,
+}
+
+// MarkPrintfLike makes vet check calls to a function as if it was
+// fmt.Printf. The function must take a format string followed by
+// '...interface{}' arguments. It's usually a host function, declared
+// without a body. Functions in other packages are given like "log.Printf"
+// and are assumed to take the format as their first argument.
+func (c *Checker) MarkPrintfLike(name string) {
+	if c.printfLike == nil {
+		c.printfLike = make(map[string]bool)
+	}
+	c.printfLike[name] = true
+}
+
+// type vetter runs the vet checks on a package which has been type
+// checked.
+type vetter struct {
+	c         *Checker
+	printf    map[*Symbol]int // the package's printf-like functions, with the index of their format argument
+	qualified map[string]int  // the printf-like functions of other packages, like "fmt.Printf"
+	errs      []*Error
+}
+
+// Vet looks for mistakes in a package which has been type checked - calls
+// to printf-like functions whose format strings don't match their
+// arguments, malformed struct tags, code after a return or panic which
+// can't be reached, assignments of variables to themselves and copies of
+// values which contain locks. It works on as much as could be checked
+// so it can be used on packages with type errors. Like go vet it looks at
+// every declaration, whether it can be reached or not.
+func (c *Checker) Vet(files []*sourceFile) []*Error {
+	v := &vetter{c: c, printf: make(map[*Symbol]int), qualified: make(map[string]int)}
+	for name, index := range knownPrintfFuncs {
+		v.qualified[name] = index
+	}
+
+	for name := range c.printfLike {
+		if strings.Contains(name, ".") {
+			v.qualified[name] = 0
+			continue
+		}
+		sym := c.pkgScope.LookupLocal(name)
+		if sym == nil || sym.kind != SymbolFunc {
+			continue
+		}
+		index := printfFormatIndex(sym.typ)
+		if index < 0 {
+			c.filename = sym.filename
+			v.errorf(sym.pos, "I can't treat %s as printf-like because it doesn't take a format string followed by '...interface{}' arguments", name)
+			continue
+		}
+		v.printf[sym] = index
+	}
+	v.findWrappers(files)
+
+	for _, sf := range files {
+		c.filename = sf.fileName
+		for _, decl := range sf.ast.(ASTTopLevel).topLevelDecls {
+			switch d := decl.(type) {
+			case ASTDataTypeDecl:
+				v.typeExpr(d.typ)
+			case ASTVarDecl:
+				v.varDecl(d)
+			case ASTFunctionDecl:
+				v.funcDecl(d)
+			}
+		}
+	}
+
+	return v.errs
+}
+
+// errorf reports a problem at a position in the current file.
+func (v *vetter) errorf(pos SrcSpan, format string, args ...interface{}) {
+	v.errs = append(v.errs, NewErrorf(v.c.filename, pos, v.c.packagePath, format, args...))
+}
+
+// typeOf returns the type of an expression in the current file, or nil if
+// it wasn't checked.
+func (v *vetter) typeOf(ast AST) DataType {
+	return v.c.info.types[exprKey{v.c.filename, ast.Pos()}]
+}
+
+// printfFormatIndex returns the index of the format argument of a function
+// which takes a format string followed by '...interface{}' arguments, or
+// -1 if it doesn't.
+func printfFormatIndex(dt DataType) int {
+	sig, ok := dt.(*DataTypeFunc)
+	if !ok || !sig.variadic || len(sig.params) < 2 {
+		return -1
+	}
+
+	n := len(sig.params)
+	args, ok := sig.params[n-1].(*DataTypeUnary)
+	if !isString(sig.params[n-2]) || !ok || args.kind != DataTypeKindSlice {
+		return -1
+	}
+	if iface, ok := Underlying(args.subType).(*DataTypeInterface); !ok || len(iface.methods) > 0 || iface.restricted {
+		return -1
+	}
+
+	return n - 2
+}
+
+// findWrappers finds the functions which pass their format and arguments
+// straight on to a printf-like function, which makes them printf-like too.
+// It repeats until it doesn't find any more since wrappers can be wrapped.
+func (v *vetter) findWrappers(files []*sourceFile) {
+	for {
+		found := false
+		for _, sf := range files {
+			v.c.filename = sf.fileName
+			for _, decl := range sf.ast.(ASTTopLevel).topLevelDecls {
+				d, ok := decl.(ASTFunctionDecl)
+				if !ok || d.body == nil || d.receiver != nil {
+					continue
+				}
+				sym := v.c.info.defs[exprKey{sf.fileName, d.pos}]
+				if sym == nil {
+					continue
+				}
+				if _, ok := v.printf[sym]; ok {
+					continue
+				}
+				index := printfFormatIndex(sym.typ)
+				if index < 0 {
+					continue
+				}
+
+				params := parameterList(d.params)
+				format, args := params[index].name, params[index+1].name
+				if format == nil || args == nil {
+					continue
+				}
+				formatSym := v.c.info.defs[exprKey{sf.fileName, format.Pos()}]
+				argsSym := v.c.info.defs[exprKey{sf.fileName, args.Pos()}]
+				if v.forwards(d.body, formatSym, argsSym) {
+					v.printf[sym] = index
+					found = true
+				}
+			}
+		}
+		if !found {
+			return
+		}
+	}
+}
+
+// forwards returns true if a statement calls a printf-like function with
+// the given format and 'args...'. Function literals aren't looked in
+// since they might not be called.
+func (v *vetter) forwards(ast AST, format *Symbol, args *Symbol) bool {
+	switch s := ast.(type) {
+	case ASTBlock:
+		for _, stmt := range s.statements {
+			if v.forwards(stmt, format, args) {
+				return true
+			}
+		}
+	case ASTLabeled:
+		return v.forwards(s.stmt, format, args)
+	case ASTIf:
+		return v.forwards(s.then, format, args) || s.els != nil && v.forwards(s.els, format, args)
+	case ASTFor:
+		return v.forwards(s.body, format, args)
+	case ASTRange:
+		return v.forwards(s.body, format, args)
+	case ASTSwitch:
+		for _, clause := range s.cases {
+			if v.forwards(ASTBlock{statements: clause.(ASTCaseClause).body}, format, args) {
+				return true
+			}
+		}
+	case ASTReturn:
+		for _, r := range s.results {
+			if v.forwards(r, format, args) {
+				return true
+			}
+		}
+	case ASTAssign:
+		for _, r := range s.right {
+			if v.forwards(r, format, args) {
+				return true
+			}
+		}
+	case ASTGo:
+		return v.forwards(s.call, format, args)
+	case ASTDefer:
+		return v.forwards(s.call, format, args)
+	case ASTCall:
+		name, index := v.printfFunc(s)
+		if name == "" || !s.ellipsis || len(s.args) != index+2 {
+			return false
+		}
+		return v.isVar(s.args[index], format) && v.isVar(s.args[index+1], args)
+	}
+
+	return false
+}
+
+// isVar returns true if an expression is an identifier which refers to a
+// variable.
+func (v *vetter) isVar(ast AST, sym *Symbol) bool {
+	ident, ok := ast.(ASTIdentifier)
+	return ok && sym != nil && v.c.info.uses[exprKey{v.c.filename, ident.pos}] == sym
+}
+
+// printfFunc returns the name of the printf-like function a call is to and
+// the index of its format argument. The name is empty if it's not a call
+// to a printf-like function.
+func (v *vetter) printfFunc(call ASTCall) (string, int) {
+	ident, ok := call.function.(ASTIdentifier)
+	if !ok {
+		return "", 0
+	}
+
+	if ident.packageName != "" {
+		pkg := v.c.fileScopes[v.c.filename].LookupLocal(ident.packageName)
+		if pkg == nil || pkg.kind != SymbolPackage {
+			return "", 0
+		}
+		name := pkg.path + "." + ident.name
+		if index, ok := v.qualified[name]; ok {
+			return ident.packageName + "." + ident.name, index
+		}
+		return "", 0
+	}
+
+	sym := v.c.info.uses[exprKey{v.c.filename, ident.pos}]
+	if index, ok := v.printf[sym]; ok && sym != nil {
+		return ident.name, index
+	}
+
+	return "", 0
+}
+
+// funcDecl vets a function or method declaration.
+func (v *vetter) funcDecl(d ASTFunctionDecl) {
+	for _, param := range d.params {
+		v.typeExpr(param.(ASTParameterDecl).typ)
+	}
+	for _, ret := range d.returns {
+		v.typeExpr(ret.(ASTParameterDecl).typ)
+	}
+
+	name := d.name
+	if d.receiver != nil {
+		recv := d.receiver.(ASTReceiver)
+		name = recv.typeName + "." + d.name
+		if !recv.pointer {
+			recvType := v.c.ts.LookupName(qualifiedName(v.c.packagePath, recv.typeName))
+			if path := v.lockPath(recvType, nil); path != "" {
+				v.errorf(d.pos, "%s passes a lock by value in its receiver: %s", name, path)
+			}
+		}
+	}
+
+	if sym := v.c.info.defs[exprKey{v.c.filename, d.pos}]; sym != nil {
+		if sig, ok := sym.typ.(*DataTypeFunc); ok {
+			v.params(d.pos, name, sig)
+		}
+	}
+
+	if d.body != nil {
+		v.stmt(d.body)
+	}
+}
+
+// params reports the parameters of a function which pass locks by value.
+func (v *vetter) params(pos SrcSpan, name string, sig *DataTypeFunc) {
+	for i, param := range sig.params {
+		if sig.variadic && i == len(sig.params)-1 {
+			break
+		}
+		if path := v.lockPath(param, nil); path != "" {
+			v.errorf(pos, "%s passes a lock by value: %s", name, path)
+			return
+		}
+	}
+}
+
+// varDecl vets a variable declaration.
+func (v *vetter) varDecl(d ASTVarDecl) {
+	v.typeExpr(d.typ)
+	if d.value == nil {
+		return
+	}
+
+	if path := v.copiesLock(d.value); path != "" {
+		v.errorf(d.value.Pos(), "the declaration of '%s' copies a lock value: %s", d.ident.(ASTIdentifier).name, path)
+	}
+	v.expr(d.value)
+}
+
+// stmtList vets a list of statements. The first statement in the list
+// which can't be reached is reported.
+func (v *vetter) stmtList(stmts []AST) {
+	dead := false
+	reported := false
+	for _, stmt := range stmts {
+		if stmt == nil {
+			continue
+		}
+
+		if _, ok := stmt.(ASTLabeled); ok {
+			// it could be the target of a goto.
+			dead = false
+		} else if dead && !reported {
+			v.errorf(stmt.Pos(), "this code can't be reached")
+			reported = true
+		}
+
+		v.stmt(stmt)
+		if branch, ok := stmt.(ASTBranch); ok && (branch.tok == TokenKindBreak || branch.tok == TokenKindContinue) || v.c.isTerminating(stmt, "") {
+			dead = true
+		}
+	}
+}
+
+// stmt vets a statement.
+func (v *vetter) stmt(ast AST) {
+	switch s := ast.(type) {
+	case nil, ASTConstDecl, ASTBranch:
+
+	case ASTDataTypeDecl:
+		v.typeExpr(s.typ)
+
+	case ASTBlock:
+		v.stmtList(s.statements)
+
+	case ASTLabeled:
+		v.stmt(s.stmt)
+
+	case ASTVarDecl:
+		v.varDecl(s)
+
+	case ASTAssign:
+		v.assign(s)
+
+	case ASTIncDec:
+		v.expr(s.expr)
+
+	case ASTSend:
+		v.expr(s.channel)
+		v.expr(s.value)
+
+	case ASTReturn:
+		for _, r := range s.results {
+			if path := v.copiesLock(r); path != "" {
+				v.errorf(r.Pos(), "this return copies a lock value: %s", path)
+			}
+			v.expr(r)
+		}
+
+	case ASTGo:
+		v.expr(s.call)
+
+	case ASTDefer:
+		v.expr(s.call)
+
+	case ASTIf:
+		v.stmt(s.init)
+		v.expr(s.cond)
+		v.stmt(s.then)
+		v.stmt(s.els)
+
+	case ASTFor:
+		v.stmt(s.init)
+		if s.cond != nil {
+			v.expr(s.cond)
+		}
+		v.stmt(s.post)
+		v.stmt(s.body)
+
+	case ASTRange:
+		v.rangeStmt(s)
+
+	case ASTSwitch:
+		v.stmt(s.init)
+		if tag, ok := s.tag.(ASTAssign); ok {
+			v.stmt(tag)
+		} else if s.tag != nil {
+			v.expr(s.tag)
+		}
+		for _, clause := range s.cases {
+			cc := clause.(ASTCaseClause)
+			for _, e := range cc.exprs {
+				v.expr(e)
+			}
+			v.stmtList(cc.body)
+		}
+
+	case ASTSelect:
+		for _, clause := range s.cases {
+			cc := clause.(ASTCommClause)
+			v.stmt(cc.comm)
+			v.stmtList(cc.body)
+		}
+
+	default:
+		v.expr(ast)
+	}
+}
+
+// assign vets an assignment or short variable declaration.
+func (v *vetter) assign(s ASTAssign) {
+	if (s.op == TokenKindAssign || s.op == TokenKindDeclareAssign) && len(s.left) == len(s.right) {
+		for i, r := range s.right {
+			if s.op == TokenKindAssign && v.sameExpr(s.left[i], r) {
+				v.errorf(s.left[i].Pos(), "this assigns %s to itself", exprString(r))
+			}
+			if path := v.copiesLock(r); path != "" {
+				v.errorf(r.Pos(), "this assignment copies a lock value: %s", path)
+			}
+		}
+	}
+
+	for _, l := range s.left {
+		v.expr(l)
+	}
+	for _, r := range s.right {
+		v.expr(r)
+	}
+}
+
+// rangeStmt vets a range statement. The value variable gets a copy of
+// each element.
+func (v *vetter) rangeStmt(s ASTRange) {
+	v.expr(s.expr)
+	if s.value != nil && !isBlank(s.value) {
+		var elem DataType
+		switch t := coreType(v.typeOf(s.expr)).(type) {
+		case *DataTypeArray:
+			elem = t.elementType
+		case *DataTypeMap:
+			elem = t.valueType
+		case *DataTypeUnary:
+			if t.kind == DataTypeKindSlice {
+				elem = t.subType
+			} else if array, ok := Underlying(t.subType).(*DataTypeArray); ok && t.kind == DataTypeKindPointer {
+				elem = array.elementType
+			}
+		}
+		if path := v.lockPath(elem, nil); path != "" {
+			v.errorf(s.value.Pos(), "the range variable %s copies a lock value: %s", exprString(s.value), path)
+		}
+	}
+	if !s.define {
+		v.expr(s.key)
+		v.expr(s.value)
+	}
+
+	v.stmt(s.body)
+}
+
+// isBlank returns true for the blank identifier.
+func isBlank(ast AST) bool {
+	ident, ok := ast.(ASTIdentifier)
+	return ok && ident.packageName == "" && ident.name == "_"
+}
+
+// expr vets an expression.
+func (v *vetter) expr(ast AST) {
+	switch e := ast.(type) {
+	case ASTUnaryExpr:
+		v.expr(e.param)
+
+	case ASTBinaryExpr:
+		v.expr(e.left)
+		v.expr(e.right)
+
+	case ASTCall:
+		v.call(e)
+
+	case ASTSelector:
+		v.expr(e.expr)
+
+	case ASTIndex:
+		v.expr(e.expr)
+		v.expr(e.index)
+
+	case ASTSliceExpr:
+		v.expr(e.expr)
+		v.expr(e.low)
+		v.expr(e.high)
+		v.expr(e.max)
+
+	case ASTTypeAssertion:
+		v.expr(e.expr)
+
+	case ASTCompositeLit:
+		v.typeExpr(e.typ)
+		for _, elem := range e.elements {
+			if kv, ok := elem.(ASTKeyedElement); ok {
+				v.expr(kv.key)
+				elem = kv.value
+			}
+			if path := v.copiesLock(elem); path != "" {
+				v.errorf(elem.Pos(), "this literal copies a lock value: %s", path)
+			}
+			v.expr(elem)
+		}
+
+	case ASTFunctionLit:
+		v.typeExpr(e.typ)
+		if sig, ok := v.typeOf(e).(*DataTypeFunc); ok {
+			v.params(e.pos, "this func literal", sig)
+		}
+		v.stmt(e.body)
+	}
+}
+
+// call vets a call.
+func (v *vetter) call(e ASTCall) {
+	v.expr(e.function)
+	for _, arg := range e.args {
+		v.expr(arg)
+	}
+
+	if name, index := v.printfFunc(e); name != "" {
+		v.printfCall(e, name, index)
+	}
+
+	// builtins and conversions don't pass their arguments to anything.
+	if ident, ok := e.function.(ASTIdentifier); ok {
+		if sym := v.c.info.uses[exprKey{v.c.filename, ident.pos}]; sym != nil && (sym.kind == SymbolBuiltin || sym.kind == SymbolType) {
+			return
+		}
+	}
+	if _, ok := v.typeOf(e.function).(*DataTypeFunc); !ok {
+		return
+	}
+	for _, arg := range e.args {
+		if path := v.copiesLock(arg); path != "" {
+			v.errorf(arg.Pos(), "this call passes a lock by value: %s", path)
+		}
+	}
+}
+
+// printfCall checks that the arguments of a call to a printf-like function
+// match its format string. Calls which pass their arguments with '...' or
+// whose format isn't constant can't be checked.
+func (v *vetter) printfCall(e ASTCall, name string, index int) {
+	if e.ellipsis || len(e.args) <= index {
+		return
+	}
+
+	var format string
+	if val, ok := v.c.info.values[exprKey{v.c.filename, e.args[index].Pos()}]; ok && val.Kind() == ConstantString {
+		format = val.StringVal()
+	} else if lit, ok := e.args[index].(ASTValue); ok {
+		str, ok := lit.val.(ValueString)
+		if !ok {
+			return
+		}
+		format = str.val
+	} else {
+		return
+	}
+
+	args := e.args[index+1:]
+	used := 0
+	nextArg := func(verb string) AST {
+		if used >= len(args) {
+			v.errorf(e.pos, "the %s in this call to %s needs argument %d but there are only %d", verb, name, used+1, len(args))
+			return nil
+		}
+		used++
+		return args[used-1]
+	}
+
+	// star reads a '*' width or precision, which takes an int argument.
+	star := func(i int, what string) (int, bool) {
+		if i >= len(format) || format[i] != '*' {
+			for i < len(format) && format[i] >= '0' && format[i] <= '9' {
+				i++
+			}
+			return i, true
+		}
+		arg := nextArg("* " + what)
+		if arg == nil {
+			return i, false
+		}
+		if typ := v.typeOf(arg); typ != nil && !isInteger(typ) && !isInterface(typ) {
+			v.errorf(arg.Pos(), "the * %s in this call to %s needs an integer but argument %d has type %s", what, name, used, typ)
+		}
+		return i + 1, true
+	}
+
+	for i := 0; i < len(format); {
+		if format[i] != '%' {
+			i++
+			continue
+		}
+		i++
+
+		for i < len(format) && strings.IndexByte("#0+- ", format[i]) >= 0 {
+			i++
+		}
+		var ok bool
+		if i < len(format) && format[i] == '[' {
+			// explicit argument indexes are too tricky to follow.
+			return
+		}
+		if i, ok = star(i, "width"); !ok {
+			return
+		}
+		if i < len(format) && format[i] == '.' {
+			if i, ok = star(i+1, "precision"); !ok {
+				return
+			}
+		}
+		if i < len(format) && format[i] == '[' {
+			return
+		}
+		if i >= len(format) {
+			v.errorf(e.pos, "the format string of this call to %s ends with an incomplete verb", name)
+			return
+		}
+
+		verb, size := utf8.DecodeRuneInString(format[i:])
+		i += size
+		if verb == '%' {
+			continue
+		}
+		kinds, known := printfVerbs[verb]
+		if !known {
+			v.errorf(e.pos, "the format string of this call to %s has %%%c, which isn't a verb I know", name, verb)
+			return
+		}
+
+		arg := nextArg("%" + string(verb))
+		if arg == nil {
+			return
+		}
+		if typ := v.typeOf(arg); typ != nil && !v.printfArgMatches(kinds, typ, true, make(map[DataType]bool)) {
+			v.errorf(arg.Pos(), "the %%%c in this call to %s can't print argument %d, which has type %s", verb, name, used, typ)
+		}
+	}
+
+	if used < len(args) {
+		v.errorf(e.pos, "this call to %s has %d arguments but its format string only uses %d", name, len(args), used)
+	}
+}
+
+// printfArgMatches returns true if a value of a type can be printed by a
+// verb which prints the given kinds of argument. The elements of arrays,
+// slices, maps and structs are printed one by one, as are the things
+// pointers point to when they're the argument.
+func (v *vetter) printfArgMatches(kinds printfArgKind, typ DataType, top bool, seen map[DataType]bool) bool {
+	if kinds == printfArgAny || isInterface(typ) || seen[typ] {
+		return true
+	}
+	if _, ok := typ.(*DataTypeTypeParam); ok {
+		return true
+	}
+	if kinds&printfArgString != 0 && (hasValueMethod(typ, "String") || hasValueMethod(typ, "Error")) {
+		return true
+	}
+	seen[typ] = true
+
+	switch u := Underlying(typ).(type) {
+	case *DataTypeUnary:
+		if kinds&printfArgPointer != 0 {
+			return true
+		}
+		if u.kind == DataTypeKindSlice {
+			if kinds&printfArgString != 0 && Underlying(u.subType) == v.c.ts.Uint8Type() {
+				return true
+			}
+			return v.printfArgMatches(kinds, u.subType, false, seen)
+		}
+		if u.kind == DataTypeKindPointer && top {
+			switch Underlying(u.subType).(type) {
+			case *DataTypeStruct, *DataTypeArray, *DataTypeMap:
+				return v.printfArgMatches(kinds, u.subType, false, seen)
+			}
+			if s, ok := Underlying(u.subType).(*DataTypeUnary); ok && s.kind == DataTypeKindSlice {
+				return v.printfArgMatches(kinds, u.subType, false, seen)
+			}
+		}
+		return false
+
+	case *DataTypeArray:
+		if kinds&printfArgString != 0 && Underlying(u.elementType) == v.c.ts.Uint8Type() {
+			return true
+		}
+		return v.printfArgMatches(kinds, u.elementType, false, seen)
+
+	case *DataTypeMap:
+		return kinds&printfArgPointer != 0 || v.printfArgMatches(kinds, u.keyType, false, seen) && v.printfArgMatches(kinds, u.valueType, false, seen)
+
+	case *DataTypeStruct:
+		for _, field := range u.fields {
+			if !v.printfArgMatches(kinds, field.typ, false, seen) {
+				return false
+			}
+		}
+		return true
+
+	case *DataTypeChan, *DataTypeFunc:
+		return kinds&printfArgPointer != 0
+	}
+
+	switch {
+	case isBoolean(typ):
+		return kinds&printfArgBool != 0
+	case isInteger(typ):
+		return kinds&printfArgInt != 0
+	case isFloat(typ):
+		return kinds&printfArgFloat != 0
+	case isComplex(typ):
+		return kinds&printfArgComplex != 0
+	case isString(typ):
+		return kinds&printfArgString != 0
+	}
+
+	// nil and unsafe.Pointer.
+	return kinds&printfArgPointer != 0
+}
+
+// hasValueMethod returns true if a type's values have a method, which
+// fmt would call to format them.
+func hasValueMethod(typ DataType, name string) bool {
+	_, isMethod, _, ptrRecv, found, _ := lookupFieldOrMethod(typ, name)
+	return found && isMethod && !ptrRecv
+}
+
+// copiesLock returns a description of the lock in a value if using the
+// value copies it, or "" if it doesn't. New values, like those made by
+// composite literals and calls, don't copy anything.
+func (v *vetter) copiesLock(ast AST) string {
+	switch e := ast.(type) {
+	case ASTIdentifier, ASTSelector, ASTIndex:
+	case ASTUnaryExpr:
+		if _, ok := e.param.(ASTCall); ok || e.op != TokenKindAsterisk {
+			return ""
+		}
+	default:
+		return ""
+	}
+
+	return v.lockPath(v.typeOf(ast), nil)
+}
+
+// lockPath returns a description of where a lock is in a type, like "T
+// contains sync.Mutex", or "" if it doesn't contain one. A type is a lock
+// if it's one of sync's, or if a pointer to it has Lock and Unlock methods
+// but a value of it doesn't. outer has the types it's already inside.
+func (v *vetter) lockPath(typ DataType, outer []DataType) string {
+	if typ == nil {
+		return ""
+	}
+	for _, dt := range outer {
+		if dt == typ {
+			return ""
+		}
+	}
+
+	if named, ok := typ.(*DataTypeNamed); ok {
+		if named.pkg == "sync" && syncLockNames[named.name] || isLocker(named) {
+			return v.c.typeString(named)
+		}
+	}
+
+	var inner []DataType
+	switch u := Underlying(typ).(type) {
+	case *DataTypeArray:
+		inner = []DataType{u.elementType}
+	case *DataTypeStruct:
+		for _, field := range u.fields {
+			inner = append(inner, field.typ)
+		}
+	}
+
+	for _, dt := range inner {
+		if path := v.lockPath(dt, append(outer, typ)); path != "" {
+			return v.c.typeString(typ) + " contains " + path
+		}
+	}
+
+	return ""
+}
+
+// isLocker returns true if a pointer to a type has Lock and Unlock methods
+// but a value of it doesn't, so copying a value copies the lock's state.
+func isLocker(named *DataTypeNamed) bool {
+	for _, name := range []string{"Lock", "Unlock"} {
+		_, isMethod, _, ptrRecv, found, _ := lookupFieldOrMethod(named, name)
+		if !found || !isMethod || !ptrRecv {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sameExpr returns true if two expressions always refer to the same
+// variable, so assigning one to the other does nothing.
+func (v *vetter) sameExpr(a AST, b AST) bool {
+	switch x := a.(type) {
+	case ASTIdentifier:
+		y, ok := b.(ASTIdentifier)
+		if !ok || x.name != y.name || x.packageName != y.packageName || x.name == "_" {
+			return false
+		}
+		if x.packageName != "" {
+			return true
+		}
+		sym := v.c.info.uses[exprKey{v.c.filename, x.pos}]
+		return sym != nil && sym.kind == SymbolVar && sym == v.c.info.uses[exprKey{v.c.filename, y.pos}]
+
+	case ASTSelector:
+		y, ok := b.(ASTSelector)
+		return ok && x.name == y.name && v.sameExpr(x.expr, y.expr)
+
+	case ASTIndex:
+		y, ok := b.(ASTIndex)
+		if !ok || !v.sameExpr(x.expr, y.expr) {
+			return false
+		}
+		xv, xConst := v.c.info.values[exprKey{v.c.filename, x.index.Pos()}]
+		yv, yConst := v.c.info.values[exprKey{v.c.filename, y.index.Pos()}]
+		if xConst && yConst {
+			return xv.Kind() == yv.Kind() && constCompare(TokenKindEquals, xv, yv)
+		}
+		return v.sameExpr(x.index, y.index)
+
+	case ASTUnaryExpr:
+		y, ok := b.(ASTUnaryExpr)
+		return ok && x.op == TokenKindAsterisk && y.op == TokenKindAsterisk && v.sameExpr(x.param, y.param)
+	}
+
+	return false
+}
+
+// exprString writes a simple expression in Go syntax for messages. More
+// complicated expressions are just called "this".
+func exprString(ast AST) string {
+	switch e := ast.(type) {
+	case ASTIdentifier:
+		return qualifiedName(e.packageName, e.name)
+	case ASTSelector:
+		return exprString(e.expr) + "." + e.name
+	case ASTIndex:
+		return exprString(e.expr) + "[" + exprString(e.index) + "]"
+	case ASTUnaryExpr:
+		if e.op == TokenKindAsterisk {
+			return "*" + exprString(e.param)
+		}
+	case ASTValue:
+		switch val := e.val.(type) {
+		case ValueInt:
+			return strconv.FormatInt(val.val, 10)
+		case ValueUint:
+			return strconv.FormatUint(val.val, 10)
+		case ValueString:
+			return strconv.Quote(val.val)
+		}
+	}
+
+	return "this"
+}
+
+// typeExpr checks the tags of the fields of any struct types in a type.
+func (v *vetter) typeExpr(ast AST) {
+	switch t := ast.(type) {
+	case ASTDataTypeStruct:
+		for _, f := range t.fields {
+			field := f.(ASTDataTypeField)
+			if field.tag != "" {
+				if problem := structTagProblem(field.tag); problem != "" {
+					name := "the embedded field"
+					pos := field.typ.Pos()
+					if ident, ok := field.identifier.(ASTIdentifier); ok {
+						name, pos = "field '"+ident.name+"'", ident.pos
+					}
+					v.errorf(pos, "the tag of %s is malformed: %s", name, problem)
+				}
+			}
+			v.typeExpr(field.typ)
+		}
+
+	case ASTDataTypePointer:
+		v.typeExpr(t.elementType)
+
+	case ASTDataTypeSlice:
+		v.typeExpr(t.elementType)
+
+	case ASTDataTypeArray:
+		v.typeExpr(t.elementType)
+
+	case ASTDataTypeMap:
+		v.typeExpr(t.keyType)
+		v.typeExpr(t.valueType)
+
+	case ASTDataTypeChan:
+		v.typeExpr(t.elementType)
+
+	case ASTDataTypeFunc:
+		for _, param := range t.params {
+			v.typeExpr(param.(ASTParameterDecl).typ)
+		}
+		for _, ret := range t.returns {
+			v.typeExpr(ret.(ASTParameterDecl).typ)
+		}
+	}
+}
+
+// structTagProblem returns what's wrong with a struct tag which isn't in
+// the conventional form of space separated key:"value" pairs, or "" if
+// there's nothing wrong with it. It's how reflect.StructTag reads them.
+func structTagProblem(tag string) string {
+	for n := 0; tag != ""; n++ {
+		if n > 0 && tag[0] != ' ' {
+			return "the key:\"value\" pairs aren't separated by spaces"
+		}
+		i := 0
+		for i < len(tag) && tag[i] == ' ' {
+			i++
+		}
+		tag = tag[i:]
+		if tag == "" {
+			break
+		}
+
+		i = 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 {
+			return "a key is missing"
+		}
+		if i+1 >= len(tag) || tag[i] != ':' {
+			return "'" + tag[:i] + "' isn't followed by a colon and a value"
+		}
+		if tag[i+1] != '"' {
+			return "the value of '" + tag[:i] + "' isn't quoted"
+		}
+		key := tag[:i]
+		tag = tag[i+1:]
+
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			return "the value of '" + key + "' isn't terminated"
+		}
+		if _, err := strconv.Unquote(tag[:i+1]); err != nil {
+			return "the value of '" + key + "' isn't a valid string"
+		}
+		tag = tag[i+1:]
+	}
+
+	return ""
+}
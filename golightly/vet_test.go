@@ -0,0 +1,192 @@
+package golightly
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVet(t *testing.T) {
+	ta := &testAST{}
+	fn := func(name string, receiver AST, params []AST, returns []AST, body ...AST) AST {
+		return ASTFunctionDecl{ta.pos(), name, nil, receiver, params, returns, ASTBlock{ta.pos(), body}}
+	}
+	printfParams := func() []AST {
+		any := ASTDataTypeInterface{ta.pos(), nil}
+		return []AST{ta.param("format", ta.ident("string")), ta.param("args", any), ASTParameterDecl{ASTEllipsis{ta.pos()}, any}}
+	}
+	assign := func(op TokenKind, left AST, right AST) AST {
+		return ASTAssign{ta.pos(), op, []AST{left}, []AST{right}}
+	}
+
+	// func logf(format string, args ...interface{})
+	logf := ASTFunctionDecl{ta.pos(), "logf", nil, nil, printfParams(), nil, nil}
+
+	// func wrap(format string, args ...interface{}) { logf(format, args...) }
+	wrap := fn("wrap", nil, printfParams(), nil,
+		ASTCall{ta.pos(), ta.ident("logf"), []AST{ta.ident("format"), ta.ident("args")}, true})
+
+	// type Mutex struct { state int }
+	// func (m *Mutex) Lock() {}
+	// func (m *Mutex) Unlock() {}
+	// type Guarded struct {
+	//	mu   Mutex
+	//	n    int `json:"n"`
+	//	name string `json:name`
+	// }
+	mutex := ASTDataTypeDecl{ta.ident("Mutex"), nil, ASTDataTypeStruct{ta.pos(), []AST{ASTDataTypeField{ta.ident("state"), ta.ident("int"), ""}}}, false}
+	lock := fn("Lock", ASTReceiver{ta.pos(), "m", true, "Mutex", nil}, nil, nil)
+	unlock := fn("Unlock", ASTReceiver{ta.pos(), "m", true, "Mutex", nil}, nil, nil)
+	guarded := ASTDataTypeDecl{ta.ident("Guarded"), nil, ASTDataTypeStruct{ta.pos(), []AST{
+		ASTDataTypeField{ta.ident("mu"), ta.ident("Mutex"), ""},
+		ASTDataTypeField{ta.ident("n"), ta.ident("int"), `json:"n"`},
+		ASTDataTypeField{ta.ident("name"), ta.ident("string"), `json:name`},
+	}}, false}
+
+	// func f(g *Guarded, x int, s string) int {
+	//	logf("%d %s", x, s)
+	//	logf("%d", s)
+	//	logf("%d %d", x)
+	//	logf("%d", x, x)
+	//	logf("%z", x)
+	//	wrap("%s", x)
+	//	logf("%*d%%", x, x)
+	//	x = x
+	//	c := *g
+	//	_ = c.n
+	//	return x
+	//	x = 1
+	//	return x
+	// }
+	f := fn("f", nil,
+		[]AST{ta.param("g", ASTDataTypePointer{ta.pos(), ta.ident("Guarded")}), ta.param("x", ta.ident("int")), ta.param("s", ta.ident("string"))},
+		[]AST{ta.param("", ta.ident("int"))},
+		ta.call(ta.ident("logf"), ta.str("%d %s"), ta.ident("x"), ta.ident("s")),
+		ta.call(ta.ident("logf"), ta.str("%d"), ta.ident("s")),
+		ta.call(ta.ident("logf"), ta.str("%d %d"), ta.ident("x")),
+		ta.call(ta.ident("logf"), ta.str("%d"), ta.ident("x"), ta.ident("x")),
+		ta.call(ta.ident("logf"), ta.str("%z"), ta.ident("x")),
+		ta.call(ta.ident("wrap"), ta.str("%s"), ta.ident("x")),
+		ta.call(ta.ident("logf"), ta.str("%*d%%"), ta.ident("x"), ta.ident("x")),
+		assign(TokenKindAssign, ta.ident("x"), ta.ident("x")),
+		assign(TokenKindDeclareAssign, ta.ident("c"), ta.unary(TokenKindAsterisk, ta.ident("g"))),
+		assign(TokenKindAssign, ta.ident("_"), ASTSelector{ta.pos(), ta.ident("c"), "n"}),
+		ASTReturn{ta.pos(), []AST{ta.ident("x")}},
+		assign(TokenKindAssign, ta.ident("x"), ta.int(1)),
+		ASTReturn{ta.pos(), []AST{ta.ident("x")}})
+
+	// func byValue(m Mutex) {}
+	byValue := fn("byValue", nil, []AST{ta.param("m", ta.ident("Mutex"))}, nil)
+
+	c, errs := checkTestFile(NewDataTypeStore(), []AST{logf, wrap, mutex, lock, unlock, guarded, f, byValue})
+	if len(errs) != 0 {
+		t.Fatal("unexpected errors: ", errs)
+	}
+	c.MarkPrintfLike("logf")
+	errs = c.Vet([]*sourceFile{{packageName: "main", fileName: "test.go", ast: ASTTopLevel{topLevelDecls: []AST{logf, wrap, mutex, lock, unlock, guarded, f, byValue}}}})
+
+	expected := []string{
+		"the tag of field 'name' is malformed: the value of 'json' isn't quoted",
+		"the %d in this call to logf can't print argument 1, which has type string",
+		"the %d in this call to logf needs argument 2 but there are only 1",
+		"this call to logf has 2 arguments but its format string only uses 1",
+		"the format string of this call to logf has %z, which isn't a verb I know",
+		"the %s in this call to wrap can't print argument 1, which has type int",
+		"this assigns x to itself",
+		"this assignment copies a lock value: Guarded contains Mutex",
+		"this code can't be reached",
+		"byValue passes a lock by value: Mutex",
+	}
+	if len(errs) != len(expected) {
+		t.Error("expected ", len(expected), " problems but got ", len(errs), ": ", errs)
+	}
+	for _, msg := range expected {
+		found := false
+		for _, err := range errs {
+			if strings.HasSuffix(err.Error(), ": "+msg) {
+				found = true
+			}
+		}
+		if !found {
+			t.Error("expected the problem \"", msg, "\" but got: ", errs)
+		}
+	}
+}
+
+func TestStructTagProblem(t *testing.T) {
+	cases := []struct {
+		tag     string
+		problem string
+	}{
+		{`json:"name"`, ""},
+		{`json:"name,omitempty" xml:"name"`, ""},
+		{`json:"name"xml:"name"`, "the key:\"value\" pairs aren't separated by spaces"},
+		{`:"name"`, "a key is missing"},
+		{`json`, "'json' isn't followed by a colon and a value"},
+		{`json:name`, "the value of 'json' isn't quoted"},
+		{`json:"name`, "the value of 'json' isn't terminated"},
+		{`json:"\q"`, "the value of 'json' isn't a valid string"},
+	}
+
+	for _, tc := range cases {
+		if problem := structTagProblem(tc.tag); problem != tc.problem {
+			t.Error("expected the tag ", tc.tag, " to have the problem \"", tc.problem, "\" but got \"", problem, "\"")
+		}
+	}
+}
+
+func TestVetImported(t *testing.T) {
+	ta := &testAST{}
+	any := func() AST { return ASTDataTypeInterface{ta.pos(), nil} }
+	importDecl := func(path string) AST {
+		return ASTImport{ta.pos(), nil, ASTValue{ta.pos(), ValueString{path}}}
+	}
+
+	// package fmt; func Printf(format string, args ...interface{})
+	// package sync; type Mutex struct { state int }
+	ts := NewDataTypeStore()
+	stubs := map[string][]AST{
+		"fmt": {ASTFunctionDecl{ta.pos(), "Printf", nil, nil,
+			[]AST{ta.param("format", ta.ident("string")), ta.param("args", any()), ASTParameterDecl{ASTEllipsis{ta.pos()}, any()}}, nil, nil}},
+		"sync": {ASTDataTypeDecl{ta.ident("Mutex"), nil, ASTDataTypeStruct{ta.pos(), []AST{ASTDataTypeField{ta.ident("state"), ta.ident("int"), ""}}}, false}},
+	}
+	c := NewChecker(ts, "main")
+	for path, decls := range stubs {
+		stub := NewChecker(ts, path)
+		if errs := stub.CheckFiles([]*sourceFile{{packageName: path, fileName: path + ".go", ast: ASTTopLevel{topLevelDecls: decls}}}); len(errs) != 0 {
+			t.Fatal("unexpected errors in ", path, ": ", errs)
+		}
+		c.AddImport(path, stub.Scope())
+	}
+
+	// import "fmt"; import "sync"
+	// var mu sync.Mutex
+	// var copied = mu
+	// func main() { fmt.Printf("%d", "s") }
+	sf := &sourceFile{packageName: "main", fileName: "test.go", ast: ASTTopLevel{
+		imports: []AST{importDecl("fmt"), importDecl("sync")},
+		topLevelDecls: []AST{
+			ta.varDecl("mu", ASTIdentifier{ta.pos(), "sync", "Mutex"}, nil),
+			ta.varDecl("copied", nil, ta.ident("mu")),
+			ASTFunctionDecl{ta.pos(), "main", nil, nil, nil, nil, ASTBlock{ta.pos(), []AST{
+				ta.call(ASTIdentifier{ta.pos(), "fmt", "Printf"}, ta.str("%d"), ta.str("s")),
+			}}},
+		},
+	}}
+	if errs := c.CheckFiles([]*sourceFile{sf}); len(errs) != 0 {
+		t.Fatal("unexpected errors: ", errs)
+	}
+
+	errs := c.Vet([]*sourceFile{sf})
+	expected := []string{
+		"the declaration of 'copied' copies a lock value: sync.Mutex",
+		"the %d in this call to fmt.Printf can't print argument 1, which has type string",
+	}
+	if len(errs) != len(expected) {
+		t.Fatal("expected ", len(expected), " problems but got: ", errs)
+	}
+	for i, msg := range expected {
+		if !strings.HasSuffix(errs[i].Error(), ": "+msg) {
+			t.Error("expected the problem \"", msg, "\" but got \"", errs[i], "\"")
+		}
+	}
+}